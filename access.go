@@ -0,0 +1,186 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogFile = "access_denials.json"
+	// accessLogLimit is how many denied requests are kept
+	accessLogLimit = 1000
+)
+
+// Reasons a request is denied
+const (
+	denyMissingPermission = "missing permission"
+	denyNoTwoFactor       = "signed in without two-factor authentication"
+	denyWrongCredentials  = "wrong admin credentials"
+)
+
+// Roles. Every account is a student; team admins are listed on their team
+// and only act for it; instructors and staff are granted on the account.
+const (
+	roleStudent    = "student"
+	roleTeamAdmin  = "team_admin"
+	roleInstructor = "instructor"
+	roleStaff      = "staff"
+)
+
+// grantableRoles are the roles an account can be given from the admin area
+var grantableRoles = []string{roleInstructor, roleStaff}
+
+// Permission is something a role allows
+type Permission string
+
+const (
+	permViewCourses    Permission = "courses.view"
+	permViewProgress   Permission = "progress.view"   // record students' progress
+	permAuthorCourses  Permission = "courses.author"  // live sessions
+	permViewOrders     Permission = "orders.view"     // orders, drip sequences and sent emails
+	permManageEmail    Permission = "email.manage"    // broadcasts and resending emails
+	permManageAccounts Permission = "accounts.manage" // sign-outs, two-factor resets and sharing
+	permManageTeams    Permission = "teams.manage"    // every team's domains, SSO and SCIM
+	permManageSeats    Permission = "team.seats"      // a team's seats
+	// permManageRoles is kept for the admin credentials, so no account can
+	// raise its own access
+	permManageRoles Permission = "roles.manage"
+)
+
+// rolePermissions is what each role allows
+var rolePermissions = map[string][]Permission{
+	roleStudent:    {permViewCourses},
+	roleTeamAdmin:  {permViewCourses, permManageSeats},
+	roleInstructor: {permViewCourses, permViewProgress, permAuthorCourses},
+	roleStaff: {permViewCourses, permViewProgress, permAuthorCourses, permViewOrders,
+		permManageEmail, permManageAccounts, permManageTeams, permManageSeats},
+}
+
+// accountCan reports whether an account has a permission, and whether it
+// comes from a granted role rather than being a student or team admin.
+// Granted roles need a session signed in with two-factor authentication.
+// Team admin permissions only count for teamID, and only when the account
+// manages that team.
+func accountCan(a Account, perm Permission, teamID string) (allowed, granted bool) {
+	if slices.Contains(rolePermissions[roleStudent], perm) {
+		return true, false
+	}
+	if teamID != "" && slices.Contains(rolePermissions[roleTeamAdmin], perm) {
+		if t, ok := teams.Get(teamID); ok && slices.Contains(t.AdminIDs, a.ID) {
+			return true, false
+		}
+	}
+	for _, role := range a.Roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// requirePermission protects a handler with a permission. Admin pages also
+// accept the admin credentials, which allow everything. Team permissions are
+// checked against the team in the "team" parameter. Refusals are audited.
+func requirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, a, signedIn := currentSession(r)
+		_, _, basicAuth := r.BasicAuth()
+		adminPage := strings.HasPrefix(r.URL.Path, "/admin/")
+		if adminPage && (basicAuth || !signedIn) {
+			requireAdmin(next)(w, r)
+			return
+		}
+		if !signedIn {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		teamID := r.FormValue("team")
+		reason := ""
+		switch allowed, granted := accountCan(a, perm, teamID); {
+		case !allowed:
+			reason = denyMissingPermission
+		case granted && (!session.TwoFactor || a.TOTPSecret == ""):
+			reason = denyNoTwoFactor
+		}
+		if reason == "" {
+			next(w, r)
+			return
+		}
+
+		auditDenial(r, a, perm, teamID, reason)
+		switch {
+		case reason == denyNoTwoFactor:
+			http.Error(w, "Turn on two-factor authentication on your account, then sign in again with your code to use this page.", http.StatusForbidden)
+		case adminPage:
+			// Whoever holds the admin credentials may be signed in as a student
+			requireAdmin(next)(w, r)
+		default:
+			http.Error(w, "You do not have access to this page.", http.StatusForbidden)
+		}
+	}
+}
+
+// auditDenial records a refused request in the access log
+func auditDenial(r *http.Request, a Account, perm Permission, teamID, reason string) {
+	denial := AccessDenial{
+		At:         time.Now(),
+		AccountID:  a.ID,
+		Email:      a.Email,
+		Permission: perm,
+		TeamID:     teamID,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		IP:         clientIP(r),
+		Reason:     reason,
+	}
+	log.Printf("Denied %s %s to %q (%s): %s", denial.Method, denial.Path, cmp.Or(a.Email, "admin credentials"), perm, reason)
+	if err := accessLog.Record(denial); err != nil {
+		log.Printf("Error recording denied access: %v", err)
+	}
+}
+
+// AccessLog keeps the latest requests refused for lack of permission
+type AccessLog struct {
+	mu      sync.Mutex
+	denials []AccessDenial // oldest first
+}
+
+// NewAccessLog creates an access log backed by the data directory
+func NewAccessLog() (*AccessLog, error) {
+	l := &AccessLog{}
+	if err := loadJSON(accessLogFile, &l.denials); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record adds a denied request to the log
+func (l *AccessLog) Record(denial AccessDenial) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.denials = append(l.denials, denial)
+	if len(l.denials) > accessLogLimit {
+		l.denials = l.denials[len(l.denials)-accessLogLimit:]
+	}
+	return saveJSON(accessLogFile, l.denials)
+}
+
+// Recent returns up to n of the latest denied requests, newest first
+func (l *AccessLog) Recent(n int) []AccessDenial {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var list []AccessDenial
+	for i := len(l.denials) - 1; i >= 0 && len(list) < n; i-- {
+		list = append(list, l.denials[i])
+	}
+	return list
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequirePermission(t *testing.T) {
+	setupTestStores(t)
+	t.Setenv("ADMIN_USERNAME", "admin")
+	t.Setenv("ADMIN_PASSWORD", "admin-password")
+
+	newAccount := func(email string, roles ...string) Account {
+		t.Helper()
+		a, err := accounts.Ensure(Enrollment{CustomerEmail: email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, err = accounts.Update(a.ID, func(a *Account) { a.Roles = roles }); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	student := newAccount("student@example.com")
+	teamAdmin := newAccount("lead@acme.test")
+	instructor := newAccount("teacher@apex.test", roleInstructor)
+	staff := newAccount("staff@apex.test", roleStaff)
+	staffWithTwoFactor := newAccount("staff2@apex.test", roleStaff)
+	if _, err := accounts.Update(staffWithTwoFactor.ID, func(a *Account) { a.TOTPSecret = "JBSWY3DPEHPK3PXP" }); err != nil {
+		t.Fatal(err)
+	}
+	acme, err := teams.Create(Team{Name: "Acme", Seats: 5, AdminIDs: []string{teamAdmin.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := teams.Create(Team{Name: "Other", Seats: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		account  *Account
+		code     bool   // session signed in with a two-factor code
+		basic    string // admin password sent with basic auth
+		perm     Permission
+		target   string
+		status   int
+		reason   string // denial audited, if any
+		deniedTo string // team of the audited denial
+	}{
+		{"anonymous student page", nil, false, "", permManageSeats, "/team?team=" + acme.ID, http.StatusSeeOther, "", ""},
+		{"anonymous admin page", nil, false, "", permViewOrders, "/admin/emails", http.StatusUnauthorized, "", ""},
+		{"wrong admin credentials", nil, false, "guess", permViewOrders, "/admin/emails", http.StatusUnauthorized, denyWrongCredentials, ""},
+		{"student page for students", &student, false, "", permViewCourses, "/courses", http.StatusOK, "", ""},
+		{"student on a team page", &student, false, "", permManageSeats, "/team?team=" + acme.ID, http.StatusForbidden, denyMissingPermission, acme.ID},
+		{"student on an admin page", &student, false, "", permViewOrders, "/admin/emails", http.StatusUnauthorized, denyMissingPermission, ""},
+		{"team admin of the team", &teamAdmin, false, "", permManageSeats, "/team?team=" + acme.ID, http.StatusOK, "", ""},
+		{"team admin of another team", &teamAdmin, false, "", permManageSeats, "/team?team=" + other.ID, http.StatusForbidden, denyMissingPermission, other.ID},
+		{"team admin without a team", &teamAdmin, false, "", permManageSeats, "/team", http.StatusForbidden, denyMissingPermission, ""},
+		{"team admin on an admin page", &teamAdmin, false, "", permManageTeams, "/admin/teams", http.StatusUnauthorized, denyMissingPermission, ""},
+		{"instructor without two-factor", &instructor, false, "", permAuthorCourses, "/admin/live-sessions", http.StatusForbidden, denyNoTwoFactor, ""},
+		{"instructor on orders", &instructor, false, "", permViewOrders, "/admin/emails", http.StatusUnauthorized, denyMissingPermission, ""},
+		{"staff without two-factor", &staff, false, "", permViewOrders, "/admin/emails", http.StatusForbidden, denyNoTwoFactor, ""},
+		{"staff with two-factor", &staffWithTwoFactor, true, "", permViewOrders, "/admin/emails", http.StatusOK, "", ""},
+		{"staff with two-factor signed in without a code", &staffWithTwoFactor, false, "", permViewOrders, "/admin/emails", http.StatusForbidden, denyNoTwoFactor, ""},
+		{"staff after turning two-factor off", &staff, true, "", permViewOrders, "/admin/emails", http.StatusForbidden, denyNoTwoFactor, ""},
+		{"staff on any team", &staffWithTwoFactor, true, "", permManageSeats, "/team?team=" + other.ID, http.StatusOK, "", ""},
+		{"staff granting roles", &staffWithTwoFactor, true, "", permManageRoles, "/admin/access", http.StatusUnauthorized, denyMissingPermission, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(accessLog.Recent(accessLogLimit))
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.account != nil {
+				r.AddCookie(startSessionForTest(t, *tt.account, tt.code))
+			}
+			if tt.basic != "" {
+				r.SetBasicAuth("admin", tt.basic)
+			}
+			w := httptest.NewRecorder()
+			requirePermission(tt.perm, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("page"))
+			})(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			denials := accessLog.Recent(accessLogLimit)
+			if tt.reason == "" {
+				if len(denials) != before {
+					t.Errorf("denial audited: %+v", denials[0])
+				}
+				return
+			}
+			if len(denials) != before+1 {
+				t.Fatalf("%d denials audited, want 1", len(denials)-before)
+			}
+			d := denials[0]
+			wantID := ""
+			if tt.account != nil {
+				wantID = tt.account.ID
+			}
+			if d.Reason != tt.reason || d.AccountID != wantID || d.TeamID != tt.deniedTo || d.Path != r.URL.Path || d.Method != http.MethodGet {
+				t.Errorf("denial = %+v, want %q for account %q and team %q", d, tt.reason, wantID, tt.deniedTo)
+			}
+			if tt.reason != denyWrongCredentials && d.Permission != tt.perm {
+				t.Errorf("denial for %q, want %q", d.Permission, tt.perm)
+			}
+		})
+	}
+}
+
+func TestAccountCanTeamScoping(t *testing.T) {
+	setupTestStores(t)
+	lead := Account{ID: "lead"}
+	acme, err := teams.Create(Team{Name: "Acme", AdminIDs: []string{lead.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := teams.Create(Team{Name: "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		account          Account
+		perm             Permission
+		team             string
+		allowed, granted bool
+	}{
+		{lead, permViewCourses, "", true, false},
+		{lead, permManageSeats, acme.ID, true, false},
+		{lead, permManageSeats, other.ID, false, false},
+		{lead, permManageSeats, "missing", false, false},
+		{lead, permManageSeats, "", false, false},
+		{lead, permManageTeams, acme.ID, false, false},
+		{Account{ID: "staff", Roles: []string{roleStaff}}, permManageSeats, other.ID, true, true},
+		{Account{ID: "staff", Roles: []string{roleStaff}}, permManageRoles, "", false, false},
+		{Account{ID: "teacher", Roles: []string{roleInstructor}}, permViewProgress, "", true, true},
+		{Account{ID: "teacher", Roles: []string{roleInstructor}}, permManageSeats, acme.ID, false, false},
+		{Account{ID: "unknown", Roles: []string{"owner"}}, permManageTeams, "", false, false},
+	}
+	for _, tt := range tests {
+		allowed, granted := accountCan(tt.account, tt.perm, tt.team)
+		if allowed != tt.allowed || granted != tt.granted {
+			t.Errorf("accountCan(%s, %s, %q) = %v, %v; want %v, %v", tt.account.ID, tt.perm, tt.team, allowed, granted, tt.allowed, tt.granted)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const accountsFile = "accounts.json"
+
+// AccountStore keeps student accounts
+type AccountStore struct {
+	mu       sync.Mutex
+	accounts map[string]*Account
+}
+
+// NewAccountStore creates an account store backed by the data directory
+func NewAccountStore() (*AccountStore, error) {
+	s := &AccountStore{accounts: make(map[string]*Account)}
+	if err := loadJSON(accountsFile, &s.accounts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the account with the given ID
+func (s *AccountStore) Get(id string) (Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, false
+	}
+	return a.copy(), true
+}
+
+// FindByEmail returns the account with the given email address
+func (s *AccountStore) FindByEmail(email string) (Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a := s.byEmail(email); a != nil {
+		return a.copy(), true
+	}
+	return Account{}, false
+}
+
+// List returns every account, oldest first
+func (s *AccountStore) List() []Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		list = append(list, a.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Ensure returns the account of an enrollment's email address, creating it
+// on the first order, and links the order's Stripe customer to it
+func (s *AccountStore) Ensure(e Enrollment) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.byEmail(e.CustomerEmail)
+	if a == nil {
+		id := make([]byte, 12)
+		if _, err := rand.Read(id); err != nil {
+			return Account{}, fmt.Errorf("error generating account ID: %v", err)
+		}
+		a = &Account{
+			ID:        hex.EncodeToString(id),
+			Email:     e.CustomerEmail,
+			Name:      e.CustomerName,
+			Locale:    e.Locale,
+			CreatedAt: time.Now(),
+		}
+		s.accounts[a.ID] = a
+	}
+	if e.CustomerID != "" && !slices.Contains(a.CustomerIDs, e.CustomerID) {
+		a.CustomerIDs = append(a.CustomerIDs, e.CustomerID)
+	}
+	return a.copy(), s.save()
+}
+
+// Update applies fn to the account with the given ID and persists the result
+func (s *AccountStore) Update(id string, fn func(*Account)) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, fmt.Errorf("account %s not found", id)
+	}
+	fn(a)
+	return a.copy(), s.save()
+}
+
+// byEmail finds an account by email address; callers must hold s.mu
+func (s *AccountStore) byEmail(email string) *Account {
+	for _, a := range s.accounts {
+		if strings.EqualFold(a.Email, email) {
+			return a
+		}
+	}
+	return nil
+}
+
+// save writes the accounts to disk; callers must hold s.mu
+func (s *AccountStore) save() error {
+	return saveJSON(accountsFile, s.accounts)
+}
+
+// copy returns the account with its own slices
+func (a *Account) copy() Account {
+	cp := *a
+	cp.CustomerIDs = append([]string(nil), a.CustomerIDs...)
+	cp.RecoveryCodes = append([]string(nil), a.RecoveryCodes...)
+	cp.Roles = append([]string(nil), a.Roles...)
+	return cp
+}
+
+// accountForEmail returns the account of an address. Students who bought
+// before accounts existed get theirs created from their orders.
+func accountForEmail(email string) (Account, bool) {
+	if a, ok := accounts.FindByEmail(email); ok {
+		return a, true
+	}
+	orders := enrollments.FindByEmail(email)
+	if len(orders) == 0 {
+		return Account{}, false
+	}
+	var a Account
+	for _, e := range orders {
+		var err error
+		if a, err = accounts.Ensure(e); err != nil {
+			return Account{}, false
+		}
+	}
+	return a, true
+}
+
+// accountEnrollments returns the orders placed by an account's Stripe
+// customers or with its email address, newest first
+func accountEnrollments(a Account) []Enrollment {
+	var list []Enrollment
+	for _, e := range enrollments.List() {
+		if (e.CustomerID != "" && slices.Contains(a.CustomerIDs, e.CustomerID)) || strings.EqualFold(e.CustomerEmail, a.Email) {
+			list = append(list, e)
+		}
+	}
+	slices.Reverse(list)
+	return list
+}
+
+// accountEmailData returns the template data for emails about an account
+func accountEmailData(a Account) EmailData {
+	return EmailData{
+		CustomerName:  a.Name,
+		CustomerEmail: a.Email,
+		CourseName:    os.Getenv("COURSE_NAME"),
+		CompanyName:   os.Getenv("COMPANY_NAME"),
+		SupportEmail:  os.Getenv("SUPPORT_EMAIL"),
+		Locale:        a.Locale,
+	}
+}
@@ -0,0 +1,1696 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminLayout wraps every admin page. Pages fill in the "content" block.
+const adminLayout = `<!DOCTYPE html>
+<html lang="en" class="dark">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>APEX AI Admin</title>
+	<link href="https://fonts.googleapis.com/css2?family=Lexend+Deca:wght@400;500;600;700&display=swap" rel="stylesheet">
+	<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-gray-950 text-white min-h-screen font-['Lexend_Deca'] p-8">
+	<nav class="mb-8 flex gap-6 text-blue-200">
+		<span class="font-bold" style="color: #0066FF">APEX AI Admin</span>
+		<a href="/admin/sequences" class="hover:text-white">Sequences</a>
+		<a href="/admin/live-sessions" class="hover:text-white">Live sessions</a>
+		<a href="/admin/broadcasts" class="hover:text-white">Broadcasts</a>
+		<a href="/admin/emails" class="hover:text-white">Emails</a>
+		<a href="/admin/email-issues" class="hover:text-white">Email issues</a>
+		<a href="/admin/accounts" class="hover:text-white">Accounts</a>
+		<a href="/admin/sharing" class="hover:text-white">Sharing</a>
+		<a href="/admin/teams" class="hover:text-white">Teams</a>
+		<a href="/admin/access" class="hover:text-white">Access</a>
+	</nav>
+	{{template "content" .}}
+</body>
+</html>`
+
+// adminTemplate parses an admin page into the shared admin layout
+func adminTemplate(content string) *template.Template {
+	return template.Must(template.Must(template.New("layout").Funcs(adminFuncs).Parse(adminLayout)).Parse(content))
+}
+
+// adminFuncs are the helpers available to admin page templates
+var adminFuncs = template.FuncMap{
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return "—"
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"network": networkOf,
+	"join":    strings.Join,
+}
+
+// requireAdmin protects a handler with the admin credentials and a current
+// two-factor code
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireAdminCredentials(func(w http.ResponseWriter, r *http.Request) {
+		if !adminTwoFactorVerified(r) {
+			http.Redirect(w, r, "/admin/2fa?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireAdminCredentials protects a handler with HTTP basic auth using the
+// admin credentials only. It is for the two-factor page itself and for
+// machine clients such as the metrics scraper.
+func requireAdminCredentials(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wantUser := os.Getenv("ADMIN_USERNAME")
+		wantPass := os.Getenv("ADMIN_PASSWORD")
+		if wantUser == "" || wantPass == "" {
+			http.Error(w, "Admin access is not configured", http.StatusForbidden)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(wantPass)) != 1 {
+			if ok {
+				auditDenial(r, Account{}, "", "", denyWrongCredentials)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="APEX AI Admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+var adminSequencesTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-6">Drip sequences</h1>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Student</th>
+				<th>Enrolled</th>
+				<th>Modules</th>
+				<th>Sequence</th>
+				<th>Next step</th>
+				<th>Due</th>
+				<th>History</th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">
+					{{.Enrollment.CustomerName}}<br>
+					<span class="text-blue-200/70">{{.Enrollment.CustomerEmail}}</span>
+					{{if .Enrollment.Refunded}}<span class="text-red-400">refunded</span>{{end}}
+					{{if not .Enrollment.RevokedAt.IsZero}}<span class="text-red-400">seat removed</span>{{end}}
+					{{if .Unsubscribed}}<span class="text-yellow-400">unsubscribed</span>{{end}}
+				</td>
+				<td>{{datetime .Enrollment.EnrolledAt}}</td>
+				<td>
+					<form method="post" action="/admin/enrollments/progress" class="flex gap-2">
+						<input type="hidden" name="id" value="{{.Enrollment.ID}}">
+						<input type="number" min="0" name="modules" value="{{.Enrollment.ModulesCompleted}}" class="w-16 bg-gray-900 rounded px-2">
+						<button class="text-blue-400 hover:text-blue-300">Save</button>
+					</form>
+				</td>
+				<td>{{.Progress.Sequence}}</td>
+				<td>{{if .Progress.Completed}}<span class="text-green-400">completed</span>{{else}}{{.NextStep}}{{end}}</td>
+				<td>{{if not .Progress.Completed}}{{datetime .NextAt}}{{end}}</td>
+				<td>
+					{{range .Progress.History}}
+						<div>{{.Step}}: {{.Status}}{{if .Reason}} ({{.Reason}}){{end}} <span class="text-blue-200/70">{{datetime .At}}</span></div>
+					{{end}}
+				</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="7" class="py-4 text-blue-200/70">No enrollments yet.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}`)
+
+// sequenceRow is one line of the admin sequences page
+type sequenceRow struct {
+	Enrollment   Enrollment
+	Progress     SequenceProgress
+	NextStep     string
+	NextAt       time.Time
+	Unsubscribed bool
+}
+
+// AdminSequencesHandler shows where each student is in each drip sequence
+func AdminSequencesHandler(w http.ResponseWriter, r *http.Request) {
+	var rows []sequenceRow
+	for _, p := range drip.Progress() {
+		e, ok := enrollments.Get(p.EnrollmentID)
+		if !ok {
+			continue
+		}
+		row := sequenceRow{Enrollment: e, Progress: p, Unsubscribed: suppressions.Suppressed(e.CustomerEmail, categoryOnboarding)}
+		if step, ok := drip.Step(p.Sequence, p.NextStep); ok {
+			row.NextStep = step.Name
+			row.NextAt = latest(e.EnrolledAt.Add(step.Delay), p.RetryAt)
+		}
+		rows = append(rows, row)
+	}
+
+	if err := adminSequencesTmpl.Execute(w, rows); err != nil {
+		log.Printf("Error rendering sequences page: %v", err)
+	}
+}
+
+// AdminEnrollmentProgressHandler records how many modules a student has completed
+func AdminEnrollmentProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	modules, err := strconv.Atoi(r.FormValue("modules"))
+	if err != nil || modules < 0 {
+		http.Error(w, "Invalid module count", http.StatusBadRequest)
+		return
+	}
+
+	if err := enrollments.Update(r.FormValue("id"), func(e *Enrollment) {
+		e.ModulesCompleted = modules
+	}); err != nil {
+		log.Printf("Error updating enrollment progress: %v", err)
+		http.Error(w, "Error updating progress", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/sequences", http.StatusSeeOther)
+}
+
+var adminEmailIssuesTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Email issues</h1>
+	<p class="text-blue-200/70 mb-6">These customers' addresses bounced, so they never received their course emails. Reach them by phone.</p>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Customer</th>
+				<th>Email</th>
+				<th>Phone</th>
+				<th>Enrolled</th>
+				<th>Problem</th>
+				<th>Detected</th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">{{.CustomerName}}</td>
+				<td>{{.CustomerEmail}}</td>
+				<td>{{if .CustomerPhone}}<a href="tel:{{.CustomerPhone}}" class="text-blue-400 hover:text-blue-300">{{.CustomerPhone}}</a>{{else}}—{{end}}</td>
+				<td>{{datetime .EnrolledAt}}</td>
+				<td>{{.EmailIssue}}</td>
+				<td>{{datetime .EmailIssueAt}}</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="6" class="py-4 text-blue-200/70">No bounced orders.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}`)
+
+// AdminEmailIssuesHandler lists orders whose customer email bounced
+func AdminEmailIssuesHandler(w http.ResponseWriter, r *http.Request) {
+	var flagged []Enrollment
+	for _, e := range enrollments.List() {
+		if e.EmailIssue != "" {
+			flagged = append(flagged, e)
+		}
+	}
+
+	if err := adminEmailIssuesTmpl.Execute(w, flagged); err != nil {
+		log.Printf("Error rendering email issues page: %v", err)
+	}
+}
+
+var adminEmailsTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-6">Emails</h1>
+	<form method="get" action="/admin/emails" class="mb-6 flex gap-2">
+		<input type="search" name="q" value="{{.Query}}" placeholder="Customer email" class="w-80 bg-gray-900 rounded px-3 py-1">
+		<button class="text-blue-400 hover:text-blue-300">Search</button>
+	</form>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Created</th>
+				<th>Recipient</th>
+				<th>Template</th>
+				<th>Subject</th>
+				<th>Status</th>
+				<th>Response</th>
+				<th>Attempts</th>
+				<th>Opens</th>
+				<th>Clicks</th>
+				<th></th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Deliveries}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">{{datetime .CreatedAt}}</td>
+				<td>{{.To}}</td>
+				<td>{{.Template}}</td>
+				<td>{{.Subject}}</td>
+				<td>{{template "status" .Status}}</td>
+				<td>{{if .ResponseCode}}{{.ResponseCode}} {{end}}{{.Response}}</td>
+				<td>{{.Attempts}}</td>
+				<td>{{.Opens}}</td>
+				<td>{{.Clicks}}</td>
+				<td><a href="/admin/emails/view?id={{.ID}}" class="text-blue-400 hover:text-blue-300">View</a></td>
+			</tr>
+		{{else}}
+			<tr><td colspan="10" class="py-4 text-blue-200/70">No emails found.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}
+{{define "status"}}{{if eq . "sent"}}<span class="text-green-400">sent</span>{{else if eq . "failed"}}<span class="text-red-400">failed</span>{{else}}<span class="text-yellow-400">{{.}}</span>{{end}}{{end}}`)
+
+// AdminEmailsHandler lists logged emails, optionally filtered by customer email
+func AdminEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	type deliveryRow struct {
+		EmailDelivery
+		Opens  int
+		Clicks int
+	}
+	query := r.FormValue("q")
+	var rows []deliveryRow
+	for _, d := range deliveries.Search(query) {
+		row := deliveryRow{EmailDelivery: d}
+		if d.MessageID != "" {
+			row.Opens, row.Clicks = tracking.Counts(d.MessageID)
+		}
+		rows = append(rows, row)
+	}
+	data := struct {
+		Query      string
+		Deliveries []deliveryRow
+	}{query, rows}
+
+	if err := adminEmailsTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering emails page: %v", err)
+	}
+}
+
+var adminEmailViewTmpl = adminTemplate(`{{define "content"}}
+	<a href="/admin/emails?q={{.To}}" class="text-blue-400 hover:text-blue-300">&larr; Emails to {{.To}}</a>
+	<h1 class="text-3xl font-bold my-6">{{.Subject}}</h1>
+	<dl class="grid grid-cols-[10rem_1fr] gap-y-1 text-sm mb-8">
+		<dt class="text-blue-200">Recipient</dt><dd>{{.To}}</dd>
+		<dt class="text-blue-200">Template</dt><dd>{{.Template}} ({{.Category}})</dd>
+		<dt class="text-blue-200">Message-ID</dt><dd>{{if .MessageID}}{{.MessageID}}{{else}}—{{end}}</dd>
+		{{if .ProviderID}}<dt class="text-blue-200">Provider ID</dt><dd>{{.ProviderID}}</dd>{{end}}
+		<dt class="text-blue-200">Status</dt><dd>{{.Status}}</dd>
+		<dt class="text-blue-200">Response</dt><dd>{{if .ResponseCode}}{{.ResponseCode}} {{end}}{{if .Response}}{{.Response}}{{else if not .ResponseCode}}—{{end}}</dd>
+		<dt class="text-blue-200">Attempts</dt><dd>{{.Attempts}}</dd>
+		<dt class="text-blue-200">Created</dt><dd>{{datetime .CreatedAt}}</dd>
+		<dt class="text-blue-200">Last attempt</dt><dd>{{datetime .UpdatedAt}}</dd>
+		{{if .ResendOf}}<dt class="text-blue-200">Resend of</dt><dd><a href="/admin/emails/view?id={{.ResendOf}}" class="text-blue-400 hover:text-blue-300">{{.ResendOf}}</a></dd>{{end}}
+	</dl>
+
+	<form method="post" action="/admin/emails/resend" class="mb-8 flex gap-2 items-center">
+		<input type="hidden" name="id" value="{{.ID}}">
+		<label class="text-blue-200" for="resend-to">Resend to</label>
+		<input type="email" id="resend-to" name="to" value="{{.To}}" required class="w-80 bg-gray-900 rounded px-3 py-1">
+		<button class="text-blue-400 hover:text-blue-300">Resend</button>
+		<span class="text-blue-200/70 text-sm">A different address also replaces the one on the customer's orders.</span>
+	</form>
+
+	<h2 class="text-xl font-bold mb-4">Activity</h2>
+	<ul class="text-sm mb-8">
+	{{range .Events}}
+		<li>{{datetime .At}} — {{.Type}}{{if .URL}} <span class="text-blue-200/70">{{.URL}}</span>{{end}}</li>
+	{{else}}
+		<li class="text-blue-200/70">No opens or clicks recorded.</li>
+	{{end}}
+	</ul>
+
+	<iframe src="/admin/emails/content?id={{.ID}}" sandbox class="w-full h-[40rem] bg-white rounded mb-8"></iframe>
+	<pre class="whitespace-pre-wrap text-sm bg-gray-900 rounded p-4">{{.TextContent}}</pre>
+{{end}}`)
+
+// AdminEmailViewHandler shows a logged email with its delivery details
+func AdminEmailViewHandler(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := deliveries.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		EmailDelivery
+		Events []TrackingEvent
+	}{EmailDelivery: delivery}
+	if delivery.MessageID != "" {
+		data.Events = tracking.ForMessage(delivery.MessageID)
+	}
+
+	if err := adminEmailViewTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering email page: %v", err)
+	}
+}
+
+// AdminEmailContentHandler serves the HTML body of a logged email for the preview frame
+func AdminEmailContentHandler(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := deliveries.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write([]byte(stripOpenPixel(delivery.HTMLContent)))
+}
+
+// AdminEmailResendHandler sends a logged email again. When the address was
+// corrected, the customer's orders are moved to the new address first.
+func AdminEmailResendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	original, ok := deliveries.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	to := original.To
+	if value := r.FormValue("to"); value != "" {
+		addr, err := mail.ParseAddress(value)
+		if err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		to = addr.Address
+	}
+
+	if !strings.EqualFold(to, original.To) {
+		if err := changeCustomerEmail(original.To, to); err != nil {
+			log.Printf("Error updating customer email: %v", err)
+			http.Error(w, "Error updating customer email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	delivery, err := emailService.Resend(original.ID, to)
+	if err != nil {
+		log.Printf("Error resending email %s: %v", original.ID, err)
+	}
+	if delivery.ID == "" {
+		http.Error(w, "Error resending email", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/emails/view?id="+delivery.ID, http.StatusSeeOther)
+}
+
+var adminLiveSessionsTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Live sessions</h1>
+	<p class="text-blue-200/70 mb-6">Every student who has not been refunded receives a calendar invite. Reschedules and cancellations update the event in their calendars.</p>
+	<table class="w-full text-left text-sm mb-10">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Session</th>
+				<th>Starts</th>
+				<th>Duration</th>
+				<th>Reschedule</th>
+				<th></th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Sessions}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">
+					{{.Title}}<br>
+					<a href="{{.JoinURL}}" class="text-blue-200/70">{{.JoinURL}}</a>
+					{{if .Cancelled}}<span class="text-red-400">cancelled</span>{{end}}
+				</td>
+				<td>{{datetime .LocalStart}} <span class="text-blue-200/70">{{.TimeZone}}</span></td>
+				<td>{{.Duration}}</td>
+				<td>
+					{{if not .Cancelled}}
+					<form method="post" action="/admin/live-sessions/reschedule" class="flex gap-2">
+						<input type="hidden" name="id" value="{{.ID}}">
+						<input type="datetime-local" name="start" value="{{.LocalStart.Format "2006-01-02T15:04"}}" required class="bg-gray-900 rounded px-2">
+						<button class="text-blue-400 hover:text-blue-300">Send update</button>
+					</form>
+					{{end}}
+				</td>
+				<td>
+					{{if not .Cancelled}}
+					<form method="post" action="/admin/live-sessions/cancel">
+						<input type="hidden" name="id" value="{{.ID}}">
+						<button class="text-red-400 hover:text-red-300">Cancel</button>
+					</form>
+					{{end}}
+				</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="5" class="py-4 text-blue-200/70">No live sessions scheduled.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+
+	<h2 class="text-xl font-bold mb-4">Schedule a session</h2>
+	<form method="post" action="/admin/live-sessions/create" class="grid grid-cols-[10rem_24rem] gap-2 text-sm">
+		<label class="text-blue-200" for="title">Title</label>
+		<input id="title" name="title" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="description">Description</label>
+		<textarea id="description" name="description" rows="3" class="bg-gray-900 rounded px-3 py-1"></textarea>
+		<label class="text-blue-200" for="start">Starts</label>
+		<input type="datetime-local" id="start" name="start" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="time_zone">Time zone</label>
+		<input id="time_zone" name="time_zone" value="{{.TimeZone}}" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="duration">Duration (minutes)</label>
+		<input type="number" min="1" id="duration" name="duration" value="60" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="join_url">Join URL</label>
+		<input type="url" id="join_url" name="join_url" required class="bg-gray-900 rounded px-3 py-1">
+		<span></span>
+		<button class="text-left text-blue-400 hover:text-blue-300">Schedule and send invites</button>
+	</form>
+{{end}}`)
+
+// AdminLiveSessionsHandler lists live sessions and lets admins schedule new ones
+func AdminLiveSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Sessions []LiveSession
+		TimeZone string
+	}{liveSessions.List(), adminTimeZone()}
+
+	if err := adminLiveSessionsTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering live sessions page: %v", err)
+	}
+}
+
+// AdminLiveSessionCreateHandler schedules a live session and invites every student
+func AdminLiveSessionCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := time.LoadLocation(r.FormValue("time_zone"))
+	if err != nil {
+		http.Error(w, "Unknown time zone", http.StatusBadRequest)
+		return
+	}
+	start, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("start"), loc)
+	if err != nil {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+	minutes, err := strconv.Atoi(r.FormValue("duration"))
+	if err != nil || minutes <= 0 {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	session, err := liveSessions.Create(LiveSession{
+		Title:       r.FormValue("title"),
+		Description: r.FormValue("description"),
+		Start:       start,
+		Duration:    time.Duration(minutes) * time.Minute,
+		TimeZone:    loc.String(),
+		JoinURL:     r.FormValue("join_url"),
+	})
+	if err != nil {
+		log.Printf("Error creating live session: %v", err)
+		http.Error(w, "Error creating live session", http.StatusInternalServerError)
+		return
+	}
+	go inviteStudents(session, "live_session_invite")
+
+	http.Redirect(w, r, "/admin/live-sessions", http.StatusSeeOther)
+}
+
+// AdminLiveSessionRescheduleHandler moves a live session and sends the updated invite
+func AdminLiveSessionRescheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, ok := liveSessions.Get(r.FormValue("id"))
+	if !ok || current.Cancelled {
+		http.NotFound(w, r)
+		return
+	}
+	loc, err := time.LoadLocation(current.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("start"), loc)
+	if err != nil {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+
+	// Calendar clients only apply an update with a higher sequence number
+	session, err := liveSessions.Update(current.ID, func(s *LiveSession) {
+		s.Start = start
+		s.Sequence++
+	})
+	if err != nil {
+		log.Printf("Error rescheduling live session: %v", err)
+		http.Error(w, "Error rescheduling live session", http.StatusInternalServerError)
+		return
+	}
+	go inviteStudents(session, "live_session_update")
+
+	http.Redirect(w, r, "/admin/live-sessions", http.StatusSeeOther)
+}
+
+// AdminLiveSessionCancelHandler cancels a live session and removes it from students' calendars
+func AdminLiveSessionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, ok := liveSessions.Get(r.FormValue("id"))
+	if !ok || current.Cancelled {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, err := liveSessions.Update(current.ID, func(s *LiveSession) {
+		s.Cancelled = true
+		s.Sequence++
+	})
+	if err != nil {
+		log.Printf("Error cancelling live session: %v", err)
+		http.Error(w, "Error cancelling live session", http.StatusInternalServerError)
+		return
+	}
+	go inviteStudents(session, "live_session_cancelled")
+
+	http.Redirect(w, r, "/admin/live-sessions", http.StatusSeeOther)
+}
+
+// adminTimeZone is the time zone admin forms default to
+func adminTimeZone() string {
+	if timeZone := os.Getenv("LIVE_SESSION_TIME_ZONE"); timeZone != "" {
+		return timeZone
+	}
+	return "UTC"
+}
+
+// broadcastFormFields is shared by the compose and edit forms
+const broadcastFormFields = `{{define "broadcast_form"}}
+		<label class="text-blue-200" for="template">Template</label>
+		<select id="template" name="template" class="bg-gray-900 rounded px-3 py-1">
+			{{range .Templates}}<option value="{{.}}" {{if eq . $.Broadcast.Template}}selected{{end}}>{{.}}</option>{{end}}
+		</select>
+		<label class="text-blue-200" for="subject">Subject</label>
+		<input id="subject" name="subject" value="{{.Broadcast.Subject}}" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="heading">Heading</label>
+		<input id="heading" name="heading" value="{{.Broadcast.Heading}}" required class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="message">Message</label>
+		<textarea id="message" name="message" rows="8" required class="bg-gray-900 rounded px-3 py-1" placeholder="Leave a blank line between paragraphs">{{.Broadcast.Message}}</textarea>
+		<label class="text-blue-200" for="button_label">Button label</label>
+		<input id="button_label" name="button_label" value="{{.Broadcast.ButtonLabel}}" class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="button_url">Button URL</label>
+		<input type="url" id="button_url" name="button_url" value="{{.Broadcast.ButtonURL}}" class="bg-gray-900 rounded px-3 py-1">
+		<label class="text-blue-200" for="segment">Send to</label>
+		<div class="flex gap-2">
+			<select id="segment" name="segment" class="bg-gray-900 rounded px-3 py-1">
+				<option value="all" {{if eq .Broadcast.Segment.Kind "all"}}selected{{end}}>All students</option>
+				<option value="product" {{if eq .Broadcast.Segment.Kind "product"}}selected{{end}}>Product</option>
+				<option value="cohort" {{if eq .Broadcast.Segment.Kind "cohort"}}selected{{end}}>Cohort (enrollment month)</option>
+				<option value="company" {{if eq .Broadcast.Segment.Kind "company"}}selected{{end}}>Company</option>
+				<option value="progress" {{if eq .Broadcast.Segment.Kind "progress"}}selected{{end}}>Modules completed</option>
+			</select>
+			<input name="segment_value" value="{{.Broadcast.Segment.Value}}" list="segment-values" placeholder="e.g. 2026-03, Acme or 0-2" class="flex-1 bg-gray-900 rounded px-3 py-1">
+			<datalist id="segment-values">
+				{{range .SegmentValues}}<option value="{{.}}">{{end}}
+			</datalist>
+		</div>
+{{end}}`
+
+var adminBroadcastsTmpl = template.Must(adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Broadcasts</h1>
+	<p class="text-blue-200/70 mb-6">Announce new modules and events to a group of students. Students who unsubscribed from course announcements are skipped.</p>
+	<table class="w-full text-left text-sm mb-10">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Subject</th>
+				<th>Segment</th>
+				<th>Status</th>
+				<th>When</th>
+				<th>Sent</th>
+				<th></th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Broadcasts}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">{{.Subject}}</td>
+				<td>{{.Segment}}</td>
+				<td>{{.Status}}</td>
+				<td>{{if eq .Status "sent"}}{{datetime .SentAt}}{{else if eq .Status "scheduled"}}{{datetime .ScheduledAt}}{{else}}—{{end}}</td>
+				<td>{{if eq .Status "sent"}}{{.Sent}} of {{.Recipients}}{{else}}—{{end}}</td>
+				<td><a href="/admin/broadcasts/view?id={{.ID}}" class="text-blue-400 hover:text-blue-300">View</a></td>
+			</tr>
+		{{else}}
+			<tr><td colspan="6" class="py-4 text-blue-200/70">No broadcasts yet.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+
+	<h2 class="text-xl font-bold mb-4">New broadcast</h2>
+	<form method="post" action="/admin/broadcasts/save" class="grid grid-cols-[10rem_36rem] gap-2 text-sm">
+		{{template "broadcast_form" .}}
+		<span></span>
+		<button class="text-left text-blue-400 hover:text-blue-300">Save draft</button>
+	</form>
+{{end}}`).Parse(broadcastFormFields))
+
+// broadcastPage is the data of the broadcast admin pages
+type broadcastPage struct {
+	Broadcasts    []Broadcast
+	Broadcast     Broadcast
+	Templates     []string
+	SegmentValues []string
+	Students      int // students in the segment
+	Unsubscribed  int // of those, students who opted out of announcements
+	Reachable     int // students who will receive it
+	TimeZone      string
+	Error         string
+}
+
+// newBroadcastPage fills in the choices shared by the broadcast forms
+func newBroadcastPage(b Broadcast) broadcastPage {
+	seen := make(map[string]bool)
+	var values []string
+	for _, e := range enrollments.List() {
+		for _, value := range []string{e.CourseName, e.EnrolledAt.Format("2006-01"), e.Company} {
+			if value != "" && !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return broadcastPage{Broadcast: b, Templates: broadcastTemplates, SegmentValues: values, TimeZone: adminTimeZone()}
+}
+
+// AdminBroadcastsHandler lists broadcasts and lets admins write a new one
+func AdminBroadcastsHandler(w http.ResponseWriter, r *http.Request) {
+	page := newBroadcastPage(Broadcast{Template: broadcastTemplates[0], Segment: Segment{Kind: segmentAll}})
+	page.Broadcasts = broadcasts.List()
+
+	if err := adminBroadcastsTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering broadcasts page: %v", err)
+	}
+}
+
+// broadcastFromForm reads and validates the content and segment of a broadcast form
+func broadcastFromForm(r *http.Request) (Broadcast, error) {
+	b := Broadcast{
+		Template:    r.FormValue("template"),
+		Subject:     strings.TrimSpace(r.FormValue("subject")),
+		Heading:     strings.TrimSpace(r.FormValue("heading")),
+		Message:     r.FormValue("message"),
+		ButtonLabel: strings.TrimSpace(r.FormValue("button_label")),
+		ButtonURL:   strings.TrimSpace(r.FormValue("button_url")),
+		Segment:     Segment{Kind: r.FormValue("segment"), Value: strings.TrimSpace(r.FormValue("segment_value"))},
+	}
+	if b.Segment.Kind == segmentAll {
+		b.Segment.Value = ""
+	}
+
+	switch {
+	case !slices.Contains(broadcastTemplates, b.Template):
+		return b, fmt.Errorf("unknown template %q", b.Template)
+	case b.Subject == "" || b.Heading == "" || len(b.Paragraphs()) == 0:
+		return b, fmt.Errorf("subject, heading and message are required")
+	case b.ButtonLabel != "" && b.ButtonURL == "":
+		return b, fmt.Errorf("the button needs a URL")
+	}
+	if b.ButtonURL != "" {
+		if u, err := url.Parse(b.ButtonURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return b, fmt.Errorf("the button URL must start with https://")
+		}
+	}
+	if err := b.Segment.Validate(); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// AdminBroadcastSaveHandler creates a draft broadcast or updates an unsent one
+func AdminBroadcastSaveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	form, err := broadcastFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var b Broadcast
+	if id := r.FormValue("id"); id != "" {
+		b, err = broadcasts.Update(id, func(b *Broadcast) {
+			b.Template = form.Template
+			b.Subject = form.Subject
+			b.Heading = form.Heading
+			b.Message = form.Message
+			b.ButtonLabel = form.ButtonLabel
+			b.ButtonURL = form.ButtonURL
+			b.Segment = form.Segment
+		})
+	} else {
+		b, err = broadcasts.Create(form)
+	}
+	if err != nil {
+		log.Printf("Error saving broadcast: %v", err)
+		http.Error(w, "Error saving broadcast", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/broadcasts/view?id="+b.ID, http.StatusSeeOther)
+}
+
+var adminBroadcastViewTmpl = template.Must(adminTemplate(`{{define "content"}}
+	<a href="/admin/broadcasts" class="text-blue-400 hover:text-blue-300">&larr; Broadcasts</a>
+	<h1 class="text-3xl font-bold my-6">{{.Broadcast.Subject}}</h1>
+	{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+	<dl class="grid grid-cols-[10rem_1fr] gap-y-1 text-sm mb-8">
+		<dt class="text-blue-200">Segment</dt><dd>{{.Broadcast.Segment}}</dd>
+		<dt class="text-blue-200">Status</dt><dd>{{.Broadcast.Status}}</dd>
+		{{if eq .Broadcast.Status "sent"}}
+		<dt class="text-blue-200">Sent</dt><dd>{{datetime .Broadcast.SentAt}} — {{.Broadcast.Sent}} of {{.Broadcast.Recipients}} students</dd>
+		{{else}}
+		<dt class="text-blue-200">Recipients</dt><dd>{{.Students}} students match; {{if .Unsubscribed}}{{.Unsubscribed}} unsubscribed, so {{end}}{{.Reachable}} will receive it</dd>
+		{{end}}
+		{{if eq .Broadcast.Status "scheduled"}}<dt class="text-blue-200">Scheduled</dt><dd>{{datetime .Broadcast.ScheduledAt}} UTC</dd>{{end}}
+	</dl>
+
+	{{if .Broadcast.Editable}}
+	<div class="flex gap-10 mb-8 text-sm">
+		<form method="post" action="/admin/broadcasts/test" class="flex gap-2 items-center">
+			<input type="hidden" name="id" value="{{.Broadcast.ID}}">
+			<label class="text-blue-200" for="test-to">Send a test to</label>
+			<input type="email" id="test-to" name="to" required class="w-64 bg-gray-900 rounded px-3 py-1">
+			<button class="text-blue-400 hover:text-blue-300">Send test</button>
+		</form>
+		<form method="post" action="/admin/broadcasts/schedule" class="flex gap-2 items-center">
+			<input type="hidden" name="id" value="{{.Broadcast.ID}}">
+			<input type="datetime-local" name="at" required class="bg-gray-900 rounded px-2">
+			<input name="time_zone" value="{{.TimeZone}}" required class="w-36 bg-gray-900 rounded px-2">
+			<button class="text-blue-400 hover:text-blue-300">Schedule</button>
+		</form>
+		<form method="post" action="/admin/broadcasts/send" onsubmit="return confirm('Send this broadcast to {{.Reachable}} students now?')">
+			<input type="hidden" name="id" value="{{.Broadcast.ID}}">
+			<button class="text-green-400 hover:text-green-300">Send now</button>
+		</form>
+		{{if eq .Broadcast.Status "scheduled"}}
+		<form method="post" action="/admin/broadcasts/unschedule">
+			<input type="hidden" name="id" value="{{.Broadcast.ID}}">
+			<button class="text-red-400 hover:text-red-300">Unschedule</button>
+		</form>
+		{{end}}
+	</div>
+	{{end}}
+
+	<iframe src="/admin/broadcasts/preview?id={{.Broadcast.ID}}" sandbox class="w-full h-[40rem] bg-white rounded mb-8"></iframe>
+
+	{{if .Broadcast.Editable}}
+	<h2 class="text-xl font-bold mb-4">Edit</h2>
+	<form method="post" action="/admin/broadcasts/save" class="grid grid-cols-[10rem_36rem] gap-2 text-sm">
+		<input type="hidden" name="id" value="{{.Broadcast.ID}}">
+		{{template "broadcast_form" .}}
+		<span></span>
+		<button class="text-left text-blue-400 hover:text-blue-300">Save changes</button>
+	</form>
+	{{end}}
+{{end}}`).Parse(broadcastFormFields))
+
+// AdminBroadcastViewHandler shows a broadcast with its audience and sending options
+func AdminBroadcastViewHandler(w http.ResponseWriter, r *http.Request) {
+	b, ok := broadcasts.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := newBroadcastPage(b)
+	page.Error = r.FormValue("error")
+	for _, e := range segmentStudents(b.Segment) {
+		page.Students++
+		if suppressions.Suppressed(e.CustomerEmail, categoryAnnouncements) {
+			page.Unsubscribed++
+		}
+	}
+	page.Reachable = page.Students - page.Unsubscribed
+
+	if err := adminBroadcastViewTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering broadcast page: %v", err)
+	}
+}
+
+// AdminBroadcastPreviewHandler renders a broadcast for the preview frame
+func AdminBroadcastPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	b, ok := broadcasts.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := enrollmentEmailData(Enrollment{CustomerName: "Test Student", CourseName: os.Getenv("COURSE_NAME")})
+	if recipients := broadcastRecipients(b); len(recipients) > 0 {
+		data = recipients[0]
+	}
+	data.Broadcast = &b
+	email, err := emailService.renderEmail(b.Template, data)
+	if err != nil {
+		log.Printf("Error rendering broadcast %s: %v", b.ID, err)
+		http.Error(w, "Error rendering broadcast", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write([]byte(stripOpenPixel(email.HTMLContent)))
+}
+
+// AdminBroadcastTestHandler sends a broadcast to a single address
+func AdminBroadcastTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, ok := broadcasts.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	addr, err := mail.ParseAddress(r.FormValue("to"))
+	if err != nil {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	target := "/admin/broadcasts/view?id=" + b.ID
+	if err := sendBroadcastTest(b, addr.Address); err != nil {
+		log.Printf("Error sending test of broadcast %s: %v", b.ID, err)
+		target += "&error=" + url.QueryEscape("Test not sent: "+err.Error())
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// AdminBroadcastScheduleHandler schedules a broadcast to be sent later
+func AdminBroadcastScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := time.LoadLocation(r.FormValue("time_zone"))
+	if err != nil {
+		http.Error(w, "Unknown time zone", http.StatusBadRequest)
+		return
+	}
+	at, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("at"), loc)
+	if err != nil || !at.After(time.Now()) {
+		http.Error(w, "Choose a time in the future", http.StatusBadRequest)
+		return
+	}
+
+	b, err := broadcasts.Update(r.FormValue("id"), func(b *Broadcast) {
+		b.Status = broadcastScheduled
+		b.ScheduledAt = at.UTC()
+	})
+	if err != nil {
+		log.Printf("Error scheduling broadcast: %v", err)
+		http.Error(w, "Error scheduling broadcast", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/broadcasts/view?id="+b.ID, http.StatusSeeOther)
+}
+
+// AdminBroadcastUnscheduleHandler turns a scheduled broadcast back into a draft
+func AdminBroadcastUnscheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := broadcasts.Update(r.FormValue("id"), func(b *Broadcast) {
+		b.Status = broadcastDraft
+		b.ScheduledAt = time.Time{}
+	})
+	if err != nil {
+		log.Printf("Error unscheduling broadcast: %v", err)
+		http.Error(w, "Error unscheduling broadcast", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/broadcasts/view?id="+b.ID, http.StatusSeeOther)
+}
+
+// AdminBroadcastSendHandler sends a broadcast right away
+func AdminBroadcastSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, ok := broadcasts.Get(r.FormValue("id"))
+	if !ok || !b.Editable() {
+		http.NotFound(w, r)
+		return
+	}
+	go broadcasts.Send(b.ID)
+
+	http.Redirect(w, r, "/admin/broadcasts", http.StatusSeeOther)
+}
+
+var adminAccountsTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-6">Student accounts</h1>
+	<form method="get" action="/admin/accounts" class="mb-6 flex gap-2">
+		<input type="search" name="q" value="{{.Query}}" placeholder="Email or name" class="w-80 bg-gray-900 rounded px-3 py-1">
+		<button class="text-blue-400 hover:text-blue-300">Search</button>
+	</form>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr>
+				<th class="py-2">Email</th>
+				<th>Name</th>
+				<th>Created</th>
+				<th>Last sign-in</th>
+				<th>Password</th>
+				<th>2FA</th>
+				<th>Signed-in devices</th>
+				<th></th>
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Accounts}}
+			<tr class="border-b border-gray-900 align-top">
+				<td class="py-2">{{.Email}}{{if not .SharingFlaggedAt.IsZero}} <a href="/admin/sharing" class="text-yellow-400">shared?</a>{{end}}</td>
+				<td>{{.Name}}</td>
+				<td>{{datetime .CreatedAt}}</td>
+				<td>{{datetime .LastLoginAt}}</td>
+				<td>{{if .PasswordHash}}set{{if .Locked}} <span class="text-red-400">locked</span>{{end}}{{else}}—{{end}}</td>
+				<td>
+					{{if .TOTPSecret}}
+					<form method="post" action="/admin/accounts/reset-2fa" onsubmit="return confirm('Turn two-factor authentication off for {{.Email}}? Only do this after confirming who is asking.')">
+						<input type="hidden" name="id" value="{{.ID}}">
+						<input type="hidden" name="q" value="{{$.Query}}">
+						on <button class="text-red-400 hover:text-red-300">Reset</button>
+					</form>
+					{{else}}—{{end}}
+				</td>
+				<td>
+					{{range .Sessions}}<div>{{.Device}}, {{.IP}}, active {{datetime .LastSeenAt}}</div>{{else}}—{{end}}
+				</td>
+				<td>
+					{{if .Sessions}}
+					<form method="post" action="/admin/accounts/logout" onsubmit="return confirm('Sign {{.Email}} out on every device?')">
+						<input type="hidden" name="id" value="{{.ID}}">
+						<input type="hidden" name="q" value="{{$.Query}}">
+						<button class="text-red-400 hover:text-red-300">Force logout</button>
+					</form>
+					{{end}}
+				</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="8" class="py-4 text-blue-200/70">No accounts found.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}`)
+
+// AdminAccountsHandler lists student accounts with their signed-in devices
+func AdminAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	type accountRow struct {
+		Account
+		Locked   bool
+		Sessions []LoginSession
+	}
+	query := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+	var rows []accountRow
+	for _, a := range accounts.List() {
+		if query != "" && !strings.Contains(strings.ToLower(a.Email), query) && !strings.Contains(strings.ToLower(a.Name), query) {
+			continue
+		}
+		rows = append(rows, accountRow{a, time.Now().Before(a.LockedUntil), loginSessions.ForAccount(a.ID)})
+	}
+	slices.Reverse(rows)
+	data := struct {
+		Query    string
+		Accounts []accountRow
+	}{r.FormValue("q"), rows}
+
+	if err := adminAccountsTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering accounts page: %v", err)
+	}
+}
+
+// AdminAccountLogoutHandler signs a student out on every device
+func AdminAccountLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, ok := accounts.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := loginSessions.RevokeAccount(a.ID, ""); err != nil {
+		log.Printf("Error signing out %s: %v", a.Email, err)
+		http.Error(w, "Error signing the student out", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin signed %s out on every device", a.Email)
+	http.Redirect(w, r, "/admin/accounts?q="+url.QueryEscape(r.FormValue("q")), http.StatusSeeOther)
+}
+
+var adminSharingTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Account sharing</h1>
+	<p class="text-blue-200/70 mb-6">Accounts whose sign-ins came from more networks or devices than one person plausibly uses. Dismissing a flag keeps the account and only counts sign-ins from then on.</p>
+	{{range .Flagged}}
+	<div class="rounded border border-gray-800 p-4 mb-6">
+		<div class="flex items-start gap-4 mb-3">
+			<div class="flex-1">
+				<h2 class="text-xl font-semibold">{{.Email}}{{if .Name}} <span class="text-blue-200/70 text-base">{{.Name}}</span>{{end}}</h2>
+				<p class="text-sm text-yellow-400">Flagged {{datetime .SharingFlaggedAt}}: {{.SharingReason}}</p>
+				<p class="text-sm text-blue-200/70">{{.Stats.SignIns}} sign-ins from {{.Stats.Networks}} networks on {{.Stats.Devices}} devices; {{len .Sessions}} signed in now</p>
+			</div>
+			<form method="post" action="/admin/accounts/logout" onsubmit="return confirm('Sign {{.Email}} out on every device?')">
+				<input type="hidden" name="id" value="{{.ID}}">
+				<input type="hidden" name="q" value="{{.Email}}">
+				<button class="text-red-400 hover:text-red-300">Force logout</button>
+			</form>
+			<form method="post" action="/admin/sharing/dismiss">
+				<input type="hidden" name="id" value="{{.ID}}">
+				<button class="text-blue-400 hover:text-blue-300">Dismiss</button>
+			</form>
+		</div>
+		<table class="w-full text-left text-sm">
+			<thead class="text-blue-200 border-b border-gray-800">
+				<tr><th class="py-1">Signed in</th><th>IP</th><th>Network</th><th>Device</th><th>Method</th><th>Pushed out</th></tr>
+			</thead>
+			<tbody>
+			{{range .Logins}}
+				<tr class="border-b border-gray-900">
+					<td class="py-1">{{datetime .At}}</td>
+					<td>{{.IP}}</td>
+					<td>{{network .IP}}</td>
+					<td>{{.Device}}</td>
+					<td>{{.Method}}</td>
+					<td>{{if .Evicted}}{{.Evicted}}{{end}}</td>
+				</tr>
+			{{end}}
+			</tbody>
+		</table>
+	</div>
+	{{else}}
+	<p class="text-blue-200/70">No accounts are flagged.</p>
+	{{end}}
+{{end}}`)
+
+// AdminSharingHandler lists the accounts flagged for possible sharing
+func AdminSharingHandler(w http.ResponseWriter, r *http.Request) {
+	type flaggedRow struct {
+		Account
+		Stats    SharingStats
+		Logins   []LoginRecord
+		Sessions []LoginSession
+	}
+	var rows []flaggedRow
+	for _, a := range accounts.List() {
+		if a.SharingFlaggedAt.IsZero() {
+			continue
+		}
+		recent := sharing.Recent(a)
+		rows = append(rows, flaggedRow{a, sharingStats(recent), recent, loginSessions.ForAccount(a.ID)})
+	}
+	slices.SortFunc(rows, func(a, b flaggedRow) int {
+		return b.SharingFlaggedAt.Compare(a.SharingFlaggedAt)
+	})
+
+	if err := adminSharingTmpl.Execute(w, struct{ Flagged []flaggedRow }{rows}); err != nil {
+		log.Printf("Error rendering sharing page: %v", err)
+	}
+}
+
+// AdminSharingDismissHandler clears an account's sharing flag after review
+func AdminSharingDismissHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := accounts.Update(r.FormValue("id"), func(a *Account) {
+		a.SharingFlaggedAt, a.SharingReason, a.SharingReviewedAt = time.Time{}, "", time.Now()
+	}); err != nil {
+		log.Printf("Error dismissing sharing flag: %v", err)
+		http.Error(w, "Error dismissing the flag", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/sharing", http.StatusSeeOther)
+}
+
+var adminTeamsTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Teams</h1>
+	<p class="text-blue-200/70 mb-2">Companies that bought several seats. Give a team's identity provider these service provider details:</p>
+	<ul class="text-sm text-blue-200/70 mb-6 list-disc ml-6">
+		<li>OpenID Connect redirect URI: <code>{{.OIDCRedirectURL}}</code></li>
+		<li>SAML entity ID and metadata: <code>{{.SAMLEntityID}}</code>; assertion consumer service (HTTP-POST): <code>{{.SAMLACSURL}}</code></li>
+	</ul>
+	{{range .Teams}}
+	<div class="rounded border border-gray-800 p-4 mb-6">
+		<h2 class="text-xl font-semibold">{{.Name}} <span class="text-blue-200/70 text-base">{{.Taken}} of {{.Seats}} seats taken</span></h2>
+		<p class="text-sm text-blue-200/70 mb-3">Created {{datetime .CreatedAt}}; managed by {{range $i, $a := .Admins}}{{if $i}}, {{end}}{{$a.Email}}{{end}}</p>
+		<table class="w-full text-left text-sm mb-4">
+			<thead class="text-blue-200 border-b border-gray-800">
+				<tr><th class="py-1">Member</th><th>Seat from</th><th>Added</th><th>Last sign-in</th><th>Modules</th></tr>
+			</thead>
+			<tbody>
+			{{range .Members}}
+				<tr class="border-b border-gray-900"><td class="py-1">{{.Email}}{{if not .Active}} <span class="text-red-400">seat freed</span>{{end}}</td><td>{{.Source}}</td><td>{{datetime .AddedAt}}</td><td>{{datetime .LastLoginAt}}</td><td>{{.ModulesCompleted}}</td></tr>
+			{{end}}
+			</tbody>
+		</table>
+		<form method="post" action="/admin/teams/sso" class="grid grid-cols-2 gap-3 text-sm">
+			<input type="hidden" name="id" value="{{.ID}}">
+			<label class="flex flex-col gap-1 col-span-2">Email domains <span class="text-blue-200/70">Comma separated. Members' addresses must be at one of them, and single sign-on signs these addresses in.</span>
+				<input type="text" name="domains" value="{{join .Domains ", "}}" placeholder="example.com" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1 col-span-2">Single sign-on
+				<select name="protocol" class="bg-gray-900 rounded px-3 py-1">
+					<option value="" {{if not .SSO}}selected{{end}}>Off</option>
+					<option value="oidc" {{if and .SSO (eq .SSO.Protocol "oidc")}}selected{{end}}>OpenID Connect</option>
+					<option value="saml" {{if and .SSO (eq .SSO.Protocol "saml")}}selected{{end}}>SAML 2.0</option>
+				</select></label>
+			{{$sso := .SSOOrEmpty}}
+			<label class="flex flex-col gap-1 col-span-2">OIDC issuer URL
+				<input type="url" name="issuer" value="{{$sso.Issuer}}" placeholder="https://login.example.com" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1">OIDC client ID
+				<input type="text" name="client_id" value="{{$sso.ClientID}}" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1">OIDC client secret <span class="text-blue-200/70">Leave empty to keep the current one</span>
+				<input type="password" name="client_secret" autocomplete="off" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1">SAML IdP entity ID
+				<input type="text" name="idp_entity_id" value="{{$sso.IdPEntityID}}" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1">SAML IdP sign-on URL (HTTP-Redirect)
+				<input type="url" name="idp_sso_url" value="{{$sso.IdPSSOURL}}" class="bg-gray-900 rounded px-3 py-1"></label>
+			<label class="flex flex-col gap-1 col-span-2">SAML IdP signing certificate (PEM)
+				<textarea name="idp_certificate" rows="4" class="bg-gray-900 rounded px-3 py-1 font-mono text-xs">{{$sso.IdPCertificate}}</textarea></label>
+			<div class="col-span-2 flex items-center gap-4">
+				<button class="px-3 py-1 rounded bg-[#0066FF] hover:bg-blue-500">Save</button>
+				{{if .SSO}}<span class="text-blue-200/70">Sign-in link for members: <code>{{.SignInURL}}</code></span>{{end}}
+			</div>
+		</form>
+		<form method="post" action="/admin/teams/scim-token" class="mt-4 flex items-center gap-4 text-sm">
+			<input type="hidden" name="id" value="{{.ID}}">
+			<button class="px-3 py-1 rounded border border-gray-700 hover:border-blue-500">{{if .SCIMToken}}Replace SCIM token{{else}}Create SCIM token{{end}}</button>
+			<span class="text-blue-200/70">{{if .SCIMToken}}SCIM provisioning is on. A new token stops the current one working.{{else}}Lets the team's identity provider assign and free seats.{{end}}</span>
+		</form>
+	</div>
+	{{else}}
+	<p class="text-blue-200/70">No team has bought seats yet.</p>
+	{{end}}
+{{end}}`)
+
+// teamMemberRow is a team member with their account and course progress
+type teamMemberRow struct {
+	TeamMember
+	Email            string
+	Name             string
+	LastLoginAt      time.Time
+	ModulesCompleted int
+}
+
+// teamMemberRows resolves the accounts and enrollments of a team's members
+func teamMemberRows(t Team) []teamMemberRow {
+	rows := make([]teamMemberRow, 0, len(t.Members))
+	for _, m := range t.Members {
+		a, _ := accounts.Get(m.AccountID)
+		e, _ := enrollments.Get(m.EnrollmentID)
+		rows = append(rows, teamMemberRow{m, a.Email, a.Name, a.LastLoginAt, e.ModulesCompleted})
+	}
+	return rows
+}
+
+// AdminTeamsHandler lists the teams with their seats and single sign-on settings
+func AdminTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	type teamRow struct {
+		Team
+		Admins     []Account
+		Members    []teamMemberRow
+		SSOOrEmpty SSOConfig
+		SignInURL  string
+	}
+	var rows []teamRow
+	for _, t := range teams.List() {
+		row := teamRow{Team: t, Members: teamMemberRows(t), SignInURL: os.Getenv("DOMAIN_URL") + ssoStartURL(t, "", "")}
+		for _, id := range t.AdminIDs {
+			if a, ok := accounts.Get(id); ok {
+				row.Admins = append(row.Admins, a)
+			}
+		}
+		if t.SSO != nil {
+			row.SSOOrEmpty = *t.SSO
+		}
+		rows = append(rows, row)
+	}
+	slices.Reverse(rows)
+	data := struct {
+		Teams           []teamRow
+		OIDCRedirectURL string
+		SAMLEntityID    string
+		SAMLACSURL      string
+	}{rows, os.Getenv("DOMAIN_URL") + "/sso/oidc/callback", samlEntityID(), samlACSURL()}
+
+	if err := adminTeamsTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering teams page: %v", err)
+	}
+}
+
+// AdminTeamSSOHandler saves a team's email domains and single sign-on settings
+func AdminTeamSSOHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var domains []string
+	for _, domain := range splitList(strings.ToLower(r.FormValue("domains"))) {
+		domain = strings.TrimPrefix(domain, "@")
+		if !validDomain(domain) {
+			http.Error(w, fmt.Sprintf("Invalid domain %q", domain), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	var sso *SSOConfig
+	switch protocol := r.FormValue("protocol"); protocol {
+	case "":
+	case ssoProtocolOIDC:
+		sso = &SSOConfig{
+			Protocol:     protocol,
+			Issuer:       strings.TrimSuffix(strings.TrimSpace(r.FormValue("issuer")), "/"),
+			ClientID:     strings.TrimSpace(r.FormValue("client_id")),
+			ClientSecret: r.FormValue("client_secret"),
+		}
+		if !strings.HasPrefix(sso.Issuer, "https://") || sso.ClientID == "" {
+			http.Error(w, "OpenID Connect needs an https issuer URL and a client ID", http.StatusBadRequest)
+			return
+		}
+	case ssoProtocolSAML:
+		sso = &SSOConfig{
+			Protocol:       protocol,
+			IdPEntityID:    strings.TrimSpace(r.FormValue("idp_entity_id")),
+			IdPSSOURL:      strings.TrimSpace(r.FormValue("idp_sso_url")),
+			IdPCertificate: strings.TrimSpace(r.FormValue("idp_certificate")),
+		}
+		if sso.IdPEntityID == "" || !strings.HasPrefix(sso.IdPSSOURL, "https://") {
+			http.Error(w, "SAML needs the IdP entity ID and an https sign-on URL", http.StatusBadRequest)
+			return
+		}
+		if _, err := parseSAMLCertificate(sso.IdPCertificate); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid IdP certificate: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Unknown single sign-on protocol", http.StatusBadRequest)
+		return
+	}
+	if sso != nil && len(domains) == 0 {
+		http.Error(w, "Single sign-on needs at least one email domain", http.StatusBadRequest)
+		return
+	}
+
+	id := r.FormValue("id")
+	for _, domain := range domains {
+		if other, ok := teams.ForSSODomain(domain); ok && sso != nil && other.ID != id {
+			http.Error(w, fmt.Sprintf("%s already signs in with the single sign-on of %s", domain, other.Name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := teams.Update(id, func(t *Team) error {
+		// Keep the stored client secret unless a new one was entered
+		if sso != nil && sso.Protocol == ssoProtocolOIDC && sso.ClientSecret == "" && t.SSO != nil {
+			sso.ClientSecret = t.SSO.ClientSecret
+		}
+		t.Domains, t.SSO = domains, sso
+		return nil
+	}); err != nil {
+		log.Printf("Error saving team settings: %v", err)
+		http.Error(w, "Error saving team settings", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/teams", http.StatusSeeOther)
+}
+
+var adminSCIMTokenTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">SCIM token for {{.Team}}</h1>
+	<p class="text-blue-200/70 mb-6">Give these to the team's identity provider. The token is shown only once; create a new one if it is lost.</p>
+	<dl class="text-sm grid grid-cols-[auto_1fr] gap-x-4 gap-y-2 mb-6">
+		<dt class="text-blue-200">Base URL</dt><dd><code>{{.BaseURL}}</code></dd>
+		<dt class="text-blue-200">Bearer token</dt><dd><code class="break-all">{{.Token}}</code></dd>
+	</dl>
+	<a href="/admin/teams" class="text-blue-400 hover:text-blue-300">Back to teams</a>
+{{end}}`)
+
+// AdminTeamSCIMTokenHandler creates a team's SCIM bearer token, replacing
+// any earlier one, and shows it once
+func AdminTeamSCIMTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, ok := teams.Get(r.FormValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	token, err := newSCIMToken(t.ID)
+	if err != nil {
+		log.Printf("Error creating SCIM token for team %s: %v", t.Name, err)
+		http.Error(w, "Error creating SCIM token", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created a new SCIM token for team %s", t.Name)
+
+	w.Header().Set("Cache-Control", "no-store")
+	data := struct {
+		Team    string
+		BaseURL string
+		Token   string
+	}{t.Name, os.Getenv("DOMAIN_URL") + scimBasePath, token}
+	if err := adminSCIMTokenTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering SCIM token page: %v", err)
+	}
+}
+
+var adminAccessTmpl = adminTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Access</h1>
+	<p class="text-blue-200/70 mb-6">Every account is a student. Instructors record progress and run live sessions; staff can use the whole admin area except this page. Both need two-factor authentication on their account. Team admins manage only their own team's seats.</p>
+	<h2 class="text-xl font-semibold mb-2">Roles</h2>
+	<table class="w-full text-left text-sm mb-4">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr><th class="py-1">Account</th><th>Role</th><th>2FA</th><th></th></tr>
+		</thead>
+		<tbody>
+		{{range .Grants}}
+			<tr class="border-b border-gray-900">
+				<td class="py-1">{{.Account.Email}}</td>
+				<td>{{.Role}}{{if .Team}} of {{.Team}}{{end}}</td>
+				<td>{{if .Account.TOTPSecret}}on{{else}}<span class="text-yellow-400">off</span>{{end}}</td>
+				<td>
+					{{if not .Team}}
+					<form method="post" action="/admin/access/revoke" onsubmit="return confirm('Take the {{.Role}} role away from {{.Account.Email}}?')">
+						<input type="hidden" name="id" value="{{.Account.ID}}">
+						<input type="hidden" name="role" value="{{.Role}}">
+						<button class="text-red-400 hover:text-red-300">Revoke</button>
+					</form>
+					{{end}}
+				</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="4" class="py-2 text-blue-200/70">Only the admin credentials have access beyond the student area.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+	<form method="post" action="/admin/access/grant" class="flex gap-2 text-sm mb-10">
+		<input type="email" name="email" required placeholder="Account email" class="w-80 bg-gray-900 rounded px-3 py-1">
+		<select name="role" class="bg-gray-900 rounded px-3 py-1">
+			{{range .Roles}}<option value="{{.}}">{{.}}</option>{{end}}
+		</select>
+		<button class="px-3 py-1 rounded bg-[#0066FF] hover:bg-blue-500">Grant</button>
+	</form>
+	<h2 class="text-xl font-semibold mb-2">Denied requests</h2>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-gray-800">
+			<tr><th class="py-1">When</th><th>Who</th><th>Request</th><th>Permission</th><th>Reason</th><th>IP</th></tr>
+		</thead>
+		<tbody>
+		{{range .Denials}}
+			<tr class="border-b border-gray-900">
+				<td class="py-1">{{datetime .At}}</td>
+				<td>{{if .Email}}{{.Email}}{{else}}admin credentials{{end}}</td>
+				<td>{{.Method}} {{.Path}}{{if .TeamID}} (team {{.TeamID}}){{end}}</td>
+				<td>{{if .Permission}}{{.Permission}}{{else}}—{{end}}</td>
+				<td>{{.Reason}}</td>
+				<td>{{.IP}}</td>
+			</tr>
+		{{else}}
+			<tr><td colspan="6" class="py-2 text-blue-200/70">No request has been denied.</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}`)
+
+// AdminAccessHandler lists who has which role and the requests that were denied
+func AdminAccessHandler(w http.ResponseWriter, r *http.Request) {
+	type grant struct {
+		Account Account
+		Role    string
+		Team    string // set for team admins
+	}
+	var grants []grant
+	for _, a := range accounts.List() {
+		for _, role := range a.Roles {
+			grants = append(grants, grant{Account: a, Role: role})
+		}
+	}
+	for _, t := range teams.List() {
+		for _, id := range t.AdminIDs {
+			if a, ok := accounts.Get(id); ok {
+				grants = append(grants, grant{a, roleTeamAdmin, t.Name})
+			}
+		}
+	}
+	data := struct {
+		Grants  []grant
+		Roles   []string
+		Denials []AccessDenial
+	}{grants, grantableRoles, accessLog.Recent(200)}
+
+	if err := adminAccessTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering access page: %v", err)
+	}
+}
+
+// AdminAccessGrantHandler gives an account a role
+func AdminAccessGrantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	role := r.FormValue("role")
+	if !slices.Contains(grantableRoles, role) {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	a, ok := accounts.FindByEmail(r.FormValue("email"))
+	if !ok {
+		http.Error(w, "No account has that email address", http.StatusBadRequest)
+		return
+	}
+	if _, err := accounts.Update(a.ID, func(a *Account) {
+		if !slices.Contains(a.Roles, role) {
+			a.Roles = append(a.Roles, role)
+		}
+	}); err != nil {
+		log.Printf("Error granting %s to %s: %v", role, a.Email, err)
+		http.Error(w, "Error granting the role", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin granted %s the %s role", a.Email, role)
+	http.Redirect(w, r, "/admin/access", http.StatusSeeOther)
+}
+
+// AdminAccessRevokeHandler takes a role away from an account
+func AdminAccessRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	role := r.FormValue("role")
+	a, err := accounts.Update(r.FormValue("id"), func(a *Account) {
+		a.Roles = slices.DeleteFunc(a.Roles, func(r string) bool { return r == role })
+	})
+	if err != nil {
+		log.Printf("Error revoking %s: %v", role, err)
+		http.Error(w, "Error revoking the role", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin took the %s role away from %s", role, a.Email)
+	http.Redirect(w, r, "/admin/access", http.StatusSeeOther)
+}
+
+// Admin two-factor authentication
+const (
+	adminTwoFactorCookie   = "apex_admin_2fa"
+	adminTwoFactorPurpose  = "admin-2fa"
+	adminTwoFactorLifetime = 12 * time.Hour
+)
+
+// adminTOTP remembers the last admin code used, so each code works once
+var adminTOTP struct {
+	mu       sync.Mutex
+	lastStep int64
+}
+
+// useAdminTOTP checks an admin code and marks it used
+func useAdminTOTP(secret, code string) bool {
+	adminTOTP.mu.Lock()
+	defer adminTOTP.mu.Unlock()
+
+	step, ok := verifyTOTP(secret, code, adminTOTP.lastStep)
+	if ok {
+		adminTOTP.lastStep = step
+	}
+	return ok
+}
+
+// adminTwoFactorToken is what the admin two-factor cookie holds. It changes
+// with the admin credentials and secret, so rotating them signs admins out.
+func adminTwoFactorToken() string {
+	sum := sha256.Sum256([]byte(os.Getenv("ADMIN_USERNAME") + "\n" + os.Getenv("ADMIN_PASSWORD") + "\n" + os.Getenv("ADMIN_TOTP_SECRET")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// adminTwoFactorVerified reports whether the admin entered a code recently
+// in this browser
+func adminTwoFactorVerified(r *http.Request) bool {
+	if os.Getenv("ADMIN_TOTP_SECRET") == "" {
+		return false
+	}
+	cookie, err := r.Cookie(adminTwoFactorCookie)
+	if err != nil {
+		return false
+	}
+	payload, ok := verifyExpiringToken(adminTwoFactorPurpose, cookie.Value)
+	return ok && payload == adminTwoFactorToken()
+}
+
+var adminTwoFactorTmpl = adminTemplate(`{{define "content"}}
+	<div class="max-w-md">
+		<h1 class="text-3xl font-bold mb-2">Two-factor authentication</h1>
+		{{if .QRCode}}
+		<p class="text-blue-200/90 mb-4">Admin pages require a code from an authenticator app, and none is set up yet. Scan this QR code, then set the key below as ADMIN_TOTP_SECRET and restart the server.</p>
+		<img src="{{.QRCode}}" alt="QR code" width="240" height="240" class="mb-4 bg-white p-2 rounded">
+		<p class="font-mono mb-4">ADMIN_TOTP_SECRET={{.Secret}}</p>
+		<p class="text-sm text-blue-200/70">Reloading this page shows a different key. Use the one you scanned.</p>
+		{{else}}
+		<p class="text-blue-200/90 mb-6">Enter the 6-digit code from your authenticator app.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/admin/2fa" class="flex flex-col gap-4">
+			<input type="hidden" name="next" value="{{.Next}}">
+			<input type="text" name="code" required autofocus inputmode="numeric" autocomplete="one-time-code" class="bg-gray-900 rounded px-3 py-2">
+			<button class="px-4 py-2 rounded bg-[#0066FF] hover:bg-blue-500">Verify</button>
+		</form>
+		{{end}}
+	</div>
+{{end}}`)
+
+// AdminTwoFactorHandler asks admins for their authenticator code, or helps
+// set up ADMIN_TOTP_SECRET when it is missing
+func AdminTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "/admin/2fa") {
+		next = "/admin/sequences"
+	}
+	data := struct {
+		Next   string
+		Error  string
+		Secret string
+		QRCode template.URL
+	}{Next: next}
+
+	secret := os.Getenv("ADMIN_TOTP_SECRET")
+	if secret == "" {
+		var err error
+		if data.Secret, err = newTOTPSecret(); err == nil {
+			data.QRCode, err = qrDataURL(totpURI(data.Secret, os.Getenv("ADMIN_USERNAME")+" (admin)"))
+		}
+		if err != nil {
+			log.Printf("Error setting up admin two-factor authentication: %v", err)
+			http.Error(w, "Error setting up two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+	} else if r.Method == http.MethodPost {
+		// The limit comes first, so a blocked client cannot use up codes
+		switch {
+		case !signInLimits.AllowPassword(clientIP(r)):
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Error = "Too many attempts. Please wait a while and try again."
+		case !useAdminTOTP(secret, r.FormValue("code")):
+			log.Printf("Failed admin two-factor code from %s", clientIP(r))
+			data.Error = "That code is not valid."
+		default:
+			http.SetCookie(w, &http.Cookie{
+				Name:     adminTwoFactorCookie,
+				Value:    expiringToken(adminTwoFactorPurpose, adminTwoFactorToken(), time.Now().Add(adminTwoFactorLifetime)),
+				Path:     "/admin",
+				MaxAge:   int(adminTwoFactorLifetime.Seconds()),
+				HttpOnly: true,
+				Secure:   secureCookies(),
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := adminTwoFactorTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering admin two-factor page: %v", err)
+	}
+}
+
+// AdminAccountResetTwoFactorHandler turns two-factor authentication off for
+// a student who lost their phone and recovery codes
+func AdminAccountResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, err := accounts.Update(r.FormValue("id"), clearTwoFactor)
+	if err != nil {
+		log.Printf("Error resetting two-factor authentication: %v", err)
+		http.Error(w, "Error resetting two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin reset two-factor authentication of %s", a.Email)
+	http.Redirect(w, r, "/admin/accounts?q="+url.QueryEscape(r.FormValue("q")), http.StatusSeeOther)
+}
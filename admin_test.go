@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postAdminTwoFactor submits a code to the admin two-factor page
+func postAdminTwoFactor(code string) *httptest.ResponseRecorder {
+	form := url.Values{"code": {code}, "next": {"/admin/orders"}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/2fa", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "203.0.113.9:4242"
+	w := httptest.NewRecorder()
+	AdminTwoFactorHandler(w, r)
+	return w
+}
+
+func TestAdminTwoFactorRateLimitedBeforeVerifying(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_TOTP_SECRET", secret)
+	limits := signInLimits
+	t.Cleanup(func() { signInLimits = limits })
+	adminTOTP.lastStep = 0
+	t.Cleanup(func() { adminTOTP.lastStep = 0 })
+	newLimits := func(attempts int) *SignInLimiter {
+		return &SignInLimiter{passwordsIP: NewRateLimiter(attempts, time.Hour)}
+	}
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signInLimits = newLimits(2)
+	for i := 0; i < 2; i++ {
+		if w := postAdminTwoFactor("000000"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "not valid") {
+			t.Fatalf("wrong code: status %d", w.Code)
+		}
+	}
+	w := postAdminTwoFactor(code)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d over the limit, want 429", w.Code)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("two-factor cookie set over the limit")
+	}
+	if adminTOTP.lastStep != 0 {
+		t.Error("a code was used up by a request over the limit")
+	}
+
+	// The code refused over the limit still works once the limit lifts
+	signInLimits = newLimits(10)
+	if w := postAdminTwoFactor(code); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/orders" {
+		t.Fatalf("valid code: status %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := postAdminTwoFactor(code); w.Code != http.StatusOK {
+		t.Errorf("code reused: status %d, want the form again", w.Code)
+	}
+}
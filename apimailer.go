@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiFormat describes how a provider expects emails to be submitted
+type apiFormat struct {
+	authHeader string // header carrying the token; bearer auth when empty
+	awsService string // AWS service the request is signed for instead of sending the token
+	messageID  string // default location of the provider's message ID in the response
+	payload    func(email *Email, message []byte) (any, error)
+}
+
+// apiFormats are the supported provider payload styles
+var apiFormats = map[string]apiFormat{
+	"postmark": {authHeader: "X-Postmark-Server-Token", messageID: "MessageID", payload: postmarkPayload},
+	"sendgrid": {messageID: "header:X-Message-Id", payload: sendgridPayload},
+	"ses":      {awsService: "ses", messageID: "MessageId", payload: sesPayload},
+	"custom":   {messageID: "id"},
+}
+
+// apiMailer submits emails to an HTTP JSON provider API for deployments
+// that cannot open outbound SMTP connections
+type apiMailer struct {
+	url       string
+	token     string
+	format    apiFormat
+	fields    map[string]string
+	messageID string
+	aws       awsCredentials // for formats signed with AWS SigV4
+	client    *http.Client
+}
+
+// apiError is a non-2xx reply from the provider
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("provider API replied %d: %s", e.StatusCode, e.Body)
+}
+
+// newAPIMailer creates the api transport from the email config
+func newAPIMailer(config EmailConfig) (*apiMailer, error) {
+	if config.APIURL == "" {
+		return nil, fmt.Errorf("EMAIL_API_URL is required for the api transport")
+	}
+	format, ok := apiFormats[config.APIFormat]
+	if !ok {
+		return nil, fmt.Errorf("unknown email API format %q", config.APIFormat)
+	}
+	if config.APIFormat == "custom" && len(config.APIFields) == 0 {
+		return nil, fmt.Errorf("EMAIL_API_FIELDS is required for the custom API format")
+	}
+	for field, path := range config.APIFields {
+		if _, ok := apiFieldValues[field]; !ok {
+			return nil, fmt.Errorf("unknown email API field %q", field)
+		}
+		if _, err := parseJSONPath(path); err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_API_FIELDS path for %s: %v", field, err)
+		}
+	}
+
+	m := &apiMailer{
+		url:       config.APIURL,
+		token:     config.APIToken,
+		format:    format,
+		fields:    config.APIFields,
+		messageID: config.APIMessageID,
+		client:    &http.Client{Timeout: config.APITimeout},
+	}
+	if m.messageID == "" {
+		m.messageID = format.messageID
+	}
+	if format.awsService != "" {
+		var err error
+		if m.aws, err = parseAWSCredentials(config.APIToken, config.APIRegion, config.APIURL); err != nil {
+			return nil, err
+		}
+	}
+	if header, ok := strings.CutPrefix(m.messageID, "header:"); ok {
+		if header == "" {
+			return nil, fmt.Errorf("EMAIL_API_MESSAGE_ID names no header")
+		}
+	} else if _, err := parseJSONPath(m.messageID); err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_API_MESSAGE_ID: %v", err)
+	}
+	return m, nil
+}
+
+// Send submits the email and returns the provider's message ID. The built
+// message is used by formats that accept raw MIME.
+func (m *apiMailer) Send(email *Email, message []byte) (sendResult, error) {
+	var payload any
+	var err error
+	if m.format.payload != nil {
+		payload, err = m.format.payload(email, message)
+	} else {
+		payload, err = customPayload(m.fields, email, message)
+	}
+	if err != nil {
+		return sendResult{}, err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return sendResult{}, fmt.Errorf("error encoding API request: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return sendResult{}, fmt.Errorf("error creating API request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if m.format.awsService != "" {
+		m.aws.sign(req, body, m.format.awsService, time.Now())
+	} else if m.token != "" {
+		if m.format.authHeader != "" {
+			req.Header.Set(m.format.authHeader, m.token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+m.token)
+		}
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return sendResult{}, fmt.Errorf("error calling email API: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		text := strings.TrimSpace(string(respBody))
+		if len(text) > 500 {
+			text = text[:500]
+		}
+		return sendResult{}, &apiError{StatusCode: resp.StatusCode, Body: text}
+	}
+
+	result := sendResult{Code: resp.StatusCode}
+	if header, ok := strings.CutPrefix(m.messageID, "header:"); ok {
+		result.ProviderID = resp.Header.Get(header)
+	} else {
+		var decoded any
+		if json.Unmarshal(respBody, &decoded) == nil {
+			result.ProviderID = jsonPathString(decoded, m.messageID)
+		}
+	}
+	return result, nil
+}
+
+// emailHeaders are the headers providers let us set on structured payloads.
+// Our Message-ID goes along so replies and bounces can be matched to the
+// delivery, as they are for SMTP.
+func emailHeaders(email *Email) [][2]string {
+	var headers [][2]string
+	if email.MessageID != "" {
+		headers = append(headers, [2]string{"Message-ID", email.MessageID})
+	}
+	return append(headers, unsubscribeHeaders(email)...)
+}
+
+// emailHeaderMap returns emailHeaders keyed by name
+func emailHeaderMap(email *Email) map[string]string {
+	headers := make(map[string]string)
+	for _, h := range emailHeaders(email) {
+		headers[h[0]] = h[1]
+	}
+	return headers
+}
+
+// postmarkPayload builds a Postmark /email request
+func postmarkPayload(email *Email, message []byte) (any, error) {
+	payload := map[string]any{
+		"From":     email.From,
+		"To":       email.To,
+		"Subject":  email.Subject,
+		"HtmlBody": email.HTMLContent,
+		"TextBody": email.TextContent,
+		"Tag":      email.Template,
+	}
+	var headers []map[string]string
+	for _, h := range emailHeaders(email) {
+		headers = append(headers, map[string]string{"Name": h[0], "Value": h[1]})
+	}
+	if headers != nil {
+		payload["Headers"] = headers
+	}
+	if email.Calendar != "" {
+		payload["Attachments"] = []map[string]string{{
+			"Name":        "invite.ics",
+			"Content":     base64.StdEncoding.EncodeToString([]byte(email.Calendar)),
+			"ContentType": "text/calendar; method=" + email.CalendarMethod,
+		}}
+	}
+	return payload, nil
+}
+
+// sendgridPayload builds a SendGrid v3 /mail/send request
+func sendgridPayload(email *Email, message []byte) (any, error) {
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", email.From, err)
+	}
+	payload := map[string]any{
+		"personalizations": []map[string]any{{"to": []map[string]string{{"email": email.To}}}},
+		"from":             map[string]string{"email": from.Address, "name": from.Name},
+		"subject":          email.Subject,
+		"content": []map[string]string{
+			{"type": "text/plain", "value": email.TextContent},
+			{"type": "text/html", "value": email.HTMLContent},
+		},
+		"categories": []string{email.Category},
+	}
+	if headers := emailHeaderMap(email); len(headers) > 0 {
+		payload["headers"] = headers
+	}
+	if email.Calendar != "" {
+		payload["attachments"] = []map[string]string{{
+			"content":     base64.StdEncoding.EncodeToString([]byte(email.Calendar)),
+			"filename":    "invite.ics",
+			"type":        "text/calendar; method=" + email.CalendarMethod,
+			"disposition": "attachment",
+		}}
+	}
+	return payload, nil
+}
+
+// sesPayload builds an SES v2 SendEmail request with the raw message, which
+// keeps our Message-ID, DKIM signature and calendar parts intact
+func sesPayload(email *Email, message []byte) (any, error) {
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", email.From, err)
+	}
+	return map[string]any{
+		"FromEmailAddress": from.Address,
+		"Destination":      map[string]any{"ToAddresses": []string{email.To}},
+		"Content":          map[string]any{"Raw": map[string]string{"Data": base64.StdEncoding.EncodeToString(message)}},
+		"EmailTags":        []map[string]string{{"Name": "template", "Value": email.Template}},
+	}, nil
+}
+
+// apiFieldValues are the email fields that can be mapped in the custom format
+var apiFieldValues = map[string]func(email *Email, message []byte) any{
+	"from": func(email *Email, _ []byte) any { return email.From },
+	"from_email": func(email *Email, _ []byte) any {
+		if addr, err := mail.ParseAddress(email.From); err == nil {
+			return addr.Address
+		}
+		return email.From
+	},
+	"from_name": func(email *Email, _ []byte) any {
+		if addr, err := mail.ParseAddress(email.From); err == nil {
+			return addr.Name
+		}
+		return ""
+	},
+	"to":         func(email *Email, _ []byte) any { return email.To },
+	"subject":    func(email *Email, _ []byte) any { return email.Subject },
+	"html":       func(email *Email, _ []byte) any { return email.HTMLContent },
+	"text":       func(email *Email, _ []byte) any { return email.TextContent },
+	"template":   func(email *Email, _ []byte) any { return email.Template },
+	"category":   func(email *Email, _ []byte) any { return email.Category },
+	"message_id": func(email *Email, _ []byte) any { return email.MessageID },
+	"headers":    func(email *Email, _ []byte) any { return emailHeaderMap(email) },
+	"raw":        func(_ *Email, message []byte) any { return base64.StdEncoding.EncodeToString(message) },
+	"calendar": func(email *Email, _ []byte) any {
+		if email.Calendar == "" {
+			return ""
+		}
+		return base64.StdEncoding.EncodeToString([]byte(email.Calendar))
+	},
+	"calendar_method": func(email *Email, _ []byte) any { return email.CalendarMethod },
+}
+
+// customPayload builds a request from EMAIL_API_FIELDS, which maps email
+// fields to JSON paths such as "personalizations[0].to[0].email". Empty
+// values are left out.
+func customPayload(fields map[string]string, email *Email, message []byte) (any, error) {
+	payload := make(map[string]any)
+	for field, path := range fields {
+		value := apiFieldValues[field](email, message)
+		if s, ok := value.(string); ok && s == "" {
+			continue
+		}
+		if m, ok := value.(map[string]string); ok && len(m) == 0 {
+			continue
+		}
+		segments, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		setJSONPath(payload, segments, value)
+	}
+	return payload, nil
+}
+
+// parseAPIFields parses "field=path" pairs separated by commas
+func parseAPIFields(value string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, pair := range splitList(value) {
+		field, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid EMAIL_API_FIELDS entry %q", pair)
+		}
+		fields[strings.TrimSpace(field)] = strings.TrimSpace(path)
+	}
+	return fields, nil
+}
+
+// jsonPathSegment is one key of a JSON path, optionally indexing into an array
+type jsonPathSegment struct {
+	key   string
+	index int // -1 when the key is not indexed
+}
+
+// parseJSONPath splits a path such as "content[1].value" into segments
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	var segments []jsonPathSegment
+	for _, part := range strings.Split(path, ".") {
+		segment := jsonPathSegment{key: part, index: -1}
+		if open := strings.Index(part, "["); open >= 0 && strings.HasSuffix(part, "]") {
+			index, err := strconv.Atoi(part[open+1 : len(part)-1])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index in JSON path %q", path)
+			}
+			segment = jsonPathSegment{key: part[:open], index: index}
+		}
+		if segment.key == "" || strings.ContainsAny(segment.key, "[]") {
+			return nil, fmt.Errorf("invalid JSON path %q", path)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// setJSONPath stores value at the path, creating objects and arrays on the way
+func setJSONPath(node any, segments []jsonPathSegment, value any) any {
+	if len(segments) == 0 {
+		return value
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		obj = make(map[string]any)
+	}
+	segment := segments[0]
+	if segment.index < 0 {
+		obj[segment.key] = setJSONPath(obj[segment.key], segments[1:], value)
+		return obj
+	}
+	list, _ := obj[segment.key].([]any)
+	for len(list) <= segment.index {
+		list = append(list, nil)
+	}
+	list[segment.index] = setJSONPath(list[segment.index], segments[1:], value)
+	obj[segment.key] = list
+	return obj
+}
+
+// jsonPathString returns the string or number found at the path, or ""
+func jsonPathString(node any, path string) string {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return ""
+	}
+	for _, segment := range segments {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return ""
+		}
+		node = obj[segment.key]
+		if segment.index >= 0 {
+			list, ok := node.([]any)
+			if !ok || segment.index >= len(list) {
+				return ""
+			}
+			node = list[segment.index]
+		}
+	}
+	switch v := node.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
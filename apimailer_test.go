@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiTestEmail is the email every provider request is built from
+func apiTestEmail() *Email {
+	return &Email{
+		To:             "ada@example.com",
+		From:           "APEX AI <hello@apex.test>",
+		Subject:        "Welcome",
+		HTMLContent:    "<p>Hello</p>",
+		TextContent:    "Hello",
+		Template:       "welcome",
+		Category:       "marketing",
+		UnsubscribeURL: "https://apex.test/unsubscribe/1",
+		MessageID:      "<m1@apex.test>",
+	}
+}
+
+// apiTestRequest is what the fake provider received
+type apiTestRequest struct {
+	header http.Header
+	body   any
+}
+
+// startAPITestServer runs a provider that records the request and answers
+// with the given status, headers and body
+func startAPITestServer(t *testing.T, status int, header map[string]string, body string) (*httptest.Server, *apiTestRequest) {
+	t.Helper()
+	received := &apiTestRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s", r.Method)
+		}
+		received.header = r.Header.Clone()
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &received.body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		for name, value := range header {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestAPIMailerSend(t *testing.T) {
+	message := []byte("From: hello@apex.test\r\n\r\nHello\r\n")
+	tests := []struct {
+		format     string
+		fields     map[string]string
+		respHeader map[string]string
+		respBody   string
+		authHeader string
+		authValue  string
+		want       map[string]string // JSON path in the request body => value
+		wantID     string
+	}{
+		{
+			format:     "postmark",
+			respBody:   `{"MessageID":"pm-1","ErrorCode":0}`,
+			authHeader: "X-Postmark-Server-Token",
+			authValue:  "token",
+			want: map[string]string{
+				"From": "APEX AI <hello@apex.test>", "To": "ada@example.com", "Subject": "Welcome",
+				"HtmlBody": "<p>Hello</p>", "TextBody": "Hello", "Tag": "welcome",
+				"Headers[0].Name": "Message-ID", "Headers[0].Value": "<m1@apex.test>",
+				"Headers[1].Name": "List-Unsubscribe", "Headers[1].Value": "<https://apex.test/unsubscribe/1>",
+			},
+			wantID: "pm-1",
+		},
+		{
+			format:     "sendgrid",
+			respHeader: map[string]string{"X-Message-Id": "sg-1"},
+			authHeader: "Authorization",
+			authValue:  "Bearer token",
+			want: map[string]string{
+				"personalizations[0].to[0].email": "ada@example.com",
+				"from.email":                      "hello@apex.test", "from.name": "APEX AI",
+				"subject":          "Welcome",
+				"content[0].value": "Hello", "content[1].value": "<p>Hello</p>",
+				"categories[0]":                 "marketing",
+				"headers.List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+				"headers.Message-ID":            "<m1@apex.test>",
+			},
+			wantID: "sg-1",
+		},
+		{
+			format: "custom",
+			fields: map[string]string{
+				"to": "recipients[0].address", "from_email": "sender.email", "from_name": "sender.name",
+				"subject": "subject", "html": "body.html", "text": "body.text", "message_id": "message_id",
+				"calendar": "attachments[0].content",
+			},
+			respBody:   `{"id":42}`,
+			authHeader: "Authorization",
+			authValue:  "Bearer token",
+			want: map[string]string{
+				"recipients[0].address": "ada@example.com", "sender.email": "hello@apex.test", "sender.name": "APEX AI",
+				"subject": "Welcome", "body.html": "<p>Hello</p>", "body.text": "Hello", "message_id": "<m1@apex.test>",
+				"attachments[0].content": "", // no calendar, so left out
+			},
+			wantID: "42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			server, received := startAPITestServer(t, http.StatusOK, tt.respHeader, tt.respBody)
+			mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL, APIToken: "token", APIFormat: tt.format, APIFields: tt.fields, APITimeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := mailer.Send(apiTestEmail(), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Code != http.StatusOK || result.ProviderID != tt.wantID {
+				t.Errorf("result = %+v, want ID %q", result, tt.wantID)
+			}
+			if got := received.header.Get(tt.authHeader); got != tt.authValue {
+				t.Errorf("%s = %q, want %q", tt.authHeader, got, tt.authValue)
+			}
+			if tt.authHeader != "Authorization" && received.header.Get("Authorization") != "" {
+				t.Errorf("token also sent as a bearer token")
+			}
+			if ct := received.header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			for path, want := range tt.want {
+				if got := jsonPathString(received.body, path); got != want {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIMailerNoToken(t *testing.T) {
+	server, received := startAPITestServer(t, http.StatusAccepted, nil, `{}`)
+	mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL, APIFormat: "sendgrid", APITimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := mailer.Send(apiTestEmail(), []byte("raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != http.StatusAccepted || result.ProviderID != "" {
+		t.Errorf("result = %+v", result)
+	}
+	if auth := received.header.Get("Authorization"); auth != "" {
+		t.Errorf("Authorization = %q without a token", auth)
+	}
+}
+
+func TestAPIMailerSES(t *testing.T) {
+	message := []byte("From: hello@apex.test\r\nMessage-ID: <m1@apex.test>\r\n\r\nHello\r\n")
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Clone(r.Context())
+		body, _ = io.ReadAll(r.Body)
+		io.WriteString(w, `{"MessageId":"ses-1"}`)
+	}))
+	t.Cleanup(server.Close)
+	mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL + "/v2/email/outbound-emails", APIToken: "AKID:secret", APIRegion: "eu-west-1", APIFormat: "ses", APITimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := mailer.Send(apiTestEmail(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProviderID != "ses-1" {
+		t.Errorf("ProviderID = %q, want ses-1", result.ProviderID)
+	}
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"FromEmailAddress":           "hello@apex.test",
+		"Destination.ToAddresses[0]": "ada@example.com",
+		"Content.Raw.Data":           base64.StdEncoding.EncodeToString(message),
+		"EmailTags[0].Value":         "welcome",
+	} {
+		if got := jsonPathString(payload, path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	// The request is signed as it arrived, and the keys never travel
+	auth := received.Header.Get("Authorization")
+	if strings.Contains(auth, "secret") || strings.HasPrefix(auth, "Bearer") {
+		t.Fatalf("Authorization = %q sends the token", auth)
+	}
+	date, err := time.Parse("20060102T150405Z", received.Header.Get("X-Amz-Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err := http.NewRequest(http.MethodPost, "http://"+received.Host+received.URL.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check.Header.Set("Content-Type", received.Header.Get("Content-Type"))
+	awsCredentials{accessKey: "AKID", secretKey: "secret", region: "eu-west-1"}.sign(check, body, "ses", date)
+	if want := check.Header.Get("Authorization"); auth != want {
+		t.Errorf("Authorization = %s\nwant %s", auth, want)
+	}
+	if !strings.Contains(auth, "Credential=AKID/"+date.Format("20060102")+"/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date,") {
+		t.Errorf("Authorization = %s, want an SES scope", auth)
+	}
+}
+
+func TestAPIMailerErrors(t *testing.T) {
+	t.Run("non-2xx", func(t *testing.T) {
+		for _, status := range []int{http.StatusUnprocessableEntity, http.StatusTooManyRequests, http.StatusInternalServerError} {
+			server, _ := startAPITestServer(t, status, nil, "  "+strings.Repeat("x", 600)+"\n")
+			mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL, APIFormat: "postmark", APITimeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = mailer.Send(apiTestEmail(), nil)
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want an apiError", err)
+			}
+			if apiErr.StatusCode != status || apiErr.Body != strings.Repeat("x", 500) {
+				t.Errorf("apiError = %d with a %d byte body", apiErr.StatusCode, len(apiErr.Body))
+			}
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		t.Cleanup(server.Close)
+		t.Cleanup(func() { close(release) })
+		mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL, APIFormat: "postmark", APITimeout: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		_, err = mailer.Send(apiTestEmail(), nil)
+		if err == nil || !strings.Contains(err.Error(), "error calling email API") || !strings.Contains(err.Error(), "Timeout") {
+			t.Errorf("err = %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("send took %v with a 100ms timeout", elapsed)
+		}
+	})
+
+	t.Run("invalid sender", func(t *testing.T) {
+		server, _ := startAPITestServer(t, http.StatusOK, nil, `{}`)
+		mailer, err := newAPIMailer(EmailConfig{APIURL: server.URL, APIFormat: "sendgrid", APITimeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		email := apiTestEmail()
+		email.From = "not an address"
+		if _, err := mailer.Send(email, nil); err == nil || !strings.Contains(err.Error(), "invalid sender") {
+			t.Errorf("err = %v, want an invalid sender", err)
+		}
+	})
+}
+
+func TestAPIMailerMessageID(t *testing.T) {
+	tests := []struct {
+		messageID  string // EMAIL_API_MESSAGE_ID
+		respHeader map[string]string
+		respBody   string
+		want       string
+	}{
+		{"", nil, `{"id":"default-1"}`, "default-1"},
+		{"data.message.id", nil, `{"data":{"message":{"id":"nested-1"}}}`, "nested-1"},
+		{"results[1].id", nil, `{"results":[{"id":"a"},{"id":"b"}]}`, "b"},
+		{"results[2].id", nil, `{"results":[{"id":"a"}]}`, ""},
+		{"id", nil, `{"id":1234567890123}`, "1234567890123"},
+		{"id", nil, `{"id":true}`, ""},
+		{"id", nil, `not json`, ""},
+		{"header:X-Request-Id", map[string]string{"X-Request-Id": "hdr-1"}, `{"id":"body-1"}`, "hdr-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.messageID, func(t *testing.T) {
+			server, _ := startAPITestServer(t, http.StatusOK, tt.respHeader, tt.respBody)
+			mailer, err := newAPIMailer(EmailConfig{
+				APIURL:       server.URL,
+				APIFormat:    "custom",
+				APIFields:    map[string]string{"to": "to", "raw": "mime"},
+				APIMessageID: tt.messageID,
+				APITimeout:   time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := mailer.Send(apiTestEmail(), []byte("raw"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ProviderID != tt.want {
+				t.Errorf("ProviderID = %q, want %q", result.ProviderID, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAPIMailerValidatesConfig(t *testing.T) {
+	valid := EmailConfig{APIURL: "https://api.example.com/send", APIFormat: "custom", APIFields: map[string]string{"to": "to"}}
+	tests := []struct {
+		name    string
+		change  func(*EmailConfig)
+		wantErr string
+	}{
+		{"valid", func(c *EmailConfig) {}, ""},
+		{"indexed path", func(c *EmailConfig) { c.APIFields["to"] = "personalizations[0].to[0].email" }, ""},
+		{"no URL", func(c *EmailConfig) { c.APIURL = "" }, "EMAIL_API_URL"},
+		{"unknown format", func(c *EmailConfig) { c.APIFormat = "mailgun" }, "unknown email API format"},
+		{"custom without fields", func(c *EmailConfig) { c.APIFields = nil }, "EMAIL_API_FIELDS is required"},
+		{"unknown field", func(c *EmailConfig) { c.APIFields["bcc"] = "bcc" }, "unknown email API field"},
+		{"empty path", func(c *EmailConfig) { c.APIFields["to"] = "" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"empty segment", func(c *EmailConfig) { c.APIFields["to"] = "recipient..email" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"index not a number", func(c *EmailConfig) { c.APIFields["to"] = "to[first]" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"negative index", func(c *EmailConfig) { c.APIFields["to"] = "to[-1]" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"unclosed index", func(c *EmailConfig) { c.APIFields["to"] = "to[0" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"index without key", func(c *EmailConfig) { c.APIFields["to"] = "[0]" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"stray bracket", func(c *EmailConfig) { c.APIFields["to"] = "to]" }, "invalid EMAIL_API_FIELDS path for to"},
+		{"invalid message ID path", func(c *EmailConfig) { c.APIMessageID = "data..id" }, "invalid EMAIL_API_MESSAGE_ID"},
+		{"message ID header without name", func(c *EmailConfig) { c.APIMessageID = "header:" }, "names no header"},
+		{"ses without access keys", func(c *EmailConfig) { c.APIFormat, c.APIToken = "ses", "token" }, "ACCESS_KEY_ID:SECRET_ACCESS_KEY"},
+		{"ses without region", func(c *EmailConfig) { c.APIFormat, c.APIToken = "ses", "AKID:secret" }, "EMAIL_API_REGION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			config.APIFields = map[string]string{}
+			for field, path := range valid.APIFields {
+				config.APIFields[field] = path
+			}
+			tt.change(&config)
+			_, err := newAPIMailer(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Student sign-in settings
+const (
+	sessionCookie       = "apex_session"
+	deviceCookie        = "apex_device"
+	deviceLifetime      = 400 * 24 * time.Hour // the longest browsers keep a cookie
+	signInTokenPurpose  = "sign-in"
+	sessionLifetime     = 30 * 24 * time.Hour // default absolute session timeout
+	signInLinkLifetime  = 15 * time.Minute
+	welcomeLinkLifetime = 7 * 24 * time.Hour // the first sign-in link in the welcome email
+	signInLinksFile     = "sign_in_links.json"
+)
+
+// SignInLinkStore remembers issued sign-in and password reset links so each
+// one works only once
+type SignInLinkStore struct {
+	mu    sync.Mutex
+	links map[string]*SignInLink // keyed by the link's nonce
+}
+
+// NewSignInLinkStore creates a sign-in link store backed by the data directory
+func NewSignInLinkStore() (*SignInLinkStore, error) {
+	s := &SignInLinkStore{links: make(map[string]*SignInLink)}
+	if err := loadJSON(signInLinksFile, &s.links); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Issue records a new link for the account and returns its nonce
+func (s *SignInLinkStore) Issue(accountID string, expires time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating sign-in nonce: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for n, link := range s.links {
+		if now.After(link.ExpiresAt) {
+			delete(s.links, n)
+		}
+	}
+	key := hex.EncodeToString(nonce)
+	s.links[key] = &SignInLink{AccountID: accountID, ExpiresAt: expires}
+	return key, s.save()
+}
+
+// Consume uses up a link. It reports false when the link was already used,
+// has expired or belongs to another account.
+func (s *SignInLinkStore) Consume(nonce, accountID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[nonce]
+	if !ok || link.AccountID != accountID || time.Now().After(link.ExpiresAt) {
+		return false
+	}
+	delete(s.links, nonce)
+	if err := s.save(); err != nil {
+		log.Printf("Error saving sign-in links: %v", err)
+	}
+	return true
+}
+
+// Valid reports whether a link can still be used, without using it up
+func (s *SignInLinkStore) Valid(nonce, accountID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[nonce]
+	return ok && link.AccountID == accountID && time.Now().Before(link.ExpiresAt)
+}
+
+// save writes the sign-in links to disk; callers must hold s.mu
+func (s *SignInLinkStore) save() error {
+	return saveJSON(signInLinksFile, s.links)
+}
+
+// SignInLimiter caps how many sign-in links can be requested per address and
+// per IP, and how many passwords each IP can try
+type SignInLimiter struct {
+	byEmail     *RateLimiter
+	byIP        *RateLimiter
+	passwordsIP *RateLimiter
+}
+
+// NewSignInLimiter reads the hourly sign-in request limits from the environment
+func NewSignInLimiter() (*SignInLimiter, error) {
+	perEmail, err := envInt("SIGN_IN_LIMIT_PER_EMAIL", 5)
+	if err != nil {
+		return nil, err
+	}
+	perIP, err := envInt("SIGN_IN_LIMIT_PER_IP", 20)
+	if err != nil {
+		return nil, err
+	}
+	passwordsPerIP, err := envInt("SIGN_IN_PASSWORD_LIMIT_PER_IP", 30)
+	if err != nil {
+		return nil, err
+	}
+	return &SignInLimiter{
+		byEmail:     NewRateLimiter(perEmail, time.Hour),
+		byIP:        NewRateLimiter(perIP, time.Hour),
+		passwordsIP: NewRateLimiter(passwordsPerIP, time.Hour),
+	}, nil
+}
+
+// Allow records a sign-in request and reports whether it is within both limits
+func (l *SignInLimiter) Allow(email, ip string) bool {
+	return l.byIP.Allow(ip) && l.byEmail.Allow(strings.ToLower(email))
+}
+
+// AllowPassword records a password attempt and reports whether the IP may make it
+func (l *SignInLimiter) AllowPassword(ip string) bool {
+	return l.passwordsIP.Allow(ip)
+}
+
+// secureCookies reports whether cookies should only travel over HTTPS
+func secureCookies() bool {
+	return strings.HasPrefix(os.Getenv("DOMAIN_URL"), "https://")
+}
+
+// expiringToken signs a payload that is only valid until the given time
+func expiringToken(purpose, payload string, expires time.Time) string {
+	return signToken(purpose, payload+"\n"+strconv.FormatInt(expires.Unix(), 10))
+}
+
+// verifyExpiringToken returns the payload of an expiringToken that has not expired
+func verifyExpiringToken(purpose, token string) (string, bool) {
+	payload, ok := verifyToken(purpose, token)
+	if !ok {
+		return "", false
+	}
+	i := strings.LastIndex(payload, "\n")
+	if i < 0 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+	return payload[:i], true
+}
+
+// signInURL issues a single-use link that signs the account in and continues to next
+func signInURL(a Account, next string, lifetime time.Duration) (string, error) {
+	return accountLink(signInTokenPurpose, "/login/verify", a, next, lifetime)
+}
+
+// accountLink issues a single-use link to path for the account
+func accountLink(purpose, path string, a Account, next string, lifetime time.Duration) (string, error) {
+	expires := time.Now().Add(lifetime)
+	nonce, err := signInLinks.Issue(a.ID, expires)
+	if err != nil {
+		return "", err
+	}
+	token := expiringToken(purpose, a.ID+"\n"+nonce, expires)
+	link := os.Getenv("DOMAIN_URL") + path + "?token=" + url.QueryEscape(token)
+	if next = localPath(next); next != "/courses" {
+		link += "&next=" + url.QueryEscape(next)
+	}
+	return link, nil
+}
+
+// parseAccountLink returns the account an accountLink token is for. With
+// consume set the link is used up.
+func parseAccountLink(purpose, token string, consume bool) (string, bool) {
+	payload, ok := verifyExpiringToken(purpose, token)
+	if !ok {
+		return "", false
+	}
+	id, nonce, ok := strings.Cut(payload, "\n")
+	if !ok {
+		return "", false
+	}
+	if consume {
+		return id, signInLinks.Consume(nonce, id)
+	}
+	return id, signInLinks.Valid(nonce, id)
+}
+
+// Ways a session was signed in
+const (
+	signInMethodLink     = "link"
+	signInMethodPassword = "password"
+	signInMethodSSO      = "sso"
+)
+
+// startSession signs the account in on this browser. Any session the browser
+// already had is ended, so every sign-in gets a new session ID, and so are the
+// account's oldest sessions when it goes over the session limits.
+func startSession(w http.ResponseWriter, r *http.Request, a Account, method string, twoFactor bool) error {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := loginSessions.RevokeToken(cookie.Value); err != nil {
+			log.Printf("Error ending previous session: %v", err)
+		}
+	}
+	deviceID, err := browserDevice(w, r)
+	if err != nil {
+		return err
+	}
+	token, session, evicted, err := loginSessions.Create(a.ID, method, deviceID, twoFactor, r)
+	if err != nil {
+		return err
+	}
+	if _, err := accounts.Update(a.ID, func(a *Account) { a.LastLoginAt = session.CreatedAt }); err != nil {
+		log.Printf("Error recording sign-in of %s: %v", a.Email, err)
+	}
+	recordLogin(a, session, evicted)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(loginSessions.absoluteTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// browserDevice returns the ID that tells the browser apart from the
+// student's other devices, issuing one on its first sign-in. The cookie
+// outlives sessions, so signing out and in again stays on the same device.
+func browserDevice(w http.ResponseWriter, r *http.Request) (string, error) {
+	deviceID := ""
+	if cookie, err := r.Cookie(deviceCookie); err == nil {
+		if b, err := hex.DecodeString(cookie.Value); err == nil && len(b) == 16 {
+			deviceID = cookie.Value
+		}
+	}
+	if deviceID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", fmt.Errorf("error generating device ID: %v", err)
+		}
+		deviceID = hex.EncodeToString(id)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    deviceID,
+		Path:     "/",
+		MaxAge:   int(deviceLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return deviceID, nil
+}
+
+// endSession signs the browser out
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := loginSessions.RevokeToken(cookie.Value); err != nil {
+			log.Printf("Error ending session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentSession returns the session of the request's browser and its account
+func currentSession(r *http.Request) (LoginSession, Account, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return LoginSession{}, Account{}, false
+	}
+	session, ok := loginSessions.Lookup(cookie.Value)
+	if !ok {
+		return LoginSession{}, Account{}, false
+	}
+	a, ok := accounts.Get(session.AccountID)
+	if !ok {
+		return LoginSession{}, Account{}, false
+	}
+	return session, a, true
+}
+
+// currentAccount returns the account signed in on the request's browser
+func currentAccount(r *http.Request) (Account, bool) {
+	_, a, ok := currentSession(r)
+	return a, ok
+}
+
+// requireStudent sends visitors who are not signed in to the login page
+func requireStudent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentAccount(r); !ok {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// localPath returns next when it is a path on this site, so sign-in never
+// redirects to another site, and the dashboard otherwise
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/courses"
+	}
+	return next
+}
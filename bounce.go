@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Bounce classifications
+const (
+	bounceHard      = "hard"
+	bounceSoft      = "soft"
+	bounceComplaint = "complaint"
+)
+
+// softBounceLimit is how many soft bounces in a row block an address
+const softBounceLimit = 3
+
+// errNotReport is returned for messages that are neither a DSN nor an ARF report
+var errNotReport = errors.New("message is not a delivery status or feedback report")
+
+// BounceReport is a single bounced or complained-about recipient
+type BounceReport struct {
+	Recipient  string
+	Type       string // hard, soft or complaint
+	Status     string // RFC 3463 enhanced status code, e.g. 5.1.1
+	Diagnostic string
+}
+
+// parseReport extracts bounce and complaint reports from a DSN (RFC 3464)
+// or ARF (RFC 5965) message
+func parseReport(r io.Reader) ([]BounceReport, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, errNotReport
+	}
+
+	var reports []BounceReport
+	var feedback textproto.MIMEHeader
+	var originalTo string
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading report: %v", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			fields, err := readFieldGroups(part)
+			if err != nil {
+				return nil, err
+			}
+			// The first group describes the message, the rest one recipient each
+			for _, recipient := range fields[min(1, len(fields)):] {
+				if report, ok := classifyDSN(recipient); ok {
+					reports = append(reports, report)
+				}
+			}
+		case "message/feedback-report":
+			fields, err := readFieldGroups(part)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) > 0 {
+				feedback = fields[0]
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			// Only the headers matter; a truncated copy still yields them
+			original, _ := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			originalTo = original.Get("To")
+		}
+	}
+
+	if feedback != nil {
+		recipient := fieldAddress(feedback.Get("Original-Rcpt-To"))
+		if recipient == "" {
+			if addr, err := mail.ParseAddress(originalTo); err == nil {
+				recipient = addr.Address
+			}
+		}
+		feedbackType := strings.ToLower(feedback.Get("Feedback-Type"))
+		if recipient != "" && feedbackType != "not-spam" {
+			reports = append(reports, BounceReport{Recipient: recipient, Type: bounceComplaint, Diagnostic: feedbackType})
+		}
+	}
+
+	if len(reports) == 0 && feedback == nil {
+		return nil, errNotReport
+	}
+	return reports, nil
+}
+
+// readFieldGroups reads blank-line separated groups of header fields
+func readFieldGroups(r io.Reader) ([]textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	var groups []textproto.MIMEHeader
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if len(fields) > 0 {
+			groups = append(groups, fields)
+		}
+		if err == io.EOF {
+			return groups, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading report fields: %v", err)
+		}
+	}
+}
+
+// classifyDSN turns a per-recipient DSN field group into a bounce report
+func classifyDSN(fields textproto.MIMEHeader) (BounceReport, bool) {
+	recipient := fieldAddress(fields.Get("Final-Recipient"))
+	if recipient == "" {
+		recipient = fieldAddress(fields.Get("Original-Recipient"))
+	}
+	if recipient == "" {
+		return BounceReport{}, false
+	}
+
+	report := BounceReport{
+		Recipient:  recipient,
+		Status:     strings.TrimSpace(fields.Get("Status")),
+		Diagnostic: strings.TrimSpace(fields.Get("Diagnostic-Code")),
+	}
+	switch strings.ToLower(strings.TrimSpace(fields.Get("Action"))) {
+	case "failed":
+		report.Type = bounceSoft
+		if strings.HasPrefix(report.Status, "5") {
+			report.Type = bounceHard
+		}
+	case "delayed":
+		report.Type = bounceSoft
+	default:
+		// delivered, relayed and expanded are not failures
+		return BounceReport{}, false
+	}
+	return report, true
+}
+
+// fieldAddress extracts the address from a typed field such as "rfc822; jane@example.com"
+func fieldAddress(value string) string {
+	if _, addr, ok := strings.Cut(value, ";"); ok {
+		value = addr
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
+
+// BounceProcessor applies bounce and complaint reports to the suppression list
+// and flags affected orders for support
+type BounceProcessor struct {
+	suppressions *SuppressionStore
+	enrollments  *EnrollmentStore
+	maildir      string
+	imap         imapConfig
+}
+
+// NewBounceProcessor creates a processor for the configured return-path mailbox
+func NewBounceProcessor(suppressions *SuppressionStore, enrollments *EnrollmentStore) *BounceProcessor {
+	mailbox := os.Getenv("BOUNCE_IMAP_MAILBOX")
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	return &BounceProcessor{
+		suppressions: suppressions,
+		enrollments:  enrollments,
+		maildir:      os.Getenv("BOUNCE_MAILDIR"),
+		imap: imapConfig{
+			Addr:     os.Getenv("BOUNCE_IMAP_ADDR"),
+			Username: os.Getenv("BOUNCE_IMAP_USERNAME"),
+			Password: os.Getenv("BOUNCE_IMAP_PASSWORD"),
+			Mailbox:  mailbox,
+		},
+	}
+}
+
+// Start polls the maildir and IMAP mailbox every interval until the process exits
+func (p *BounceProcessor) Start(interval time.Duration) {
+	if p.maildir == "" && p.imap.Addr == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if p.maildir != "" {
+				if err := p.ProcessMaildir(p.maildir); err != nil {
+					log.Printf("Error processing bounce maildir: %v", err)
+				}
+			}
+			if p.imap.Addr != "" {
+				if err := p.ProcessIMAP(p.imap); err != nil {
+					log.Printf("Error processing bounce mailbox: %v", err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Process parses a single message and applies its reports
+func (p *BounceProcessor) Process(r io.Reader) (int, error) {
+	reports, err := parseReport(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, report := range reports {
+		if err := p.apply(report); err != nil {
+			return 0, err
+		}
+	}
+	return len(reports), nil
+}
+
+// apply updates the suppression list and flags orders for a single report
+func (p *BounceProcessor) apply(report BounceReport) error {
+	log.Printf("Received %s report for %s: %s %s", report.Type, report.Recipient, report.Status, report.Diagnostic)
+
+	var blocked bool
+	var err error
+	switch report.Type {
+	case bounceHard:
+		blocked, err = true, p.suppressions.Block(report.Recipient, "hard bounce "+report.Status)
+	case bounceSoft:
+		blocked, err = p.suppressions.RecordSoftBounce(report.Recipient, "soft bounce "+report.Status)
+	case bounceComplaint:
+		var all []string
+		for _, c := range emailCategories {
+			all = append(all, c.Name)
+		}
+		err = p.suppressions.SetUnsubscribed(report.Recipient, all)
+	}
+	if err != nil || !blocked {
+		return err
+	}
+
+	// The customer never got their emails; support needs to call them
+	issue := fmt.Sprintf("%s bounce %s %s", report.Type, report.Status, report.Diagnostic)
+	for _, e := range p.enrollments.FindByEmail(report.Recipient) {
+		if err := p.enrollments.Update(e.ID, func(e *Enrollment) {
+			e.EmailIssue = strings.TrimSpace(issue)
+			e.EmailIssueAt = time.Now()
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProcessMaildir processes every new message in a maildir and moves it to cur
+func (p *BounceProcessor) ProcessMaildir(dir string) error {
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return fmt.Errorf("error reading maildir: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "new", entry.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %v", entry.Name(), err)
+		}
+		_, err = p.Process(f)
+		f.Close()
+		if err != nil && !errors.Is(err, errNotReport) {
+			log.Printf("Error processing bounce %s: %v", entry.Name(), err)
+			continue
+		}
+
+		// Mark the message as seen so it is not processed again
+		if err := os.Rename(path, filepath.Join(dir, "cur", entry.Name()+":2,S")); err != nil {
+			return fmt.Errorf("error moving %s to cur: %v", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// ProcessIMAP processes every unseen message in an IMAP mailbox and marks it seen
+func (p *BounceProcessor) ProcessIMAP(config imapConfig) error {
+	client, err := dialIMAP(config)
+	if err != nil {
+		return err
+	}
+	defer client.Logout()
+
+	uids, err := client.UnseenUIDs()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		message, err := client.Fetch(uid)
+		if err != nil {
+			return err
+		}
+		if _, err := p.Process(strings.NewReader(string(message))); err != nil && !errors.Is(err, errNotReport) {
+			log.Printf("Error processing bounce UID %d: %v", uid, err)
+			continue
+		}
+		if err := client.MarkSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BounceWebhookHandler accepts a raw DSN or ARF message posted by an inbound mail provider
+func BounceWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := os.Getenv("BOUNCE_WEBHOOK_TOKEN")
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	n, err := bounces.Process(http.MaxBytesReader(w, r.Body, 10<<20))
+	if errors.Is(err, errNotReport) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		log.Printf("Error processing bounce webhook: %v", err)
+		http.Error(w, "Error processing report", http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "processed %d reports\n", n)
+}
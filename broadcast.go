@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const broadcastsFile = "broadcasts.json"
+
+// Broadcast statuses
+const (
+	broadcastDraft     = "draft"
+	broadcastScheduled = "scheduled"
+	broadcastSending   = "sending"
+	broadcastSent      = "sent"
+)
+
+// Segment kinds
+const (
+	segmentAll      = "all"
+	segmentProduct  = "product"
+	segmentCohort   = "cohort"
+	segmentCompany  = "company"
+	segmentProgress = "progress"
+)
+
+// broadcastTemplates are the email templates a broadcast can be written in
+var broadcastTemplates = []string{"broadcast_announcement", "broadcast_new_module"}
+
+// BroadcastStore keeps announcements and sends them when they are due
+type BroadcastStore struct {
+	mu         sync.Mutex
+	broadcasts map[string]*Broadcast
+}
+
+// NewBroadcastStore creates a broadcast store backed by the data directory
+func NewBroadcastStore() (*BroadcastStore, error) {
+	s := &BroadcastStore{broadcasts: make(map[string]*Broadcast)}
+	if err := loadJSON(broadcastsFile, &s.broadcasts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create stores a new draft broadcast and assigns its ID
+func (s *BroadcastStore) Create(b Broadcast) (Broadcast, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Broadcast{}, fmt.Errorf("error generating broadcast ID: %v", err)
+	}
+	b.ID = hex.EncodeToString(id)
+	b.Status = broadcastDraft
+	b.CreatedAt = time.Now()
+	b.UpdatedAt = b.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broadcasts[b.ID] = &b
+	return b, s.save()
+}
+
+// Get returns the broadcast with the given ID
+func (s *BroadcastStore) Get(id string) (Broadcast, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.broadcasts[id]
+	if !ok {
+		return Broadcast{}, false
+	}
+	return *b, true
+}
+
+// List returns all broadcasts, newest first
+func (s *BroadcastStore) List() []Broadcast {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Broadcast, 0, len(s.broadcasts))
+	for _, b := range s.broadcasts {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Update applies fn to a broadcast that has not started sending and persists the result
+func (s *BroadcastStore) Update(id string, fn func(*Broadcast)) (Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.broadcasts[id]
+	if !ok {
+		return Broadcast{}, fmt.Errorf("broadcast %s not found", id)
+	}
+	if !b.Editable() {
+		return Broadcast{}, fmt.Errorf("broadcast %s is already %s", id, b.Status)
+	}
+	fn(b)
+	b.UpdatedAt = time.Now()
+	return *b, s.save()
+}
+
+// claim marks a broadcast as sending so it is only sent once. It reports
+// false when the broadcast was already sent or is being sent.
+func (s *BroadcastStore) claim(id string) (Broadcast, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.broadcasts[id]
+	if !ok || !b.Editable() {
+		return Broadcast{}, false
+	}
+	b.Status = broadcastSending
+	b.UpdatedAt = time.Now()
+	if err := s.save(); err != nil {
+		log.Printf("Error saving broadcast %s: %v", id, err)
+	}
+	return *b, true
+}
+
+// finish records the outcome of a sent broadcast
+func (s *BroadcastStore) finish(id string, recipients, sent int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.broadcasts[id]
+	if !ok {
+		return
+	}
+	b.Status = broadcastSent
+	b.Recipients = recipients
+	b.Sent = sent
+	b.SentAt = time.Now()
+	b.UpdatedAt = b.SentAt
+	if err := s.save(); err != nil {
+		log.Printf("Error saving broadcast %s: %v", id, err)
+	}
+}
+
+// save writes the broadcasts to disk; callers must hold s.mu
+func (s *BroadcastStore) save() error {
+	return saveJSON(broadcastsFile, s.broadcasts)
+}
+
+// Start sends scheduled broadcasts every interval until the process exits
+func (s *BroadcastStore) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.RunDue(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// RunDue sends every scheduled broadcast whose time has come
+func (s *BroadcastStore) RunDue(now time.Time) {
+	for _, b := range s.List() {
+		if b.Status == broadcastScheduled && !b.ScheduledAt.After(now) {
+			s.Send(b.ID)
+		}
+	}
+}
+
+// Send emails a broadcast to its segment. Students who unsubscribed from
+// announcements are skipped by the email service.
+func (s *BroadcastStore) Send(id string) {
+	b, ok := s.claim(id)
+	if !ok {
+		return
+	}
+	recipients := broadcastRecipients(b)
+	sent := emailService.SendBulk(b.Template, recipients)
+	s.finish(b.ID, len(recipients), sent)
+	log.Printf("Sent broadcast %s to %d of %d students", b.ID, sent, len(recipients))
+}
+
+// Editable reports whether the broadcast can still be changed or rescheduled
+func (b Broadcast) Editable() bool {
+	return b.Status == broadcastDraft || b.Status == broadcastScheduled
+}
+
+// Paragraphs splits the message on blank lines
+func (b Broadcast) Paragraphs() []string {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(b.Message, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// Validate checks that a segment is complete and its value can be parsed
+func (seg Segment) Validate() error {
+	switch seg.Kind {
+	case segmentAll:
+		return nil
+	case segmentProduct, segmentCompany:
+		if strings.TrimSpace(seg.Value) == "" {
+			return fmt.Errorf("a %s segment needs a value", seg.Kind)
+		}
+		return nil
+	case segmentCohort:
+		if _, err := time.Parse("2006-01", seg.Value); err != nil {
+			return fmt.Errorf("cohort must be a month such as 2026-03")
+		}
+		return nil
+	case segmentProgress:
+		_, _, err := parseModuleRange(seg.Value)
+		return err
+	}
+	return fmt.Errorf("unknown segment %q", seg.Kind)
+}
+
+// Matches reports whether an enrollment belongs to the segment
+func (seg Segment) Matches(e Enrollment) bool {
+	switch seg.Kind {
+	case segmentAll:
+		return true
+	case segmentProduct:
+		return strings.EqualFold(strings.TrimSpace(e.CourseName), strings.TrimSpace(seg.Value))
+	case segmentCohort:
+		return e.EnrolledAt.Format("2006-01") == seg.Value
+	case segmentCompany:
+		return strings.EqualFold(strings.TrimSpace(e.Company), strings.TrimSpace(seg.Value))
+	case segmentProgress:
+		low, high, err := parseModuleRange(seg.Value)
+		return err == nil && e.ModulesCompleted >= low && (high < 0 || e.ModulesCompleted <= high)
+	}
+	return false
+}
+
+// String describes the segment for the admin pages
+func (seg Segment) String() string {
+	switch seg.Kind {
+	case segmentAll:
+		return "All students"
+	case segmentProduct:
+		return "Product: " + seg.Value
+	case segmentCohort:
+		return "Cohort: " + seg.Value
+	case segmentCompany:
+		return "Company: " + seg.Value
+	case segmentProgress:
+		return "Modules completed: " + seg.Value
+	}
+	return seg.Kind
+}
+
+// parseModuleRange parses a completed-modules range such as "3", "0-2" or
+// "4-" (at least 4). high is -1 when the range is open.
+func parseModuleRange(value string) (low, high int, err error) {
+	invalid := fmt.Errorf("progress must be a module count or range such as 0-2 or 4-")
+	from, to, isRange := strings.Cut(strings.TrimSpace(value), "-")
+	if from == "" {
+		from = "0"
+	}
+	if low, err = strconv.Atoi(from); err != nil || low < 0 {
+		return 0, 0, invalid
+	}
+	if !isRange {
+		return low, low, nil
+	}
+	if to == "" {
+		return low, -1, nil
+	}
+	if high, err = strconv.Atoi(to); err != nil || high < low {
+		return 0, 0, invalid
+	}
+	return low, high, nil
+}
+
+// segmentStudents returns one enrollment per student in the segment,
+// leaving out refunded orders and removed team seats
+func segmentStudents(seg Segment) []Enrollment {
+	seen := make(map[string]bool)
+	var students []Enrollment
+	for _, e := range enrollments.List() {
+		key := strings.ToLower(e.CustomerEmail)
+		if !e.Active() || seen[key] || !seg.Matches(e) {
+			continue
+		}
+		seen[key] = true
+		students = append(students, e)
+	}
+	return students
+}
+
+// broadcastRecipients returns the email data for every student in the broadcast's segment
+func broadcastRecipients(b Broadcast) []EmailData {
+	var recipients []EmailData
+	for _, e := range segmentStudents(b.Segment) {
+		data := enrollmentEmailData(e)
+		data.Broadcast = &b
+		recipients = append(recipients, data)
+	}
+	return recipients
+}
+
+// sendBroadcastTest sends a broadcast to a single address, personalised with
+// the first student in its segment when there is one
+func sendBroadcastTest(b Broadcast, to string) error {
+	data := enrollmentEmailData(Enrollment{CustomerName: "Test Student", CourseName: os.Getenv("COURSE_NAME")})
+	if students := segmentStudents(b.Segment); len(students) > 0 {
+		data = enrollmentEmailData(students[0])
+	}
+	data.CustomerEmail = to
+	b.Subject = "[Test] " + b.Subject
+	data.Broadcast = &b
+
+	err := emailService.SendTemplateEmail(b.Template, data)
+	if errors.Is(err, errSuppressed) {
+		return fmt.Errorf("%s has unsubscribed from announcements", to)
+	}
+	return err
+}
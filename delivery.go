@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deliveriesFile = "deliveries.json"
+	// deliveryLogLimit is how many emails the log keeps, dropping the oldest
+	deliveryLogLimit = 5000
+)
+
+// redactedLink stands in for sign-in and password reset links in the log, so
+// reading it never lets anyone sign in as a customer. Resends issue new links.
+const redactedLink = "[link removed]"
+
+// Delivery statuses
+const (
+	deliveryQueued     = "queued"
+	deliveryRetrying   = "retrying"
+	deliverySent       = "sent"
+	deliveryFailed     = "failed"
+	deliverySuppressed = "suppressed"
+)
+
+// DeliveryLog records every email sent to customers and the outcome of each attempt
+type DeliveryLog struct {
+	mu         sync.Mutex
+	deliveries map[string]*EmailDelivery
+}
+
+// NewDeliveryLog creates a delivery log backed by the data directory
+func NewDeliveryLog() (*DeliveryLog, error) {
+	l := &DeliveryLog{deliveries: make(map[string]*EmailDelivery)}
+	if err := loadJSON(deliveriesFile, &l.deliveries); err != nil {
+		return nil, err
+	}
+	// Logs written before links were redacted or the log was limited need
+	// cleaning up
+	changed := l.prune()
+	for _, d := range l.deliveries {
+		changed = redactLinks(d) || changed
+	}
+	if changed {
+		if err := l.save(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Create adds a queued entry for an email that is about to be sent
+func (l *DeliveryLog) Create(email *Email, data EmailData, resendOf string) (EmailDelivery, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return EmailDelivery{}, fmt.Errorf("error generating delivery ID: %v", err)
+	}
+
+	now := time.Now()
+	d := &EmailDelivery{
+		ID:          hex.EncodeToString(id),
+		MessageID:   email.MessageID,
+		Template:    email.Template,
+		Category:    email.Category,
+		To:          email.To,
+		Subject:     email.Subject,
+		Status:      deliveryQueued,
+		ResendOf:    resendOf,
+		HTMLContent: email.HTMLContent,
+		TextContent: email.TextContent,
+		Data:        data,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	redactLinks(d)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deliveries[d.ID] = d
+	l.prune()
+	return *d, l.save()
+}
+
+// prune drops the oldest deliveries once the log is over its limit. It drops
+// a tenth more than needed, so a full log is not sorted again on every send.
+// It reports whether any were dropped; callers must hold l.mu.
+func (l *DeliveryLog) prune() bool {
+	if len(l.deliveries) <= deliveryLogLimit {
+		return false
+	}
+	list := make([]*EmailDelivery, 0, len(l.deliveries))
+	for _, d := range l.deliveries {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	for _, d := range list[:len(list)-deliveryLogLimit*9/10] {
+		delete(l.deliveries, d.ID)
+	}
+	return true
+}
+
+// redactLinks replaces the sign-in and password reset links of a logged email.
+// It reports whether there were any.
+func redactLinks(d *EmailDelivery) bool {
+	redacted := false
+	for _, link := range []*string{&d.Data.SignInURL, &d.Data.PasswordResetURL} {
+		if *link == "" || *link == redactedLink {
+			continue
+		}
+		for _, s := range []string{*link, html.EscapeString(*link)} {
+			d.HTMLContent = strings.ReplaceAll(d.HTMLContent, s, redactedLink)
+			d.TextContent = strings.ReplaceAll(d.TextContent, s, redactedLink)
+		}
+		*link = redactedLink
+		redacted = true
+	}
+	return redacted
+}
+
+// RecordAttempt stores the outcome of one send attempt
+func (l *DeliveryLog) RecordAttempt(id, messageID, status string, result sendResult) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.deliveries[id]
+	if !ok {
+		return fmt.Errorf("delivery %s not found", id)
+	}
+	d.Attempts++
+	d.MessageID = messageID
+	d.Status = status
+	d.ResponseCode = result.Code
+	d.Response = result.Response
+	if result.ProviderID != "" {
+		d.ProviderID = result.ProviderID
+	}
+	d.UpdatedAt = time.Now()
+	return l.save()
+}
+
+// Get returns the delivery with the given ID
+func (l *DeliveryLog) Get(id string) (EmailDelivery, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.deliveries[id]
+	if !ok {
+		return EmailDelivery{}, false
+	}
+	return *d, true
+}
+
+// Search returns the deliveries whose recipient contains the query, newest first.
+// An empty query returns every delivery.
+func (l *DeliveryLog) Search(query string) []EmailDelivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var list []EmailDelivery
+	for _, d := range l.deliveries {
+		if strings.Contains(strings.ToLower(d.To), query) {
+			list = append(list, *d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// save writes the delivery log to disk; callers must hold l.mu
+func (l *DeliveryLog) save() error {
+	return saveJSON(deliveriesFile, l.deliveries)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliveryLogRedactsLinks(t *testing.T) {
+	setupTestStores(t)
+	a, err := accounts.Ensure(Enrollment{CustomerEmail: "ada@example.com", CustomerName: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signIn, err := signInURL(a, "", welcomeLinkLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset, err := accountLink(passwordResetPurpose, "/password/reset", a, "/account", passwordResetLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sends := []struct {
+		template string
+		data     EmailData
+		link     string
+	}{
+		{"welcome", EmailData{CustomerName: "Ada", CustomerEmail: a.Email, SignInURL: signIn}, signIn},
+		{"password_reset", EmailData{CustomerName: "Ada", CustomerEmail: a.Email, PasswordResetURL: reset}, reset},
+	}
+	for _, send := range sends {
+		if err := emailService.SendTemplateEmail(send.template, send.data); err != nil {
+			t.Fatal(err)
+		}
+		token := send.link[strings.Index(send.link, "token=")+len("token="):]
+		token, _, _ = strings.Cut(token, "&")
+
+		logged := deliveries.Search(a.Email)[0]
+		if logged.Template != send.template {
+			t.Fatalf("latest delivery is %s, want %s", logged.Template, send.template)
+		}
+		if logged.Data.SignInURL != "" && logged.Data.SignInURL != redactedLink ||
+			logged.Data.PasswordResetURL != "" && logged.Data.PasswordResetURL != redactedLink {
+			t.Errorf("%s: logged data keeps a link: %+v", send.template, logged.Data)
+		}
+		if strings.Contains(logged.HTMLContent, token) || strings.Contains(logged.TextContent, token) {
+			t.Errorf("%s: logged body keeps the link token", send.template)
+		}
+		if !strings.Contains(logged.HTMLContent, redactedLink) {
+			t.Errorf("%s: logged body has no placeholder for the link", send.template)
+		}
+
+		// The preview admins see serves the logged body
+		r := httptest.NewRequest(http.MethodGet, "/admin/emails/content?id="+logged.ID, nil)
+		w := httptest.NewRecorder()
+		AdminEmailContentHandler(w, r)
+		if strings.Contains(w.Body.String(), token) {
+			t.Errorf("%s: preview shows the link token", send.template)
+		}
+
+		// Resending still works and issues a new link
+		resent, err := emailService.Resend(logged.ID, "")
+		if err != nil {
+			t.Fatalf("%s: resend: %v", send.template, err)
+		}
+		if resent.Status != deliverySent || strings.Contains(resent.HTMLContent, token) || !strings.Contains(resent.HTMLContent, redactedLink) {
+			t.Errorf("%s: resend = %s, want sent with the new link redacted", send.template, resent.Status)
+		}
+	}
+}
+
+func TestRedactLinksInOldLogs(t *testing.T) {
+	setupTestStores(t)
+	link := "https://apex.test/login/verify?token=abc.def&next=%2Fcourses%2Fai"
+	old := map[string]*EmailDelivery{"old": {
+		ID:          "old",
+		Template:    "welcome",
+		HTMLContent: `<a href="https://apex.test/login/verify?token=abc.def&amp;next=%2Fcourses%2Fai">Access</a>`,
+		TextContent: "Access: " + link,
+		Data:        EmailData{SignInURL: link},
+	}}
+	if err := saveJSON(deliveriesFile, old); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewDeliveryLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := l.Get("old")
+	if strings.Contains(d.HTMLContent, "abc.def") || strings.Contains(d.TextContent, "abc.def") || d.Data.SignInURL != redactedLink {
+		t.Errorf("old delivery not redacted: %+v", d)
+	}
+	var saved map[string]*EmailDelivery
+	if err := loadJSON(deliveriesFile, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(saved["old"].HTMLContent, "abc.def") {
+		t.Error("redacted log not saved")
+	}
+}
+
+func TestDeliveryLogLimit(t *testing.T) {
+	setupTestStores(t)
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	deliveries.mu.Lock()
+	for i := 0; i < deliveryLogLimit; i++ {
+		id := fmt.Sprintf("d%05d", i)
+		deliveries.deliveries[id] = &EmailDelivery{ID: id, To: "ada@example.com", CreatedAt: start.Add(time.Duration(i) * time.Second)}
+	}
+	deliveries.mu.Unlock()
+
+	latest, err := deliveries.Create(&Email{To: "ada@example.com", Template: "welcome"}, EmailData{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := deliveries.Search("")
+	if want := deliveryLogLimit * 9 / 10; len(kept) != want {
+		t.Fatalf("%d deliveries kept, want %d", len(kept), want)
+	}
+	if kept[0].ID != latest.ID {
+		t.Error("latest delivery dropped")
+	}
+	if _, ok := deliveries.Get("d00500"); ok {
+		t.Error("oldest deliveries kept")
+	}
+	if _, ok := deliveries.Get(fmt.Sprintf("d%05d", deliveryLogLimit-1)); !ok {
+		t.Error("recent delivery dropped")
+	}
+
+	// The limit also applies to the log on disk
+	reloaded, err := NewDeliveryLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reloaded.Search("")); n != len(kept) {
+		t.Errorf("%d deliveries saved, want %d", n, len(kept))
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// emailFixtures is the sample data the preview gallery renders templates with
+var emailFixtures = []struct {
+	Name string
+	Data EmailData
+}{
+	{
+		Name: "Typical student",
+		Data: EmailData{
+			CustomerName:  "Jane Doe",
+			CustomerEmail: "jane.doe@example.com",
+			CourseName:    "APEX AI Course",
+			CompanyName:   "APEX AI",
+			SupportEmail:  "support@apexai.com",
+			AmountPaid:    299900,
+			Currency:      "usd",
+			EnrolledAt:    time.Date(2026, time.March, 14, 9, 30, 0, 0, time.UTC),
+		},
+	},
+	{
+		Name: "French buyer",
+		Data: EmailData{
+			CustomerName:  "Camille Martin",
+			CustomerEmail: "camille.martin@example.fr",
+			CourseName:    "APEX AI Course",
+			CompanyName:   "APEX AI",
+			SupportEmail:  "support@apexai.com",
+			Locale:        "fr-FR",
+			AmountPaid:    279900,
+			Currency:      "eur",
+			EnrolledAt:    time.Date(2026, time.March, 14, 9, 30, 0, 0, time.UTC),
+		},
+	},
+	{
+		Name: "German buyer",
+		Data: EmailData{
+			CustomerName:  "Lukas Schneider",
+			CustomerEmail: "lukas.schneider@example.de",
+			CourseName:    "APEX AI Course",
+			CompanyName:   "APEX AI",
+			SupportEmail:  "support@apexai.com",
+			Locale:        "de",
+			AmountPaid:    1279900,
+			Currency:      "eur",
+			EnrolledAt:    time.Date(2026, time.March, 14, 9, 30, 0, 0, time.UTC),
+		},
+	},
+	{
+		Name: "Long name with special characters",
+		Data: EmailData{
+			CustomerName:  "Dr. Siobhán O'Connor-Østergaard & Partners <Consulting>",
+			CustomerEmail: "siobhan.oconnor-ostergaard@very-long-subdomain.example.co.uk",
+			CourseName:    "APEX AI Course",
+			CompanyName:   "APEX AI",
+			SupportEmail:  "support@apexai.com",
+		},
+	},
+}
+
+var devEmailsTmpl = template.Must(template.New("dev-emails").Parse(`<!DOCTYPE html>
+<html lang="en" class="dark">
+<head>
+	<meta charset="UTF-8">
+	<title>Email previews</title>
+	<link href="https://fonts.googleapis.com/css2?family=Lexend+Deca:wght@400;500;600;700&display=swap" rel="stylesheet">
+	<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-gray-950 text-white min-h-screen font-['Lexend_Deca'] p-8">
+	<h1 class="text-3xl font-bold mb-2">Email previews</h1>
+	<p class="text-blue-200/70 mb-6">Development only. Previews are sent to the file or stdout mailer, never over SMTP.</p>
+	<div class="flex gap-8">
+		<ul class="w-80 space-y-4">
+		{{range $name := .Templates}}
+			<li>
+				<p class="font-medium">{{$name}}</p>
+				{{range $i, $f := $.Fixtures}}
+				<p class="text-sm text-blue-200 ml-2">
+					{{$f.Name}}:
+					<a target="preview" href="/dev/emails/render?template={{$name}}&fixture={{$i}}&format=html" class="text-blue-400 hover:text-blue-300">HTML</a>
+					<a target="preview" href="/dev/emails/render?template={{$name}}&fixture={{$i}}&format=text" class="text-blue-400 hover:text-blue-300">Text</a>
+				</p>
+				<form method="post" action="/dev/emails/send" class="ml-2 text-sm flex gap-2">
+					<input type="hidden" name="template" value="{{$name}}">
+					<input type="hidden" name="fixture" value="{{$i}}">
+					<select name="mailer" class="bg-gray-900 rounded px-1">
+						<option value="file">file</option>
+						<option value="stdout">stdout</option>
+					</select>
+					<button class="text-blue-400 hover:text-blue-300">Send</button>
+				</form>
+				{{end}}
+			</li>
+		{{end}}
+		</ul>
+		<iframe name="preview" class="flex-1 h-[85vh] bg-white rounded"></iframe>
+	</div>
+</body>
+</html>`))
+
+// devEmailTemplateNames returns the names of all email templates in order
+func devEmailTemplateNames() []string {
+	names := make([]string, 0, len(emailTemplates))
+	for name := range emailTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// devLiveSession is the live session shown in invitation previews
+var devLiveSession = LiveSession{
+	ID:          "preview",
+	Title:       "Monthly Q&A: Building your AI roadmap",
+	Description: "Bring the AI initiative you are working on; we review as many as time allows.",
+	Start:       time.Date(2026, time.April, 2, 16, 0, 0, 0, time.UTC),
+	Duration:    time.Hour,
+	TimeZone:    "Europe/Paris",
+	JoinURL:     "https://meet.example.com/apex-qa",
+}
+
+// devBroadcast is the announcement shown in broadcast previews
+var devBroadcast = Broadcast{
+	ID:          "preview",
+	Subject:     "New module: AI procurement playbook",
+	Heading:     "AI procurement playbook",
+	Message:     "A new module is live: how to evaluate AI vendors, run a pilot and negotiate the contract.\n\nIt includes the scorecard template our students asked for after the last live session.",
+	ButtonLabel: "Watch the Module",
+	ButtonURL:   "https://apex.example.com/login",
+	Segment:     Segment{Kind: segmentAll},
+}
+
+// devFixture looks up the fixture selected in the request
+func devFixture(r *http.Request) (EmailData, bool) {
+	i, err := strconv.Atoi(r.FormValue("fixture"))
+	if err != nil || i < 0 || i >= len(emailFixtures) {
+		return EmailData{}, false
+	}
+	data := emailFixtures[i].Data
+	session := devLiveSession
+	data.Session = &session
+	broadcast := devBroadcast
+	data.Broadcast = &broadcast
+	data.SignInURL = os.Getenv("DOMAIN_URL") + "/login/verify?token=preview"
+	data.PasswordResetURL = os.Getenv("DOMAIN_URL") + "/password/reset?token=preview"
+	data.Order = &Enrollment{
+		ID:            "preview",
+		CustomerName:  data.CustomerName,
+		CustomerEmail: data.CustomerEmail,
+		CourseName:    data.CourseName,
+		AmountTotal:   data.AmountPaid,
+		Currency:      data.Currency,
+		Company:       "Globex Corporation",
+		JobTitle:      "Chief Operating Officer",
+		Coupon:        "LAUNCH20",
+		Source:        "linkedin / paid / spring-launch",
+		EnrolledAt:    data.EnrolledAt,
+	}
+	return data, true
+}
+
+// DevEmailsHandler lists every email template with its fixtures
+func DevEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Templates []string
+		Fixtures  any
+	}{devEmailTemplateNames(), emailFixtures}
+
+	if err := devEmailsTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering email previews: %v", err)
+	}
+}
+
+// DevEmailRenderHandler renders a template with a fixture as HTML or plain text
+func DevEmailRenderHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := devFixture(r)
+	if !ok {
+		http.Error(w, "Unknown fixture", http.StatusBadRequest)
+		return
+	}
+
+	email, err := emailService.renderEmail(r.FormValue("template"), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Subject: " + email.Subject + "\n\n" + email.TextContent))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(email.HTMLContent))
+}
+
+// DevEmailSendHandler delivers a preview to the file or stdout mailer
+func DevEmailSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, ok := devFixture(r)
+	if !ok {
+		http.Error(w, "Unknown fixture", http.StatusBadRequest)
+		return
+	}
+
+	var mailer Mailer
+	switch r.FormValue("mailer") {
+	case "file":
+		mailer = &fileMailer{dir: emailService.config.FileDir}
+	case "stdout":
+		mailer = &stdoutMailer{w: os.Stdout}
+	default:
+		http.Error(w, "Previews can only be sent to the file or stdout mailer", http.StatusBadRequest)
+		return
+	}
+
+	email, err := emailService.renderEmail(r.FormValue("template"), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message, err := emailService.buildMessage(email)
+	if err != nil {
+		log.Printf("Error building preview email: %v", err)
+		http.Error(w, "Error building email", http.StatusInternalServerError)
+		return
+	}
+	if err := mailer.Send(emailService.config.From, []string{email.To}, message); err != nil {
+		log.Printf("Error sending preview email: %v", err)
+		http.Error(w, "Error sending email", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dev/emails", http.StatusSeeOther)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultDKIMHeaders are signed when DKIM_HEADERS is not set
+var defaultDKIMHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+	"List-Unsubscribe", "List-Unsubscribe-Post"}
+
+// DKIMSigner adds DKIM-Signature headers (RFC 6376) to outgoing messages
+// using relaxed/relaxed canonicalization
+type DKIMSigner struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+	headers   []string
+}
+
+// NewDKIMSigner creates a signer from the DKIM settings in the email config.
+// It returns nil when DKIM is not configured.
+func NewDKIMSigner(config EmailConfig) (*DKIMSigner, error) {
+	if config.DKIMPrivateKey == "" {
+		return nil, nil
+	}
+	if config.DKIMDomain == "" || config.DKIMSelector == "" {
+		return nil, fmt.Errorf("DKIM domain and selector are required with a private key")
+	}
+
+	key, err := loadDKIMKey(config.DKIMPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DKIMSigner{
+		domain:   config.DKIMDomain,
+		selector: config.DKIMSelector,
+		key:      key,
+		headers:  config.DKIMHeaders,
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		d.algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		d.algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+
+	if len(d.headers) == 0 {
+		d.headers = defaultDKIMHeaders
+	}
+	hasFrom := false
+	for _, h := range d.headers {
+		if strings.EqualFold(h, "From") {
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		// RFC 6376 requires the From header to be signed
+		d.headers = append([]string{"From"}, d.headers...)
+	}
+	return d, nil
+}
+
+// loadDKIMKey reads a PEM encoded RSA or Ed25519 private key from a file
+func loadDKIMKey(path string) (crypto.Signer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading DKIM key: %v", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("DKIM key %s is not PEM encoded", path)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DKIM key: %v", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DKIM key: %v", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in DKIM key", block.Type)
+	}
+}
+
+// Sign returns the message with a DKIM-Signature header prepended.
+// The message must use CRLF line endings.
+func (d *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	headerEnd := bytes.Index(message, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		return nil, fmt.Errorf("message has no header/body separator")
+	}
+	headers := splitHeaders(string(message[:headerEnd+2]))
+	body := message[headerEnd+4:]
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	// Pick the headers to sign, using the last unsigned instance of each name
+	// as required for repeated headers
+	used := make(map[int]bool)
+	var signedNames []string
+	var signed strings.Builder
+	for _, name := range d.headers {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headerName(headers[i]), name) {
+				continue
+			}
+			used[i] = true
+			signedNames = append(signedNames, strings.ToLower(name))
+			signed.WriteString(relaxedHeader(headers[i]))
+			signed.WriteString("\r\n")
+			break
+		}
+	}
+	// Oversign From: naming it once more than it appears means a From header
+	// added on the way breaks the signature instead of replacing the sender
+	signedNames = append(signedNames, "from")
+
+	sigHeader := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		d.algorithm, d.domain, d.selector, time.Now().Unix(),
+		foldHeaderNames(signedNames), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	signed.WriteString(relaxedHeader(sigHeader))
+
+	hash := sha256.Sum256([]byte(signed.String()))
+	var signature []byte
+	var err error
+	switch key := d.key.(type) {
+	case ed25519.PrivateKey:
+		// RFC 8463 signs the SHA-256 hash with PureEdDSA
+		signature = ed25519.Sign(key, hash[:])
+	default:
+		signature, err = d.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error signing message: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigHeader)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(signature)))
+	out.WriteString("\r\n")
+	out.Write(message)
+	return out.Bytes(), nil
+}
+
+// splitHeaders splits a header block into individual, still folded header fields
+func splitHeaders(block string) []string {
+	var headers []string
+	for _, line := range strings.SplitAfter(block, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	for i, h := range headers {
+		headers[i] = strings.TrimSuffix(h, "\r\n")
+	}
+	return headers
+}
+
+// headerName returns the field name of a header
+func headerName(header string) string {
+	name, _, _ := strings.Cut(header, ":")
+	return strings.TrimSpace(name)
+}
+
+// relaxedHeader applies the relaxed header canonicalization from RFC 6376 section 3.4.2
+func relaxedHeader(header string) string {
+	name, value, _ := strings.Cut(header, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// relaxedBody applies the relaxed body canonicalization from RFC 6376 section 3.4.4
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRightFunc(line, isWSP)
+		var b strings.Builder
+		inWSP := false
+		for _, r := range line {
+			if isWSP(r) {
+				inWSP = true
+				continue
+			}
+			if inWSP {
+				b.WriteByte(' ')
+				inWSP = false
+			}
+			b.WriteRune(r)
+		}
+		lines[i] = b.String()
+	}
+
+	// Ignore all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// isWSP reports whether r is whitespace as defined by RFC 5234
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldHeaderNames joins the signed header names for the h= tag, wrapping
+// before a name that would make the line too long
+func foldHeaderNames(names []string) string {
+	var b strings.Builder
+	line := len("\th=")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(':')
+			line++
+			if line+len(name) > 76 {
+				b.WriteString("\r\n\t ")
+				line = 2
+			}
+		}
+		b.WriteString(name)
+		line += len(name)
+	}
+	return b.String()
+}
+
+// foldBase64 wraps a long base64 value so the header stays within line limits
+func foldBase64(value string) string {
+	var b strings.Builder
+	for len(value) > 72 {
+		b.WriteString(value[:72])
+		b.WriteString("\r\n\t ")
+		value = value[72:]
+	}
+	b.WriteString(value)
+	return b.String()
+}
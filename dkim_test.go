@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const dkimTestMessage = "From: APEX AI <hello@apex.test>\r\n" +
+	"To: student@example.com\r\n" +
+	"Subject: Welcome to\r\n" +
+	"\tthe   APEX AI course\r\n" +
+	"Date: Mon, 19 Oct 2026 09:00:00 +0000\r\n" +
+	"Message-ID: <welcome-1@apex.test>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"X-Not-Signed: anything\r\n" +
+	"\r\n" +
+	"Hello  there, \r\n" +
+	"\r\n" +
+	"Your course is ready.\t\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// dkimTestKey is a signing key with the DNS record that publishes it
+type dkimTestKey struct {
+	name   string
+	key    crypto.Signer
+	record string
+}
+
+func dkimTestKeys(t *testing.T) []dkimTestKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []dkimTestKey{
+		{"rsa", rsaKey, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPublic)},
+		{"ed25519", edKey, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic)},
+	}
+}
+
+// newTestDKIMSigner writes the key to a PEM file and loads it like the app does
+func newTestDKIMSigner(t *testing.T, key crypto.Signer, headers []string) *DKIMSigner {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewDKIMSigner(EmailConfig{DKIMDomain: "apex.test", DKIMSelector: "mail", DKIMPrivateKey: path, DKIMHeaders: headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// verifyDKIM checks a message's signature with the key record served from memory
+func verifyDKIM(t *testing.T, message []byte, record string) *dkim.Verification {
+	t.Helper()
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(message), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.apex.test" {
+				return nil, fmt.Errorf("unexpected lookup of %s", domain)
+			}
+			return []string{record}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("got %d signatures, want 1", len(verifications))
+	}
+	return verifications[0]
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	for _, k := range dkimTestKeys(t) {
+		t.Run(k.name, func(t *testing.T) {
+			signed, err := newTestDKIMSigner(t, k.key, nil).Sign([]byte(dkimTestMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := verifyDKIM(t, signed, k.record)
+			if v.Err != nil {
+				t.Fatalf("signature does not verify: %v", v.Err)
+			}
+			if v.Domain != "apex.test" {
+				t.Errorf("domain = %q", v.Domain)
+			}
+			for _, name := range []string{"from", "to", "subject", "date", "message-id", "mime-version", "content-type"} {
+				if !containsFold(v.HeaderKeys, name) {
+					t.Errorf("%s is not signed: %v", name, v.HeaderKeys)
+				}
+			}
+			if containsFold(v.HeaderKeys, "x-not-signed") {
+				t.Errorf("X-Not-Signed was signed")
+			}
+		})
+	}
+}
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	for _, k := range dkimTestKeys(t) {
+		t.Run(k.name, func(t *testing.T) {
+			signed, err := newTestDKIMSigner(t, k.key, nil).Sign([]byte(dkimTestMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tests := []struct {
+				name   string
+				change func(string) string
+				valid  bool
+			}{
+				{"unchanged", func(m string) string { return m }, true},
+				{"body whitespace added", func(m string) string {
+					return strings.Replace(m, "Hello  there, \r\n", "Hello \t there, \t\r\n", 1)
+				}, true},
+				{"body whitespace collapsed", func(m string) string {
+					return strings.Replace(m, "Hello  there, \r\n", "Hello there,\r\n", 1)
+				}, true},
+				{"empty lines added at the end", func(m string) string { return m + "\r\n\r\n" }, true},
+				{"header refolded", func(m string) string {
+					return strings.Replace(m, "Subject: Welcome to\r\n\tthe   APEX AI course", "Subject:   Welcome to the\r\n APEX AI course ", 1)
+				}, true},
+				{"header name case changed", func(m string) string { return strings.Replace(m, "\r\nTo: ", "\r\nTO: ", 1) }, true},
+				{"unsigned header changed", func(m string) string { return strings.Replace(m, "X-Not-Signed: anything", "X-Not-Signed: else", 1) }, true},
+				{"body changed", func(m string) string { return strings.Replace(m, "ready", "gone", 1) }, false},
+				{"subject changed", func(m string) string { return strings.Replace(m, "Welcome to", "Welcome back to", 1) }, false},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					v := verifyDKIM(t, []byte(tt.change(string(signed))), k.record)
+					if valid := v.Err == nil; valid != tt.valid {
+						t.Errorf("valid = %v, want %v (%v)", valid, tt.valid, v.Err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDKIMBodyHash(t *testing.T) {
+	k := dkimTestKeys(t)[1]
+	signed, err := newTestDKIMSigner(t, k.key, nil).Sign([]byte(dkimTestMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The relaxed body of the test message, worked out by hand from RFC 6376 section 3.4.4
+	want := "Hello there,\r\n\r\nYour course is ready.\r\n"
+	if got := string(relaxedBody([]byte(dkimTestMessage[strings.Index(dkimTestMessage, "\r\n\r\n")+4:]))); got != want {
+		t.Errorf("relaxed body = %q, want %q", got, want)
+	}
+	tags := dkimTags(t, signed)
+	if bh := tags["bh"]; bh != sha256Base64(want) {
+		t.Errorf("bh = %s, want %s", bh, sha256Base64(want))
+	}
+	if tags["c"] != "relaxed/relaxed" || tags["a"] != "ed25519-sha256" {
+		t.Errorf("c = %q, a = %q", tags["c"], tags["a"])
+	}
+}
+
+func TestDKIMOversignsFrom(t *testing.T) {
+	for _, k := range dkimTestKeys(t) {
+		t.Run(k.name, func(t *testing.T) {
+			// From is added even when the configured headers leave it out
+			signed, err := newTestDKIMSigner(t, k.key, []string{"Subject"}).Sign([]byte(dkimTestMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h := dkimTags(t, signed)["h"]; h != "from:subject:from" {
+				t.Errorf("h = %q, want from:subject:from", h)
+			}
+
+			headerEnd := bytes.Index(signed, []byte("\r\n\r\n"))
+			for name, forged := range map[string]string{
+				"From added above": "From: attacker@evil.test\r\n" + string(signed),
+				"From added below": string(signed[:headerEnd]) + "\r\nFrom: attacker@evil.test" + string(signed[headerEnd:]),
+			} {
+				if v := verifyDKIM(t, []byte(forged), k.record); v.Err == nil {
+					t.Errorf("%s: signature still verifies", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDKIMFoldsSignature(t *testing.T) {
+	k := dkimTestKeys(t)[0]
+	signed, err := newTestDKIMSigner(t, k.key, nil).Sign([]byte(dkimTestMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := string(signed[:bytes.Index(signed, []byte("\r\n\r\n"))])
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("header line is %d characters long: %q", len(line), line)
+		}
+	}
+}
+
+func TestDKIMFoldsLongHeaderList(t *testing.T) {
+	k := dkimTestKeys(t)[1]
+	names := []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+		"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post", "List-Id"}
+	message := "Cc: team@example.com\r\nReply-To: support@apex.test\r\nList-Id: <news.apex.test>\r\n" +
+		"List-Unsubscribe: <https://apex.test/unsubscribe>\r\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" + dkimTestMessage
+	signed, err := newTestDKIMSigner(t, k.key, names).Sign([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := string(signed[:bytes.Index(signed, []byte("\r\n\r\n"))])
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("header line is %d characters long: %q", len(line), line)
+		}
+	}
+	if v := verifyDKIM(t, signed, k.record); v.Err != nil || len(v.HeaderKeys) != len(names)+1 {
+		t.Errorf("err = %v, signed %v", v.Err, v.HeaderKeys)
+	}
+}
+
+func TestDKIMRejectsMessageWithoutBody(t *testing.T) {
+	k := dkimTestKeys(t)[1]
+	if _, err := newTestDKIMSigner(t, k.key, nil).Sign([]byte("From: a@apex.test\r\n")); err == nil {
+		t.Error("signed a message without header/body separator")
+	}
+}
+
+// dkimTags returns the tags of a signed message's DKIM-Signature header
+func dkimTags(t *testing.T, signed []byte) map[string]string {
+	t.Helper()
+	headers := splitHeaders(string(signed[:bytes.Index(signed, []byte("\r\n\r\n"))+2]))
+	if headerName(headers[0]) != "DKIM-Signature" {
+		t.Fatalf("first header is %q", headers[0])
+	}
+	_, value, _ := strings.Cut(headers[0], ":")
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
+
+func sha256Base64(s string) string {
+	h := crypto.SHA256.New()
+	h.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"sync"
+	"time"
+)
+
+const sequenceProgressFile = "sequences.json"
+
+const (
+	// dripMaxAttempts is how many times a step is tried before it fails for good
+	dripMaxAttempts = 5
+	// dripRetryBackoff is the wait after a step's first failed attempt,
+	// doubled after each further one
+	dripRetryBackoff = 15 * time.Minute
+)
+
+// StepCondition decides whether a sequence step is skipped for an enrollment
+type StepCondition struct {
+	Name string
+	Skip func(e Enrollment) bool
+}
+
+// SequenceStep is a single scheduled email in a drip sequence
+type SequenceStep struct {
+	Name     string
+	Delay    time.Duration // time after enrollment when the step is due
+	Template string
+	SkipIf   []StepCondition
+}
+
+// Sequence is an ordered series of emails sent after enrollment
+type Sequence struct {
+	Name  string
+	Steps []SequenceStep
+}
+
+// skipIfUnsubscribed skips steps for students who opted out of onboarding emails
+var skipIfUnsubscribed = StepCondition{
+	Name: "unsubscribed",
+	Skip: func(e Enrollment) bool { return suppressions.Suppressed(e.CustomerEmail, categoryOnboarding) },
+}
+
+// skipIfRefunded skips steps for refunded orders
+var skipIfRefunded = StepCondition{
+	Name: "refunded",
+	Skip: func(e Enrollment) bool { return e.Refunded },
+}
+
+// skipIfSeatRemoved skips steps for team seats that were taken back
+var skipIfSeatRemoved = StepCondition{
+	Name: "seat removed",
+	Skip: func(e Enrollment) bool { return !e.RevokedAt.IsZero() },
+}
+
+// skipIfProgressAtLeast skips steps once the student has completed n modules
+func skipIfProgressAtLeast(n int) StepCondition {
+	return StepCondition{
+		Name: "progress",
+		Skip: func(e Enrollment) bool { return e.ModulesCompleted >= n },
+	}
+}
+
+// onboardingSequence follows up on the welcome email during the first week
+var onboardingSequence = Sequence{
+	Name: "onboarding",
+	Steps: []SequenceStep{
+		{
+			Name:     "getting-started",
+			Delay:    24 * time.Hour,
+			Template: "drip_getting_started",
+			SkipIf:   []StepCondition{skipIfUnsubscribed, skipIfRefunded, skipIfSeatRemoved},
+		},
+		{
+			Name:     "module-1-reminder",
+			Delay:    3 * 24 * time.Hour,
+			Template: "drip_module_one",
+			SkipIf:   []StepCondition{skipIfUnsubscribed, skipIfRefunded, skipIfSeatRemoved, skipIfProgressAtLeast(1)},
+		},
+		{
+			Name:     "check-in",
+			Delay:    7 * 24 * time.Hour,
+			Template: "drip_check_in",
+			SkipIf:   []StepCondition{skipIfUnsubscribed, skipIfRefunded, skipIfSeatRemoved},
+		},
+	},
+}
+
+// DripScheduler sends drip sequence emails as they become due
+type DripScheduler struct {
+	mu          sync.Mutex
+	email       *EmailService
+	enrollments *EnrollmentStore
+	sequences   []Sequence
+	progress    map[string]*SequenceProgress
+}
+
+// NewDripScheduler creates a scheduler for the given sequences
+func NewDripScheduler(email *EmailService, enrollments *EnrollmentStore, sequences ...Sequence) (*DripScheduler, error) {
+	d := &DripScheduler{
+		email:       email,
+		enrollments: enrollments,
+		sequences:   sequences,
+		progress:    make(map[string]*SequenceProgress),
+	}
+	if err := loadJSON(sequenceProgressFile, &d.progress); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// progressKey identifies an enrollment's progress in a sequence
+func progressKey(enrollmentID, sequence string) string {
+	return enrollmentID + "/" + sequence
+}
+
+// Enroll schedules an enrollment into every sequence
+func (d *DripScheduler) Enroll(e Enrollment) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, seq := range d.sequences {
+		key := progressKey(e.ID, seq.Name)
+		if _, ok := d.progress[key]; ok {
+			continue
+		}
+		d.progress[key] = &SequenceProgress{EnrollmentID: e.ID, Sequence: seq.Name}
+	}
+	return saveJSON(sequenceProgressFile, d.progress)
+}
+
+// Start runs due steps every interval until the process exits
+func (d *DripScheduler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			d.RunDue(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// dueStep is a step that should be processed in the current run
+type dueStep struct {
+	key        string
+	step       SequenceStep
+	enrollment Enrollment
+	attempts   int // failed attempts so far
+}
+
+// RunDue processes every step that is due at the given time
+func (d *DripScheduler) RunDue(now time.Time) {
+	for _, due := range d.collectDue(now) {
+		result := StepResult{Step: due.step.Name, Status: "sent"}
+
+		for _, cond := range due.step.SkipIf {
+			if cond.Skip(due.enrollment) {
+				result.Status = "skipped"
+				result.Reason = cond.Name
+				break
+			}
+		}
+
+		var retryAt time.Time
+		if result.Status == "sent" {
+			data := enrollmentEmailData(due.enrollment)
+			if err := d.email.SendTemplateEmail(due.step.Template, data); errors.Is(err, errSuppressed) {
+				result.Status = "skipped"
+				result.Reason = "suppressed"
+			} else if err != nil {
+				log.Printf("Error sending %s to %s: %v", due.step.Name, due.enrollment.CustomerEmail, err)
+				result.Status = "failed"
+				result.Reason = err.Error()
+				if !permanentSendError(err) && due.attempts+1 < dripMaxAttempts {
+					result.Status = "retrying"
+					retryAt = now.Add(dripRetryBackoff << due.attempts)
+				}
+			}
+		}
+
+		result.At = time.Now()
+		d.record(due.key, result, retryAt)
+	}
+}
+
+// permanentSendError reports whether sending the email again cannot help
+func permanentSendError(err error) bool {
+	var protoErr *textproto.Error
+	var apiErr *apiError
+	switch {
+	case errors.Is(err, errInvalidEmail):
+		return true
+	case errors.As(err, &protoErr):
+		return protoErr.Code >= 500
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusTooManyRequests
+	}
+	return false
+}
+
+// collectDue returns the next step of every sequence whose delay has passed
+func (d *DripScheduler) collectDue(now time.Time) []dueStep {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due []dueStep
+	for key, p := range d.progress {
+		if p.Completed {
+			continue
+		}
+		seq, ok := d.sequence(p.Sequence)
+		if !ok || p.NextStep >= len(seq.Steps) {
+			continue
+		}
+		e, ok := d.enrollments.Get(p.EnrollmentID)
+		if !ok {
+			continue
+		}
+		step := seq.Steps[p.NextStep]
+		if now.Before(e.EnrolledAt.Add(step.Delay)) || now.Before(p.RetryAt) {
+			continue
+		}
+		due = append(due, dueStep{key: key, step: step, enrollment: e, attempts: p.Attempts})
+	}
+	return due
+}
+
+// record stores a step result. A step to retry stays next until retryAt;
+// otherwise the sequence advances.
+func (d *DripScheduler) record(key string, result StepResult, retryAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.progress[key]
+	if !ok {
+		return
+	}
+	// Keep only the latest attempt of a step that is being retried
+	if n := len(p.History); n > 0 && p.History[n-1].Step == result.Step && p.History[n-1].Status == "retrying" {
+		p.History = p.History[:n-1]
+	}
+	p.History = append(p.History, result)
+	if !retryAt.IsZero() {
+		p.Attempts++
+		p.RetryAt = retryAt
+	} else {
+		p.Attempts, p.RetryAt = 0, time.Time{}
+		p.NextStep++
+		if seq, ok := d.sequence(p.Sequence); !ok || p.NextStep >= len(seq.Steps) {
+			p.Completed = true
+		}
+	}
+	if err := saveJSON(sequenceProgressFile, d.progress); err != nil {
+		log.Printf("Error saving sequence progress: %v", err)
+	}
+}
+
+// sequence looks up a sequence by name; callers must hold d.mu
+func (d *DripScheduler) sequence(name string) (Sequence, bool) {
+	for _, seq := range d.sequences {
+		if seq.Name == name {
+			return seq, true
+		}
+	}
+	return Sequence{}, false
+}
+
+// Progress returns a snapshot of every enrollment's position in every sequence
+func (d *DripScheduler) Progress() []SequenceProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]SequenceProgress, 0, len(d.progress))
+	for _, p := range d.progress {
+		cp := *p
+		cp.History = append([]StepResult(nil), p.History...)
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].EnrollmentID != list[j].EnrollmentID {
+			return list[i].EnrollmentID < list[j].EnrollmentID
+		}
+		return list[i].Sequence < list[j].Sequence
+	})
+	return list
+}
+
+// Step returns the step at index i in the named sequence
+func (d *DripScheduler) Step(sequence string, i int) (SequenceStep, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seq, ok := d.sequence(sequence)
+	if !ok || i >= len(seq.Steps) {
+		return SequenceStep{}, false
+	}
+	return seq.Steps[i], true
+}
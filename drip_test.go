@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+// startDripTest enrolls a student in the given sequences of a new scheduler
+func startDripTest(t *testing.T, email string, sequences ...Sequence) (*DripScheduler, Enrollment) {
+	t.Helper()
+	setupTestStores(t)
+	d, err := NewDripScheduler(emailService, enrollments, sequences...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Enrollment{ID: "cs_test_drip", CustomerName: "Ada", CustomerEmail: email, CourseName: "APEX AI", EnrolledAt: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)}
+	if _, err := enrollments.Add(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Enroll(e); err != nil {
+		t.Fatal(err)
+	}
+	return d, e
+}
+
+func TestDripGivesUpOnPermanentFailures(t *testing.T) {
+	d, e := startDripTest(t, "ada@example", onboardingSequence)
+
+	// An address that can never receive mail fails each step once
+	for day := 1; day <= 8; day++ {
+		d.RunDue(e.EnrolledAt.Add(time.Duration(day) * 24 * time.Hour))
+	}
+	p := d.Progress()[0]
+	if !p.Completed || len(p.History) != len(onboardingSequence.Steps) {
+		t.Fatalf("progress = %+v, want every step tried once", p)
+	}
+	for _, result := range p.History {
+		if result.Status != "failed" {
+			t.Errorf("%s: %s, want failed", result.Step, result.Status)
+		}
+	}
+	if n := len(deliveries.Search(e.CustomerEmail)); n != len(onboardingSequence.Steps) {
+		t.Errorf("%d emails logged, want one per step", n)
+	}
+}
+
+func TestDripRetriesWithBackoff(t *testing.T) {
+	// The unknown template fails every time, like a provider that is down
+	d, e := startDripTest(t, "ada@example.com", Sequence{Name: "test", Steps: []SequenceStep{
+		{Name: "hello", Template: "missing"},
+		{Name: "check-in", Template: "drip_check_in"},
+	}})
+
+	now := e.EnrolledAt
+	backoff := dripRetryBackoff
+	for attempt := 1; attempt < dripMaxAttempts; attempt++ {
+		d.RunDue(now)
+		p := d.Progress()[0]
+		if p.NextStep != 0 || p.Attempts != attempt || !p.RetryAt.Equal(now.Add(backoff)) {
+			t.Fatalf("attempt %d: progress = %+v, want a retry in %v", attempt, p, backoff)
+		}
+		if len(p.History) != 1 || p.History[0].Status != "retrying" {
+			t.Fatalf("attempt %d: history = %+v, want the latest attempt only", attempt, p.History)
+		}
+
+		// Nothing is sent before the backoff is over
+		d.RunDue(now.Add(backoff - time.Minute))
+		if p := d.Progress()[0]; p.Attempts != attempt {
+			t.Fatalf("attempt %d: retried after %v", attempt, backoff-time.Minute)
+		}
+		now = now.Add(backoff)
+		backoff *= 2
+	}
+
+	// The last attempt fails the step for good and the sequence moves on
+	d.RunDue(now)
+	p := d.Progress()[0]
+	if p.NextStep != 1 || p.Attempts != 0 || !p.RetryAt.IsZero() || len(p.History) != 1 || p.History[0].Status != "failed" {
+		t.Fatalf("progress = %+v, want hello failed after %d attempts", p, dripMaxAttempts)
+	}
+	d.RunDue(now)
+	if p := d.Progress()[0]; !p.Completed || p.History[1].Status != "sent" {
+		t.Errorf("progress = %+v, want check-in sent", p)
+	}
+}
+
+func TestDripRetrySucceeds(t *testing.T) {
+	d, e := startDripTest(t, "ada@example.com", Sequence{Name: "test", Steps: []SequenceStep{
+		{Name: "hello", Template: "missing"},
+	}})
+
+	d.RunDue(e.EnrolledAt)
+	d.sequences[0].Steps[0].Template = "drip_check_in"
+	d.RunDue(e.EnrolledAt.Add(dripRetryBackoff))
+	p := d.Progress()[0]
+	if !p.Completed || p.Attempts != 0 || len(p.History) != 1 || p.History[0].Status != "sent" {
+		t.Errorf("progress = %+v, want hello sent on the retry", p)
+	}
+}
+
+func TestDripSkipsSuppressedSteps(t *testing.T) {
+	d, e := startDripTest(t, "ada@example.com", onboardingSequence)
+	if err := suppressions.Block(e.CustomerEmail, "hard bounce"); err != nil {
+		t.Fatal(err)
+	}
+
+	d.RunDue(e.EnrolledAt.Add(8 * 24 * time.Hour))
+	p := d.Progress()[0]
+	if p.NextStep != 1 || len(p.History) != 1 || p.History[0].Status != "skipped" {
+		t.Errorf("progress = %+v, want the suppressed step skipped", p)
+	}
+}
+
+func TestPermanentSendError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errInvalidEmail, true},
+		{errDisposableEmail, true},
+		{fmt.Errorf("error sending: %w", &textproto.Error{Code: 550, Msg: "No such user"}), true},
+		{&textproto.Error{Code: 421, Msg: "Busy"}, false},
+		{&textproto.Error{Code: 450, Msg: "Greylisted"}, false},
+		{&apiError{StatusCode: 422}, true},
+		{&apiError{StatusCode: 429}, false},
+		{&apiError{StatusCode: 502}, false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := permanentSendError(tt.err); got != tt.want {
+			t.Errorf("permanentSendError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,176 +2,381 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
-	"net/smtp"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
 	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
 	"time"
 )
 
 // EmailService handles email operations
 type EmailService struct {
-	config EmailConfig
+	config       EmailConfig
+	transport    Mailer
+	api          *apiMailer // set instead of transport for the api transport
+	throttle     *Throttle
+	dkim         *DKIMSigner
+	suppressions *SuppressionStore
+	deliveries   *DeliveryLog
 }
 
 // NewEmailService creates a new email service instance
-func NewEmailService() *EmailService {
-	return &EmailService{
-		config: EmailConfig{
-			Host:     os.Getenv("SMTP_HOST"),
-			Port:     os.Getenv("SMTP_PORT"),
-			Username: os.Getenv("SMTP_USERNAME"),
-			Password: os.Getenv("SMTP_PASSWORD"),
-			From:     os.Getenv("SENDER_EMAIL"),
-		},
+func NewEmailService(suppressions *SuppressionStore, deliveries *DeliveryLog) (*EmailService, error) {
+	config := EmailConfig{
+		Host:           os.Getenv("SMTP_HOST"),
+		Port:           os.Getenv("SMTP_PORT"),
+		Username:       os.Getenv("SMTP_USERNAME"),
+		Password:       os.Getenv("SMTP_PASSWORD"),
+		From:           os.Getenv("SENDER_EMAIL"),
+		ReturnPath:     os.Getenv("BOUNCE_ADDRESS"),
+		Transport:      strings.ToLower(os.Getenv("EMAIL_TRANSPORT")),
+		FileDir:        os.Getenv("EMAIL_FILE_DIR"),
+		Security:       strings.ToLower(os.Getenv("SMTP_SECURITY")),
+		AuthMechanism:  strings.ToLower(os.Getenv("SMTP_AUTH")),
+		CAFile:         os.Getenv("SMTP_CA_FILE"),
+		DKIMDomain:     os.Getenv("DKIM_DOMAIN"),
+		DKIMSelector:   os.Getenv("DKIM_SELECTOR"),
+		DKIMPrivateKey: os.Getenv("DKIM_PRIVATE_KEY"),
+		DKIMHeaders:    splitList(os.Getenv("DKIM_HEADERS")),
+		APIURL:         os.Getenv("EMAIL_API_URL"),
+		APIToken:       os.Getenv("EMAIL_API_TOKEN"),
+		APIFormat:      strings.ToLower(os.Getenv("EMAIL_API_FORMAT")),
+		APIMessageID:   os.Getenv("EMAIL_API_MESSAGE_ID"),
+		APIRegion:      os.Getenv("EMAIL_API_REGION"),
 	}
+
+	// Port 465 is reserved for implicit TLS; everything else negotiates STARTTLS
+	if config.Security == "" {
+		config.Security = smtpSecurityStartTLS
+		if config.Port == "465" {
+			config.Security = smtpSecurityTLS
+		}
+	}
+	// Relays that accept mail without credentials need no authentication
+	if config.AuthMechanism == "" {
+		config.AuthMechanism = smtpAuthPlain
+		if config.Username == "" {
+			config.AuthMechanism = smtpAuthNone
+		}
+	}
+
+	if config.ReturnPath == "" {
+		config.ReturnPath = config.From
+	}
+	if config.FileDir == "" {
+		config.FileDir = dataPath("outbox")
+	}
+
+	var err error
+	if config.DialTimeout, err = envDuration("SMTP_DIAL_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if config.ReadTimeout, err = envDuration("SMTP_READ_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.WriteTimeout, err = envDuration("SMTP_WRITE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.IdleTimeout, err = envDuration("SMTP_IDLE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.APITimeout, err = envDuration("EMAIL_API_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.APIFields, err = parseAPIFields(os.Getenv("EMAIL_API_FIELDS")); err != nil {
+		return nil, err
+	}
+	if config.APIFormat == "" {
+		config.APIFormat = "custom"
+	}
+	if config.Throttle, err = loadThrottleConfig(config.Transport); err != nil {
+		return nil, err
+	}
+
+	var transport Mailer
+	var api *apiMailer
+	if config.Transport == "api" {
+		api, err = newAPIMailer(config)
+	} else {
+		transport, err = newMailer(config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error configuring email transport: %v", err)
+	}
+
+	dkim, err := NewDKIMSigner(config)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring DKIM: %v", err)
+	}
+
+	return &EmailService{config: config, transport: transport, api: api, throttle: NewThrottle(config.Throttle), dkim: dkim, suppressions: suppressions, deliveries: deliveries}, nil
+}
+
+// envDuration reads a duration such as "30s" from the environment
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// splitList parses a comma separated configuration value
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 // SendWelcomeEmail sends a welcome email to the customer
 func (s *EmailService) SendWelcomeEmail(data EmailData) error {
+	return s.SendTemplateEmail("welcome", data)
+}
+
+// SendTemplateEmail renders the named template and sends it to the customer
+func (s *EmailService) SendTemplateEmail(name string, data EmailData) error {
+	email, err := s.renderEmail(name, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.deliver(email, data, "")
+	return err
+}
+
+// bulkWorkers is how many emails SendBulk hands to the throttle at once
+const bulkWorkers = 8
+
+// SendBulk sends a template to many recipients in parallel, leaving the pace
+// to the throttle. It returns how many emails were sent.
+func (s *EmailService) SendBulk(name string, recipients []EmailData) int {
+	jobs := make(chan EmailData)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sent := 0
+	for i := 0; i < min(bulkWorkers, len(recipients)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				err := s.SendTemplateEmail(name, data)
+				if err != nil {
+					if !errors.Is(err, errSuppressed) {
+						log.Printf("Error sending %s to %s: %v", name, data.CustomerEmail, err)
+					}
+					continue
+				}
+				mu.Lock()
+				sent++
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, data := range recipients {
+		jobs <- data
+	}
+	close(jobs)
+	wg.Wait()
+	return sent
+}
+
+// Resend renders a logged email again and sends it, to a corrected address if one is given
+func (s *EmailService) Resend(id, to string) (EmailDelivery, error) {
+	original, ok := s.deliveries.Get(id)
+	if !ok {
+		return EmailDelivery{}, fmt.Errorf("delivery %s not found", id)
+	}
+
+	data := original.Data
+	if to != "" {
+		data.CustomerEmail = to
+	}
+	if data.SignInURL != "" {
+		// The log only keeps a placeholder for the original link, which may
+		// have been used anyway; only the account's own address gets a fresh one
+		data.SignInURL = ""
+		if a, ok := accounts.FindByEmail(data.CustomerEmail); ok {
+			lifetime := signInLinkLifetime
+			if original.Template == "welcome" {
+				lifetime = welcomeLinkLifetime
+			}
+			link, err := signInURL(a, "", lifetime)
+			if err != nil {
+				return EmailDelivery{}, err
+			}
+			data.SignInURL = link
+		}
+	}
+	if data.PasswordResetURL != "" {
+		a, ok := accounts.FindByEmail(data.CustomerEmail)
+		if !ok {
+			return EmailDelivery{}, fmt.Errorf("no account for %s to reset the password of", data.CustomerEmail)
+		}
+		link, err := accountLink(passwordResetPurpose, "/password/reset", a, "", passwordResetLifetime)
+		if err != nil {
+			return EmailDelivery{}, err
+		}
+		data.PasswordResetURL = link
+	}
+	email, err := s.renderEmail(original.Template, data)
+	if err != nil {
+		return EmailDelivery{}, err
+	}
+	return s.deliver(email, data, original.ID)
+}
+
+// deliver records the email in the delivery log and sends it with retries
+func (s *EmailService) deliver(email *Email, data EmailData, resendOf string) (EmailDelivery, error) {
+	delivery, err := s.deliveries.Create(email, data, resendOf)
+	if err != nil {
+		return EmailDelivery{}, fmt.Errorf("error logging email delivery: %v", err)
+	}
+
+	// Send with retry
+	err = s.sendEmailWithRetry(email, delivery.ID, 3)
+	delivery, _ = s.deliveries.Get(delivery.ID)
+	return delivery, err
+}
+
+// renderEmail builds an email from the named template and the customer data
+func (s *EmailService) renderEmail(name string, data EmailData) (*Email, error) {
 	// Add domain URL and sender email to the template data
 	data.DomainURL = os.Getenv("DOMAIN_URL")
 	data.SenderEmail = s.config.From
 
-	// Create welcome email content
-	htmlContent := `<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <style>
-        /* Base styles */
-        body {
-            font-family: Arial, sans-serif;
-            line-height: 1.6;
-            color: #333;
-            margin: 0;
-            padding: 0;
-            background-color: #f9f9f9;
-        }
-        .container {
-            max-width: 600px;
-            margin: 0 auto;
-            padding: 20px;
-            background-color: #ffffff;
-            border-radius: 8px;
-            box-shadow: 0 2px 4px rgba(0, 0, 0, 0.1);
-        }
-        .header {
-            text-align: center;
-            padding: 20px 0;
-            background-color: #0066FF;
-            color: white;
-            border-radius: 8px 8px 0 0;
-        }
-        .content {
-            padding: 30px 20px;
-            background: #ffffff;
-        }
-        .button {
-            display: inline-block;
-            padding: 12px 24px;
-            background: #0066FF;
-            color: white !important;
-            text-decoration: none;
-            border-radius: 5px;
-            font-weight: bold;
-            margin: 20px 0;
-        }
-        .footer {
-            text-align: center;
-            padding: 20px 0;
-            font-size: 0.9em;
-            color: #666;
-            border-top: 1px solid #eee;
-        }
-        .next-steps {
-            background: #f5f7ff;
-            padding: 20px;
-            border-radius: 5px;
-            margin: 20px 0;
-        }
-        .next-steps h3 {
-            margin-top: 0;
-            color: #0066FF;
-        }
-        .support-section {
-            background: #fff8f0;
-            padding: 15px;
-            border-radius: 5px;
-            margin-top: 20px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">
-            <h1>Welcome to {{.CourseName}}!</h1>
-        </div>
-        <div class="content">
-            <p>Dear {{.CustomerName}},</p>
-            
-            <p>Thank you for enrolling in <strong>{{.CourseName}}</strong>! We're excited to have you join us on this transformative journey into AI implementation and strategy.</p>
-            
-            <div class="next-steps">
-                <h3>🚀 Here's what happens next:</h3>
-                <ol>
-                    <li>Check your inbox for your login credentials (arriving within 10 minutes)</li>
-                    <li>Access the complete course materials immediately after login</li>
-                    <li>Join our community of business leaders and AI innovators</li>
-                    <li>Start your learning journey at your own pace</li>
-                </ol>
-            </div>
-
-            <p style="text-align: center;">
-                <a href="{{.DomainURL}}/login" class="button">Access Your Course</a>
-            </p>
-
-            <div class="support-section">
-                <p><strong>Need Help?</strong></p>
-                <p>Our support team is here for you at <a href="mailto:{{.SupportEmail}}">{{.SupportEmail}}</a></p>
-                <p>We typically respond within 2 hours during business hours.</p>
-            </div>
-        </div>
-        
-        <div class="footer">
-            <p>© {{.CompanyName}}. All rights reserved.</p>
-            <p>This email was sent to {{.CustomerEmail}}</p>
-            <p><small>Please add {{.SenderEmail}} to your contacts to ensure you receive our communications.</small></p>
-        </div>
-    </div>
-</body>
-</html>`
-
-	// Parse the template
-	tmpl, err := template.New("welcome").Parse(htmlContent)
+	def, ok := emailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	if def.Category != categoryTransactional {
+		data.UnsubscribeURL = unsubscribeURL(data.CustomerEmail, def.Category)
+	}
+
+	// Use the customer's language only when the template has been translated,
+	// so an email never mixes languages
+	bodySource := def.Body
+	data.Locale = matchLocale(data.Locale)
+	if variant, ok := def.Variants[data.Locale]; ok {
+		bodySource = variant
+	} else {
+		data.Locale = defaultLocale
+	}
+	funcs := map[string]any{
+		"t":     func(key string, args ...any) string { return translate(data.Locale, key, args...) },
+		"date":  func(t time.Time) string { return formatDate(data.Locale, t) },
+		"money": func(amount int64, currency string) string { return formatMoney(data.Locale, amount, currency) },
+		"clock": func(t time.Time) string { return formatClock(data.Locale, t) },
+	}
+
+	// Parse the layout and the template blocks
+	tmpl, err := template.New(name).Funcs(funcs).Parse(emailLayout)
+	if err == nil {
+		_, err = tmpl.Parse(bodySource)
+	}
 	if err != nil {
-		return fmt.Errorf("error parsing template: %v", err)
+		return nil, fmt.Errorf("error parsing template: %v", err)
 	}
 
 	// Execute the template with the data
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
-		return fmt.Errorf("error executing template: %v", err)
+		return nil, fmt.Errorf("error executing template: %v", err)
+	}
+
+	subjectTmpl, err := texttemplate.New(name + "_subject").Funcs(funcs).Parse(def.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing subject: %v", err)
+	}
+	var subject bytes.Buffer
+	if err := subjectTmpl.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("error executing subject: %v", err)
 	}
 
 	// Create email
 	email := &Email{
-		To:          data.CustomerEmail,
-		From:        fmt.Sprintf("%s <%s>", os.Getenv("SENDER_NAME"), s.config.From),
-		Subject:     fmt.Sprintf("Welcome to %s!", data.CourseName),
-		HTMLContent: body.String(),
+		To:             data.CustomerEmail,
+		From:           fmt.Sprintf("%s <%s>", os.Getenv("SENDER_NAME"), s.config.From),
+		Subject:        subject.String(),
+		HTMLContent:    body.String(),
+		TextContent:    htmlToText(body.String()),
+		Template:       name,
+		Category:       def.Category,
+		UnsubscribeURL: data.UnsubscribeURL,
 	}
 
-	// Send with retry
-	return s.sendEmailWithRetry(email, 3)
+	// Tracking links point at the message, so it needs its Message-ID now
+	if trackingEnabled() && !def.NoTracking {
+		if email.MessageID, err = newMessageID(s.config.From); err != nil {
+			return nil, err
+		}
+		email.HTMLContent = addTracking(email.HTMLContent, email, data.SignInURL, data.PasswordResetURL)
+	}
+
+	// Live session emails carry the event so it lands in the student's calendar
+	if def.CalendarMethod != "" {
+		if data.Session == nil {
+			return nil, fmt.Errorf("template %q requires a live session", name)
+		}
+		organizer := calendarParty{Name: os.Getenv("SENDER_NAME"), Email: s.config.From}
+		attendee := calendarParty{Name: data.CustomerName, Email: data.CustomerEmail}
+		if email.Calendar, err = buildICS(*data.Session, def.CalendarMethod, organizer, attendee); err != nil {
+			return nil, err
+		}
+		email.CalendarMethod = def.CalendarMethod
+	}
+	return email, nil
 }
 
-// sendEmailWithRetry attempts to send an email with retries
-func (s *EmailService) sendEmailWithRetry(email *Email, maxRetries int) error {
+// sendEmailWithRetry attempts to send an email with retries, recording each
+// attempt in the delivery log
+func (s *EmailService) sendEmailWithRetry(email *Email, deliveryID string, maxRetries int) error {
 	var lastErr error
 	for i := 0; i < maxRetries; i++ {
-		if err := s.sendEmail(email); err != nil {
+		result, err := s.sendEmail(email)
+
+		status := deliverySent
+		switch {
+		case errors.Is(err, errSuppressed):
+			status = deliverySuppressed
+		case errors.Is(err, errInvalidEmail):
+			status = deliveryFailed
+		case err != nil && i < maxRetries-1:
+			status = deliveryRetrying
+		case err != nil:
+			status = deliveryFailed
+		}
+		if err != nil {
+			result = failedResult(err)
+		}
+		if logErr := s.deliveries.RecordAttempt(deliveryID, email.MessageID, status, result); logErr != nil {
+			log.Printf("Error recording email delivery: %v", logErr)
+		}
+
+		if err != nil {
+			// Retrying cannot help an address that will never accept mail
+			if errors.Is(err, errSuppressed) || errors.Is(err, errInvalidEmail) {
+				return err
+			}
 			lastErr = err
 			// Wait before retrying (exponential backoff)
 			time.Sleep(time.Duration(i+1) * time.Second)
@@ -182,34 +387,215 @@ func (s *EmailService) sendEmailWithRetry(email *Email, maxRetries int) error {
 	return fmt.Errorf("failed to send email after %d attempts: %v", maxRetries, lastErr)
 }
 
-// sendEmail sends a single email
-func (s *EmailService) sendEmail(email *Email) error {
-	// Set email headers
-	headers := make(map[string]string)
-	headers["From"] = email.From
-	headers["To"] = email.To
-	headers["Subject"] = email.Subject
-	headers["MIME-Version"] = "1.0"
-	headers["Content-Type"] = "text/html; charset=UTF-8"
+// sendResult is what the transport reported for one send attempt
+type sendResult struct {
+	Code       int    // SMTP reply code or HTTP status
+	Response   string // reply text for failed attempts
+	ProviderID string // message ID assigned by an HTTP provider API
+}
 
-	// Build email message
+// failedResult returns the SMTP reply or API response of a failed attempt
+func failedResult(err error) sendResult {
+	var protoErr *textproto.Error
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &protoErr):
+		return sendResult{Code: protoErr.Code, Response: protoErr.Msg}
+	case errors.As(err, &apiErr):
+		return sendResult{Code: apiErr.StatusCode, Response: apiErr.Body}
+	}
+	return sendResult{Response: err.Error()}
+}
+
+// buildMessage renders the email as an RFC 5322 message with CRLF line endings
+func (s *EmailService) buildMessage(email *Email) ([]byte, error) {
+	// Retries reuse the Message-ID so the log and the recipient's copy match
+	if email.MessageID == "" {
+		messageID, err := newMessageID(s.config.From)
+		if err != nil {
+			return nil, err
+		}
+		email.MessageID = messageID
+	}
+
+	// The plain-text and HTML versions are sent as alternatives; invites wrap
+	// them in a mixed part so the .ics file can be attached as well
 	var message bytes.Buffer
-	for key, value := range headers {
-		message.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	parts := multipart.NewWriter(&message)
+	contentType := "multipart/alternative"
+	if email.Calendar != "" {
+		contentType = "multipart/mixed"
+	}
+
+	// Set email headers in a fixed order so signatures are reproducible
+	headers := [][2]string{
+		{"From", email.From},
+		{"To", email.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", email.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", email.MessageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType(contentType, map[string]string{"boundary": parts.Boundary()})},
+	}
+	headers = append(headers, unsubscribeHeaders(email)...)
+
+	// Build email message
+	for _, header := range headers {
+		message.WriteString(fmt.Sprintf("%s: %s\r\n", header[0], header[1]))
 	}
 	message.WriteString("\r\n")
-	message.WriteString(email.HTMLContent)
+	write := writeAlternatives
+	if email.Calendar != "" {
+		write = writeInvite
+	}
+	if err := write(parts, email); err != nil {
+		return nil, err
+	}
+
+	if s.dkim == nil {
+		return message.Bytes(), nil
+	}
+	return s.dkim.Sign(message.Bytes())
+}
+
+// unsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers,
+// required by Gmail and Yahoo for bulk mail
+func unsubscribeHeaders(email *Email) [][2]string {
+	if email.UnsubscribeURL == "" {
+		return nil
+	}
+	return [][2]string{
+		{"List-Unsubscribe", "<" + email.UnsubscribeURL + ">"},
+		{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+	}
+}
 
-	// Connect to SMTP server
-	auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
-	addr := fmt.Sprintf("%s:%s", s.config.Host, s.config.Port)
+// writeAlternatives writes the plain-text and HTML parts of the email,
+// quoted-printable encoded so long HTML lines stay within SMTP limits
+func writeAlternatives(parts *multipart.Writer, email *Email) error {
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", email.TextContent},
+		{"text/html; charset=UTF-8", email.HTMLContent},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return fmt.Errorf("error building message: %v", err)
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(toCRLF(part.content))); err != nil {
+			return fmt.Errorf("error encoding message: %v", err)
+		}
+		if err := qp.Close(); err != nil {
+			return fmt.Errorf("error encoding message: %v", err)
+		}
+	}
+
+	// Calendar clients show the invite inline from the text/calendar alternative
+	if email.Calendar != "" {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType("text/calendar", map[string]string{"charset": "UTF-8", "method": email.CalendarMethod})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return fmt.Errorf("error building message: %v", err)
+		}
+		writeBase64(w, email.Calendar)
+	}
+	return parts.Close()
+}
+
+// writeInvite writes the alternatives followed by the invite as an .ics
+// attachment for clients that ignore text/calendar parts
+func writeInvite(mixed *multipart.Writer, email *Email) error {
+	var alternatives bytes.Buffer
+	inner := multipart.NewWriter(&alternatives)
+	if err := writeAlternatives(inner, email); err != nil {
+		return err
+	}
+	w, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": inner.Boundary()})},
+	})
+	if err != nil {
+		return fmt.Errorf("error building message: %v", err)
+	}
+	w.Write(alternatives.Bytes())
+
+	w, err = mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType("application/ics", map[string]string{"name": "invite.ics"})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": "invite.ics"})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return fmt.Errorf("error building message: %v", err)
+	}
+	writeBase64(w, email.Calendar)
+	return mixed.Close()
+}
+
+// writeBase64 writes content base64 encoded in 76 character lines
+func writeBase64(w io.Writer, content string) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
+
+// newMessageID generates a unique Message-ID in the sender's domain
+func newMessageID(from string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("error generating message ID: %v", err)
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain), nil
+}
+
+// toCRLF normalizes line endings to CRLF as required on the wire
+func toCRLF(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// sendEmail sends a single email
+func (s *EmailService) sendEmail(email *Email) (sendResult, error) {
+	if s.suppressions.Suppressed(email.To, email.Category) {
+		return sendResult{}, errSuppressed
+	}
+	if _, _, err := validateEmail(email.To); err != nil {
+		return sendResult{}, err
+	}
+
+	message, err := s.buildMessage(email)
+	if err != nil {
+		return sendResult{}, err
+	}
+
+	// Wait for our turn so bulk sends do not trip the receiving domain's rate
+	// limits. Transactional email such as sign-in links never waits out a pause.
+	release := s.throttle.Acquire(recipientDomain(email.To), email.Category != categoryTransactional)
+	if s.api != nil {
+		result, err := s.api.Send(email, message)
+		release(err)
+		return result, err
+	}
 
 	// Send email
-	return smtp.SendMail(
-		addr,
-		auth,
-		s.config.From,
-		[]string{email.To},
-		message.Bytes(),
-	)
+	err = s.transport.Send(s.config.ReturnPath, []string{email.To}, message)
+	release(err)
+	if err != nil {
+		return sendResult{}, err
+	}
+	// The server accepted the message; net/smtp does not expose the reply text
+	if _, ok := s.transport.(*smtpTransport); ok {
+		return sendResult{Code: 250}, nil
+	}
+	return sendResult{}, nil
 }
@@ -0,0 +1,352 @@
+package main
+
+// emailLayout wraps every email template. Templates fill in the
+// "heading" and "content" blocks; shared strings come from the message catalog.
+const emailLayout = `<!DOCTYPE html>
+<html lang="{{.Locale}}">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <style>
+        /* Base styles */
+        body {
+            font-family: Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            margin: 0;
+            padding: 0;
+            background-color: #f9f9f9;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 20px;
+            background-color: #ffffff;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0, 0, 0, 0.1);
+        }
+        .header {
+            text-align: center;
+            padding: 20px 0;
+            background-color: #0066FF;
+            color: white;
+            border-radius: 8px 8px 0 0;
+        }
+        .content {
+            padding: 30px 20px;
+            background: #ffffff;
+        }
+        .button {
+            display: inline-block;
+            padding: 12px 24px;
+            background: #0066FF;
+            color: white !important;
+            text-decoration: none;
+            border-radius: 5px;
+            font-weight: bold;
+            margin: 20px 0;
+        }
+        .footer {
+            text-align: center;
+            padding: 20px 0;
+            font-size: 0.9em;
+            color: #666;
+            border-top: 1px solid #eee;
+        }
+        .next-steps {
+            background: #f5f7ff;
+            padding: 20px;
+            border-radius: 5px;
+            margin: 20px 0;
+        }
+        .next-steps h3 {
+            margin-top: 0;
+            color: #0066FF;
+        }
+        .support-section {
+            background: #fff8f0;
+            padding: 15px;
+            border-radius: 5px;
+            margin-top: 20px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{template "heading" .}}</h1>
+        </div>
+        <div class="content">
+            {{template "content" .}}
+
+            <div class="support-section">
+                <p><strong>{{t "layout.need_help"}}</strong></p>
+                <p>{{t "layout.support"}} <a href="mailto:{{.SupportEmail}}">{{.SupportEmail}}</a></p>
+                <p>{{t "layout.response_time"}}</p>
+            </div>
+        </div>
+        
+        <div class="footer">
+            <p>© {{.CompanyName}}. {{t "layout.rights"}}</p>
+            <p>{{t "layout.sent_to" .CustomerEmail}}</p>
+            <p><small>{{t "layout.add_contact" .SenderEmail}}</small></p>
+            {{if .UnsubscribeURL}}<p><small><a href="{{.UnsubscribeURL}}">{{t "layout.unsubscribe"}}</a></small></p>{{end}}
+        </div>
+    </div>
+</body>
+</html>`
+
+// emailTemplates holds every email the application sends, keyed by name.
+// Bodies are written in English; Variants hold translated bodies by locale.
+var emailTemplates = map[string]EmailTemplate{
+	"welcome": {
+		Name:     "welcome",
+		Category: categoryTransactional,
+		Subject:  `{{t "welcome.subject" .CourseName}}`,
+		Body: `{{define "heading"}}Welcome to {{.CourseName}}!{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+            
+            <p>Thank you for enrolling in <strong>{{.CourseName}}</strong>! We're excited to have you join us on this transformative journey into AI implementation and strategy.</p>
+            {{if .AmountPaid}}<p>{{t "order.summary" (money .AmountPaid .Currency) (date .EnrolledAt)}}</p>{{end}}
+            
+            <div class="next-steps">
+                <h3>🚀 Here's what happens next:</h3>
+                <ol>
+                    <li>Sign in with the button below — no password needed</li>
+                    <li>Access the complete course materials immediately after signing in</li>
+                    <li>Join our community of business leaders and AI innovators</li>
+                    <li>Start your learning journey at your own pace</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{if .SignInURL}}{{.SignInURL}}{{else}}{{.DomainURL}}/login{{end}}" class="button">Access Your Course</a>
+            </p>
+            {{if .SignInURL}}<p><small>This sign-in link works once. Later, sign in at {{.DomainURL}}/login with this email address.</small></p>{{end}}
+{{end}}`,
+		Variants: map[string]string{"fr": welcomeFR, "de": welcomeDE},
+	},
+	"drip_getting_started": {
+		Name:     "drip_getting_started",
+		Category: categoryOnboarding,
+		Subject:  `{{t "getting_started.subject" .CourseName}}`,
+		Body: `{{define "heading"}}Let's get you started{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Your first day in <strong>{{.CourseName}}</strong> is the best time to set yourself up for success.</p>
+
+            <div class="next-steps">
+                <h3>📋 Your first hour:</h3>
+                <ol>
+                    <li>Sign in and watch the course introduction</li>
+                    <li>Block two hours a week in your calendar for the course</li>
+                    <li>Write down the one AI initiative you want to move forward</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Start Learning</a>
+            </p>
+{{end}}`,
+		Variants: map[string]string{"fr": gettingStartedFR, "de": gettingStartedDE},
+	},
+	"drip_module_one": {
+		Name:     "drip_module_one",
+		Category: categoryOnboarding,
+		Subject:  `{{t "module_one.subject"}}`,
+		Body: `{{define "heading"}}Have you watched module 1?{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Module 1 of <strong>{{.CourseName}}</strong> lays the foundation for everything that follows. It takes less than an hour, and the frameworks it introduces come back in every later module.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Watch Module 1</a>
+            </p>
+{{end}}`,
+		Variants: map[string]string{"fr": moduleOneFR, "de": moduleOneDE},
+	},
+	"drip_check_in": {
+		Name:     "drip_check_in",
+		Category: categoryOnboarding,
+		Subject:  `{{t "check_in.subject" .CourseName}}`,
+		Body: `{{define "heading"}}One week in{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>You've been part of <strong>{{.CourseName}}</strong> for a week now. We'd love to hear how it's going and whether anything is getting in your way.</p>
+
+            <p>Just reply to this email — a real person on our team reads every response.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Continue the Course</a>
+            </p>
+{{end}}`,
+		Variants: map[string]string{"fr": checkInFR, "de": checkInDE},
+	},
+	"live_session_invite": {
+		Name:           "live_session_invite",
+		Category:       categoryAnnouncements,
+		Subject:        `{{t "live_invite.subject" .Session.Title}}`,
+		CalendarMethod: calendarRequest,
+		Body: `{{define "heading"}}You're invited: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>As a student of <strong>{{.CourseName}}</strong>, you're invited to our next live Q&amp;A call. Bring your questions — we answer as many as we can live.</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+                {{if .Session.Description}}<p>{{.Session.Description}}</p>{{end}}
+            </div>
+
+            <p>The invitation is attached, so the call shows up in your calendar with one click.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Join the Call</a>
+            </p>
+{{end}}`,
+		Variants: map[string]string{"fr": liveInviteFR, "de": liveInviteDE},
+	},
+	"live_session_update": {
+		Name:           "live_session_update",
+		Category:       categoryAnnouncements,
+		Subject:        `{{t "live_update.subject" .Session.Title}}`,
+		CalendarMethod: calendarRequest,
+		Body: `{{define "heading"}}New time: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>We've moved our live Q&amp;A call. The invitation in your calendar has been updated with the new time:</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Join the Call</a>
+            </p>
+{{end}}`,
+		Variants: map[string]string{"fr": liveUpdateFR, "de": liveUpdateDE},
+	},
+	"live_session_cancelled": {
+		Name:           "live_session_cancelled",
+		Category:       categoryAnnouncements,
+		Subject:        `{{t "live_cancel.subject" .Session.Title}}`,
+		CalendarMethod: calendarCancel,
+		Body: `{{define "heading"}}Cancelled: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Unfortunately we have had to cancel the live Q&amp;A call planned for {{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}. It has been removed from your calendar.</p>
+
+            <p>We'll let you know as soon as the next call is scheduled.</p>
+{{end}}`,
+		Variants: map[string]string{"fr": liveCancelledFR, "de": liveCancelledDE},
+	},
+	"broadcast_announcement": {
+		Name:     "broadcast_announcement",
+		Category: categoryAnnouncements,
+		Subject:  `{{.Broadcast.Subject}}`,
+		Body: `{{define "heading"}}{{.Broadcast.Heading}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+{{range .Broadcast.Paragraphs}}
+            <p>{{.}}</p>
+{{end}}
+            {{if .Broadcast.ButtonURL}}<p style="text-align: center;">
+                <a href="{{.Broadcast.ButtonURL}}" class="button">{{.Broadcast.ButtonLabel}}</a>
+            </p>{{end}}
+{{end}}`,
+	},
+	"broadcast_new_module": {
+		Name:     "broadcast_new_module",
+		Category: categoryAnnouncements,
+		Subject:  `{{.Broadcast.Subject}}`,
+		Body: `{{define "heading"}}New in {{.CourseName}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <div class="next-steps">
+                <h3>✨ {{.Broadcast.Heading}}</h3>
+{{range .Broadcast.Paragraphs}}
+                <p>{{.}}</p>
+{{end}}
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{if .Broadcast.ButtonURL}}{{.Broadcast.ButtonURL}}{{else}}{{.DomainURL}}/login{{end}}" class="button">{{if .Broadcast.ButtonLabel}}{{.Broadcast.ButtonLabel}}{{else}}Watch the New Module{{end}}</a>
+            </p>
+{{end}}`,
+	},
+	"sale_notification": {
+		Name:       "sale_notification",
+		Category:   categoryTransactional,
+		Subject:    `New sale: {{.Order.CustomerName}}{{if .Order.Company}} ({{.Order.Company}}){{end}} — {{money .Order.AmountTotal .Order.Currency}}`,
+		NoTracking: true,
+		Body: `{{define "heading"}}New sale{{end}}
+{{define "content"}}
+            <p><strong>{{.Order.CustomerName}}</strong> just enrolled in <strong>{{.Order.CourseName}}</strong>.</p>
+
+            <div class="next-steps">
+                <h3>🎉 Order details</h3>
+                <p>
+                    Email: <a href="mailto:{{.Order.CustomerEmail}}">{{.Order.CustomerEmail}}</a><br>
+                    {{if .Order.CustomerPhone}}Phone: {{.Order.CustomerPhone}}<br>{{end}}
+                    Company: {{if .Order.Company}}{{.Order.Company}}{{else}}—{{end}}<br>
+                    Job title: {{if .Order.JobTitle}}{{.Order.JobTitle}}{{else}}—{{end}}<br>
+                    {{if .Order.Country}}Country: {{.Order.Country}}<br>{{end}}
+                    Amount: {{money .Order.AmountTotal .Order.Currency}}<br>
+                    Coupon: {{if .Order.Coupon}}{{.Order.Coupon}}{{else}}none{{end}}<br>
+                    Source: {{if .Order.Source}}{{.Order.Source}}{{else}}direct{{end}}
+                </p>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/admin/sequences" class="button">Open the Admin</a>
+            </p>
+{{end}}`,
+	},
+	"sign_in": {
+		Name:       "sign_in",
+		Category:   categoryTransactional,
+		Subject:    `{{t "sign_in.subject" .CourseName}}`,
+		NoTracking: true,
+		Body: `{{define "heading"}}Sign in to {{.CourseName}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Click the button below to sign in. The link works once, for the next 15 minutes.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.SignInURL}}" class="button">Sign In</a>
+            </p>
+
+            <p><small>If you didn't ask to sign in, you can ignore this email. Nobody can sign in without this link.</small></p>
+{{end}}`,
+		Variants: map[string]string{"fr": signInFR, "de": signInDE},
+	},
+	"password_reset": {
+		Name:       "password_reset",
+		Category:   categoryTransactional,
+		Subject:    `{{t "password_reset.subject" .CourseName}}`,
+		NoTracking: true,
+		Body: `{{define "heading"}}Reset your password{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Click the button below to choose a new password for {{.CourseName}}. The link works once, for the next hour.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.PasswordResetURL}}" class="button">Choose a New Password</a>
+            </p>
+
+            <p><small>If you didn't ask to reset your password, you can ignore this email. Your password stays the same.</small></p>
+{{end}}`,
+		Variants: map[string]string{"fr": passwordResetFR, "de": passwordResetDE},
+	},
+}
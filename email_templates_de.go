@@ -0,0 +1,140 @@
+package main
+
+// German variants of the email bodies in emailTemplates
+
+const welcomeDE = `{{define "heading"}}Willkommen bei {{.CourseName}}!{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Vielen Dank für Ihre Anmeldung zu <strong>{{.CourseName}}</strong>! Wir freuen uns, Sie auf Ihrem Weg zu KI-Strategie und -Umsetzung zu begleiten.</p>
+            {{if .AmountPaid}}<p>{{t "order.summary" (money .AmountPaid .Currency) (date .EnrolledAt)}}</p>{{end}}
+
+            <div class="next-steps">
+                <h3>🚀 So geht es weiter:</h3>
+                <ol>
+                    <li>Melden Sie sich über die Schaltfläche unten an – ganz ohne Passwort</li>
+                    <li>Nach der Anmeldung steht Ihnen das gesamte Kursmaterial sofort zur Verfügung</li>
+                    <li>Werden Sie Teil unserer Community aus Führungskräften und KI-Innovatoren</li>
+                    <li>Lernen Sie in Ihrem eigenen Tempo</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{if .SignInURL}}{{.SignInURL}}{{else}}{{.DomainURL}}/login{{end}}" class="button">Zum Kurs</a>
+            </p>
+            {{if .SignInURL}}<p><small>Dieser Anmeldelink funktioniert einmal. Später melden Sie sich unter {{.DomainURL}}/login mit dieser E-Mail-Adresse an.</small></p>{{end}}
+{{end}}`
+
+const gettingStartedDE = `{{define "heading"}}Los geht's{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Ihr erster Tag in <strong>{{.CourseName}}</strong> ist der beste Zeitpunkt, um die Weichen für Ihren Erfolg zu stellen.</p>
+
+            <div class="next-steps">
+                <h3>📋 Ihre erste Stunde:</h3>
+                <ol>
+                    <li>Melden Sie sich an und sehen Sie sich die Kurseinführung an</li>
+                    <li>Planen Sie zwei Stunden pro Woche für den Kurs ein</li>
+                    <li>Notieren Sie die eine KI-Initiative, die Sie voranbringen möchten</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Jetzt starten</a>
+            </p>
+{{end}}`
+
+const moduleOneDE = `{{define "heading"}}Haben Sie Modul 1 schon gesehen?{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Modul 1 von <strong>{{.CourseName}}</strong> legt die Grundlage für alles Weitere. Es dauert weniger als eine Stunde, und die vorgestellten Methoden begegnen Ihnen in jedem folgenden Modul wieder.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Modul 1 ansehen</a>
+            </p>
+{{end}}`
+
+const checkInDE = `{{define "heading"}}Eine Woche dabei{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Sie sind jetzt seit einer Woche bei <strong>{{.CourseName}}</strong> dabei. Wir würden gern erfahren, wie es Ihnen geht und ob Sie etwas aufhält.</p>
+
+            <p>Antworten Sie einfach auf diese E-Mail – jede Antwort wird von einem echten Menschen in unserem Team gelesen.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Kurs fortsetzen</a>
+            </p>
+{{end}}`
+
+const liveInviteDE = `{{define "heading"}}Einladung: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Als Teilnehmer von <strong>{{.CourseName}}</strong> laden wir Sie zu unserer nächsten Live-Fragestunde ein. Bringen Sie Ihre Fragen mit – wir beantworten so viele wie möglich live.</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+                {{if .Session.Description}}<p>{{.Session.Description}}</p>{{end}}
+            </div>
+
+            <p>Die Einladung ist angehängt, sodass Sie den Termin mit einem Klick in Ihren Kalender übernehmen können.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Zum Call</a>
+            </p>
+{{end}}`
+
+const liveUpdateDE = `{{define "heading"}}Neuer Termin: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Unsere Live-Fragestunde wurde verschoben. Die Einladung in Ihrem Kalender wurde auf den neuen Termin aktualisiert:</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Zum Call</a>
+            </p>
+{{end}}`
+
+const liveCancelledDE = `{{define "heading"}}Abgesagt: {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Leider müssen wir die für den {{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}} geplante Live-Fragestunde absagen. Der Termin wurde aus Ihrem Kalender entfernt.</p>
+
+            <p>Wir melden uns, sobald die nächste Fragestunde feststeht.</p>
+{{end}}`
+
+const signInDE = `{{define "heading"}}Anmeldung bei {{.CourseName}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Klicken Sie auf die Schaltfläche unten, um sich anzumelden. Der Link funktioniert einmal und ist 15 Minuten gültig.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.SignInURL}}" class="button">Anmelden</a>
+            </p>
+
+            <p><small>Falls Sie keine Anmeldung angefordert haben, können Sie diese E-Mail ignorieren. Ohne diesen Link kann sich niemand anmelden.</small></p>
+{{end}}`
+
+const passwordResetDE = `{{define "heading"}}Passwort zurücksetzen{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Klicken Sie auf die Schaltfläche unten, um ein neues Passwort für {{.CourseName}} festzulegen. Der Link funktioniert einmal und ist eine Stunde gültig.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.PasswordResetURL}}" class="button">Neues Passwort festlegen</a>
+            </p>
+
+            <p><small>Falls Sie das Zurücksetzen nicht angefordert haben, können Sie diese E-Mail ignorieren. Ihr Passwort bleibt unverändert.</small></p>
+{{end}}`
@@ -0,0 +1,140 @@
+package main
+
+// French variants of the email bodies in emailTemplates
+
+const welcomeFR = `{{define "heading"}}Bienvenue dans {{.CourseName}} !{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Merci de votre inscription à <strong>{{.CourseName}}</strong> ! Nous sommes ravis de vous accompagner dans ce parcours consacré à la stratégie et à la mise en œuvre de l'IA.</p>
+            {{if .AmountPaid}}<p>{{t "order.summary" (money .AmountPaid .Currency) (date .EnrolledAt)}}</p>{{end}}
+
+            <div class="next-steps">
+                <h3>🚀 Les prochaines étapes :</h3>
+                <ol>
+                    <li>Connectez-vous avec le bouton ci-dessous, sans mot de passe</li>
+                    <li>Accédez à l'ensemble du contenu dès votre connexion</li>
+                    <li>Rejoignez notre communauté de dirigeants et d'innovateurs en IA</li>
+                    <li>Avancez à votre rythme</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{if .SignInURL}}{{.SignInURL}}{{else}}{{.DomainURL}}/login{{end}}" class="button">Accéder au cours</a>
+            </p>
+            {{if .SignInURL}}<p><small>Ce lien de connexion ne fonctionne qu'une fois. Par la suite, connectez-vous sur {{.DomainURL}}/login avec cette adresse e-mail.</small></p>{{end}}
+{{end}}`
+
+const gettingStartedFR = `{{define "heading"}}C'est parti !{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Votre premier jour dans <strong>{{.CourseName}}</strong> est le meilleur moment pour bien vous organiser.</p>
+
+            <div class="next-steps">
+                <h3>📋 Votre première heure :</h3>
+                <ol>
+                    <li>Connectez-vous et regardez l'introduction du cours</li>
+                    <li>Réservez deux heures par semaine dans votre agenda</li>
+                    <li>Notez le projet d'IA que vous souhaitez faire avancer</li>
+                </ol>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Commencer</a>
+            </p>
+{{end}}`
+
+const moduleOneFR = `{{define "heading"}}Avez-vous regardé le module 1 ?{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Le module 1 de <strong>{{.CourseName}}</strong> pose les bases de tout le reste. Il dure moins d'une heure, et les méthodes qu'il présente reviennent dans chaque module suivant.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Regarder le module 1</a>
+            </p>
+{{end}}`
+
+const checkInFR = `{{define "heading"}}Une semaine déjà{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Vous suivez <strong>{{.CourseName}}</strong> depuis une semaine. Nous aimerions savoir comment cela se passe et si quelque chose vous freine.</p>
+
+            <p>Répondez simplement à cet e-mail : chaque réponse est lue par une vraie personne de notre équipe.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.DomainURL}}/login" class="button">Continuer le cours</a>
+            </p>
+{{end}}`
+
+const liveInviteFR = `{{define "heading"}}Vous êtes invité : {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>En tant qu'élève de <strong>{{.CourseName}}</strong>, vous êtes invité à notre prochaine session de questions-réponses en direct. Venez avec vos questions : nous répondons à un maximum d'entre elles en direct.</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+                {{if .Session.Description}}<p>{{.Session.Description}}</p>{{end}}
+            </div>
+
+            <p>L'invitation est jointe : ajoutez la session à votre agenda en un clic.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Rejoindre la session</a>
+            </p>
+{{end}}`
+
+const liveUpdateFR = `{{define "heading"}}Nouvel horaire : {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Notre session de questions-réponses en direct a été déplacée. L'invitation dans votre agenda a été mise à jour avec le nouvel horaire :</p>
+
+            <div class="next-steps">
+                <h3>📅 {{.Session.Title}}</h3>
+                <p>{{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}</p>
+            </div>
+
+            <p style="text-align: center;">
+                <a href="{{.Session.JoinURL}}" class="button">Rejoindre la session</a>
+            </p>
+{{end}}`
+
+const liveCancelledFR = `{{define "heading"}}Annulée : {{.Session.Title}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Nous avons malheureusement dû annuler la session de questions-réponses prévue le {{t "live.when" (date .Session.LocalStart) (clock .Session.LocalStart) .Session.TimeZone}}. Elle a été retirée de votre agenda.</p>
+
+            <p>Nous vous préviendrons dès que la prochaine session sera programmée.</p>
+{{end}}`
+
+const signInFR = `{{define "heading"}}Connexion à {{.CourseName}}{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Cliquez sur le bouton ci-dessous pour vous connecter. Le lien ne fonctionne qu'une fois, pendant 15 minutes.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.SignInURL}}" class="button">Se connecter</a>
+            </p>
+
+            <p><small>Si vous n'avez pas demandé à vous connecter, ignorez cet e-mail. Personne ne peut se connecter sans ce lien.</small></p>
+{{end}}`
+
+const passwordResetFR = `{{define "heading"}}Réinitialisez votre mot de passe{{end}}
+{{define "content"}}
+            <p>{{t "greeting" .CustomerName}}</p>
+
+            <p>Cliquez sur le bouton ci-dessous pour choisir un nouveau mot de passe pour {{.CourseName}}. Le lien ne fonctionne qu'une fois, pendant une heure.</p>
+
+            <p style="text-align: center;">
+                <a href="{{.PasswordResetURL}}" class="button">Choisir un nouveau mot de passe</a>
+            </p>
+
+            <p><small>Si vous n'avez pas demandé à réinitialiser votre mot de passe, ignorez cet e-mail. Votre mot de passe reste inchangé.</small></p>
+{{end}}`
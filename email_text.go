@@ -0,0 +1,49 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlHeadPattern    = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	htmlLinkPattern    = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlItemPattern    = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlBreakPattern   = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|ol|ul|tr)>`)
+	htmlTagPattern     = regexp.MustCompile(`<[^>]*>`)
+	blankLinesPattern  = regexp.MustCompile(`\n{3,}`)
+	inlineSpacePattern = regexp.MustCompile(`[ \t]+`)
+)
+
+// htmlToText derives the plain-text version of an HTML email
+func htmlToText(content string) string {
+	text := htmlHeadPattern.ReplaceAllString(content, "")
+	text = htmlLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		m := htmlLinkPattern.FindStringSubmatch(link)
+		href, label := m[1], strings.TrimSpace(htmlTagPattern.ReplaceAllString(m[2], ""))
+		if strings.HasPrefix(href, "mailto:") || label == href || label == "" {
+			return label
+		}
+		return label + " (" + href + ")"
+	})
+	text = htmlItemPattern.ReplaceAllString(text, "\n- ")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(inlineSpacePattern.ReplaceAllString(line, " "))
+		// Keep list items together instead of separating them with blank lines
+		if strings.HasPrefix(line, "- ") {
+			for len(lines) > 1 && lines[len(lines)-1] == "" && strings.HasPrefix(lines[len(lines)-2], "- ") {
+				lines = lines[:len(lines)-1]
+			}
+		}
+		lines = append(lines, line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// Validation errors; errDisposableEmail is also an errInvalidEmail
+var (
+	errInvalidEmail    = errors.New("invalid email address")
+	errDisposableEmail = fmt.Errorf("%w: disposable email provider", errInvalidEmail)
+)
+
+// disposableDomains are throwaway inbox providers we do not accept.
+// DISPOSABLE_EMAIL_DOMAINS adds more.
+var disposableDomains = map[string]bool{
+	"10minutemail.com":  true,
+	"discard.email":     true,
+	"dispostable.com":   true,
+	"emailondeck.com":   true,
+	"fakeinbox.com":     true,
+	"getnada.com":       true,
+	"guerrillamail.com": true,
+	"guerrillamail.net": true,
+	"maildrop.cc":       true,
+	"mailinator.com":    true,
+	"mailnesia.com":     true,
+	"mintemail.com":     true,
+	"mohmal.com":        true,
+	"sharklasers.com":   true,
+	"temp-mail.org":     true,
+	"tempmail.com":      true,
+	"tempmailo.com":     true,
+	"throwawaymail.com": true,
+	"trashmail.com":     true,
+	"yopmail.com":       true,
+}
+
+// commonDomains are the mailbox providers most of our customers use; typos of
+// them get a "did you mean" suggestion
+var commonDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "yahoo.fr", "yahoo.de", "yahoo.es",
+	"yahoo.it", "ymail.com", "hotmail.com", "hotmail.fr", "hotmail.de", "hotmail.it",
+	"outlook.com", "outlook.fr", "outlook.de", "live.com", "msn.com", "icloud.com",
+	"me.com", "aol.com", "mail.com", "email.com", "protonmail.com", "proton.me",
+	"gmx.de", "gmx.net", "web.de", "t-online.de", "orange.fr", "free.fr", "wanadoo.fr",
+	"laposte.net", "sfr.fr",
+}
+
+// tldTypos are top-level domains that are almost always a slip of the finger
+var tldTypos = map[string]string{
+	"con": "com", "cmo": "com", "ocm": "com", "vom": "com", "xom": "com", "comm": "com", "coom": "com",
+	"nte": "net", "nett": "net", "ogr": "org", "orgg": "org",
+}
+
+// validateEmail checks that an address is syntactically valid and not a
+// disposable inbox, without looking anything up in DNS. It returns the
+// address in canonical form, and a likely intended address when the domain
+// looks like a typo.
+func validateEmail(address string) (normalized, suggestion string, err error) {
+	address = strings.TrimSpace(address)
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Name != "" || parsed.Address != address {
+		return "", "", fmt.Errorf("%w: %q is not an email address", errInvalidEmail, address)
+	}
+
+	at := strings.LastIndex(address, "@")
+	local, domain := address[:at], strings.ToLower(address[at+1:])
+	if len(local) > 64 || len(address) > 254 {
+		return "", "", fmt.Errorf("%w: %q is too long", errInvalidEmail, address)
+	}
+	if !validDomain(domain) {
+		return "", "", fmt.Errorf("%w: %q is not a valid domain", errInvalidEmail, domain)
+	}
+	if isDisposableDomain(domain) {
+		return "", "", fmt.Errorf("%w %s", errDisposableEmail, domain)
+	}
+
+	normalized = local + "@" + domain
+	if suggested := suggestDomain(domain); suggested != "" {
+		suggestion = local + "@" + suggested
+	}
+	return normalized, suggestion, nil
+}
+
+// validDomain reports whether a domain is made of valid hostname labels and
+// ends in an alphabetic top-level domain
+func validDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	for _, c := range tld {
+		if c < 'a' || c > 'z' {
+			// Punycode TLDs such as xn--p1ai are allowed
+			return strings.HasPrefix(tld, "xn--")
+		}
+	}
+	return true
+}
+
+// isDisposableDomain reports whether the domain or one of its parents is a
+// disposable inbox provider
+func isDisposableDomain(domain string) bool {
+	extra := splitList(os.Getenv("DISPOSABLE_EMAIL_DOMAINS"))
+	for d := domain; strings.Contains(d, "."); d = d[strings.Index(d, ".")+1:] {
+		if disposableDomains[d] {
+			return true
+		}
+		for _, e := range extra {
+			if strings.EqualFold(d, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// suggestDomain returns the common domain a mistyped domain most likely
+// meant, or "" when the domain looks intended
+func suggestDomain(domain string) string {
+	// Fix a slip in the TLD first, so gmail.con is matched as gmail.com
+	fixed := domain
+	if dot := strings.LastIndex(domain, "."); dot > 0 {
+		if tld, ok := tldTypos[domain[dot+1:]]; ok {
+			fixed = domain[:dot+1] + tld
+		}
+	}
+
+	best, bestDistance := fixed, 3
+	for _, common := range commonDomains {
+		if fixed == common {
+			best = common
+			break
+		}
+		// A provider under another country's domain, such as hotmail.es, is
+		// most likely intended
+		if withoutTLD(fixed) == withoutTLD(common) {
+			continue
+		}
+		// Short domains need a closer match to avoid far-fetched suggestions
+		limit := 2
+		if len(common) < 8 {
+			limit = 1
+		}
+		if d := editDistance(fixed, common); d <= limit && d < bestDistance {
+			best, bestDistance = common, d
+		}
+	}
+	if best == domain {
+		return ""
+	}
+	return best
+}
+
+// withoutTLD returns a domain without its top-level domain
+func withoutTLD(domain string) string {
+	if dot := strings.LastIndex(domain, "."); dot > 0 {
+		return domain[:dot]
+	}
+	return domain
+}
+
+// editDistance is the number of single-character insertions, deletions,
+// substitutions and adjacent transpositions that turn a into b
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// stubDNS makes every DNS query, MX lookups included, fail the test, so
+// validation is shown to work offline
+func stubDNS(t *testing.T) {
+	t.Helper()
+	resolver := net.DefaultResolver
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			t.Errorf("DNS query sent to %s", address)
+			return nil, errors.New("DNS is not available in tests")
+		},
+	}
+	t.Cleanup(func() { net.DefaultResolver = resolver })
+}
+
+func TestValidateEmail(t *testing.T) {
+	stubDNS(t)
+	t.Setenv("DISPOSABLE_EMAIL_DOMAINS", "spam.example, Burner.Test")
+
+	tests := []struct {
+		address    string
+		normalized string
+		suggestion string
+		err        error
+	}{
+		// Syntax and normalization
+		{"ada@example.com", "ada@example.com", "", nil},
+		{"  Ada.Lovelace@Example.COM \n", "Ada.Lovelace@example.com", "", nil},
+		{"ada+courses@sub.example.co.uk", "ada+courses@sub.example.co.uk", "", nil},
+		{"ada@xn--80ak6aa92e.xn--p1ai", "ada@xn--80ak6aa92e.xn--p1ai", "", nil},
+		{"ada@my-company.io", "ada@my-company.io", "", nil},
+		{"", "", "", errInvalidEmail},
+		{"ada", "", "", errInvalidEmail},
+		{"ada@", "", "", errInvalidEmail},
+		{"@example.com", "", "", errInvalidEmail},
+		{"ada@@example.com", "", "", errInvalidEmail},
+		{"ada lovelace@example.com", "", "", errInvalidEmail},
+		{"Ada <ada@example.com>", "", "", errInvalidEmail},
+		{"<ada@example.com>", "", "", errInvalidEmail},
+		{"ada@example.com, bob@example.com", "", "", errInvalidEmail},
+		{"ada@example", "", "", errInvalidEmail},
+		{"ada@example.c", "", "", errInvalidEmail},
+		{"ada@example.123", "", "", errInvalidEmail},
+		{"ada@192.168.0.1", "", "", errInvalidEmail},
+		{"ada@[192.168.0.1]", "", "", errInvalidEmail},
+		{"ada@exa_mple.com", "", "", errInvalidEmail},
+		{"ada@-example.com", "", "", errInvalidEmail},
+		{"ada@example-.com", "", "", errInvalidEmail},
+		{"ada@example..com", "", "", errInvalidEmail},
+		{strings.Repeat("a", 65) + "@example.com", "", "", errInvalidEmail},
+		{"ada@" + strings.Repeat("a", 64) + ".com", "", "", errInvalidEmail},
+		{"ada@" + strings.Repeat(strings.Repeat("a", 60)+".", 5) + "com", "", "", errInvalidEmail},
+
+		// Disposable providers, their subdomains and DISPOSABLE_EMAIL_DOMAINS
+		{"ada@mailinator.com", "", "", errDisposableEmail},
+		{"ada@MAILINATOR.com", "", "", errDisposableEmail},
+		{"ada@eu.yopmail.com", "", "", errDisposableEmail},
+		{"ada@spam.example", "", "", errDisposableEmail},
+		{"ada@burner.test", "", "", errDisposableEmail},
+		{"ada@mailinator.com.example.com", "ada@mailinator.com.example.com", "", nil},
+
+		// Typos of common providers
+		{"ada@gmial.com", "ada@gmial.com", "ada@gmail.com", nil},
+		{"ada@gamil.com", "ada@gamil.com", "ada@gmail.com", nil},
+		{"ada@gmai.com", "ada@gmai.com", "ada@gmail.com", nil},
+		{"Ada@GMAIL.CON", "Ada@gmail.con", "Ada@gmail.com", nil},
+		{"ada@hotmial.com", "ada@hotmial.com", "ada@hotmail.com", nil},
+		{"ada@hotmai.de", "ada@hotmai.de", "ada@hotmail.de", nil},
+		{"ada@hotmal.it", "ada@hotmal.it", "ada@hotmail.it", nil},
+		{"ada@yaho.com", "ada@yaho.com", "ada@yahoo.com", nil},
+		{"ada@yahooo.es", "ada@yahooo.es", "ada@yahoo.es", nil},
+		{"ada@yhaoo.it", "ada@yhaoo.it", "ada@yahoo.it", nil},
+		{"ada@ymial.com", "ada@ymial.com", "ada@ymail.com", nil},
+		{"ada@outlok.de", "ada@outlok.de", "ada@outlook.de", nil},
+		{"ada@iclod.com", "ada@iclod.com", "ada@icloud.com", nil},
+		{"ada@company.cmo", "ada@company.cmo", "ada@company.com", nil},
+		{"ada@company.nte", "ada@company.nte", "ada@company.net", nil},
+		{"ada@hotmial.con", "ada@hotmial.con", "ada@hotmail.com", nil},
+		{"ada@yahoo.con", "ada@yahoo.con", "ada@yahoo.com", nil},
+
+		// Intended domains that look like typos
+		{"ada@mail.com", "ada@mail.com", "", nil},
+		{"ada@email.com", "ada@email.com", "", nil},
+		{"ada@ymail.com", "ada@ymail.com", "", nil},
+		{"ada@hotmail.de", "ada@hotmail.de", "", nil},
+		{"ada@outlook.de", "ada@outlook.de", "", nil},
+		{"ada@yahoo.es", "ada@yahoo.es", "", nil},
+		{"ada@yahoo.it", "ada@yahoo.it", "", nil},
+		{"ada@acme.com", "ada@acme.com", "", nil},
+		{"ada@me.org", "ada@me.org", "", nil},
+
+		// The same provider under another TLD
+		{"ada@hotmail.es", "ada@hotmail.es", "", nil},
+		{"ada@hotmail.co.uk", "ada@hotmail.co.uk", "", nil},
+		{"ada@yahoo.ca", "ada@yahoo.ca", "", nil},
+		{"ada@outlook.it", "ada@outlook.it", "", nil},
+		{"ada@gmx.at", "ada@gmx.at", "", nil},
+		{"ada@web.at", "ada@web.at", "", nil},
+		{"ada@gmail.de", "ada@gmail.de", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			normalized, suggestion, err := validateEmail(tt.address)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				if tt.err == errInvalidEmail && errors.Is(err, errDisposableEmail) {
+					t.Errorf("err = %v, want a syntax error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if normalized != tt.normalized || suggestion != tt.suggestion {
+				t.Errorf("got %q, %q; want %q, %q", normalized, suggestion, tt.normalized, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestDisposableEmailIsInvalid(t *testing.T) {
+	if !errors.Is(errDisposableEmail, errInvalidEmail) {
+		t.Error("a disposable address is not an invalid address")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"gmail", "gmail", 0},
+		{"gmail", "", 5},
+		{"gmial", "gmail", 1}, // transposition
+		{"gmai", "gmail", 1},  // deletion
+		{"gmaill", "gmail", 1},
+		{"gmall", "gmail", 1},
+		{"yhaoo", "yahoo", 1},
+		{"hotmial.con", "hotmail.com", 2},
+		{"outlook", "hotmail", 6},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const enrollmentsFile = "enrollments.json"
+
+// EnrollmentStore keeps track of fulfilled purchases
+type EnrollmentStore struct {
+	mu          sync.Mutex
+	enrollments map[string]*Enrollment
+}
+
+// NewEnrollmentStore creates an enrollment store backed by the data directory
+func NewEnrollmentStore() (*EnrollmentStore, error) {
+	s := &EnrollmentStore{enrollments: make(map[string]*Enrollment)}
+	if err := loadJSON(enrollmentsFile, &s.enrollments); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add stores a new enrollment. It reports false if the enrollment already exists,
+// so a reloaded success page never fulfills the same order twice.
+func (s *EnrollmentStore) Add(e Enrollment) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.enrollments[e.ID]; ok {
+		return false, nil
+	}
+	s.enrollments[e.ID] = &e
+	return true, s.save()
+}
+
+// Get returns the enrollment with the given ID
+func (s *EnrollmentStore) Get(id string) (Enrollment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.enrollments[id]
+	if !ok {
+		return Enrollment{}, false
+	}
+	return *e, true
+}
+
+// List returns all enrollments, oldest first
+func (s *EnrollmentStore) List() []Enrollment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Enrollment, 0, len(s.enrollments))
+	for _, e := range s.enrollments {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].EnrolledAt.Before(list[j].EnrolledAt)
+	})
+	return list
+}
+
+// Update applies fn to the enrollment with the given ID and persists the result
+func (s *EnrollmentStore) Update(id string, fn func(*Enrollment)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.enrollments[id]
+	if !ok {
+		return fmt.Errorf("enrollment %s not found", id)
+	}
+	fn(e)
+	return s.save()
+}
+
+// FindByPaymentIntent returns the enrollment paid with the given payment intent
+func (s *EnrollmentStore) FindByPaymentIntent(paymentIntentID string) (Enrollment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.enrollments {
+		if e.PaymentIntentID == paymentIntentID {
+			return *e, true
+		}
+	}
+	return Enrollment{}, false
+}
+
+// FindByEmail returns every enrollment purchased with the given address
+func (s *EnrollmentStore) FindByEmail(email string) []Enrollment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Enrollment
+	for _, e := range s.enrollments {
+		if strings.EqualFold(e.CustomerEmail, email) {
+			list = append(list, *e)
+		}
+	}
+	return list
+}
+
+// Active reports whether the enrollment still gives access to the course:
+// it was not refunded and its team seat was not taken back
+func (e Enrollment) Active() bool {
+	return !e.Refunded && e.RevokedAt.IsZero()
+}
+
+// enrollmentEmailData returns the template data for emails about an enrollment
+func enrollmentEmailData(e Enrollment) EmailData {
+	return EmailData{
+		CustomerName:  e.CustomerName,
+		CustomerEmail: e.CustomerEmail,
+		CourseName:    e.CourseName,
+		CompanyName:   os.Getenv("COMPANY_NAME"),
+		SupportEmail:  os.Getenv("SUPPORT_EMAIL"),
+		Locale:        e.Locale,
+		AmountPaid:    e.AmountTotal,
+		Currency:      e.Currency,
+		EnrolledAt:    e.EnrolledAt,
+	}
+}
+
+// save writes the enrollments to disk; callers must hold s.mu
+func (s *EnrollmentStore) save() error {
+	return saveJSON(enrollmentsFile, s.enrollments)
+}
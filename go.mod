@@ -3,6 +3,19 @@ module apex-ai
 go 1.23.6
 
 require (
+	github.com/beevik/etree v1.1.0
+	github.com/coreos/go-oidc/v3 v3.11.0
+	github.com/emersion/go-msgauth v0.7.0
+	github.com/go-jose/go-jose/v4 v4.0.2
 	github.com/joho/godotenv v1.5.1
+	github.com/russellhaering/goxmldsig v1.4.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stripe/stripe-go/v74 v74.30.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/oauth2 v0.23.0
+)
+
+require (
+	github.com/jonboulle/clockwork v0.2.2 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
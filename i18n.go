@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLocale is used when the customer's language is not supported
+const defaultLocale = "en"
+
+// messages holds the translated strings used by email layouts and subjects,
+// keyed by locale and message key. Missing keys fall back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"layout.need_help":        "Need Help?",
+		"layout.support":          "Our support team is here for you at",
+		"layout.response_time":    "We typically respond within 2 hours during business hours.",
+		"layout.rights":           "All rights reserved.",
+		"layout.sent_to":          "This email was sent to %s",
+		"layout.add_contact":      "Please add %s to your contacts to ensure you receive our communications.",
+		"layout.unsubscribe":      "Unsubscribe or manage your email preferences",
+		"greeting":                "Dear %s,",
+		"order.summary":           "Your payment of %s on %s has been received.",
+		"welcome.subject":         "Welcome to %s!",
+		"getting_started.subject": "Getting started with %s",
+		"module_one.subject":      "Have you watched module 1 yet?",
+		"check_in.subject":        "How is %s going?",
+		"live.when":               "%s at %s (%s)",
+		"live_invite.subject":     "Live Q&A: %s",
+		"live_update.subject":     "Rescheduled: %s",
+		"live_cancel.subject":     "Cancelled: %s",
+		"sign_in.subject":         "Your sign-in link for %s",
+		"password_reset.subject":  "Reset your %s password",
+	},
+	"fr": {
+		"layout.need_help":        "Besoin d'aide ?",
+		"layout.support":          "Notre équipe d'assistance est à votre disposition à l'adresse",
+		"layout.response_time":    "Nous répondons généralement en moins de 2 heures pendant les heures ouvrées.",
+		"layout.rights":           "Tous droits réservés.",
+		"layout.sent_to":          "Cet e-mail a été envoyé à %s",
+		"layout.add_contact":      "Ajoutez %s à vos contacts pour être sûr de recevoir nos messages.",
+		"layout.unsubscribe":      "Se désabonner ou gérer vos préférences d'e-mail",
+		"greeting":                "Bonjour %s,",
+		"order.summary":           "Votre paiement de %s du %s a bien été reçu.",
+		"welcome.subject":         "Bienvenue dans %s !",
+		"getting_started.subject": "Bien démarrer avec %s",
+		"module_one.subject":      "Avez-vous regardé le module 1 ?",
+		"check_in.subject":        "Comment se passe %s ?",
+		"live.when":               "%s à %s (%s)",
+		"live_invite.subject":     "Session en direct : %s",
+		"live_update.subject":     "Reprogrammée : %s",
+		"live_cancel.subject":     "Annulée : %s",
+		"sign_in.subject":         "Votre lien de connexion à %s",
+		"password_reset.subject":  "Réinitialisez votre mot de passe %s",
+	},
+	"de": {
+		"layout.need_help":        "Brauchen Sie Hilfe?",
+		"layout.support":          "Unser Support-Team erreichen Sie unter",
+		"layout.response_time":    "Während der Geschäftszeiten antworten wir in der Regel innerhalb von 2 Stunden.",
+		"layout.rights":           "Alle Rechte vorbehalten.",
+		"layout.sent_to":          "Diese E-Mail wurde an %s gesendet",
+		"layout.add_contact":      "Bitte fügen Sie %s zu Ihren Kontakten hinzu, damit Sie unsere Nachrichten sicher erhalten.",
+		"layout.unsubscribe":      "Abmelden oder E-Mail-Einstellungen verwalten",
+		"greeting":                "Guten Tag %s,",
+		"order.summary":           "Ihre Zahlung über %s vom %s ist eingegangen.",
+		"welcome.subject":         "Willkommen bei %s!",
+		"getting_started.subject": "Ihr Einstieg in %s",
+		"module_one.subject":      "Haben Sie Modul 1 schon gesehen?",
+		"check_in.subject":        "Wie läuft %s?",
+		"live.when":               "%s um %s Uhr (%s)",
+		"live_invite.subject":     "Live-Fragestunde: %s",
+		"live_update.subject":     "Verschoben: %s",
+		"live_cancel.subject":     "Abgesagt: %s",
+		"sign_in.subject":         "Ihr Anmeldelink für %s",
+		"password_reset.subject":  "Ihr Passwort für %s zurücksetzen",
+	},
+}
+
+// monthNames are the month names used in long dates
+var monthNames = map[string][12]string{
+	"fr": {"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	"de": {"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+}
+
+// countryLocales maps billing countries to the locale we write to them in
+var countryLocales = map[string]string{
+	"FR": "fr", "BE": "fr", "LU": "fr", "MC": "fr", "SN": "fr", "CI": "fr",
+	"DE": "de", "AT": "de", "LI": "de", "CH": "de",
+}
+
+// currencySymbols are the symbols used when formatting amounts
+var currencySymbols = map[string]string{
+	"usd": "$", "eur": "€", "gbp": "£", "chf": "CHF", "cad": "CA$",
+}
+
+// matchLocale maps a language tag such as "fr-CA" to a supported locale
+func matchLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	base, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if _, ok := messages[base]; ok {
+		return base
+	}
+	return ""
+}
+
+// acceptLanguageLocale returns the best supported locale from an Accept-Language header
+func acceptLanguageLocale(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if locale := matchLocale(t.tag); locale != "" {
+			return locale
+		}
+	}
+	return ""
+}
+
+// customerLocale picks the locale for a buyer from the checkout locale, the
+// browser's languages and the billing country, in that order
+func customerLocale(checkoutLocale string, r *http.Request, country string) string {
+	if checkoutLocale != "auto" {
+		if locale := matchLocale(checkoutLocale); locale != "" {
+			return locale
+		}
+	}
+	if r != nil {
+		if locale := acceptLanguageLocale(r.Header.Get("Accept-Language")); locale != "" {
+			return locale
+		}
+	}
+	if locale, ok := countryLocales[strings.ToUpper(country)]; ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// translate looks up a message for the locale, falling back to English
+func translate(locale, key string, args ...any) string {
+	msg, ok := messages[locale][key]
+	if !ok {
+		msg, ok = messages[defaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// formatDate formats a date the way the locale writes long dates
+func formatDate(locale string, t time.Time) string {
+	switch locale {
+	case "fr":
+		return fmt.Sprintf("%d %s %d", t.Day(), monthNames["fr"][t.Month()-1], t.Year())
+	case "de":
+		return fmt.Sprintf("%d. %s %d", t.Day(), monthNames["de"][t.Month()-1], t.Year())
+	default:
+		return t.Format("January 2, 2006")
+	}
+}
+
+// formatClock formats a time of day the way the locale writes it
+func formatClock(locale string, t time.Time) string {
+	if locale == defaultLocale {
+		return t.Format("3:04 PM")
+	}
+	return t.Format("15:04")
+}
+
+// formatMoney formats an amount in the currency's smallest unit for the locale
+func formatMoney(locale string, amount int64, currency string) string {
+	currency = strings.ToLower(currency)
+	symbol, ok := currencySymbols[currency]
+	if !ok {
+		symbol = strings.ToUpper(currency)
+	}
+
+	negative := amount < 0
+	if negative {
+		amount = -amount
+	}
+	units, cents := amount/100, amount%100
+
+	// Group thousands with the locale's separator
+	digits := strconv.FormatInt(units, 10)
+	var groups []string
+	for len(digits) > 3 {
+		groups = append([]string{digits[len(digits)-3:]}, groups...)
+		digits = digits[:len(digits)-3]
+	}
+	groups = append([]string{digits}, groups...)
+
+	var formatted string
+	switch locale {
+	case "fr":
+		// French uses a narrow no-break space between groups and puts the symbol last
+		formatted = fmt.Sprintf("%s,%02d %s", strings.Join(groups, " "), cents, symbol)
+	case "de":
+		formatted = fmt.Sprintf("%s,%02d %s", strings.Join(groups, "."), cents, symbol)
+	default:
+		formatted = fmt.Sprintf("%s%s.%02d", symbol, strings.Join(groups, ","), cents)
+	}
+	if negative {
+		formatted = "-" + formatted
+	}
+	return formatted
+}
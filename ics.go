@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iTIP methods (RFC 5546) used for live session invites
+const (
+	calendarRequest = "REQUEST"
+	calendarCancel  = "CANCEL"
+)
+
+// icsLocalFormat and icsUTCFormat are the iCalendar DATE-TIME forms
+const (
+	icsLocalFormat = "20060102T150405"
+	icsUTCFormat   = "20060102T150405Z"
+)
+
+// calendarParty is the organizer or an attendee of an event
+type calendarParty struct {
+	Name  string
+	Email string
+}
+
+// buildICS renders a live session as an iCalendar object (RFC 5545) for a
+// single attendee. REQUEST creates or updates the event; CANCEL removes it.
+func buildICS(session LiveSession, method string, organizer, attendee calendarParty) (string, error) {
+	loc, err := time.LoadLocation(session.TimeZone)
+	if err != nil {
+		return "", fmt.Errorf("invalid time zone %q: %v", session.TimeZone, err)
+	}
+	start := session.Start.In(loc)
+	end := start.Add(session.Duration)
+
+	domain := "localhost"
+	if at := strings.LastIndex(organizer.Email, "@"); at >= 0 {
+		domain = organizer.Email[at+1:]
+	}
+	status := "CONFIRMED"
+	if method == calendarCancel {
+		status = "CANCELLED"
+	}
+
+	var ics icsWriter
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("PRODID:-//APEX AI//Live sessions//EN")
+	ics.line("VERSION:2.0")
+	ics.line("CALSCALE:GREGORIAN")
+	ics.line("METHOD:" + method)
+	writeVTimezone(&ics, loc, start)
+	ics.line("BEGIN:VEVENT")
+	ics.line("UID:" + session.ID + "@" + domain)
+	ics.line(fmt.Sprintf("SEQUENCE:%d", session.Sequence))
+	ics.line("DTSTAMP:" + time.Now().UTC().Format(icsUTCFormat))
+	ics.line("DTSTART;TZID=" + loc.String() + ":" + start.Format(icsLocalFormat))
+	ics.line("DTEND;TZID=" + loc.String() + ":" + end.Format(icsLocalFormat))
+	ics.line("SUMMARY:" + icsText(session.Title))
+	if session.Description != "" {
+		ics.line("DESCRIPTION:" + icsText(session.Description))
+	}
+	if session.JoinURL != "" {
+		ics.line("LOCATION:" + icsText(session.JoinURL))
+		ics.line("URL:" + session.JoinURL)
+	}
+	ics.line("STATUS:" + status)
+	ics.line("ORGANIZER;CN=" + icsParam(organizer.Name) + ":mailto:" + organizer.Email)
+	partstat := "NEEDS-ACTION;RSVP=TRUE"
+	if method == calendarCancel {
+		partstat = "NEEDS-ACTION;RSVP=FALSE"
+	}
+	ics.line("ATTENDEE;CN=" + icsParam(attendee.Name) + ";CUTYPE=INDIVIDUAL;ROLE=REQ-PARTICIPANT;PARTSTAT=" + partstat + ":mailto:" + attendee.Email)
+	if method == calendarRequest {
+		ics.line("BEGIN:VALARM")
+		ics.line("ACTION:DISPLAY")
+		ics.line("DESCRIPTION:" + icsText(session.Title))
+		ics.line("TRIGGER:-PT15M")
+		ics.line("END:VALARM")
+	}
+	ics.line("END:VEVENT")
+	ics.line("END:VCALENDAR")
+	return ics.String(), nil
+}
+
+// writeVTimezone describes the event's time zone so clients that do not know
+// the IANA name, notably Outlook, still place the event correctly. It lists the
+// offset changes in the year around the event.
+func writeVTimezone(ics *icsWriter, loc *time.Location, around time.Time) {
+	ics.line("BEGIN:VTIMEZONE")
+	ics.line("TZID:" + loc.String())
+
+	from := around.AddDate(-1, 0, 0)
+	to := around.AddDate(1, 0, 0)
+	transitions := zoneTransitions(loc, from, to)
+	if len(transitions) == 0 {
+		name, offset := around.In(loc).Zone()
+		ics.line("BEGIN:STANDARD")
+		ics.line("DTSTART:19700101T000000")
+		ics.line("TZOFFSETFROM:" + icsOffset(offset))
+		ics.line("TZOFFSETTO:" + icsOffset(offset))
+		ics.line("TZNAME:" + name)
+		ics.line("END:STANDARD")
+	}
+	for _, t := range transitions {
+		_, before := t.Add(-time.Second).In(loc).Zone()
+		name, after := t.In(loc).Zone()
+		kind := "STANDARD"
+		if t.In(loc).IsDST() {
+			kind = "DAYLIGHT"
+		}
+		ics.line("BEGIN:" + kind)
+		// DTSTART is the moment of the change in the offset that was in effect before it
+		ics.line("DTSTART:" + t.In(time.FixedZone("", before)).Format(icsLocalFormat))
+		ics.line("TZOFFSETFROM:" + icsOffset(before))
+		ics.line("TZOFFSETTO:" + icsOffset(after))
+		ics.line("TZNAME:" + name)
+		ics.line("END:" + kind)
+	}
+	ics.line("END:VTIMEZONE")
+}
+
+// zoneTransitions returns the instants between from and to at which the
+// location's UTC offset changes
+func zoneTransitions(loc *time.Location, from, to time.Time) []time.Time {
+	var transitions []time.Time
+	_, prev := from.In(loc).Zone()
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, offset := next.In(loc).Zone()
+		if offset == prev {
+			continue
+		}
+		// Narrow the change down to the second
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, o := mid.In(loc).Zone(); o == prev {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transitions = append(transitions, hi)
+		prev = offset
+	}
+	return transitions
+}
+
+// icsOffset formats a UTC offset in seconds as +HHMM
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// icsText escapes a TEXT property value
+func icsText(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// icsParam quotes a parameter value; double quotes are not allowed inside one
+func icsParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// icsWriter builds an iCalendar object with CRLF line endings, folding
+// lines longer than 75 octets without splitting UTF-8 characters
+type icsWriter struct {
+	strings.Builder
+}
+
+// line writes one content line
+func (w *icsWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts towards the limit
+		limit = 74
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
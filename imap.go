@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapConfig describes the mailbox bounces are collected from
+type imapConfig struct {
+	Addr     string // host:port; TLS is used unless the host is local
+	Username string
+	Password string
+	Mailbox  string
+}
+
+// imapClient is a minimal IMAP4rev1 (RFC 3501) client that can read and flag
+// messages in a single mailbox
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response line with any literals it contained
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+// dialIMAP connects, logs in and selects the configured mailbox
+func dialIMAP(config imapConfig) (*imapClient, error) {
+	host, _, err := net.SplitHostPort(config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP address: %v", err)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if isLocalhost(host) {
+		conn, err = dialer.Dial("tcp", config.Addr)
+	} else {
+		conn, err = tls.DialWithDialer(dialer, "tcp", config.Addr, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", config.Addr, err)
+	}
+	conn = &timeoutConn{Conn: conn, readTimeout: time.Minute, writeTimeout: time.Minute}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error reading IMAP greeting: %v", err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting %q", greeting.text)
+	}
+
+	if _, err := c.command("LOGIN %s %s", imapQuote(config.Username), imapQuote(config.Password)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error logging in: %v", err)
+	}
+	if _, err := c.command("SELECT %s", imapQuote(config.Mailbox)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error selecting %s: %v", config.Mailbox, err)
+	}
+	return c, nil
+}
+
+// UnseenUIDs returns the UIDs of messages without the \Seen flag
+func (c *imapClient) UnseenUIDs() ([]int, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, fmt.Errorf("error searching mailbox: %v", err)
+	}
+
+	var uids []int
+	for _, resp := range responses {
+		fields := strings.Fields(resp.text)
+		if len(fields) < 2 || fields[1] != "SEARCH" {
+			continue
+		}
+		for _, f := range fields[2:] {
+			uid, err := strconv.Atoi(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UID %q in search response", f)
+			}
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the full message with the given UID without marking it seen
+func (c *imapClient) Fetch(uid int) ([]byte, error) {
+	responses, err := c.command("UID FETCH %d (BODY.PEEK[])", uid)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching UID %d: %v", uid, err)
+	}
+	for _, resp := range responses {
+		if strings.Contains(resp.text, "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message UID %d not found", uid)
+}
+
+// MarkSeen sets the \Seen flag on a message
+func (c *imapClient) MarkSeen(uid int) error {
+	if _, err := c.command(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid); err != nil {
+		return fmt.Errorf("error flagging UID %d: %v", uid, err)
+	}
+	return nil
+}
+
+// Logout ends the session and closes the connection
+func (c *imapClient) Logout() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// command sends a tagged command and collects untagged responses until the
+// tagged completion, which must be OK
+func (c *imapClient) command(format string, args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%03d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(resp.text, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				return nil, fmt.Errorf("IMAP server replied %q", rest)
+			}
+			return responses, nil
+		}
+		responses = append(responses, resp)
+	}
+}
+
+// readResponse reads one response line, including any {n} literals it announces
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	var text strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// A line ending in {n} is followed by n bytes of literal data
+		open := strings.LastIndex(line, "{")
+		if open < 0 || !strings.HasSuffix(line, "}") {
+			text.WriteString(line)
+			resp.text = text.String()
+			return resp, nil
+		}
+		size, err := strconv.Atoi(line[open+1 : len(line)-1])
+		if err != nil {
+			text.WriteString(line)
+			resp.text = text.String()
+			return resp, nil
+		}
+
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		text.WriteString(line[:open])
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+// imapQuote encodes a string as an IMAP quoted string
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
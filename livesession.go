@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const liveSessionsFile = "live_sessions.json"
+
+// LiveSessionStore keeps the scheduled live Q&A calls
+type LiveSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*LiveSession
+}
+
+// NewLiveSessionStore creates a live session store backed by the data directory
+func NewLiveSessionStore() (*LiveSessionStore, error) {
+	s := &LiveSessionStore{sessions: make(map[string]*LiveSession)}
+	if err := loadJSON(liveSessionsFile, &s.sessions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create stores a new live session and assigns its ID
+func (s *LiveSessionStore) Create(session LiveSession) (LiveSession, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return LiveSession{}, fmt.Errorf("error generating session ID: %v", err)
+	}
+	session.ID = hex.EncodeToString(id)
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = session.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = &session
+	return session, s.save()
+}
+
+// Get returns the live session with the given ID
+func (s *LiveSessionStore) Get(id string) (LiveSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return LiveSession{}, false
+	}
+	return *session, true
+}
+
+// List returns all live sessions, soonest first
+func (s *LiveSessionStore) List() []LiveSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]LiveSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		list = append(list, *session)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// Upcoming returns the sessions that have not started and were not cancelled
+func (s *LiveSessionStore) Upcoming(now time.Time) []LiveSession {
+	var upcoming []LiveSession
+	for _, session := range s.List() {
+		if !session.Cancelled && session.Start.After(now) {
+			upcoming = append(upcoming, session)
+		}
+	}
+	return upcoming
+}
+
+// Update applies fn to the live session with the given ID and persists the result
+func (s *LiveSessionStore) Update(id string, fn func(*LiveSession)) (LiveSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return LiveSession{}, fmt.Errorf("live session %s not found", id)
+	}
+	fn(session)
+	session.UpdatedAt = time.Now()
+	return *session, s.save()
+}
+
+// save writes the live sessions to disk; callers must hold s.mu
+func (s *LiveSessionStore) save() error {
+	return saveJSON(liveSessionsFile, s.sessions)
+}
+
+// LocalStart returns the start time in the session's time zone
+func (s LiveSession) LocalStart() time.Time {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return s.Start
+	}
+	return s.Start.In(loc)
+}
+
+// inviteStudents sends a live session email to every student whose enrollment is active
+func inviteStudents(session LiveSession, template string) {
+	seen := make(map[string]bool)
+	var recipients []EmailData
+	for _, e := range enrollments.List() {
+		key := strings.ToLower(e.CustomerEmail)
+		if !e.Active() || seen[key] {
+			continue
+		}
+		seen[key] = true
+		data := enrollmentEmailData(e)
+		data.Session = &session
+		recipients = append(recipients, data)
+	}
+	sent := emailService.SendBulk(template, recipients)
+	log.Printf("Sent %s for session %s to %d of %d students", template, session.ID, sent, len(recipients))
+}
+
+// inviteStudent sends a live session email to the student of one enrollment
+func inviteStudent(email *EmailService, session LiveSession, template string, e Enrollment) {
+	data := enrollmentEmailData(e)
+	data.Session = &session
+	if err := email.SendTemplateEmail(template, data); err != nil && !errors.Is(err, errSuppressed) {
+		log.Printf("Error sending %s for session %s to %s: %v", template, session.ID, e.CustomerEmail, err)
+	}
+}
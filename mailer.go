@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers a fully built message
+type Mailer interface {
+	Send(from string, to []string, message []byte) error
+}
+
+// newMailer creates the mailer selected by EMAIL_TRANSPORT
+func newMailer(config EmailConfig) (Mailer, error) {
+	switch config.Transport {
+	case "", "smtp":
+		transport, err := newSMTPTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		return transport, nil
+	case "file":
+		return &fileMailer{dir: config.FileDir}, nil
+	case "stdout":
+		return &stdoutMailer{w: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown email transport %q", config.Transport)
+	}
+}
+
+// fileMailer writes each message to an .eml file instead of sending it
+type fileMailer struct {
+	dir string
+}
+
+func (m *fileMailer) Send(from string, to []string, message []byte) error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return fmt.Errorf("error creating outbox: %v", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("error naming message file: %v", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), message, 0o600); err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+	return nil
+}
+
+// stdoutMailer prints each message instead of sending it
+type stdoutMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (m *stdoutMailer) Send(from string, to []string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "----- email from %s to %s -----\n%s\n----- end of email -----\n",
+		from, strings.Join(to, ", "), message)
+	return err
+}
@@ -4,6 +4,11 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stripe/stripe-go/v74"
 )
 
 var tmpl = template.Must(template.New("index").Parse(`
@@ -816,19 +821,192 @@ var tmpl = template.Must(template.New("index").Parse(`
 </html>
 `))
 
+var (
+	emailService  *EmailService
+	enrollments   *EnrollmentStore
+	suppressions  *SuppressionStore
+	deliveries    *DeliveryLog
+	drip          *DripScheduler
+	bounces       *BounceProcessor
+	liveSessions  *LiveSessionStore
+	tracking      *TrackingStore
+	broadcasts    *BroadcastStore
+	sales         *SaleNotifier
+	accounts      *AccountStore
+	signInLinks   *SignInLinkStore
+	loginSessions *LoginSessionStore
+	logins        *LoginLog
+	sharing       *SharingDetector
+	signInLimits  *SignInLimiter
+	teams         *TeamStore
+	accessLog     *AccessLog
+)
+
 func main() {
+	// Load environment variables from .env file
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found")
+	}
+
+	// Set your secret key
+	stripeKey := os.Getenv("STRIPE_SECRET_KEY")
+	if stripeKey == "" {
+		log.Fatal("STRIPE_SECRET_KEY is required")
+	}
+	stripe.Key = stripeKey
+
+	if os.Getenv("APP_SECRET") == "" {
+		log.Fatal("APP_SECRET is required")
+	}
+
+	var err error
+	if suppressions, err = NewSuppressionStore(); err != nil {
+		log.Fatalf("Error loading suppression list: %v", err)
+	}
+	if deliveries, err = NewDeliveryLog(); err != nil {
+		log.Fatalf("Error loading email delivery log: %v", err)
+	}
+	if emailService, err = NewEmailService(suppressions, deliveries); err != nil {
+		log.Fatalf("Error configuring email: %v", err)
+	}
+	if enrollments, err = NewEnrollmentStore(); err != nil {
+		log.Fatalf("Error loading enrollments: %v", err)
+	}
+	if accounts, err = NewAccountStore(); err != nil {
+		log.Fatalf("Error loading accounts: %v", err)
+	}
+	if teams, err = NewTeamStore(); err != nil {
+		log.Fatalf("Error loading teams: %v", err)
+	}
+	if accessLog, err = NewAccessLog(); err != nil {
+		log.Fatalf("Error loading access log: %v", err)
+	}
+	if loginSessions, err = NewLoginSessionStore(); err != nil {
+		log.Fatalf("Error loading sessions: %v", err)
+	}
+	if logins, err = NewLoginLog(); err != nil {
+		log.Fatalf("Error loading login log: %v", err)
+	}
+	if sharing, err = NewSharingDetector(); err != nil {
+		log.Fatalf("Error configuring sharing detection: %v", err)
+	}
+	if signInLinks, err = NewSignInLinkStore(); err != nil {
+		log.Fatalf("Error loading sign-in links: %v", err)
+	}
+	if signInLimits, err = NewSignInLimiter(); err != nil {
+		log.Fatalf("Error configuring sign-in limits: %v", err)
+	}
+	if drip, err = NewDripScheduler(emailService, enrollments, onboardingSequence); err != nil {
+		log.Fatalf("Error loading drip sequences: %v", err)
+	}
+	drip.Start(time.Minute)
+	if tracking, err = NewTrackingStore(); err != nil {
+		log.Fatalf("Error loading email tracking: %v", err)
+	}
+	if liveSessions, err = NewLiveSessionStore(); err != nil {
+		log.Fatalf("Error loading live sessions: %v", err)
+	}
+	if broadcasts, err = NewBroadcastStore(); err != nil {
+		log.Fatalf("Error loading broadcasts: %v", err)
+	}
+	broadcasts.Start(time.Minute)
+	if sales, err = NewSaleNotifier(); err != nil {
+		log.Fatalf("Error configuring sale notifications: %v", err)
+	}
+	bounces = NewBounceProcessor(suppressions, enrollments)
+	bounces.Start(5 * time.Minute)
+
 	// Serve static files from the assets directory
 	fs := http.FileServer(http.Dir("assets"))
 	http.Handle("/assets/", http.StripPrefix("/assets/", fs))
 
 	// Serve the landing page
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		rememberAttribution(w, r)
 		tmpl.Execute(w, nil)
 	})
 
 	// Payment routes
 	http.HandleFunc("/payment", PaymentHandler)
 	http.HandleFunc("/payment-success", PaymentSuccessHandler)
+	http.HandleFunc("/stripe/webhook", StripeWebhookHandler)
+	http.HandleFunc("/unsubscribe", UnsubscribeHandler)
+	http.HandleFunc("/webhooks/bounces", BounceWebhookHandler)
+	http.HandleFunc("/t/open", TrackOpenHandler)
+	http.HandleFunc("/t/click", TrackClickHandler)
+
+	// Student area
+	http.HandleFunc("/login", LoginHandler)
+	http.HandleFunc("/login/verify", LoginVerifyHandler)
+	http.HandleFunc("/logout", LogoutHandler)
+	http.HandleFunc("/courses", requireStudent(CoursesHandler))
+	http.HandleFunc("/account/password", requireStudent(AccountPasswordHandler))
+	http.HandleFunc("/account/devices", requireStudent(DevicesHandler))
+	http.HandleFunc("/account/devices/revoke", requireStudent(DevicesRevokeHandler))
+	http.HandleFunc("/account/2fa", requireStudent(TwoFactorHandler))
+	http.HandleFunc("/login/2fa", TwoFactorLoginHandler)
+	http.HandleFunc("/password/forgot", ForgotPasswordHandler)
+	http.HandleFunc("/password/reset", ResetPasswordHandler)
+
+	// Team admin area, each admin only for their own team
+	http.HandleFunc("/team", requirePermission(permManageSeats, TeamHandler))
+	http.HandleFunc("/team/invite", requirePermission(permManageSeats, TeamInviteHandler))
+	http.HandleFunc("/team/remove", requirePermission(permManageSeats, TeamRemoveHandler))
+	http.HandleFunc("/team/reassign", requirePermission(permManageSeats, TeamReassignHandler))
+	http.HandleFunc("/team/resend", requirePermission(permManageSeats, TeamResendHandler))
+	http.HandleFunc("/team/buy", requirePermission(permManageSeats, TeamBuySeatsHandler))
+	http.HandleFunc("/team/export", requirePermission(permManageSeats, TeamExportHandler))
+
+	// Single sign-on for teams
+	http.HandleFunc("/sso/start", SSOStartHandler)
+	http.HandleFunc("/sso/oidc/callback", OIDCCallbackHandler)
+	http.HandleFunc("/sso/saml/acs", SAMLACSHandler)
+	http.HandleFunc("/sso/saml/metadata", SAMLMetadataHandler)
+
+	// SCIM provisioning for teams, authenticated with each team's token
+	http.HandleFunc(scimBasePath+"/", SCIMHandler)
+
+	// Email previews are only available in development
+	if os.Getenv("APP_ENV") == "dev" {
+		http.HandleFunc("/dev/emails", DevEmailsHandler)
+		http.HandleFunc("/dev/emails/render", DevEmailRenderHandler)
+		http.HandleFunc("/dev/emails/send", DevEmailSendHandler)
+	}
+
+	// Admin routes
+	http.HandleFunc("/admin/sequences", requirePermission(permViewOrders, AdminSequencesHandler))
+	http.HandleFunc("/admin/enrollments/progress", requirePermission(permViewProgress, AdminEnrollmentProgressHandler))
+	http.HandleFunc("/admin/email-issues", requirePermission(permViewOrders, AdminEmailIssuesHandler))
+	http.HandleFunc("/admin/live-sessions", requirePermission(permAuthorCourses, AdminLiveSessionsHandler))
+	http.HandleFunc("/admin/live-sessions/create", requirePermission(permAuthorCourses, AdminLiveSessionCreateHandler))
+	http.HandleFunc("/admin/live-sessions/reschedule", requirePermission(permAuthorCourses, AdminLiveSessionRescheduleHandler))
+	http.HandleFunc("/admin/live-sessions/cancel", requirePermission(permAuthorCourses, AdminLiveSessionCancelHandler))
+	http.HandleFunc("/admin/broadcasts", requirePermission(permManageEmail, AdminBroadcastsHandler))
+	http.HandleFunc("/admin/broadcasts/save", requirePermission(permManageEmail, AdminBroadcastSaveHandler))
+	http.HandleFunc("/admin/broadcasts/view", requirePermission(permManageEmail, AdminBroadcastViewHandler))
+	http.HandleFunc("/admin/broadcasts/preview", requirePermission(permManageEmail, AdminBroadcastPreviewHandler))
+	http.HandleFunc("/admin/broadcasts/test", requirePermission(permManageEmail, AdminBroadcastTestHandler))
+	http.HandleFunc("/admin/broadcasts/schedule", requirePermission(permManageEmail, AdminBroadcastScheduleHandler))
+	http.HandleFunc("/admin/broadcasts/unschedule", requirePermission(permManageEmail, AdminBroadcastUnscheduleHandler))
+	http.HandleFunc("/admin/broadcasts/send", requirePermission(permManageEmail, AdminBroadcastSendHandler))
+	http.HandleFunc("/admin/accounts", requirePermission(permManageAccounts, AdminAccountsHandler))
+	http.HandleFunc("/admin/accounts/logout", requirePermission(permManageAccounts, AdminAccountLogoutHandler))
+	http.HandleFunc("/admin/accounts/reset-2fa", requirePermission(permManageAccounts, AdminAccountResetTwoFactorHandler))
+	http.HandleFunc("/admin/sharing", requirePermission(permManageAccounts, AdminSharingHandler))
+	http.HandleFunc("/admin/sharing/dismiss", requirePermission(permManageAccounts, AdminSharingDismissHandler))
+	http.HandleFunc("/admin/teams", requirePermission(permManageTeams, AdminTeamsHandler))
+	http.HandleFunc("/admin/teams/sso", requirePermission(permManageTeams, AdminTeamSSOHandler))
+	http.HandleFunc("/admin/teams/scim-token", requirePermission(permManageTeams, AdminTeamSCIMTokenHandler))
+	http.HandleFunc("/admin/access", requirePermission(permManageRoles, AdminAccessHandler))
+	http.HandleFunc("/admin/access/grant", requirePermission(permManageRoles, AdminAccessGrantHandler))
+	http.HandleFunc("/admin/access/revoke", requirePermission(permManageRoles, AdminAccessRevokeHandler))
+	http.HandleFunc("/admin/2fa", requireAdminCredentials(AdminTwoFactorHandler))
+	// Scrapers cannot enter two-factor codes
+	http.HandleFunc("/admin/metrics", requireAdminCredentials(MetricsHandler))
+	http.HandleFunc("/admin/emails", requirePermission(permViewOrders, AdminEmailsHandler))
+	http.HandleFunc("/admin/emails/view", requirePermission(permViewOrders, AdminEmailViewHandler))
+	http.HandleFunc("/admin/emails/content", requirePermission(permViewOrders, AdminEmailContentHandler))
+	http.HandleFunc("/admin/emails/resend", requirePermission(permManageEmail, AdminEmailResendHandler))
 
 	log.Println("Server started at http://localhost:3000")
 	http.ListenAndServe(":3000", nil)
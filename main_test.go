@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupTestStores points the data directory and outbox at a temporary
+// directory and opens the stores the handlers use
+func setupTestStores(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("APP_SECRET", "test-secret")
+	t.Setenv("EMAIL_TRANSPORT", "file")
+	t.Setenv("EMAIL_FILE_DIR", dir+"/outbox")
+	t.Setenv("SENDER_EMAIL", "hello@apex.test")
+	t.Setenv("COURSE_NAME", "APEX AI")
+	t.Setenv("DOMAIN_URL", "https://apex.test")
+
+	var err error
+	check := func(name string) {
+		if err != nil {
+			t.Fatalf("Error loading %s: %v", name, err)
+		}
+	}
+	suppressions, err = NewSuppressionStore()
+	check("suppressions")
+	deliveries, err = NewDeliveryLog()
+	check("deliveries")
+	emailService, err = NewEmailService(suppressions, deliveries)
+	check("email service")
+	enrollments, err = NewEnrollmentStore()
+	check("enrollments")
+	accounts, err = NewAccountStore()
+	check("accounts")
+	teams, err = NewTeamStore()
+	check("teams")
+	accessLog, err = NewAccessLog()
+	check("access log")
+	loginSessions, err = NewLoginSessionStore()
+	check("login sessions")
+	logins, err = NewLoginLog()
+	check("logins")
+	sharing, err = NewSharingDetector()
+	check("sharing detector")
+	signInLinks, err = NewSignInLinkStore()
+	check("sign-in links")
+	signInLimits, err = NewSignInLimiter()
+	check("sign-in limits")
+	drip, err = NewDripScheduler(emailService, enrollments, onboardingSequence)
+	check("drip scheduler")
+	tracking, err = NewTrackingStore()
+	check("tracking")
+	liveSessions, err = NewLiveSessionStore()
+	check("live sessions")
+	broadcasts, err = NewBroadcastStore()
+	check("broadcasts")
+}
+
+// signInForTest starts a session for the account and returns its cookie
+func signInForTest(t *testing.T, a Account) *http.Cookie {
+	t.Helper()
+	return startSessionForTest(t, a, false)
+}
+
+// twoFactorSignInForTest starts a session that checked the second factor
+func twoFactorSignInForTest(t *testing.T, a Account) *http.Cookie {
+	t.Helper()
+	return startSessionForTest(t, a, true)
+}
+
+// startSessionForTest signs the account in with a password
+func startSessionForTest(t *testing.T, a Account, twoFactor bool) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := startSession(w, httptest.NewRequest(http.MethodGet, "/", nil), a, signInMethodPassword, twoFactor); err != nil {
+		t.Fatal(err)
+	}
+	cookie, ok := responseCookie(w, sessionCookie)
+	if !ok {
+		t.Fatal("no session cookie")
+	}
+	return cookie
+}
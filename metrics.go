@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MetricsHandler exposes email throttle metrics in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	stats := emailService.throttle.Stats()
+	metrics := []struct {
+		name, help, kind string
+		value            func(ThrottleStats) float64
+	}{
+		{"apex_email_sent_total", "Emails accepted by the transport.", "counter",
+			func(s ThrottleStats) float64 { return float64(s.Sent) }},
+		{"apex_email_failed_total", "Emails rejected with a permanent or unknown error.", "counter",
+			func(s ThrottleStats) float64 { return float64(s.Failed) }},
+		{"apex_email_temporary_failures_total", "Temporary failures that paused bulk email.", "counter",
+			func(s ThrottleStats) float64 { return float64(s.TempFailures) }},
+		{"apex_email_throttle_wait_seconds_total", "Time senders spent waiting for the throttle.", "counter",
+			func(s ThrottleStats) float64 { return s.Waited.Seconds() }},
+		{"apex_email_in_flight", "Emails currently being sent.", "gauge",
+			func(s ThrottleStats) float64 { return float64(s.InFlight) }},
+		{"apex_email_domain_paused", "Whether the domain is paused after a temporary failure.", "gauge",
+			func(s ThrottleStats) float64 {
+				if s.Paused {
+					return 1
+				}
+				return 0
+			}},
+		{"apex_email_domain_backoff_seconds", "Current backoff of the domain.", "gauge",
+			func(s ThrottleStats) float64 { return s.Backoff.Seconds() }},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{domain=%q} %g\n", m.name, metricLabel(s.Domain), m.value(s))
+		}
+	}
+
+	transport := emailService.config.Transport
+	if transport == "" {
+		transport = "smtp"
+	}
+	fmt.Fprintf(w, "# HELP apex_email_throttle_rate Configured global send rate per second; 0 is unlimited.\n# TYPE apex_email_throttle_rate gauge\n")
+	fmt.Fprintf(w, "apex_email_throttle_rate{transport=%q} %g\n", metricLabel(transport), emailService.config.Throttle.Rate)
+}
+
+// metricLabel strips characters that would break a label value
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, "", `"`, "", "\n", "").Replace(value)
+}
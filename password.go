@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password settings. The Argon2id parameters follow the OWASP recommendation.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 2
+	argonKeyLen  = 32
+
+	maxPasswordLength     = 128
+	passwordResetPurpose  = "password-reset"
+	passwordResetLifetime = time.Hour
+
+	freeLoginAttempts = 5         // failed passwords in a row before the account locks
+	maxLoginBackoff   = time.Hour // longest an account stays locked
+)
+
+// commonPasswords are refused whatever the policy, since they are the first
+// ones guessed
+var commonPasswords = map[string]bool{
+	"123456789012": true, "1234567890": true, "1q2w3e4r5t": true, "abc123456789": true,
+	"letmein12345": true, "password": true, "password123": true, "password1234": true,
+	"passw0rd123": true, "qwerty123456": true, "qwertyuiop": true, "iloveyou123": true,
+	"welcome12345": true, "administrator": true, "changeme123": true, "trustno1234": true,
+}
+
+// passwordHashAlgorithm returns the algorithm new passwords are hashed with,
+// argon2id unless PASSWORD_HASH is bcrypt
+func passwordHashAlgorithm() string {
+	if strings.EqualFold(os.Getenv("PASSWORD_HASH"), "bcrypt") {
+		return "bcrypt"
+	}
+	return "argon2id"
+}
+
+// checkPasswordPolicy returns a message explaining why a password is not
+// acceptable for the account, or nil
+func checkPasswordPolicy(password, email string) error {
+	minLength, err := envInt("PASSWORD_MIN_LENGTH", 10)
+	if err != nil {
+		return err
+	}
+	length := utf8.RuneCountInString(password)
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	switch {
+	case length < max(minLength, 1):
+		return fmt.Errorf("Use at least %d characters.", max(minLength, 1))
+	case length > maxPasswordLength:
+		return fmt.Errorf("Use at most %d characters.", maxPasswordLength)
+	case strings.Trim(password, password[:1]) == "":
+		return errors.New("Don't repeat a single character.")
+	case commonPasswords[lower]:
+		return errors.New("This password is too common. Choose another one.")
+	case len(local) >= 4 && strings.Contains(lower, local):
+		return errors.New("Don't use your email address in your password.")
+	}
+	return nil
+}
+
+// hashPassword hashes a password with the configured algorithm. Argon2id
+// hashes use the PHC string format.
+func hashPassword(password string) (string, error) {
+	if passwordHashAlgorithm() == "bcrypt" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			return "", fmt.Errorf("error hashing password: %v", err)
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating password salt: %v", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword reports whether the password matches an Argon2id or bcrypt hash
+func verifyPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	var version, memory, iterations, threads int
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil || threads < 1 || threads > 255 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, uint32(iterations), uint32(memory), uint8(threads), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// needsRehash reports whether a hash was made with another algorithm or
+// weaker parameters than new passwords get
+func needsRehash(hash string) bool {
+	if passwordHashAlgorithm() == "bcrypt" {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < 12
+	}
+	return !strings.HasPrefix(hash, fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$", argon2.Version, argonMemory, argonTime, argonThreads))
+}
+
+// dummyPasswordHash is checked against when there is no account, so a
+// failed sign-in takes as long whether or not the address is known
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("not a real password")
+	return hash
+})
+
+// loginBackoff is how long an account stays locked after the given number
+// of failed passwords in a row. It doubles with every failure past the free
+// attempts.
+func loginBackoff(failures int) time.Duration {
+	if failures < freeLoginAttempts {
+		return 0
+	}
+	backoff := 30 * time.Second
+	for i := freeLoginAttempts; i < failures && backoff < maxLoginBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxLoginBackoff)
+}
+
+// errWrongPassword is shown for unknown addresses and wrong passwords alike
+var errWrongPassword = errors.New("Incorrect email address or password.")
+
+// signInWithPassword checks a password for the account of an email address,
+// locking the account after repeated failures. The error is meant for the
+// login page.
+func signInWithPassword(email, password string) (Account, error) {
+	a, ok := accounts.FindByEmail(email)
+	if !ok || a.PasswordHash == "" {
+		verifyPassword(dummyPasswordHash(), password)
+		return Account{}, errWrongPassword
+	}
+	if wait := time.Until(a.LockedUntil); wait > 0 {
+		return Account{}, fmt.Errorf("Too many failed attempts. Try again in %s, or reset your password.", waitText(wait))
+	}
+
+	if !verifyPassword(a.PasswordHash, password) {
+		if _, err := accounts.Update(a.ID, func(a *Account) {
+			a.FailedLogins++
+			if backoff := loginBackoff(a.FailedLogins); backoff > 0 {
+				a.LockedUntil = time.Now().Add(backoff)
+			}
+		}); err != nil {
+			log.Printf("Error recording failed sign-in for %s: %v", a.Email, err)
+		}
+		return Account{}, errWrongPassword
+	}
+
+	rehash := ""
+	if needsRehash(a.PasswordHash) {
+		rehash, _ = hashPassword(password)
+	}
+	return accounts.Update(a.ID, func(a *Account) {
+		a.FailedLogins, a.LockedUntil = 0, time.Time{}
+		if rehash != "" {
+			a.PasswordHash = rehash
+		}
+	})
+}
+
+// waitText describes a lockout delay in whole minutes or seconds
+func waitText(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d seconds", int(d.Seconds())+1)
+	}
+	minutes := int(d.Minutes() + 0.999)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// setPassword replaces the account's password and signs every browser out
+func setPassword(id, password string) (Account, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return Account{}, err
+	}
+	a, err := accounts.Update(id, func(a *Account) {
+		a.PasswordHash, a.PasswordChangedAt = hash, time.Now()
+		a.FailedLogins, a.LockedUntil = 0, time.Time{}
+	})
+	if err != nil {
+		return Account{}, err
+	}
+	return a, loginSessions.RevokeAccount(a.ID, "")
+}
+
+var passwordTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-8">
+		<h1 class="text-3xl font-bold mb-2">{{if .Account.PasswordHash}}Change your password{{else}}Set a password{{end}}</h1>
+		{{if .Saved}}
+		<p class="text-green-400 mb-4">Your password was saved. Other browsers and devices were signed out.</p>
+		{{end}}
+		<p class="text-blue-200/90 mb-6">A password lets you sign in without waiting for an email link. Changing it signs you out everywhere else.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/account/password" class="flex flex-col gap-4">
+			{{if .Account.PasswordHash}}
+			<label for="current" class="text-blue-200">Current password</label>
+			<input type="password" id="current" name="current" required autocomplete="current-password" class="bg-gray-900 rounded px-4 py-3">
+			{{end}}
+			<label for="password" class="text-blue-200">New password</label>
+			<input type="password" id="password" name="password" required minlength="{{.MinLength}}" maxlength="128" autocomplete="new-password" class="bg-gray-900 rounded px-4 py-3">
+			<label for="confirm" class="text-blue-200">Repeat the new password</label>
+			<input type="password" id="confirm" name="confirm" required autocomplete="new-password" class="bg-gray-900 rounded px-4 py-3">
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Save password</button>
+		</form>
+		{{if .Account.PasswordHash}}
+		<p class="mt-4 text-sm text-blue-200/70">Forgot your current password? <a href="/password/forgot" class="text-blue-400 hover:text-blue-300">Reset it by email</a>.</p>
+		{{end}}
+	</div>
+{{end}}`)
+
+// passwordPage is the data of the password pages
+type passwordPage struct {
+	Account   Account // the signed-in student
+	Email     string  // whose password a reset link changes
+	Token     string
+	MinLength int
+	Error     string
+	Saved     bool
+}
+
+// newPasswordPage returns the data of a password page for the account
+func newPasswordPage(a Account) passwordPage {
+	minLength, _ := envInt("PASSWORD_MIN_LENGTH", 10)
+	return passwordPage{Account: a, MinLength: minLength}
+}
+
+// checkNewPassword validates the new password fields of a form
+func checkNewPassword(r *http.Request, a Account) error {
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm") {
+		return errors.New("The two passwords don't match.")
+	}
+	return checkPasswordPolicy(password, a.Email)
+}
+
+// AccountPasswordHandler lets a signed-in student set or change their password
+func AccountPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	session, a, _ := currentSession(r)
+	page := newPasswordPage(a)
+	page.Saved = r.FormValue("saved") == "1"
+
+	if r.Method == http.MethodPost {
+		page.Saved = false
+		if a.PasswordHash != "" && !signInLimits.AllowPassword(clientIP(r)) {
+			// Guessing the current password is guessing a sign-in password
+			w.WriteHeader(http.StatusTooManyRequests)
+			page.Error = "Too many attempts. Please wait a while and try again."
+		} else if a.PasswordHash != "" && !verifyPassword(a.PasswordHash, r.FormValue("current")) {
+			page.Error = "Your current password is incorrect."
+		} else if err := checkNewPassword(r, a); err != nil {
+			page.Error = err.Error()
+		} else if saved, err := setPassword(a.ID, r.FormValue("password")); err != nil {
+			log.Printf("Error saving password for %s: %v", a.Email, err)
+			page.Error = "Your password could not be saved. Please try again."
+		} else if err := startSession(w, r, saved, signInMethodPassword, session.TwoFactor); err != nil {
+			log.Printf("Error signing in account %s: %v", a.ID, err)
+			http.Error(w, "Error signing in", http.StatusInternalServerError)
+			return
+		} else {
+			http.Redirect(w, r, "/account/password?saved=1", http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := passwordTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering password page: %v", err)
+	}
+}
+
+var forgotPasswordTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Reset your password</h1>
+		{{if .Sent}}
+		<p class="text-blue-200/90 mb-4">If {{.Email}} belongs to a student, a link to choose a new password is on its way. It works for the next hour.</p>
+		<p class="text-sm text-blue-200/70">Nothing after a few minutes? Check your spam folder or <a href="/password/forgot" class="text-blue-400 hover:text-blue-300">try again</a>.</p>
+		{{else}}
+		<p class="text-blue-200/90 mb-6">Enter the email address you enrolled with and we'll email you a link to choose a new password. This also works if you never set one.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/password/forgot" class="flex flex-col gap-4">
+			<label for="email" class="text-blue-200">Email address</label>
+			<input type="email" id="email" name="email" value="{{.Email}}" required autofocus autocomplete="email" class="bg-gray-900 rounded px-4 py-3">
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Email me a reset link</button>
+		</form>
+		{{end}}
+	</div>
+{{end}}`)
+
+// ForgotPasswordHandler emails password reset links
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	page := loginPage{}
+	if r.Method == http.MethodPost {
+		email, _, err := validateEmail(r.FormValue("email"))
+		if err != nil {
+			page.Email = r.FormValue("email")
+			page.Error = "Please enter a valid email address."
+		} else if !signInLimits.Allow(email, clientIP(r)) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			page.Email = email
+			page.Error = "Too many requests. Please wait a while and try again."
+		} else {
+			// Same answer for every address, as on the login page
+			page.Email, page.Sent = email, true
+			if a, ok := accountForEmail(email); ok {
+				go sendPasswordReset(a)
+			}
+		}
+	}
+
+	if err := forgotPasswordTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering forgot password page: %v", err)
+	}
+}
+
+// sendPasswordReset emails a password reset link to the account
+func sendPasswordReset(a Account) {
+	data := accountEmailData(a)
+	var err error
+	if data.PasswordResetURL, err = accountLink(passwordResetPurpose, "/password/reset", a, "", passwordResetLifetime); err != nil {
+		log.Printf("Error creating password reset link for %s: %v", a.Email, err)
+		return
+	}
+	if err := emailService.SendTemplateEmail("password_reset", data); err != nil && !errors.Is(err, errSuppressed) {
+		log.Printf("Error sending password reset link to %s: %v", a.Email, err)
+	}
+}
+
+var resetPasswordTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Choose a new password</h1>
+		{{if not .Token}}
+		<p class="text-red-400 mb-4">{{.Error}}</p>
+		<p><a href="/password/forgot" class="text-blue-400 hover:text-blue-300">Get a new reset link</a></p>
+		{{else}}
+		<p class="text-blue-200/90 mb-6">For {{.Email}}. Saving it signs you out on every other browser and device.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/password/reset" class="flex flex-col gap-4">
+			<input type="hidden" name="token" value="{{.Token}}">
+			<label for="password" class="text-blue-200">New password</label>
+			<input type="password" id="password" name="password" required minlength="{{.MinLength}}" maxlength="128" autofocus autocomplete="new-password" class="bg-gray-900 rounded px-4 py-3">
+			<label for="confirm" class="text-blue-200">Repeat the new password</label>
+			<input type="password" id="confirm" name="confirm" required autocomplete="new-password" class="bg-gray-900 rounded px-4 py-3">
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Save password and sign in</button>
+		</form>
+		{{end}}
+	</div>
+{{end}}`)
+
+// ResetPasswordHandler sets a new password from an emailed link. Opening the
+// link only shows the form; the link is used up when the form is submitted,
+// so security gateways that open links in emails cannot spend it.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	expired := passwordPage{Error: "This reset link has expired or was already used."}
+	id, ok := parseAccountLink(passwordResetPurpose, token, false)
+	if !ok {
+		resetPasswordTmpl.Execute(w, expired)
+		return
+	}
+	a, ok := accounts.Get(id)
+	if !ok {
+		resetPasswordTmpl.Execute(w, expired)
+		return
+	}
+	page := newPasswordPage(Account{})
+	page.Email, page.Token = a.Email, token
+
+	if r.Method == http.MethodPost {
+		if err := checkNewPassword(r, a); err != nil {
+			page.Error = err.Error()
+		} else if _, ok := parseAccountLink(passwordResetPurpose, token, true); !ok {
+			resetPasswordTmpl.Execute(w, expired)
+			return
+		} else if saved, err := setPassword(a.ID, r.FormValue("password")); err != nil {
+			log.Printf("Error saving password for %s: %v", a.Email, err)
+			page.Error = "Your password could not be saved. Please try again."
+		} else {
+			// A reset link proves the email address only, so accounts with
+			// two-factor authentication still need their code
+			completeSignIn(w, r, saved, signInMethodPassword, "/courses")
+			return
+		}
+	}
+
+	if err := resetPasswordTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering reset password page: %v", err)
+	}
+}
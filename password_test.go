@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountPasswordRateLimitsCurrentPassword(t *testing.T) {
+	setupTestStores(t)
+	a, err := accounts.Ensure(Enrollment{CustomerEmail: "ada@example.com", CustomerName: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err = setPassword(a.ID, "old correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	session := signInForTest(t, a)
+	signInLimits = &SignInLimiter{passwordsIP: NewRateLimiter(2, time.Hour)}
+
+	change := func(current string) *httptest.ResponseRecorder {
+		form := url.Values{"current": {current}, "password": {"new battery staple"}, "confirm": {"new battery staple"}}
+		r := httptest.NewRequest(http.MethodPost, "/account/password", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "203.0.113.9:4242"
+		r.AddCookie(session)
+		w := httptest.NewRecorder()
+		AccountPasswordHandler(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := change("wrong guess"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "incorrect") {
+			t.Fatalf("wrong password: status %d", w.Code)
+		}
+	}
+	if w := change("old correct horse"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d over the limit, want 429", w.Code)
+	}
+	if a, _ := accounts.Get(a.ID); !verifyPassword(a.PasswordHash, "old correct horse") {
+		t.Error("password changed over the limit")
+	}
+}
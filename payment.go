@@ -1,31 +1,27 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/stripe/stripe-go/v74"
 	"github.com/stripe/stripe-go/v74/checkout/session"
+	"github.com/stripe/stripe-go/v74/customer"
 	"github.com/stripe/stripe-go/v74/product"
+	"github.com/stripe/stripe-go/v74/webhook"
 )
 
-func init() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found")
-	}
-
-	// Set your secret key
-	stripeKey := os.Getenv("STRIPE_SECRET_KEY")
-	if stripeKey == "" {
-		log.Fatal("STRIPE_SECRET_KEY is required")
-	}
-	stripe.Key = stripeKey
-}
-
 // createOrGetProduct ensures our product exists in Stripe
 func createOrGetProduct() (*stripe.Product, error) {
 	// Try to find existing product
@@ -53,8 +49,107 @@ func createOrGetProduct() (*stripe.Product, error) {
 	return product.New(productParams)
 }
 
-// PaymentHandler creates a Stripe checkout session and redirects to it
+var checkoutTmpl = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html lang="en" class="dark">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Enroll - {{.CourseName}}</title>
+	<link href="https://fonts.googleapis.com/css2?family=Lexend+Deca:wght@400;500;600;700&display=swap" rel="stylesheet">
+	<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-black text-white min-h-screen flex items-center justify-center font-['Lexend_Deca']">
+	<div class="w-full max-w-md p-8">
+		<h1 class="text-3xl font-bold mb-2">Enroll in {{.CourseName}}</h1>
+		<p class="text-blue-200/90 mb-6">Your course access is sent to this address, so please double-check it.</p>
+		{{if .Suggestion}}
+		<p class="text-yellow-300 mb-4">Did you mean <strong>{{.Suggestion}}</strong>?</p>
+		<div class="flex gap-3 mb-6">
+			<form method="post">
+				<input type="hidden" name="email" value="{{.Suggestion}}">
+				<input type="hidden" name="seats" value="{{.Seats}}">
+				<button class="px-4 py-2 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Yes, use {{.Suggestion}}</button>
+			</form>
+			<form method="post">
+				<input type="hidden" name="email" value="{{.Email}}">
+				<input type="hidden" name="confirmed" value="{{.Email}}">
+				<input type="hidden" name="seats" value="{{.Seats}}">
+				<button class="px-4 py-2 rounded border border-blue-200/40 hover:border-blue-200">No, keep {{.Email}}</button>
+			</form>
+		</div>
+		{{else}}
+		<form method="post" class="flex flex-col gap-4">
+			<label for="email" class="text-blue-200">Email address</label>
+			<input type="email" id="email" name="email" value="{{.Email}}" required autofocus autocomplete="email"
+				class="bg-gray-900 rounded px-4 py-3 {{if .Error}}ring-2 ring-red-500{{end}}">
+			{{if .Error}}<p class="text-red-400 text-sm">{{.Error}}</p>{{end}}
+			<label for="seats" class="text-blue-200">Seats <span class="text-blue-200/70 text-sm">(buying for your team? You manage the other seats after checkout)</span></label>
+			<input type="number" id="seats" name="seats" value="{{.Seats}}" min="1" max="{{.MaxSeats}}" required class="bg-gray-900 rounded px-4 py-3">
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Continue to payment</button>
+		</form>
+		{{end}}
+	</div>
+</body>
+</html>`))
+
+// checkoutForm is the state of the pre-checkout email form
+type checkoutForm struct {
+	CourseName string
+	Email      string
+	Seats      int
+	MaxSeats   int
+	Error      string
+	Suggestion string
+}
+
+// renderCheckoutForm shows the pre-checkout email form
+func renderCheckoutForm(w http.ResponseWriter, form checkoutForm) {
+	form.CourseName = os.Getenv("COURSE_NAME")
+	form.MaxSeats = maxSeatsPerOrder
+	if form.Seats < 1 {
+		form.Seats = 1
+	}
+	if err := checkoutTmpl.Execute(w, form); err != nil {
+		log.Printf("Error rendering checkout form: %v", err)
+	}
+}
+
+// checkoutEmail validates the address entered before checkout. It reports
+// false after showing the form again with an error or a typo suggestion.
+func checkoutEmail(w http.ResponseWriter, r *http.Request, seats int) (string, bool) {
+	entered := r.FormValue("email")
+	email, suggestion, err := validateEmail(entered)
+	switch {
+	case errors.Is(err, errDisposableEmail):
+		renderCheckoutForm(w, checkoutForm{Email: entered, Seats: seats, Error: "Please use a permanent email address. We send your course access there."})
+		return "", false
+	case err != nil:
+		renderCheckoutForm(w, checkoutForm{Email: entered, Seats: seats, Error: "Please enter a valid email address, such as name@company.com."})
+		return "", false
+	case suggestion != "" && r.FormValue("confirmed") != email:
+		renderCheckoutForm(w, checkoutForm{Email: email, Seats: seats, Suggestion: suggestion})
+		return "", false
+	}
+	return email, true
+}
+
+// PaymentHandler asks for the buyer's email address and how many seats they
+// want, then creates a Stripe checkout session for them and redirects to it
 func PaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderCheckoutForm(w, checkoutForm{})
+		return
+	}
+	seats, err := strconv.Atoi(cmp.Or(r.FormValue("seats"), "1"))
+	if err != nil || seats < 1 || seats > maxSeatsPerOrder {
+		renderCheckoutForm(w, checkoutForm{Email: r.FormValue("email"), Error: fmt.Sprintf("Please choose between 1 and %d seats.", maxSeatsPerOrder)})
+		return
+	}
+	email, ok := checkoutEmail(w, r, seats)
+	if !ok {
+		return
+	}
+
 	// Get or create the product
 	prod, err := createOrGetProduct()
 	if err != nil {
@@ -65,13 +160,15 @@ func PaymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create checkout session with enhanced customization
 	params := &stripe.CheckoutSessionParams{
-		SuccessURL: stripe.String(fmt.Sprintf("%s/payment-success?session_id={CHECKOUT_SESSION_ID}", os.Getenv("DOMAIN_URL"))),
-		CancelURL:  stripe.String(fmt.Sprintf("%s/payment", os.Getenv("DOMAIN_URL"))),
-		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:    stripe.String(fmt.Sprintf("%s/payment-success?session_id={CHECKOUT_SESSION_ID}", os.Getenv("DOMAIN_URL"))),
+		CancelURL:     stripe.String(fmt.Sprintf("%s/payment", os.Getenv("DOMAIN_URL"))),
+		Mode:          stripe.String(string(stripe.CheckoutSessionModePayment)),
+		Locale:        stripe.String("auto"),
+		CustomerEmail: stripe.String(email),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				Price:    stripe.String(prod.DefaultPrice.ID),
-				Quantity: stripe.Int64(1),
+				Quantity: stripe.Int64(int64(seats)),
 			},
 		},
 		PaymentMethodTypes: stripe.StringSlice([]string{
@@ -114,6 +211,11 @@ func PaymentHandler(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	if source := checkoutAttribution(r); source != "" {
+		params.AddMetadata("source", source)
+	}
+	params.AddMetadata("seats", strconv.Itoa(seats))
+
 	session, err := session.New(params)
 	if err != nil {
 		log.Printf("Error creating checkout session: %v", err)
@@ -125,6 +227,240 @@ func PaymentHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, session.URL, http.StatusSeeOther)
 }
 
+// teamSeatsCheckout starts a Checkout session for more seats of an existing
+// team and returns its URL. The team admin pays with their own address.
+func teamSeatsCheckout(t Team, admin Account, seats int) (string, error) {
+	prod, err := createOrGetProduct()
+	if err != nil {
+		return "", fmt.Errorf("error creating/getting product: %v", err)
+	}
+	teamURL := fmt.Sprintf("%s/team?%s", os.Getenv("DOMAIN_URL"), url.Values{"team": {t.ID}}.Encode())
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL:    stripe.String(fmt.Sprintf("%s/payment-success?session_id={CHECKOUT_SESSION_ID}", os.Getenv("DOMAIN_URL"))),
+		CancelURL:     stripe.String(teamURL),
+		Mode:          stripe.String(string(stripe.CheckoutSessionModePayment)),
+		Locale:        stripe.String("auto"),
+		CustomerEmail: stripe.String(admin.Email),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(prod.DefaultPrice.ID),
+				Quantity: stripe.Int64(int64(seats)),
+			},
+		},
+		PaymentMethodTypes:       stripe.StringSlice([]string{"card", "link"}),
+		AllowPromotionCodes:      stripe.Bool(true),
+		BillingAddressCollection: stripe.String(string(stripe.CheckoutSessionBillingAddressCollectionRequired)),
+	}
+	params.AddMetadata("team", t.ID)
+	params.AddMetadata("seats", strconv.Itoa(seats))
+
+	session, err := session.New(params)
+	if err != nil {
+		return "", fmt.Errorf("error creating checkout session: %v", err)
+	}
+	return session.URL, nil
+}
+
+// fulfillOrder records the enrollment for a paid checkout session, sends the
+// welcome email and upcoming live session invites, and schedules the onboarding
+// sequence. Orders that were already fulfilled are left untouched.
+func fulfillOrder(checkoutSession *stripe.CheckoutSession, r *http.Request) error {
+	// Seats bought from the team admin area join the team that bought them
+	if teamID := checkoutSession.Metadata["team"]; teamID != "" {
+		return addTeamSeats(teamID, checkoutSession.ID, checkoutSeats(checkoutSession.Metadata))
+	}
+
+	enrollment := Enrollment{
+		ID:            checkoutSession.ID,
+		CustomerName:  checkoutSession.CustomerDetails.Name,
+		CustomerEmail: checkoutSession.CustomerEmail,
+		CustomerPhone: checkoutSession.CustomerDetails.Phone,
+		CourseName:    os.Getenv("COURSE_NAME"),
+		AmountTotal:   checkoutSession.AmountTotal,
+		Currency:      string(checkoutSession.Currency),
+		Company:       customFieldText(checkoutSession, "company_name"),
+		JobTitle:      customFieldText(checkoutSession, "job_title"),
+		Coupon:        checkoutCoupon(checkoutSession),
+		Source:        checkoutSession.Metadata["source"],
+		EnrolledAt:    time.Now(),
+	}
+	if checkoutSession.CustomerDetails.Address != nil {
+		enrollment.Country = checkoutSession.CustomerDetails.Address.Country
+	}
+	enrollment.Locale = customerLocale(checkoutSession.Locale, r, enrollment.Country)
+	if checkoutSession.Customer != nil {
+		enrollment.CustomerID = checkoutSession.Customer.ID
+
+		// Keep the language on the Stripe customer so receipts and invoices match
+		if _, err := customer.Update(enrollment.CustomerID, &stripe.CustomerParams{
+			PreferredLocales: stripe.StringSlice([]string{enrollment.Locale}),
+		}); err != nil {
+			log.Printf("Error saving locale on customer %s: %v", enrollment.CustomerID, err)
+		}
+	}
+	if checkoutSession.PaymentIntent != nil {
+		enrollment.PaymentIntentID = checkoutSession.PaymentIntent.ID
+	}
+
+	created, err := enrollments.Add(enrollment)
+	if err != nil {
+		return fmt.Errorf("error saving enrollment: %v", err)
+	}
+	if !created {
+		return nil
+	}
+
+	account, err := onboardStudent(enrollment)
+	go sales.Notify(enrollment)
+
+	// Orders for several seats start a team the buyer manages
+	if seats := checkoutSeats(checkoutSession.Metadata); seats > 1 && account.ID != "" {
+		if _, err := startTeam(enrollment, account, seats); err != nil {
+			log.Printf("Error creating team for order %s: %v", enrollment.ID, err)
+		}
+	}
+	return err
+}
+
+// onboardStudent gives a new enrollment its student account and sends the
+// welcome email with a first sign-in link, the upcoming live session invites
+// and the onboarding sequence. The account is empty when it could not be created.
+func onboardStudent(enrollment Enrollment) (Account, error) {
+	account, err := accounts.Ensure(enrollment)
+	if err != nil {
+		log.Printf("Error creating account for %s: %v", enrollment.CustomerEmail, err)
+	}
+
+	// Send welcome email
+	if err := sendWelcomeEmail(enrollment, account); err != nil {
+		log.Printf("Error sending welcome email: %v", err)
+	}
+
+	// Invite the new student to the live sessions already on the calendar.
+	// The sessions and email service are looked up before the request returns.
+	upcoming := liveSessions.Upcoming(time.Now())
+	go func(email *EmailService) {
+		for _, session := range upcoming {
+			inviteStudent(email, session, "live_session_invite", enrollment)
+		}
+	}(emailService)
+
+	if err := drip.Enroll(enrollment); err != nil {
+		return account, fmt.Errorf("error scheduling onboarding sequence: %v", err)
+	}
+	return account, nil
+}
+
+// sendWelcomeEmail sends an enrollment's welcome email with a new first
+// sign-in link. Without an account the email goes out without a link.
+func sendWelcomeEmail(enrollment Enrollment, account Account) error {
+	welcome := enrollmentEmailData(enrollment)
+	if account.ID != "" {
+		var err error
+		if welcome.SignInURL, err = signInURL(account, "", welcomeLinkLifetime); err != nil {
+			log.Printf("Error creating sign-in link for %s: %v", enrollment.CustomerEmail, err)
+		}
+	}
+	return emailService.SendWelcomeEmail(welcome)
+}
+
+// customFieldText returns what the customer entered in a text field at checkout
+func customFieldText(checkoutSession *stripe.CheckoutSession, key string) string {
+	for _, field := range checkoutSession.CustomFields {
+		if field.Key == key && field.Text != nil {
+			return strings.TrimSpace(field.Text.Value)
+		}
+	}
+	return ""
+}
+
+// checkoutCoupon returns the coupon applied at checkout, by name when it has one
+func checkoutCoupon(checkoutSession *stripe.CheckoutSession) string {
+	if checkoutSession.TotalDetails == nil || checkoutSession.TotalDetails.Breakdown == nil {
+		return ""
+	}
+	for _, d := range checkoutSession.TotalDetails.Breakdown.Discounts {
+		if d.Discount == nil || d.Discount.Coupon == nil {
+			continue
+		}
+		if d.Discount.Coupon.Name != "" {
+			return d.Discount.Coupon.Name
+		}
+		return d.Discount.Coupon.ID
+	}
+	return ""
+}
+
+// changeCustomerEmail moves a customer's orders to a corrected address and
+// clears any delivery problem recorded for the old one
+func changeCustomerEmail(oldEmail, newEmail string) error {
+	// Keep the student's login, unless the new address already has one
+	if _, taken := accounts.FindByEmail(newEmail); !taken {
+		if a, ok := accounts.FindByEmail(oldEmail); ok {
+			if _, err := accounts.Update(a.ID, func(a *Account) { a.Email = newEmail }); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range enrollments.FindByEmail(oldEmail) {
+		if err := enrollments.Update(e.ID, func(e *Enrollment) {
+			e.CustomerEmail = newEmail
+			e.EmailIssue = ""
+			e.EmailIssueAt = time.Time{}
+		}); err != nil {
+			return err
+		}
+
+		if e.CustomerID != "" {
+			if _, err := customer.Update(e.CustomerID, &stripe.CustomerParams{
+				Email: stripe.String(newEmail),
+			}); err != nil {
+				log.Printf("Error updating email on customer %s: %v", e.CustomerID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// StripeWebhookHandler receives Stripe events and keeps enrollments in sync
+func StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 65536))
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusServiceUnavailable)
+		return
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+	if err != nil {
+		log.Printf("Error verifying webhook signature: %v", err)
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	switch event.Type {
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			log.Printf("Error parsing charge: %v", err)
+			http.Error(w, "Invalid event data", http.StatusBadRequest)
+			return
+		}
+		if charge.PaymentIntent == nil {
+			break
+		}
+		if e, ok := enrollments.FindByPaymentIntent(charge.PaymentIntent.ID); ok {
+			if err := enrollments.Update(e.ID, func(e *Enrollment) { e.Refunded = true }); err != nil {
+				log.Printf("Error marking enrollment %s refunded: %v", e.ID, err)
+				http.Error(w, "Error updating enrollment", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // PaymentSuccessHandler handles the success page after payment
 func PaymentSuccessHandler(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session_id")
@@ -134,29 +470,28 @@ func PaymentSuccessHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify the session
-	checkoutSession, err := session.Get(sessionID, nil)
+	params := &stripe.CheckoutSessionParams{}
+	params.AddExpand("total_details.breakdown")
+	checkoutSession, err := session.Get(sessionID, params)
 	if err != nil {
 		log.Printf("Error retrieving session: %v", err)
 		http.Error(w, "Error verifying payment", http.StatusInternalServerError)
 		return
 	}
 
-	// Initialize email service
-	emailService := NewEmailService()
-
-	// Send welcome email
-	emailData := EmailData{
-		CustomerName:  checkoutSession.CustomerDetails.Name,
-		CustomerEmail: checkoutSession.CustomerEmail,
-		CourseName:    os.Getenv("COURSE_NAME"),
-		CompanyName:   os.Getenv("COMPANY_NAME"),
-		SupportEmail:  os.Getenv("SUPPORT_EMAIL"),
+	if checkoutSession.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		http.Error(w, "Payment not completed", http.StatusPaymentRequired)
+		return
 	}
 
-	if err := emailService.SendWelcomeEmail(emailData); err != nil {
-		log.Printf("Error sending welcome email: %v", err)
+	if err := fulfillOrder(checkoutSession, r); err != nil {
+		log.Printf("Error fulfilling order %s: %v", checkoutSession.ID, err)
 		// Continue anyway, as the payment was successful
 	}
+	if teamID := checkoutSession.Metadata["team"]; teamID != "" {
+		http.Redirect(w, r, "/team?"+url.Values{"team": {teamID}, "done": {"bought"}}.Encode(), http.StatusSeeOther)
+		return
+	}
 
 	// Show success page
 	w.Write([]byte(fmt.Sprintf(`
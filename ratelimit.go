@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter allows a number of events per key within a sliding window
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+// NewRateLimiter creates a limiter allowing limit events per window; zero disables it
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records an event for the key and reports whether it is within the limit
+func (l *RateLimiter) Allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.hits[key][:0]
+	for _, t := range l.hits[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+
+	// Forget keys that have gone quiet so the map does not grow forever
+	if len(l.hits) > 10000 {
+		for k, times := range l.hits {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+				delete(l.hits, k)
+			}
+		}
+	}
+	return true
+}
+
+// clientIP returns the address of the visitor. X-Forwarded-For is only
+// trusted when TRUST_PROXY is set, since anyone can send the header. Even
+// then only the entries our own proxies appended count: the visitor is
+// TRUSTED_PROXY_HOPS entries from the right, and what they sent themselves is
+// further left and ignored.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		proxies, err := envInt("TRUSTED_PROXY_HOPS", 1)
+		if err != nil || proxies < 1 {
+			proxies = 1
+		}
+		if len(hops) >= proxies {
+			if ip := hops[len(hops)-proxies]; net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     string
+		hops      string
+		forwarded []string
+		want      string
+	}{
+		{"proxy not trusted", "", "", []string{"203.0.113.7"}, "192.0.2.1"},
+		{"single proxy", "true", "", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed entries are ignored", "true", "", []string{"1.2.3.4, 5.6.7.8, 203.0.113.7"}, "203.0.113.7"},
+		{"repeated headers", "true", "", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"two proxies", "true", "2", []string{"1.2.3.4, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"fewer entries than proxies", "true", "2", []string{"203.0.113.7"}, "192.0.2.1"},
+		{"not an address", "true", "", []string{"1.2.3.4, unknown"}, "192.0.2.1"},
+		{"no header", "true", "", nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY", tt.trust)
+			t.Setenv("TRUSTED_PROXY_HOPS", tt.hops)
+			r := httptest.NewRequest("GET", "/", nil)
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attributionCookie remembers where a visitor first came from until they check out
+const attributionCookie = "apex_source"
+
+// attributionSource describes the campaign in a request's utm_ parameters as
+// "source / medium / campaign", or returns "" when there is none
+func attributionSource(r *http.Request) string {
+	query := r.URL.Query()
+	source := query.Get("utm_source")
+	if source == "" {
+		source = query.Get("ref")
+	}
+	if source == "" {
+		return ""
+	}
+	parts := []string{source}
+	for _, key := range []string{"utm_medium", "utm_campaign"} {
+		if value := query.Get(key); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	source = strings.Join(parts, " / ")
+	if len(source) > 200 {
+		source = source[:200]
+	}
+	return source
+}
+
+// rememberAttribution stores the first campaign a visitor arrived from
+func rememberAttribution(w http.ResponseWriter, r *http.Request) {
+	source := attributionSource(r)
+	if source == "" {
+		return
+	}
+	if _, err := r.Cookie(attributionCookie); err == nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     attributionCookie,
+		Value:    signToken(attributionCookie, source),
+		Path:     "/",
+		MaxAge:   30 * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(os.Getenv("DOMAIN_URL"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// checkoutAttribution returns the campaign of the current visit, falling back
+// to the one remembered from the visitor's first visit
+func checkoutAttribution(r *http.Request) string {
+	if source := attributionSource(r); source != "" {
+		return source
+	}
+	if cookie, err := r.Cookie(attributionCookie); err == nil {
+		if source, ok := verifyToken(attributionCookie, cookie.Value); ok {
+			return source
+		}
+	}
+	return ""
+}
+
+// saleCondition is one test of a notification rule, such as "min_amount=1000"
+// or "title~chief|vp"
+type saleCondition struct {
+	field  string
+	op     string // "=" matches a whole value, "~" a substring
+	values []string
+}
+
+// saleRule matches a sale when all its conditions hold
+type saleRule []saleCondition
+
+// saleFields are the order fields notification rules can test
+var saleFields = map[string]func(e Enrollment) string{
+	"company": func(e Enrollment) string { return e.Company },
+	"title":   func(e Enrollment) string { return e.JobTitle },
+	"coupon":  func(e Enrollment) string { return e.Coupon },
+	"source":  func(e Enrollment) string { return e.Source },
+	"country": func(e Enrollment) string { return e.Country },
+	"email":   func(e Enrollment) string { return e.CustomerEmail },
+}
+
+// parseSaleRules parses SALE_NOTIFY_RULES. Rules are separated by ";" and
+// their conditions by ",". A condition is min_amount=N (in whole currency
+// units), field=a|b for an exact match or field~a|b for a substring match.
+func parseSaleRules(value string) ([]saleRule, error) {
+	var rules []saleRule
+	for _, text := range strings.Split(value, ";") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		var rule saleRule
+		for _, cond := range splitList(text) {
+			i := strings.IndexAny(cond, "=~")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid SALE_NOTIFY_RULES condition %q", cond)
+			}
+			c := saleCondition{field: strings.ToLower(strings.TrimSpace(cond[:i])), op: cond[i : i+1]}
+			for _, v := range strings.Split(cond[i+1:], "|") {
+				if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+					c.values = append(c.values, v)
+				}
+			}
+
+			_, known := saleFields[c.field]
+			switch {
+			case c.field == "min_amount":
+				if c.op != "=" || len(c.values) != 1 {
+					return nil, fmt.Errorf("invalid SALE_NOTIFY_RULES condition %q", cond)
+				}
+				if _, err := strconv.ParseFloat(c.values[0], 64); err != nil {
+					return nil, fmt.Errorf("invalid SALE_NOTIFY_RULES condition %q", cond)
+				}
+			case !known:
+				return nil, fmt.Errorf("unknown field in SALE_NOTIFY_RULES condition %q", cond)
+			case len(c.values) == 0:
+				return nil, fmt.Errorf("invalid SALE_NOTIFY_RULES condition %q", cond)
+			}
+			rule = append(rule, c)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches reports whether the order meets the condition
+func (c saleCondition) matches(e Enrollment) bool {
+	if c.field == "min_amount" {
+		minimum, _ := strconv.ParseFloat(c.values[0], 64)
+		return float64(e.AmountTotal) >= minimum*100
+	}
+	value := strings.ToLower(strings.TrimSpace(saleFields[c.field](e)))
+	for _, want := range c.values {
+		if (c.op == "=" && value == want) || (c.op == "~" && strings.Contains(value, want)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaleNotifier tells the team about new orders by email and chat webhook
+type SaleNotifier struct {
+	recipients    []string
+	webhookURL    string
+	webhookFormat string // slack, discord or json
+	rules         []saleRule
+	client        *http.Client
+}
+
+// NewSaleNotifier reads the notification channels and rules from the environment
+func NewSaleNotifier() (*SaleNotifier, error) {
+	n := &SaleNotifier{
+		recipients:    splitList(os.Getenv("SALE_NOTIFY_EMAILS")),
+		webhookURL:    os.Getenv("SALE_NOTIFY_WEBHOOK_URL"),
+		webhookFormat: strings.ToLower(os.Getenv("SALE_NOTIFY_WEBHOOK_FORMAT")),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if n.webhookFormat == "" {
+		n.webhookFormat = "slack"
+	}
+	if n.webhookFormat != "slack" && n.webhookFormat != "discord" && n.webhookFormat != "json" {
+		return nil, fmt.Errorf("unknown SALE_NOTIFY_WEBHOOK_FORMAT %q", n.webhookFormat)
+	}
+
+	var err error
+	if n.rules, err = parseSaleRules(os.Getenv("SALE_NOTIFY_RULES")); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Matches reports whether a sale passes the notification rules. Every sale
+// matches when no rules are configured.
+func (n *SaleNotifier) Matches(e Enrollment) bool {
+	if len(n.rules) == 0 {
+		return true
+	}
+	for _, rule := range n.rules {
+		matched := true
+		for _, c := range rule {
+			if !c.matches(e) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify sends the configured notifications for a sale that passes the rules
+func (n *SaleNotifier) Notify(e Enrollment) {
+	if !n.Matches(e) {
+		return
+	}
+
+	for _, to := range n.recipients {
+		data := enrollmentEmailData(e)
+		data.CustomerName = ""
+		data.CustomerEmail = to
+		data.Locale = defaultLocale
+		data.Order = &e
+		if err := emailService.SendTemplateEmail("sale_notification", data); err != nil && !errors.Is(err, errSuppressed) {
+			log.Printf("Error sending sale notification to %s: %v", to, err)
+		}
+	}
+
+	if n.webhookURL != "" {
+		if err := n.post(e); err != nil {
+			log.Printf("Error posting sale notification: %v", err)
+		}
+	}
+}
+
+// post sends the sale to the chat webhook
+func (n *SaleNotifier) post(e Enrollment) error {
+	var payload any
+	switch n.webhookFormat {
+	case "discord":
+		payload = map[string]string{"content": saleSummary(e)}
+	case "json":
+		payload = map[string]any{
+			"order_id":  e.ID,
+			"name":      e.CustomerName,
+			"email":     e.CustomerEmail,
+			"company":   e.Company,
+			"job_title": e.JobTitle,
+			"course":    e.CourseName,
+			"amount":    e.AmountTotal,
+			"currency":  e.Currency,
+			"coupon":    e.Coupon,
+			"source":    e.Source,
+			"country":   e.Country,
+			"summary":   saleSummary(e),
+		}
+	default:
+		payload = map[string]string{"text": saleSummary(e)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %v", err)
+	}
+
+	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error calling webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("webhook replied %d: %s", resp.StatusCode, strings.TrimSpace(string(text)))
+	}
+	return nil
+}
+
+// saleSummary describes a sale in a few lines of chat text
+func saleSummary(e Enrollment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "New sale: %s", e.CourseName)
+	fmt.Fprintf(&b, "\n%s <%s>", e.CustomerName, e.CustomerEmail)
+	if e.JobTitle != "" || e.Company != "" {
+		fmt.Fprintf(&b, "\n%s", strings.Trim(e.JobTitle+", "+e.Company, ", "))
+	}
+	fmt.Fprintf(&b, "\nAmount: %s", formatMoney(defaultLocale, e.AmountTotal, e.Currency))
+	if e.Coupon != "" {
+		fmt.Fprintf(&b, " (coupon %s)", e.Coupon)
+	}
+	source := e.Source
+	if source == "" {
+		source = "direct"
+	}
+	fmt.Fprintf(&b, "\nSource: %s", source)
+	return b.String()
+}
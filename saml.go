@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// SAML namespaces and values
+const (
+	samlProtocolNS   = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS  = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS   = "urn:oasis:names:tc:SAML:2.0:metadata"
+	xmlDSigNS        = "http://www.w3.org/2000/09/xmldsig#"
+	samlPOSTBinding  = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlEmailFormat  = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlStatusOK     = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer       = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlTimeFormat   = "2006-01-02T15:04:05Z"
+	samlClockSkew    = 2 * time.Minute
+	samlResponseSize = 512 * 1024
+)
+
+// Attributes identity providers commonly put the address and name in
+var (
+	samlEmailAttributes = []string{"email", "mail", "emailaddress", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress", "urn:oid:0.9.2342.19200300.100.1.3"}
+	samlNameAttributes  = []string{"name", "displayName", "http://schemas.microsoft.com/identity/claims/displayname", "urn:oid:2.16.840.1.113730.3.1.241"}
+)
+
+// samlEntityID identifies the course site to identity providers. All teams
+// share it; the provider tells them apart by their own configuration.
+func samlEntityID() string {
+	return os.Getenv("DOMAIN_URL") + "/sso/saml/metadata"
+}
+
+// samlACSURL is where identity providers post their responses
+func samlACSURL() string {
+	return os.Getenv("DOMAIN_URL") + "/sso/saml/acs"
+}
+
+// parseSAMLCertificate reads the PEM certificate an identity provider signs with
+func parseSAMLCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// startSAML returns the identity provider's URL with a new AuthnRequest,
+// using the HTTP-Redirect binding
+func startSAML(w http.ResponseWriter, t Team, next string) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	state := ssoState{TeamID: t.ID, Request: "_" + id, Next: next}
+
+	doc := etree.NewDocument()
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", samlProtocolNS)
+	req.CreateAttr("xmlns:saml", samlAssertionNS)
+	req.CreateAttr("ID", state.Request)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", time.Now().UTC().Format(samlTimeFormat))
+	req.CreateAttr("Destination", t.SSO.IdPSSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", samlACSURL())
+	req.CreateAttr("ProtocolBinding", samlPOSTBinding)
+	req.CreateElement("saml:Issuer").SetText(samlEntityID())
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", samlEmailFormat)
+	policy.CreateAttr("AllowCreate", "true")
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", fmt.Errorf("error encoding AuthnRequest: %v", err)
+	}
+
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	fw.Write(raw)
+	if err := fw.Close(); err != nil {
+		return "", fmt.Errorf("error compressing AuthnRequest: %v", err)
+	}
+
+	target, err := url.Parse(t.SSO.IdPSSOURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid IdP SSO URL: %v", err)
+	}
+	query := target.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	target.RawQuery = query.Encode()
+	setSSOState(w, state)
+	return target.String(), nil
+}
+
+// SAMLACSHandler is the assertion consumer service: it receives the identity
+// provider's response through the HTTP-POST binding and signs in the address
+// of a validly signed assertion
+func SAMLACSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state, ok := takeSSOState(w, r)
+	if !ok {
+		renderSSOError(w, http.StatusBadRequest, "This sign-in has expired. Please start again.")
+		return
+	}
+	t, ok := teams.Get(state.TeamID)
+	if !ok || t.SSO == nil || t.SSO.Protocol != ssoProtocolSAML {
+		renderSSOError(w, http.StatusNotFound, "Single sign-on is not set up for this team.")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, samlResponseSize)
+	email, name, err := verifySAMLResponse(*t.SSO, r.FormValue("SAMLResponse"), state.Request, time.Now())
+	if err != nil {
+		log.Printf("Error verifying SAML response for team %s: %v", t.Name, err)
+		renderSSOError(w, http.StatusForbidden, "We could not verify your sign-in with your company's identity provider.")
+		return
+	}
+	ssoSignIn(w, r, t, email, name, state.Next)
+}
+
+// verifySAMLResponse checks a base64 encoded SAML response to the request
+// with the given ID and returns the address and name it asserts. The response,
+// its assertion or both must be signed with the team's IdP certificate, and
+// only the signed XML is read, so content wrapped around it is ignored.
+func verifySAMLResponse(sso SSOConfig, encoded, requestID string, now time.Time) (string, string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding response: %v", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return "", "", fmt.Errorf("error parsing response: %v", err)
+	}
+	response := doc.Root()
+	if response == nil || !samlIs(response, samlProtocolNS, "Response") {
+		return "", "", errors.New("not a SAML response")
+	}
+
+	cert, err := parseSAMLCertificate(sso.IdPCertificate)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid IdP certificate: %v", err)
+	}
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: []*x509.Certificate{cert}})
+	validator.Clock = dsig.NewFakeClockAt(now)
+
+	responseSigned := samlChild(response, xmlDSigNS, "Signature") != nil
+	if responseSigned {
+		if response, err = validator.Validate(response); err != nil {
+			return "", "", fmt.Errorf("invalid response signature: %v", err)
+		}
+	}
+
+	if got := response.SelectAttrValue("InResponseTo", ""); got != requestID {
+		return "", "", fmt.Errorf("response is to request %q, not %q", got, requestID)
+	}
+	if dest := response.SelectAttrValue("Destination", ""); dest != "" && dest != samlACSURL() {
+		return "", "", fmt.Errorf("response is for %q", dest)
+	}
+	status := samlPath(response, samlProtocolNS, "Status", "StatusCode")
+	if status == nil || status.SelectAttrValue("Value", "") != samlStatusOK {
+		return "", "", errors.New("identity provider did not report success")
+	}
+	if samlChild(response, samlAssertionNS, "EncryptedAssertion") != nil {
+		return "", "", errors.New("encrypted assertions are not supported")
+	}
+	assertions := samlChildren(response, samlAssertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return "", "", fmt.Errorf("response has %d assertions", len(assertions))
+	}
+	assertion := assertions[0]
+	if samlChild(assertion, xmlDSigNS, "Signature") != nil {
+		if assertion, err = validator.Validate(samlWithNamespaces(assertion)); err != nil {
+			return "", "", fmt.Errorf("invalid assertion signature: %v", err)
+		}
+	} else if !responseSigned {
+		return "", "", errors.New("neither the response nor the assertion is signed")
+	}
+
+	if err := checkSAMLAssertion(assertion, sso, requestID, now); err != nil {
+		return "", "", err
+	}
+
+	email := samlAttribute(assertion, samlEmailAttributes)
+	if email == "" {
+		if nameID := samlPath(assertion, samlAssertionNS, "Subject", "NameID"); nameID != nil && strings.Contains(nameID.Text(), "@") {
+			email = strings.TrimSpace(nameID.Text())
+		}
+	}
+	if email == "" {
+		return "", "", errors.New("assertion has no email address")
+	}
+	name := samlAttribute(assertion, samlNameAttributes)
+	if name == "" {
+		name = strings.TrimSpace(samlAttribute(assertion, []string{"givenName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"}) + " " +
+			samlAttribute(assertion, []string{"sn", "surname", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"}))
+	}
+	return email, name, nil
+}
+
+// checkSAMLAssertion checks who issued an assertion, who it is for and when it is valid
+func checkSAMLAssertion(assertion *etree.Element, sso SSOConfig, requestID string, now time.Time) error {
+	issuer := samlChild(assertion, samlAssertionNS, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != sso.IdPEntityID {
+		return errors.New("assertion is from another issuer")
+	}
+
+	conditions := samlChild(assertion, samlAssertionNS, "Conditions")
+	if conditions == nil {
+		return errors.New("assertion has no conditions")
+	}
+	if err := samlCheckWindow(conditions, now); err != nil {
+		return fmt.Errorf("assertion conditions: %v", err)
+	}
+	for _, restriction := range samlChildren(conditions, samlAssertionNS, "AudienceRestriction") {
+		allowed := false
+		for _, audience := range samlChildren(restriction, samlAssertionNS, "Audience") {
+			allowed = allowed || strings.TrimSpace(audience.Text()) == samlEntityID()
+		}
+		if !allowed {
+			return errors.New("assertion is for another audience")
+		}
+	}
+
+	subject := samlChild(assertion, samlAssertionNS, "Subject")
+	if subject == nil {
+		return errors.New("assertion has no subject")
+	}
+	for _, confirmation := range samlChildren(subject, samlAssertionNS, "SubjectConfirmation") {
+		data := samlChild(confirmation, samlAssertionNS, "SubjectConfirmationData")
+		if confirmation.SelectAttrValue("Method", "") != samlBearer || data == nil {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != samlACSURL() {
+			continue
+		}
+		if got := data.SelectAttrValue("InResponseTo", requestID); got != requestID {
+			continue
+		}
+		if data.SelectAttrValue("NotOnOrAfter", "") == "" || samlCheckWindow(data, now) != nil {
+			continue
+		}
+		return nil
+	}
+	return errors.New("assertion has no valid bearer confirmation for this site")
+}
+
+// samlCheckWindow checks an element's NotBefore and NotOnOrAfter times
+func samlCheckWindow(el *etree.Element, now time.Time) error {
+	if value := el.SelectAttrValue("NotBefore", ""); value != "" {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid NotBefore %q", value)
+		}
+		if now.Add(samlClockSkew).Before(notBefore) {
+			return errors.New("not valid yet")
+		}
+	}
+	if value := el.SelectAttrValue("NotOnOrAfter", ""); value != "" {
+		notOnOrAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid NotOnOrAfter %q", value)
+		}
+		if !now.Add(-samlClockSkew).Before(notOnOrAfter) {
+			return errors.New("expired")
+		}
+	}
+	return nil
+}
+
+// samlAttribute returns the first value of the first of the named attributes
+// the assertion has
+func samlAttribute(assertion *etree.Element, names []string) string {
+	for _, statement := range samlChildren(assertion, samlAssertionNS, "AttributeStatement") {
+		for _, name := range names {
+			for _, attr := range samlChildren(statement, samlAssertionNS, "Attribute") {
+				if !strings.EqualFold(attr.SelectAttrValue("Name", ""), name) {
+					continue
+				}
+				if value := samlChild(attr, samlAssertionNS, "AttributeValue"); value != nil && strings.TrimSpace(value.Text()) != "" {
+					return strings.TrimSpace(value.Text())
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// samlWithNamespaces returns a copy of an element that declares the
+// namespaces it inherits from its ancestors, so its signature can be checked
+// on its own
+func samlWithNamespaces(el *etree.Element) *etree.Element {
+	cp := el.Copy()
+	declared := make(map[string]bool)
+	for p := el; p != nil; p = p.Parent() {
+		for _, attr := range p.Attr {
+			prefix, ok := "", attr.Space == "" && attr.Key == "xmlns"
+			if attr.Space == "xmlns" {
+				prefix, ok = attr.Key, true
+			}
+			if !ok || declared[prefix] {
+				continue
+			}
+			declared[prefix] = true
+			if p != el {
+				cp.CreateAttr(attr.FullKey(), attr.Value)
+			}
+		}
+	}
+	return cp
+}
+
+// samlIs reports whether an element has the given namespace and local name
+func samlIs(el *etree.Element, ns, tag string) bool {
+	return el.Tag == tag && el.NamespaceURI() == ns
+}
+
+// samlChildren returns the child elements with the given namespace and local name
+func samlChildren(el *etree.Element, ns, tag string) []*etree.Element {
+	var list []*etree.Element
+	for _, child := range el.ChildElements() {
+		if samlIs(child, ns, tag) {
+			list = append(list, child)
+		}
+	}
+	return list
+}
+
+// samlChild returns the first child element with the given namespace and local name
+func samlChild(el *etree.Element, ns, tag string) *etree.Element {
+	if list := samlChildren(el, ns, tag); len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// samlPath follows a path of child elements in one namespace
+func samlPath(el *etree.Element, ns string, tags ...string) *etree.Element {
+	for _, tag := range tags {
+		if el = samlChild(el, ns, tag); el == nil {
+			return nil
+		}
+	}
+	return el
+}
+
+// SAMLMetadataHandler serves the service provider metadata teams give their
+// identity provider
+func SAMLMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", samlMetadataNS)
+	entity.CreateAttr("entityID", samlEntityID())
+	sp := entity.CreateElement("md:SPSSODescriptor")
+	sp.CreateAttr("AuthnRequestsSigned", "false")
+	sp.CreateAttr("WantAssertionsSigned", "true")
+	sp.CreateAttr("protocolSupportEnumeration", samlProtocolNS)
+	sp.CreateElement("md:NameIDFormat").SetText(samlEmailFormat)
+	acs := sp.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", samlPOSTBinding)
+	acs.CreateAttr("Location", samlACSURL())
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+	doc.Indent(2)
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	if _, err := doc.WriteTo(w); err != nil {
+		log.Printf("Error writing SAML metadata: %v", err)
+	}
+}
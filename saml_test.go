@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const samlTestIssuer = "https://idp.acme.test"
+
+var samlTestNow = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+// samlTestIdP signs SAML responses with its own certificate
+type samlTestIdP struct {
+	keyStore dsig.TLSCertKeyStore
+	certPEM  string
+}
+
+func newSAMLTestIdP(t *testing.T) *samlTestIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    samlTestNow.Add(-time.Hour),
+		NotAfter:     samlTestNow.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &samlTestIdP{
+		keyStore: dsig.TLSCertKeyStore(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}),
+		certPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// config is the team's single sign-on settings for this provider
+func (idp *samlTestIdP) config() SSOConfig {
+	return SSOConfig{Protocol: ssoProtocolSAML, IdPEntityID: samlTestIssuer, IdPSSOURL: samlTestIssuer + "/sso", IdPCertificate: idp.certPEM}
+}
+
+// samlTestResponse holds the values of a response that the tests change
+type samlTestResponse struct {
+	InResponseTo string
+	Audience     string
+	NotOnOrAfter time.Time
+	Email        string
+}
+
+func (r samlTestResponse) xml() string {
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="%[1]s" xmlns:saml="%[2]s" ID="_response1" Version="2.0" IssueInstant="%[3]s" Destination="%[4]s" InResponseTo="%[5]s">`+
+		`<saml:Issuer>%[6]s</saml:Issuer>`+
+		`<samlp:Status><samlp:StatusCode Value="%[7]s"/></samlp:Status>`+
+		`<saml:Assertion xmlns:saml="%[2]s" ID="_assertion1" Version="2.0" IssueInstant="%[3]s">`+
+		`<saml:Issuer>%[6]s</saml:Issuer>`+
+		`<saml:Subject><saml:NameID Format="%[8]s">%[9]s</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="%[10]s"><saml:SubjectConfirmationData InResponseTo="%[5]s" Recipient="%[4]s" NotOnOrAfter="%[11]s"/></saml:SubjectConfirmation>`+
+		`</saml:Subject>`+
+		`<saml:Conditions NotBefore="%[3]s" NotOnOrAfter="%[11]s"><saml:AudienceRestriction><saml:Audience>%[12]s</saml:Audience></saml:AudienceRestriction></saml:Conditions>`+
+		`<saml:AttributeStatement>`+
+		`<saml:Attribute Name="email"><saml:AttributeValue>%[9]s</saml:AttributeValue></saml:Attribute>`+
+		`<saml:Attribute Name="displayName"><saml:AttributeValue>Ada Lovelace</saml:AttributeValue></saml:Attribute>`+
+		`</saml:AttributeStatement>`+
+		`</saml:Assertion></samlp:Response>`,
+		samlProtocolNS, samlAssertionNS, samlTestNow.Format(samlTimeFormat), samlACSURL(), r.InResponseTo,
+		samlTestIssuer, samlStatusOK, samlEmailFormat, r.Email, samlBearer, r.NotOnOrAfter.Format(samlTimeFormat), r.Audience)
+}
+
+// sign signs the response, its assertion or neither and returns the XML
+func (idp *samlTestIdP) sign(t *testing.T, xml, what string) string {
+	t.Helper()
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xml); err != nil {
+		t.Fatal(err)
+	}
+	// Identity providers sign with exclusive canonicalization, which keeps an
+	// assertion's signature valid when it is moved between documents
+	signer := dsig.NewDefaultSigningContext(idp.keyStore)
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	response := doc.Root()
+	switch what {
+	case "response":
+		signed, err := signer.SignEnveloped(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.SetRoot(signed)
+	case "assertion":
+		assertion := samlChild(response, samlAssertionNS, "Assertion")
+		signed, err := signer.SignEnveloped(assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := assertion.Index()
+		response.RemoveChildAt(i)
+		response.InsertChildAt(i, signed)
+	}
+	out, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestVerifySAMLResponse(t *testing.T) {
+	t.Setenv("DOMAIN_URL", "https://apex.test")
+	idp := newSAMLTestIdP(t)
+	otherIdP := newSAMLTestIdP(t)
+
+	tests := []struct {
+		name      string
+		change    func(*samlTestResponse)
+		signer    *samlTestIdP
+		sign      string              // response, assertion or nothing
+		tamper    func(string) string // applied to the signed XML
+		requestID string              // the request the site sent, when not _request1
+		now       time.Time           // when the response arrives, when not samlTestNow
+		wantErr   string
+	}{
+		{name: "signed assertion", sign: "assertion"},
+		{name: "signed response", sign: "response"},
+		{name: "within clock skew", sign: "assertion", now: samlTestNow.Add(6 * time.Minute)},
+		{name: "unsigned", wantErr: "neither the response nor the assertion is signed"},
+		{name: "tampered assertion", sign: "assertion", tamper: func(x string) string {
+			return strings.ReplaceAll(x, "ada@acme.test", "eve@acme.test")
+		}, wantErr: "invalid assertion signature"},
+		{name: "tampered response", sign: "response", tamper: func(x string) string {
+			return strings.ReplaceAll(x, "ada@acme.test", "eve@acme.test")
+		}, wantErr: "invalid response signature"},
+		{name: "signature removed", sign: "assertion", tamper: func(x string) string {
+			start, end := strings.Index(x, "<ds:Signature"), strings.Index(x, "</ds:Signature>")
+			return x[:start] + x[end+len("</ds:Signature>"):]
+		}, wantErr: "neither the response nor the assertion is signed"},
+		{name: "unsigned assertion added", sign: "assertion", tamper: func(x string) string {
+			forged := x[strings.Index(x, "<saml:Assertion"):strings.Index(x, "<ds:Signature")] + "</saml:Assertion>"
+			forged = strings.ReplaceAll(forged, "ada@acme.test", "eve@acme.test")
+			return strings.Replace(x, "</samlp:Response>", forged+"</samlp:Response>", 1)
+		}, wantErr: "response has 2 assertions"},
+		{name: "signed by another IdP", sign: "assertion", signer: otherIdP, wantErr: "invalid assertion signature"},
+		{name: "wrong audience", sign: "assertion", change: func(r *samlTestResponse) {
+			r.Audience = "https://other.test/sso/saml/metadata"
+		}, wantErr: "another audience"},
+		{name: "wrong InResponseTo", sign: "assertion", requestID: "_request2", wantErr: "response is to request"},
+		{name: "InResponseTo changed outside the signature", sign: "assertion", requestID: "_request2", tamper: func(x string) string {
+			return strings.Replace(x, `InResponseTo="_request1"`, `InResponseTo="_request2"`, 1)
+		}, wantErr: "no valid bearer confirmation"},
+		{name: "expired NotOnOrAfter", sign: "assertion", now: samlTestNow.Add(10 * time.Minute), wantErr: "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := samlTestResponse{
+				InResponseTo: "_request1",
+				Audience:     samlEntityID(),
+				NotOnOrAfter: samlTestNow.Add(5 * time.Minute),
+				Email:        "ada@acme.test",
+			}
+			if tt.change != nil {
+				tt.change(&response)
+			}
+			signer := idp
+			if tt.signer != nil {
+				signer = tt.signer
+			}
+			xml := signer.sign(t, response.xml(), tt.sign)
+			if tt.tamper != nil {
+				xml = tt.tamper(xml)
+			}
+			requestID := "_request1"
+			if tt.requestID != "" {
+				requestID = tt.requestID
+			}
+			now := samlTestNow
+			if !tt.now.IsZero() {
+				now = tt.now
+			}
+
+			email, name, err := verifySAMLResponse(idp.config(), base64.StdEncoding.EncodeToString([]byte(xml)), requestID, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email != "ada@acme.test" || name != "Ada Lovelace" {
+				t.Errorf("got %q, %q", email, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCIM 2.0 schemas and limits
+const (
+	scimUserSchema    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema   = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimConfigSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimDefaultCount  = 100
+	scimMaxCount      = 200
+	scimMaxBodySize   = 1 << 20
+	scimContentType   = "application/scim+json"
+	scimTokenBytes    = 32
+	scimTimeFormat    = time.RFC3339
+	scimBasePath      = "/scim/v2"
+	scimResourceUsers = "Users"
+)
+
+// scimUser is the SCIM representation of a team member
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"` // missing on create means active
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location"`
+}
+
+// scimGroup is the SCIM representation of a team group
+type scimGroup struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []scimMemberRef `json:"members"`
+	Meta        *scimMeta       `json:"meta,omitempty"`
+}
+
+type scimMemberRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Operations []scimOperation `json:"Operations"`
+}
+
+type scimOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// scimProblem is an error answered with a SCIM error response
+type scimProblem struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (p *scimProblem) Error() string { return p.detail }
+
+// badSCIMRequest reports a malformed request
+func badSCIMRequest(scimType, format string, args ...any) error {
+	return &scimProblem{http.StatusBadRequest, scimType, fmt.Sprintf(format, args...)}
+}
+
+// writeSCIM sends a SCIM resource or message
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing SCIM response: %v", err)
+	}
+}
+
+// writeSCIMError sends a SCIM error response. Errors that are not a
+// scimProblem are logged and reported as internal errors.
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var problem *scimProblem
+	if !errors.As(err, &problem) {
+		log.Printf("Error handling SCIM request: %v", err)
+		problem = &scimProblem{status: http.StatusInternalServerError, detail: "Internal error"}
+	}
+	writeSCIM(w, problem.status, map[string]any{
+		"schemas":  []string{scimErrorSchema},
+		"status":   strconv.Itoa(problem.status),
+		"scimType": problem.scimType,
+		"detail":   problem.detail,
+	})
+}
+
+// scimLocation is the URL of a SCIM resource
+func scimLocation(resource, id string) string {
+	return os.Getenv("DOMAIN_URL") + scimBasePath + "/" + resource + "/" + id
+}
+
+// newSCIMToken replaces a team's SCIM bearer token and returns the new one.
+// Only its hash is stored, so it can be shown once.
+func newSCIMToken(teamID string) (string, error) {
+	token, err := randomHex(scimTokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("error generating SCIM token: %v", err)
+	}
+	if _, err := teams.Update(teamID, func(t *Team) error {
+		t.SCIMToken = hashSessionToken(token)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SCIMHandler serves the SCIM 2.0 API a team's identity provider uses to
+// assign and free its seats. Each team has its own bearer token and only sees
+// its own members and groups.
+func SCIMHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	t, found := teams.ForSCIMToken(strings.TrimSpace(token))
+	if !ok || !found {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="SCIM"`)
+		writeSCIMError(w, &scimProblem{status: http.StatusUnauthorized, detail: "Invalid bearer token"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, scimMaxBodySize)
+
+	resource, id, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, scimBasePath), "/"), "/")
+	var status int
+	var result any
+	var err error
+	switch {
+	case resource == "ServiceProviderConfig" && r.Method == http.MethodGet:
+		status, result = http.StatusOK, scimServiceProviderConfig()
+	case resource == scimResourceUsers && id == "" && r.Method == http.MethodGet:
+		status = http.StatusOK
+		result, err = listSCIMUsers(t, r)
+	case resource == scimResourceUsers && id == "" && r.Method == http.MethodPost:
+		status = http.StatusCreated
+		result, err = createSCIMUser(t, r.Body)
+	case resource == scimResourceUsers && r.Method == http.MethodGet:
+		status = http.StatusOK
+		result, err = getSCIMUser(t, id)
+	case resource == scimResourceUsers && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		status = http.StatusOK
+		result, err = changeSCIMUser(t, id, r.Method == http.MethodPatch, r.Body)
+	case resource == scimResourceUsers && r.Method == http.MethodDelete:
+		status = http.StatusNoContent
+		err = deleteSCIMUser(t, id)
+	case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+		status = http.StatusOK
+		result, err = listSCIMGroups(t, r)
+	case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+		status = http.StatusCreated
+		result, err = createSCIMGroup(t, r.Body)
+	case resource == "Groups" && r.Method == http.MethodGet:
+		status = http.StatusOK
+		result, err = getSCIMGroup(t, id)
+	case resource == "Groups" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		status = http.StatusOK
+		result, err = changeSCIMGroup(t, id, r.Method == http.MethodPatch, r.Body)
+	case resource == "Groups" && r.Method == http.MethodDelete:
+		status = http.StatusNoContent
+		err = deleteSCIMGroup(t, id)
+	case resource == "ServiceProviderConfig" || resource == scimResourceUsers || resource == "Groups":
+		err = &scimProblem{status: http.StatusMethodNotAllowed, detail: "Method not allowed"}
+	default:
+		err = &scimProblem{status: http.StatusNotFound, detail: "Unknown resource"}
+	}
+	switch {
+	case err != nil:
+		writeSCIMError(w, err)
+	case status == http.StatusNoContent:
+		w.WriteHeader(status)
+	default:
+		if u, ok := result.(scimUser); ok && status == http.StatusCreated {
+			w.Header().Set("Location", u.Meta.Location)
+		}
+		if g, ok := result.(scimGroup); ok && status == http.StatusCreated {
+			w.Header().Set("Location", g.Meta.Location)
+		}
+		writeSCIM(w, status, result)
+	}
+}
+
+// scimServiceProviderConfig tells identity providers which SCIM features are supported
+func scimServiceProviderConfig() map[string]any {
+	supported := func(ok bool) map[string]bool { return map[string]bool{"supported": ok} }
+	return map[string]any{
+		"schemas":        []string{scimConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The team's SCIM token, created by APEX AI support",
+			"primary":     true,
+		}},
+	}
+}
+
+// decodeSCIM reads a JSON request body
+func decodeSCIM(body io.Reader, v any) error {
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return badSCIMRequest("invalidSyntax", "Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// scimPage returns the requested page of a filtered list
+func scimPage(r *http.Request, resources []any) (scimListResponse, error) {
+	start, count := 1, scimDefaultCount
+	if value := r.FormValue("startIndex"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return scimListResponse{}, badSCIMRequest("invalidValue", "Invalid startIndex %q", value)
+		}
+		start = max(n, 1)
+	}
+	if value := r.FormValue("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return scimListResponse{}, badSCIMRequest("invalidValue", "Invalid count %q", value)
+		}
+		count = min(max(n, 0), scimMaxCount)
+	}
+
+	page := scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		Resources:    []any{},
+	}
+	if start <= len(resources) {
+		page.Resources = resources[start-1 : min(start-1+count, len(resources))]
+	}
+	page.ItemsPerPage = len(page.Resources)
+	return page, nil
+}
+
+// Users
+
+// scimUserOf returns the SCIM user of a team member
+func scimUserOf(m TeamMember, a Account) scimUser {
+	given, family, _ := strings.Cut(a.Name, " ")
+	active := m.Active()
+	modified := m.UpdatedAt
+	if modified.IsZero() {
+		modified = m.AddedAt
+	}
+	return scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          m.AccountID,
+		ExternalID:  m.ExternalID,
+		UserName:    a.Email,
+		Name:        &scimName{Formatted: a.Name, GivenName: given, FamilyName: family},
+		DisplayName: a.Name,
+		Emails:      []scimEmail{{Value: a.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      m.AddedAt.UTC().Format(scimTimeFormat),
+			LastModified: modified.UTC().Format(scimTimeFormat),
+			Location:     scimLocation(scimResourceUsers, m.AccountID),
+		},
+	}
+}
+
+// scimUserValues returns the values of a user attribute for filtering
+func scimUserValues(u scimUser, attr string) []string {
+	switch strings.ToLower(attr) {
+	case "id":
+		return []string{u.ID}
+	case "externalid":
+		return []string{u.ExternalID}
+	case "username":
+		return []string{u.UserName}
+	case "displayname", "name.formatted":
+		return []string{u.DisplayName}
+	case "name.givenname":
+		return []string{u.Name.GivenName}
+	case "name.familyname":
+		return []string{u.Name.FamilyName}
+	case "emails", "emails.value", `emails[type eq "work"].value`:
+		return []string{u.Emails[0].Value}
+	case "active":
+		return []string{strconv.FormatBool(*u.Active)}
+	}
+	return nil
+}
+
+// teamUsers returns the SCIM users of every member of the team, active or not
+func teamUsers(t Team) []scimUser {
+	users := make([]scimUser, 0, len(t.Members))
+	for _, m := range t.Members {
+		if a, ok := accounts.Get(m.AccountID); ok {
+			users = append(users, scimUserOf(m, a))
+		}
+	}
+	return users
+}
+
+// listSCIMUsers answers GET /Users with filtering and pagination
+func listSCIMUsers(t Team, r *http.Request) (scimListResponse, error) {
+	filter, err := parseSCIMFilter(r.FormValue("filter"))
+	if err != nil {
+		return scimListResponse{}, err
+	}
+	var matched []any
+	for _, u := range teamUsers(t) {
+		if filter.matches(func(attr string) []string { return scimUserValues(u, attr) }) {
+			matched = append(matched, u)
+		}
+	}
+	return scimPage(r, matched)
+}
+
+// scimMember finds a member of the team by SCIM user ID
+func scimMember(t Team, id string) (TeamMember, Account, error) {
+	m, ok := t.Member(id)
+	if !ok {
+		return TeamMember{}, Account{}, &scimProblem{status: http.StatusNotFound, detail: "User " + id + " not found"}
+	}
+	a, ok := accounts.Get(id)
+	if !ok {
+		return TeamMember{}, Account{}, &scimProblem{status: http.StatusNotFound, detail: "User " + id + " not found"}
+	}
+	return m, a, nil
+}
+
+// getSCIMUser answers GET /Users/{id}
+func getSCIMUser(t Team, id string) (scimUser, error) {
+	m, a, err := scimMember(t, id)
+	if err != nil {
+		return scimUser{}, err
+	}
+	return scimUserOf(m, a), nil
+}
+
+// email returns the address a SCIM user is provisioned with: its primary
+// email, or its user name
+func (u scimUser) email() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if strings.Contains(u.UserName, "@") || len(u.Emails) == 0 {
+		return u.UserName
+	}
+	return u.Emails[0].Value
+}
+
+// fullName returns the name of a SCIM user
+func (u scimUser) fullName() string {
+	if u.Name != nil {
+		if u.Name.Formatted != "" {
+			return u.Name.Formatted
+		}
+		if name := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); name != "" {
+			return name
+		}
+	}
+	return u.DisplayName
+}
+
+// createSCIMUser answers POST /Users: the user takes one of the team's free
+// seats and gets the welcome email. A user created inactive gets neither
+// until activated.
+func createSCIMUser(t Team, body io.Reader) (scimUser, error) {
+	var u scimUser
+	if err := decodeSCIM(body, &u); err != nil {
+		return scimUser{}, err
+	}
+	email, _, err := validateEmail(u.email())
+	if err != nil {
+		return scimUser{}, badSCIMRequest("invalidValue", "userName must be a valid email address")
+	}
+
+	add := addTeamMember
+	if u.Active != nil && !*u.Active {
+		add = addInactiveTeamMember
+	}
+	a, err := add(t.ID, email, u.fullName(), seatSourceSCIM)
+	switch {
+	case errors.Is(err, errAlreadyMember):
+		return scimUser{}, &scimProblem{http.StatusConflict, "uniqueness", email + " already holds a seat"}
+	case errors.Is(err, errEmailDomain):
+		return scimUser{}, badSCIMRequest("invalidValue", "%s is not at one of the team's domains", email)
+	case errors.Is(err, errNoSeats):
+		return scimUser{}, &scimProblem{status: http.StatusForbidden, detail: fmt.Sprintf("All %d seats the team bought are taken", t.Seats)}
+	case err != nil:
+		return scimUser{}, err
+	}
+
+	if t, err = teams.Update(t.ID, func(t *Team) error {
+		i := slices.IndexFunc(t.Members, func(m TeamMember) bool { return m.AccountID == a.ID })
+		if i < 0 {
+			return errNotMember
+		}
+		t.Members[i].ExternalID = u.ExternalID
+		return nil
+	}); err != nil {
+		return scimUser{}, err
+	}
+	return getSCIMUser(t, a.ID)
+}
+
+// changeSCIMUser answers PUT and PATCH /Users/{id}. Deactivating a user frees
+// their seat and reactivating takes one again, welcoming a user who was
+// created inactive. Attributes other than the name, external ID and active
+// flag are ignored; the address cannot change.
+func changeSCIMUser(t Team, id string, patch bool, body io.Reader) (scimUser, error) {
+	m, a, err := scimMember(t, id)
+	if err != nil {
+		return scimUser{}, err
+	}
+	current := scimUserOf(m, a)
+	changed := current
+	changed.Name = &scimName{GivenName: current.Name.GivenName, FamilyName: current.Name.FamilyName}
+	changed.DisplayName = ""
+
+	if patch {
+		var req scimPatchRequest
+		if err := decodeSCIM(body, &req); err != nil {
+			return scimUser{}, err
+		}
+		for _, op := range req.Operations {
+			if err := applySCIMUserOperation(&changed, op); err != nil {
+				return scimUser{}, err
+			}
+		}
+	} else {
+		var replacement scimUser
+		if err := decodeSCIM(body, &replacement); err != nil {
+			return scimUser{}, err
+		}
+		if replacement.Name == nil {
+			replacement.Name = &scimName{}
+		}
+		if replacement.Active == nil {
+			replacement.Active = current.Active
+		}
+		changed = replacement
+	}
+
+	if email := changed.email(); email != "" && !strings.EqualFold(email, a.Email) {
+		return scimUser{}, badSCIMRequest("mutability", "The email address of a user cannot change")
+	}
+	if name := changed.fullName(); name != "" && name != a.Name {
+		if _, err := accounts.Update(a.ID, func(a *Account) { a.Name = name }); err != nil {
+			return scimUser{}, err
+		}
+	}
+	if changed.ExternalID != m.ExternalID {
+		if _, err := teams.Update(t.ID, func(t *Team) error {
+			i := slices.IndexFunc(t.Members, func(m TeamMember) bool { return m.AccountID == id })
+			if i < 0 {
+				return errNotMember
+			}
+			t.Members[i].ExternalID, t.Members[i].UpdatedAt = changed.ExternalID, time.Now()
+			return nil
+		}); err != nil {
+			return scimUser{}, err
+		}
+	}
+	switch active := *changed.Active; {
+	case active && !m.Active():
+		if err := restoreTeamMember(t.ID, m); errors.Is(err, errNoSeats) {
+			return scimUser{}, &scimProblem{status: http.StatusForbidden, detail: fmt.Sprintf("All %d seats the team bought are taken", t.Seats)}
+		} else if err != nil {
+			return scimUser{}, err
+		}
+	case !active && m.Active():
+		if err := removeTeamMember(t.ID, id, false); err != nil {
+			return scimUser{}, err
+		}
+	}
+
+	t, _ = teams.Get(t.ID)
+	return getSCIMUser(t, id)
+}
+
+// applySCIMUserOperation applies one PATCH operation to a user
+func applySCIMUserOperation(u *scimUser, op scimOperation) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		if strings.EqualFold(op.Path, "externalId") {
+			u.ExternalID = ""
+		}
+		return nil
+	default:
+		return badSCIMRequest("invalidSyntax", "Unknown operation %q", op.Op)
+	}
+
+	// Without a path the value holds the attributes to set
+	if op.Path == "" {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return badSCIMRequest("invalidValue", "Operation value must be an object when there is no path")
+		}
+		for path, value := range values {
+			if err := applySCIMUserOperation(u, scimOperation{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch strings.ToLower(op.Path) {
+	case "active":
+		active, err := scimBool(op.Value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+	case "externalid":
+		return scimString(op.Value, &u.ExternalID)
+	case "displayname":
+		return scimString(op.Value, &u.DisplayName)
+	case "name.formatted":
+		return scimString(op.Value, &u.Name.Formatted)
+	case "name.givenname":
+		return scimString(op.Value, &u.Name.GivenName)
+	case "name.familyname":
+		return scimString(op.Value, &u.Name.FamilyName)
+	case "name":
+		if err := json.Unmarshal(op.Value, u.Name); err != nil {
+			return badSCIMRequest("invalidValue", "Invalid name")
+		}
+	case "username", `emails[type eq "work"].value`:
+		if err := scimString(op.Value, &u.UserName); err != nil {
+			return err
+		}
+		u.Emails = nil
+	}
+	return nil
+}
+
+// scimBool reads a boolean, which some providers send as a string
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, badSCIMRequest("invalidValue", "active must be true or false")
+}
+
+// scimString reads a string value
+func scimString(value json.RawMessage, into *string) error {
+	if err := json.Unmarshal(value, into); err != nil {
+		return badSCIMRequest("invalidValue", "Expected a string, got %s", value)
+	}
+	return nil
+}
+
+// deleteSCIMUser answers DELETE /Users/{id}: the user leaves the team and frees their seat
+func deleteSCIMUser(t Team, id string) error {
+	if _, _, err := scimMember(t, id); err != nil {
+		return err
+	}
+	return removeTeamMember(t.ID, id, true)
+}
+
+// Groups
+
+// scimGroupOf returns the SCIM group of a team group
+func scimGroupOf(g TeamGroup) scimGroup {
+	members := make([]scimMemberRef, 0, len(g.MemberIDs))
+	for _, id := range g.MemberIDs {
+		ref := scimMemberRef{Value: id, Ref: scimLocation(scimResourceUsers, id)}
+		if a, ok := accounts.Get(id); ok {
+			ref.Display = a.Email
+		}
+		members = append(members, ref)
+	}
+	return scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          g.ID,
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Members:     members,
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt.UTC().Format(scimTimeFormat),
+			LastModified: g.UpdatedAt.UTC().Format(scimTimeFormat),
+			Location:     scimLocation("Groups", g.ID),
+		},
+	}
+}
+
+// scimGroupValues returns the values of a group attribute for filtering
+func scimGroupValues(g TeamGroup, attr string) []string {
+	switch strings.ToLower(attr) {
+	case "id":
+		return []string{g.ID}
+	case "externalid":
+		return []string{g.ExternalID}
+	case "displayname":
+		return []string{g.DisplayName}
+	case "members", "members.value":
+		return g.MemberIDs
+	}
+	return nil
+}
+
+// listSCIMGroups answers GET /Groups with filtering and pagination
+func listSCIMGroups(t Team, r *http.Request) (scimListResponse, error) {
+	filter, err := parseSCIMFilter(r.FormValue("filter"))
+	if err != nil {
+		return scimListResponse{}, err
+	}
+	var matched []any
+	for _, g := range t.Groups {
+		if filter.matches(func(attr string) []string { return scimGroupValues(g, attr) }) {
+			matched = append(matched, scimGroupOf(g))
+		}
+	}
+	return scimPage(r, matched)
+}
+
+// getSCIMGroup answers GET /Groups/{id}
+func getSCIMGroup(t Team, id string) (scimGroup, error) {
+	i := slices.IndexFunc(t.Groups, func(g TeamGroup) bool { return g.ID == id })
+	if i < 0 {
+		return scimGroup{}, &scimProblem{status: http.StatusNotFound, detail: "Group " + id + " not found"}
+	}
+	return scimGroupOf(t.Groups[i]), nil
+}
+
+// scimMemberIDs checks that group members are users of the team
+func scimMemberIDs(t *Team, refs []scimMemberRef) ([]string, error) {
+	var ids []string
+	for _, ref := range refs {
+		if _, ok := t.Member(ref.Value); !ok {
+			return nil, badSCIMRequest("invalidValue", "User %s is not a member of the team", ref.Value)
+		}
+		if !slices.Contains(ids, ref.Value) {
+			ids = append(ids, ref.Value)
+		}
+	}
+	return ids, nil
+}
+
+// createSCIMGroup answers POST /Groups. Groups only mirror the provider's
+// grouping; seats follow the users it provisions.
+func createSCIMGroup(t Team, body io.Reader) (scimGroup, error) {
+	var req scimGroup
+	if err := decodeSCIM(body, &req); err != nil {
+		return scimGroup{}, err
+	}
+	if strings.TrimSpace(req.DisplayName) == "" {
+		return scimGroup{}, badSCIMRequest("invalidValue", "displayName is required")
+	}
+	id, err := randomHex(12)
+	if err != nil {
+		return scimGroup{}, err
+	}
+
+	now := time.Now()
+	group := TeamGroup{ID: id, DisplayName: req.DisplayName, ExternalID: req.ExternalID, CreatedAt: now, UpdatedAt: now}
+	if _, err := teams.Update(t.ID, func(t *Team) error {
+		if slices.ContainsFunc(t.Groups, func(g TeamGroup) bool { return strings.EqualFold(g.DisplayName, group.DisplayName) }) {
+			return &scimProblem{http.StatusConflict, "uniqueness", "A group named " + group.DisplayName + " already exists"}
+		}
+		var err error
+		if group.MemberIDs, err = scimMemberIDs(t, req.Members); err != nil {
+			return err
+		}
+		t.Groups = append(t.Groups, group)
+		return nil
+	}); err != nil {
+		return scimGroup{}, err
+	}
+	return scimGroupOf(group), nil
+}
+
+// changeSCIMGroup answers PUT and PATCH /Groups/{id}
+func changeSCIMGroup(t Team, id string, patch bool, body io.Reader) (scimGroup, error) {
+	var replacement scimGroup
+	var req scimPatchRequest
+	if patch {
+		if err := decodeSCIM(body, &req); err != nil {
+			return scimGroup{}, err
+		}
+	} else if err := decodeSCIM(body, &replacement); err != nil {
+		return scimGroup{}, err
+	}
+
+	var changed TeamGroup
+	if _, err := teams.Update(t.ID, func(t *Team) error {
+		i := slices.IndexFunc(t.Groups, func(g TeamGroup) bool { return g.ID == id })
+		if i < 0 {
+			return &scimProblem{status: http.StatusNotFound, detail: "Group " + id + " not found"}
+		}
+		g := &t.Groups[i]
+		if patch {
+			for _, op := range req.Operations {
+				if err := applySCIMGroupOperation(t, g, op); err != nil {
+					return err
+				}
+			}
+		} else {
+			ids, err := scimMemberIDs(t, replacement.Members)
+			if err != nil {
+				return err
+			}
+			if replacement.DisplayName != "" {
+				g.DisplayName = replacement.DisplayName
+			}
+			g.ExternalID, g.MemberIDs = replacement.ExternalID, ids
+		}
+		g.UpdatedAt = time.Now()
+		changed = *g
+		return nil
+	}); err != nil {
+		return scimGroup{}, err
+	}
+	return scimGroupOf(changed), nil
+}
+
+// applySCIMGroupOperation applies one PATCH operation to a group
+func applySCIMGroupOperation(t *Team, g *TeamGroup, op scimOperation) error {
+	path := strings.ToLower(op.Path)
+	var refs []scimMemberRef
+	if path == "members" && len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &refs); err != nil {
+			return badSCIMRequest("invalidValue", "members must be a list of user references")
+		}
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		switch path {
+		case "":
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return badSCIMRequest("invalidValue", "Operation value must be an object when there is no path")
+			}
+			for key, value := range values {
+				if err := applySCIMGroupOperation(t, g, scimOperation{Op: op.Op, Path: key, Value: value}); err != nil {
+					return err
+				}
+			}
+		case "displayname":
+			return scimString(op.Value, &g.DisplayName)
+		case "externalid":
+			return scimString(op.Value, &g.ExternalID)
+		case "members":
+			ids, err := scimMemberIDs(t, refs)
+			if err != nil {
+				return err
+			}
+			if strings.EqualFold(op.Op, "replace") {
+				g.MemberIDs = nil
+			}
+			for _, id := range ids {
+				if !slices.Contains(g.MemberIDs, id) {
+					g.MemberIDs = append(g.MemberIDs, id)
+				}
+			}
+		}
+	case "remove":
+		switch {
+		case path == "members" && len(refs) == 0:
+			g.MemberIDs = nil
+		case path == "members":
+			g.MemberIDs = slices.DeleteFunc(g.MemberIDs, func(id string) bool {
+				return slices.ContainsFunc(refs, func(ref scimMemberRef) bool { return ref.Value == id })
+			})
+		case strings.HasPrefix(path, "members["):
+			// members[value eq "id"]
+			filter, err := parseSCIMFilter(strings.TrimSuffix(op.Path[len("members["):], "]"))
+			if err != nil {
+				return err
+			}
+			g.MemberIDs = slices.DeleteFunc(g.MemberIDs, func(id string) bool {
+				return filter.matches(func(attr string) []string {
+					if strings.EqualFold(attr, "value") {
+						return []string{id}
+					}
+					return nil
+				})
+			})
+		case path == "externalid":
+			g.ExternalID = ""
+		}
+	default:
+		return badSCIMRequest("invalidSyntax", "Unknown operation %q", op.Op)
+	}
+	return nil
+}
+
+// deleteSCIMGroup answers DELETE /Groups/{id}. The members keep their seats.
+func deleteSCIMGroup(t Team, id string) error {
+	_, err := teams.Update(t.ID, func(t *Team) error {
+		i := slices.IndexFunc(t.Groups, func(g TeamGroup) bool { return g.ID == id })
+		if i < 0 {
+			return &scimProblem{status: http.StatusNotFound, detail: "Group " + id + " not found"}
+		}
+		t.Groups = slices.Delete(t.Groups, i, i+1)
+		return nil
+	})
+	return err
+}
+
+// Filters
+
+// scimFilter is a parsed SCIM filter: comparisons that must all match.
+// It covers what identity providers send, such as userName eq "a@b.com";
+// "or", "not" and grouping are not supported.
+type scimFilter []scimComparison
+
+type scimComparison struct {
+	attr  string
+	op    string // eq, ne, co, sw, ew or pr
+	value string
+}
+
+// parseSCIMFilter parses a filter expression; an empty one matches everything
+func parseSCIMFilter(expr string) (scimFilter, error) {
+	tokens, err := scimFilterTokens(expr)
+	if err != nil {
+		return nil, err
+	}
+	var filter scimFilter
+	for len(tokens) > 0 {
+		if len(filter) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, badSCIMRequest("invalidFilter", "Only \"and\" can combine filter expressions")
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 2 {
+			return nil, badSCIMRequest("invalidFilter", "Incomplete filter %q", expr)
+		}
+		c := scimComparison{attr: tokens[0], op: strings.ToLower(tokens[1])}
+		tokens = tokens[2:]
+		switch c.op {
+		case "pr":
+		case "eq", "ne", "co", "sw", "ew":
+			if len(tokens) == 0 {
+				return nil, badSCIMRequest("invalidFilter", "Missing value in filter %q", expr)
+			}
+			c.value, tokens = tokens[0], tokens[1:]
+		default:
+			return nil, badSCIMRequest("invalidFilter", "Unsupported filter operator %q", c.op)
+		}
+		filter = append(filter, c)
+	}
+	return filter, nil
+}
+
+// scimFilterTokens splits a filter into words and JSON string values
+func scimFilterTokens(expr string) ([]string, error) {
+	var tokens []string
+	for expr = strings.TrimSpace(expr); expr != ""; expr = strings.TrimSpace(expr) {
+		if expr[0] != '"' {
+			end := strings.IndexAny(expr, " \t")
+			if end < 0 {
+				end = len(expr)
+			}
+			tokens = append(tokens, expr[:end])
+			expr = expr[end:]
+			continue
+		}
+		// Find the closing quote, skipping escaped ones
+		end := 1
+		for end < len(expr) && expr[end] != '"' {
+			if expr[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(expr) {
+			return nil, badSCIMRequest("invalidFilter", "Unterminated string in filter")
+		}
+		var value string
+		if err := json.Unmarshal([]byte(expr[:end+1]), &value); err != nil {
+			return nil, badSCIMRequest("invalidFilter", "Invalid string in filter")
+		}
+		tokens = append(tokens, value)
+		expr = expr[end+1:]
+	}
+	return tokens, nil
+}
+
+// matches reports whether a resource matches every comparison. IDs compare
+// exactly; other attributes ignore case, like SCIM's caseExact=false.
+func (f scimFilter) matches(values func(attr string) []string) bool {
+	for _, c := range f {
+		exact := strings.EqualFold(c.attr, "id") || strings.EqualFold(c.attr, "externalId") || strings.EqualFold(c.attr, "value")
+		match := false
+		for _, v := range values(c.attr) {
+			a, b := v, c.value
+			if !exact {
+				a, b = strings.ToLower(a), strings.ToLower(b)
+			}
+			switch c.op {
+			case "pr":
+				match = v != ""
+			case "eq":
+				match = a == b
+			case "ne":
+				match = a != b
+			case "co":
+				match = strings.Contains(a, b)
+			case "sw":
+				match = strings.HasPrefix(a, b)
+			case "ew":
+				match = strings.HasSuffix(a, b)
+			}
+			if match {
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
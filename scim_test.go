@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSCIMTestTeam creates a team with the given number of seats, all free
+func startSCIMTestTeam(t *testing.T, seats int) Team {
+	t.Helper()
+	order := Enrollment{
+		ID:            "cs_test_team",
+		CustomerName:  "Grace Hopper",
+		CustomerEmail: "grace@acme.test",
+		CourseName:    "APEX AI",
+		EnrolledAt:    time.Now(),
+	}
+	if _, err := enrollments.Add(order); err != nil {
+		t.Fatal(err)
+	}
+	team, err := teams.Create(Team{Name: "Acme", Seats: seats, Domains: []string{"acme.test"}, Orders: []string{order.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return team
+}
+
+// welcomesTo counts the welcome emails sent to an address
+func welcomesTo(email string) int {
+	n := 0
+	for _, d := range deliveries.Search(email) {
+		if d.Template == "welcome" {
+			n++
+		}
+	}
+	return n
+}
+
+func patchSCIMActive(t *testing.T, team Team, id string, active bool) (scimUser, error) {
+	t.Helper()
+	value := "false"
+	if active {
+		value = "true"
+	}
+	body := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"active","value":` + value + `}]}`
+	team, _ = teams.Get(team.ID)
+	return changeSCIMUser(team, id, true, strings.NewReader(body))
+}
+
+func TestSCIMCreateInactiveUser(t *testing.T) {
+	setupTestStores(t)
+	team := startSCIMTestTeam(t, 1)
+
+	u, err := createSCIMUser(team, strings.NewReader(`{"userName":"ada@acme.test","externalId":"okta-1","name":{"givenName":"Ada","familyName":"Lovelace"},"active":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Active == nil || *u.Active || u.ExternalID != "okta-1" {
+		t.Errorf("created user = %+v, want inactive with its external ID", u)
+	}
+	team, _ = teams.Get(team.ID)
+	m, ok := team.Member(u.ID)
+	if !ok || m.Active() || !m.Pending {
+		t.Fatalf("member = %+v, want pending without a seat", m)
+	}
+	if team.FreeSeats() != 1 {
+		t.Errorf("%d free seats, want the only seat still free", team.FreeSeats())
+	}
+	if e, _ := enrollments.Get(m.EnrollmentID); e.Active() {
+		t.Error("inactive user can open the course")
+	}
+	if n := welcomesTo("ada@acme.test"); n != 0 {
+		t.Errorf("%d welcome emails sent to an inactive user", n)
+	}
+
+	// Activating takes the seat and sends the welcome, once
+	if u, err = patchSCIMActive(t, team, u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if !*u.Active {
+		t.Error("user still inactive")
+	}
+	team, _ = teams.Get(team.ID)
+	if m, _ = team.Member(u.ID); !m.Active() || m.Pending || team.FreeSeats() != 0 {
+		t.Errorf("member = %+v with %d free seats, want the seat taken", m, team.FreeSeats())
+	}
+	if e, _ := enrollments.Get(m.EnrollmentID); !e.Active() {
+		t.Error("activated user cannot open the course")
+	}
+	if n := welcomesTo("ada@acme.test"); n != 1 {
+		t.Errorf("%d welcome emails sent on activation, want 1", n)
+	}
+
+	if _, err := patchSCIMActive(t, team, u.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patchSCIMActive(t, team, u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := welcomesTo("ada@acme.test"); n != 1 {
+		t.Errorf("%d welcome emails after reactivating, want still 1", n)
+	}
+}
+
+func TestSCIMCreateInactiveUserWithoutFreeSeats(t *testing.T) {
+	setupTestStores(t)
+	team := startSCIMTestTeam(t, 1)
+	if _, err := createSCIMUser(team, strings.NewReader(`{"userName":"grace@acme.test"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Provisioning an inactive user needs no seat, but activating one does
+	team, _ = teams.Get(team.ID)
+	u, err := createSCIMUser(team, strings.NewReader(`{"userName":"ada@acme.test","active":false}`))
+	if err != nil {
+		t.Fatalf("creating an inactive user on a full team: %v", err)
+	}
+	_, err = patchSCIMActive(t, team, u.ID, true)
+	var problem *scimProblem
+	if !errors.As(err, &problem) || problem.status != http.StatusForbidden {
+		t.Fatalf("err = %v, want 403 for a full team", err)
+	}
+	if n := welcomesTo("ada@acme.test"); n != 0 {
+		t.Errorf("%d welcome emails sent without a seat", n)
+	}
+
+	// Replacing the user with PUT once a seat is free activates and welcomes them
+	team, _ = teams.Get(team.ID)
+	grace, _ := accounts.FindByEmail("grace@acme.test")
+	if err := removeTeamMember(team.ID, grace.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	team, _ = teams.Get(team.ID)
+	if _, err := changeSCIMUser(team, u.ID, false, strings.NewReader(`{"userName":"ada@acme.test","active":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n := welcomesTo("ada@acme.test"); n != 1 {
+		t.Errorf("%d welcome emails after PUT activation, want 1", n)
+	}
+}
+
+func TestSCIMCreateActiveUser(t *testing.T) {
+	setupTestStores(t)
+	team := startSCIMTestTeam(t, 1)
+	u, err := createSCIMUser(team, strings.NewReader(`{"userName":"ada@acme.test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	team, _ = teams.Get(team.ID)
+	if m, _ := team.Member(u.ID); !m.Active() || m.Pending || team.FreeSeats() != 0 {
+		t.Errorf("member = %+v, want the seat taken", m)
+	}
+	if n := welcomesTo("ada@acme.test"); n != 1 {
+		t.Errorf("%d welcome emails, want 1", n)
+	}
+}
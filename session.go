@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginSessionsFile = "sessions.json"
+	// sessionTouchInterval limits how often a session's last activity is written to disk
+	sessionTouchInterval = time.Minute
+)
+
+// LoginSessionStore keeps the signed-in browsers of student accounts. Only a
+// hash of each session token is stored, so the file cannot be used to sign in.
+type LoginSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*LoginSession // keyed by the token's SHA-256 hash
+
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	maxSessions     int // concurrent sessions per account; 0 means no limit
+	maxDevices      int // distinct devices among an account's sessions; 0 means no limit
+}
+
+// NewLoginSessionStore creates a session store backed by the data directory,
+// with its timeouts and limits read from the environment
+func NewLoginSessionStore() (*LoginSessionStore, error) {
+	s := &LoginSessionStore{sessions: make(map[string]*LoginSession)}
+	var err error
+	if s.maxSessions, err = envInt("SESSION_LIMIT", 4); err != nil {
+		return nil, err
+	}
+	if s.maxDevices, err = envInt("DEVICE_LIMIT", 3); err != nil {
+		return nil, err
+	}
+	if s.idleTimeout, err = envDuration("SESSION_IDLE_TIMEOUT", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if s.absoluteTimeout, err = envDuration("SESSION_ABSOLUTE_TIMEOUT", sessionLifetime); err != nil {
+		return nil, err
+	}
+	if err := loadJSON(loginSessionsFile, &s.sessions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// hashSessionToken returns the key a session token is stored under
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create starts a session for the account and returns its token for the
+// cookie. When the account goes over the session or device limit, its oldest
+// sessions are ended and returned.
+func (s *LoginSessionStore) Create(accountID, method, deviceID string, twoFactor bool, r *http.Request) (string, LoginSession, []LoginSession, error) {
+	token := make([]byte, 32)
+	id := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return "", LoginSession{}, nil, fmt.Errorf("error generating session token: %v", err)
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", LoginSession{}, nil, fmt.Errorf("error generating session ID: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, key)
+		}
+	}
+	session := &LoginSession{
+		ID:         hex.EncodeToString(id),
+		AccountID:  accountID,
+		Method:     method,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		DeviceID:   deviceID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		TwoFactor:  twoFactor,
+	}
+	tokenText := hex.EncodeToString(token)
+	s.sessions[hashSessionToken(tokenText)] = session
+	evicted := s.enforceLimits(session)
+	return tokenText, *session, evicted, s.save()
+}
+
+// enforceLimits ends the oldest sessions of the new session's account until
+// it is within the session and device limits; callers must hold s.mu
+func (s *LoginSessionStore) enforceLimits(newest *LoginSession) []LoginSession {
+	type keyed struct {
+		key     string
+		session *LoginSession
+	}
+	var others []keyed
+	devices := map[string]int{newest.deviceKey(): 1}
+	for key, session := range s.sessions {
+		if session.AccountID == newest.AccountID && session != newest {
+			others = append(others, keyed{key, session})
+			devices[session.deviceKey()]++
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].session.CreatedAt.Before(others[j].session.CreatedAt)
+	})
+
+	var evicted []LoginSession
+	for len(others) > 0 &&
+		((s.maxSessions > 0 && len(others)+1 > s.maxSessions) || (s.maxDevices > 0 && len(devices) > s.maxDevices)) {
+		oldest := others[0]
+		others = others[1:]
+		delete(s.sessions, oldest.key)
+		if devices[oldest.session.deviceKey()]--; devices[oldest.session.deviceKey()] == 0 {
+			delete(devices, oldest.session.deviceKey())
+		}
+		evicted = append(evicted, *oldest.session)
+	}
+	return evicted
+}
+
+// Lookup returns the live session of a token and records the activity
+func (s *LoginSessionStore) Lookup(token string) (LoginSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := hashSessionToken(token)
+	session, ok := s.sessions[key]
+	if !ok {
+		return LoginSession{}, false
+	}
+	now := time.Now()
+	if s.expired(session, now) {
+		delete(s.sessions, key)
+		if err := s.save(); err != nil {
+			log.Printf("Error saving sessions: %v", err)
+		}
+		return LoginSession{}, false
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		session.LastSeenAt = now
+		if err := s.save(); err != nil {
+			log.Printf("Error saving sessions: %v", err)
+		}
+	}
+	return *session, true
+}
+
+// ForAccount returns the live sessions of an account, most recently used first
+func (s *LoginSessionStore) ForAccount(accountID string) []LoginSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var list []LoginSession
+	for _, session := range s.sessions {
+		if session.AccountID == accountID && !s.expired(session, now) {
+			list = append(list, *session)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list
+}
+
+// Revoke ends one of the account's sessions by its ID
+func (s *LoginSessionStore) Revoke(accountID, id string) error {
+	return s.revoke(func(session *LoginSession) bool {
+		return session.AccountID == accountID && session.ID == id
+	})
+}
+
+// RevokeToken ends the session of a token
+func (s *LoginSessionStore) RevokeToken(token string) error {
+	key := hashSessionToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[key]; !ok {
+		return nil
+	}
+	delete(s.sessions, key)
+	return s.save()
+}
+
+// RevokeAccount ends every session of the account except the one with the
+// given ID, which may be empty
+func (s *LoginSessionStore) RevokeAccount(accountID, except string) error {
+	return s.revoke(func(session *LoginSession) bool {
+		return session.AccountID == accountID && session.ID != except
+	})
+}
+
+// revoke deletes the sessions that match
+func (s *LoginSessionStore) revoke(match func(*LoginSession) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := false
+	for key, session := range s.sessions {
+		if match(session) {
+			delete(s.sessions, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return s.save()
+}
+
+// expired reports whether a session has been idle or alive for too long
+func (s *LoginSessionStore) expired(session *LoginSession, now time.Time) bool {
+	return now.Sub(session.LastSeenAt) > s.idleTimeout || now.Sub(session.CreatedAt) > s.absoluteTimeout
+}
+
+// save writes the sessions to disk; callers must hold s.mu
+func (s *LoginSessionStore) save() error {
+	return saveJSON(loginSessionsFile, s.sessions)
+}
+
+// deviceKey is what the device limit counts: the browser's device cookie, or
+// for sessions from before it was issued, the browser and system
+func (session LoginSession) deviceKey() string {
+	if session.DeviceID != "" {
+		return session.DeviceID
+	}
+	return session.Device()
+}
+
+// Device describes the browser and operating system of a session, such as
+// "Firefox on Windows"
+func (session LoginSession) Device() string {
+	ua := session.UserAgent
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSessionTestAccount creates a student account
+func newSessionTestAccount(t *testing.T, email string) Account {
+	t.Helper()
+	a, err := accounts.Ensure(Enrollment{CustomerEmail: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// signedInRequest is a request from the browser holding the session cookie
+func signedInRequest(method, target string, cookie *http.Cookie, form url.Values) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	return r
+}
+
+// ageSession moves a session's creation and last activity into the past
+func ageSession(token string, created, lastSeen time.Duration) {
+	loginSessions.mu.Lock()
+	defer loginSessions.mu.Unlock()
+	session := loginSessions.sessions[hashSessionToken(token)]
+	session.CreatedAt = session.CreatedAt.Add(-created)
+	session.LastSeenAt = session.LastSeenAt.Add(-lastSeen)
+}
+
+func TestSessionSignInAndOut(t *testing.T) {
+	setupTestStores(t)
+	a := newSessionTestAccount(t, "ada@example.com")
+	cookie := signInForTest(t, a)
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Errorf("cookie = %+v, want HttpOnly and SameSite=Lax with a lifetime", cookie)
+	}
+	if got, ok := currentAccount(signedInRequest(http.MethodGet, "/courses", cookie, nil)); !ok || got.ID != a.ID {
+		t.Fatalf("current account = %v, %v; want %s", got.ID, ok, a.ID)
+	}
+
+	// Only a hash of the token is stored
+	saved, err := os.ReadFile(dataPath(loginSessionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), cookie.Value) {
+		t.Error("session token stored in the clear")
+	}
+
+	// Signing in again on the same browser replaces its session
+	w := httptest.NewRecorder()
+	if err := startSession(w, signedInRequest(http.MethodGet, "/", cookie, nil), a, signInMethodLink, false); err != nil {
+		t.Fatal(err)
+	}
+	renewed, _ := responseCookie(w, sessionCookie)
+	if _, ok := currentAccount(signedInRequest(http.MethodGet, "/courses", cookie, nil)); ok {
+		t.Error("previous session still valid after signing in again")
+	}
+	if sessions := loginSessions.ForAccount(a.ID); len(sessions) != 1 || sessions[0].Method != signInMethodLink {
+		t.Errorf("sessions = %+v, want only the new one", sessions)
+	}
+
+	w = httptest.NewRecorder()
+	endSession(w, signedInRequest(http.MethodPost, "/logout", renewed, nil))
+	if _, ok := currentAccount(signedInRequest(http.MethodGet, "/courses", renewed, nil)); ok {
+		t.Error("session still valid after signing out")
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("cookies = %+v, want the session cookie cleared", c)
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	t.Setenv("SESSION_IDLE_TIMEOUT", "1h")
+	t.Setenv("SESSION_ABSOLUTE_TIMEOUT", "24h")
+	setupTestStores(t)
+	a := newSessionTestAccount(t, "ada@example.com")
+
+	tests := []struct {
+		name              string
+		created, lastSeen time.Duration
+		live              bool
+	}{
+		{"fresh", 0, 0, true},
+		{"active for most of a day", 23 * time.Hour, 59 * time.Minute, true},
+		{"idle too long", 2 * time.Hour, 61 * time.Minute, false},
+		{"too old while active", 25 * time.Hour, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie := signInForTest(t, a)
+			ageSession(cookie.Value, tt.created, tt.lastSeen)
+			session, ok := loginSessions.Lookup(cookie.Value)
+			if ok != tt.live {
+				t.Fatalf("live = %v, want %v", ok, tt.live)
+			}
+			if !ok {
+				loginSessions.mu.Lock()
+				_, kept := loginSessions.sessions[hashSessionToken(cookie.Value)]
+				loginSessions.mu.Unlock()
+				if kept {
+					t.Error("expired session kept")
+				}
+				return
+			}
+			// Use keeps an idle session alive
+			if time.Since(session.LastSeenAt) > time.Second {
+				t.Errorf("last seen %v ago after a request", time.Since(session.LastSeenAt))
+			}
+		})
+	}
+}
+
+func TestAdminForceLogout(t *testing.T) {
+	setupTestStores(t)
+	ada := newSessionTestAccount(t, "ada@example.com")
+	grace := newSessionTestAccount(t, "grace@example.com")
+	laptop, phone, other := signInForTest(t, ada), signInForTest(t, ada), signInForTest(t, grace)
+
+	w := httptest.NewRecorder()
+	AdminAccountLogoutHandler(w, httptest.NewRequest(http.MethodGet, "/admin/accounts/logout?id="+ada.ID, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", w.Code)
+	}
+	if len(loginSessions.ForAccount(ada.ID)) != 2 {
+		t.Fatal("GET signed the student out")
+	}
+
+	w = httptest.NewRecorder()
+	AdminAccountLogoutHandler(w, signedInRequest(http.MethodPost, "/admin/accounts/logout", &http.Cookie{Name: "unused"}, url.Values{"id": {ada.ID}}))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want 303", w.Code)
+	}
+	for _, cookie := range []*http.Cookie{laptop, phone} {
+		if _, ok := loginSessions.Lookup(cookie.Value); ok {
+			t.Error("student still signed in after force logout")
+		}
+	}
+	if _, ok := loginSessions.Lookup(other.Value); !ok {
+		t.Error("another student was signed out")
+	}
+}
+
+func TestDevicesRevoke(t *testing.T) {
+	setupTestStores(t)
+	ada := newSessionTestAccount(t, "ada@example.com")
+	grace := newSessionTestAccount(t, "grace@example.com")
+	current, laptop, phone := signInForTest(t, ada), signInForTest(t, ada), signInForTest(t, ada)
+	other := signInForTest(t, grace)
+	session := func(cookie *http.Cookie) LoginSession {
+		s, _ := loginSessions.Lookup(cookie.Value)
+		return s
+	}
+	revoke := func(id string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		DevicesRevokeHandler(w, signedInRequest(http.MethodPost, "/account/devices/revoke", current, url.Values{"id": {id}}))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status = %d, want 303", w.Code)
+		}
+	}
+
+	// Another student's session cannot be ended by its ID
+	revoke(session(other).ID)
+	if _, ok := loginSessions.Lookup(other.Value); !ok {
+		t.Error("another student's session was ended")
+	}
+
+	revoke(session(laptop).ID)
+	if _, ok := loginSessions.Lookup(laptop.Value); ok {
+		t.Error("revoked device still signed in")
+	}
+	if len(loginSessions.ForAccount(ada.ID)) != 2 {
+		t.Error("other devices were signed out too")
+	}
+
+	revoke("others")
+	if _, ok := loginSessions.Lookup(phone.Value); ok {
+		t.Error("other device still signed in")
+	}
+	if _, ok := loginSessions.Lookup(current.Value); !ok {
+		t.Error("current device signed out")
+	}
+}
+
+func TestPasswordChangeEndsSessions(t *testing.T) {
+	setupTestStores(t)
+	a := newSessionTestAccount(t, "ada@example.com")
+	cookie := signInForTest(t, a)
+	if _, err := setPassword(a.ID, "new battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loginSessions.Lookup(cookie.Value); ok {
+		t.Error("session survived a password change")
+	}
+}
+
+func TestLoginLinkNeedsConfirmation(t *testing.T) {
+	setupTestStores(t)
+	a := newSessionTestAccount(t, "ada@example.com")
+	link, err := signInURL(a, "/courses/ai", signInLinkLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the link, as a mail scanner would, signs nobody in
+	for range 2 {
+		w := httptest.NewRecorder()
+		LoginVerifyHandler(w, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+		if _, ok := responseCookie(w, sessionCookie); ok || w.Code != http.StatusOK {
+			t.Fatalf("GET: status %d, want the confirmation page without a session", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), `method="post"`) || !strings.Contains(w.Body.String(), a.Email) {
+			t.Fatal("GET: no confirmation form")
+		}
+	}
+
+	confirm := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		LoginVerifyHandler(w, signedInRequest(http.MethodPost, "/login/verify", &http.Cookie{Name: "unused"}, u.Query()))
+		return w
+	}
+	w := confirm()
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/courses/ai" {
+		t.Fatalf("POST: %d to %q, want signed in and sent on", w.Code, loc)
+	}
+	if _, ok := responseCookie(w, sessionCookie); !ok {
+		t.Fatal("POST: not signed in")
+	}
+	if w := confirm(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "already used") {
+		t.Errorf("second POST: status %d, want the link refused", w.Code)
+	}
+}
+
+func TestDeviceLimitCountsBrowsers(t *testing.T) {
+	t.Setenv("SESSION_LIMIT", "0")
+	t.Setenv("DEVICE_LIMIT", "2")
+	setupTestStores(t)
+	a := newSessionTestAccount(t, "ada@example.com")
+
+	// Every laptop runs the same browser on the same system
+	signIn := func(device *http.Cookie) (session, deviceID *http.Cookie) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+		if device != nil {
+			r.AddCookie(device)
+		}
+		w := httptest.NewRecorder()
+		if err := startSession(w, r, a, signInMethodPassword, false); err != nil {
+			t.Fatal(err)
+		}
+		session, _ = responseCookie(w, sessionCookie)
+		deviceID, ok := responseCookie(w, deviceCookie)
+		if !ok || deviceID.MaxAge <= 0 || !deviceID.HttpOnly {
+			t.Fatalf("device cookie = %+v, want a lasting HttpOnly cookie", deviceID)
+		}
+		return session, deviceID
+	}
+	live := func(session *http.Cookie) bool {
+		_, ok := loginSessions.Lookup(session.Value)
+		return ok
+	}
+
+	first, laptop := signIn(nil)
+	second, other := signIn(nil)
+	if laptop.Value == other.Value {
+		t.Fatal("two browsers got the same device ID")
+	}
+	// The same browser keeps its ID, so a second session is not a new device
+	third, again := signIn(laptop)
+	if again.Value != laptop.Value {
+		t.Error("device ID changed on the same browser")
+	}
+	if !live(first) || !live(second) || !live(third) {
+		t.Fatal("sessions on two devices ended")
+	}
+
+	// A third laptop goes over the limit: the oldest sessions end until two
+	// devices are left
+	fourth, _ := signIn(nil)
+	if live(first) || live(second) {
+		t.Error("oldest sessions kept over the device limit")
+	}
+	if !live(third) || !live(fourth) {
+		t.Error("newest sessions ended")
+	}
+
+	// A forged device ID is replaced
+	if _, forged := signIn(&http.Cookie{Name: deviceCookie, Value: "not-an-id"}); forged.Value == "not-an-id" {
+		t.Error("invalid device ID kept")
+	}
+}
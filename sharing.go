@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginLogFile = "logins.json"
+	// loginLogLimit is how many sign-ins are kept per account
+	loginLogLimit = 200
+)
+
+// LoginLog records where and on what device each account signs in, for
+// spotting shared accounts
+type LoginLog struct {
+	mu      sync.Mutex
+	records map[string][]LoginRecord // by account ID, oldest first
+}
+
+// NewLoginLog creates a login log backed by the data directory
+func NewLoginLog() (*LoginLog, error) {
+	l := &LoginLog{records: make(map[string][]LoginRecord)}
+	if err := loadJSON(loginLogFile, &l.records); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record adds a sign-in to the log
+func (l *LoginLog) Record(rec LoginRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := append(l.records[rec.AccountID], rec)
+	if len(list) > loginLogLimit {
+		list = list[len(list)-loginLogLimit:]
+	}
+	l.records[rec.AccountID] = list
+	return saveJSON(loginLogFile, l.records)
+}
+
+// ForAccount returns the account's sign-ins since the given time, newest first
+func (l *LoginLog) ForAccount(accountID string, since time.Time) []LoginRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var list []LoginRecord
+	records := l.records[accountID]
+	for i := len(records) - 1; i >= 0 && !records[i].At.Before(since); i-- {
+		list = append(list, records[i])
+	}
+	return list
+}
+
+// networkOf returns the network an IP address belongs to: its /24 for IPv4
+// and /48 for IPv6. Addresses from one office or home usually share it.
+func networkOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// SharingDetector flags accounts whose sign-ins come from more networks and
+// devices than one person plausibly uses
+type SharingDetector struct {
+	window       time.Duration // how far back sign-ins are considered
+	maxNetworks  int
+	maxDevices   int
+	maxEvictions int // sign-ins that pushed another session out
+}
+
+// NewSharingDetector reads the sharing thresholds from the environment
+func NewSharingDetector() (*SharingDetector, error) {
+	d := &SharingDetector{}
+	var err error
+	if d.window, err = envDuration("SHARING_WINDOW", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if d.maxNetworks, err = envInt("SHARING_MAX_NETWORKS", 6); err != nil {
+		return nil, err
+	}
+	if d.maxDevices, err = envInt("SHARING_MAX_DEVICES", 5); err != nil {
+		return nil, err
+	}
+	if d.maxEvictions, err = envInt("SHARING_MAX_EVICTIONS", 5); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SharingStats summarizes an account's recent sign-ins
+type SharingStats struct {
+	SignIns   int
+	Networks  int
+	Devices   int
+	Evictions int
+}
+
+// sharingStats counts the distinct networks and devices of a list of sign-ins
+func sharingStats(records []LoginRecord) SharingStats {
+	networks := make(map[string]bool)
+	devices := make(map[string]bool)
+	stats := SharingStats{SignIns: len(records)}
+	for _, rec := range records {
+		networks[networkOf(rec.IP)] = true
+		devices[rec.deviceKey()] = true
+		stats.Evictions += rec.Evicted
+	}
+	stats.Networks, stats.Devices = len(networks), len(devices)
+	return stats
+}
+
+// Recent returns the sign-ins the detector looks at for an account: those in
+// the window and since its last review
+func (d *SharingDetector) Recent(a Account) []LoginRecord {
+	since := time.Now().Add(-d.window)
+	if a.SharingReviewedAt.After(since) {
+		since = a.SharingReviewedAt
+	}
+	return logins.ForAccount(a.ID, since)
+}
+
+// Reason explains why the sign-ins look shared, or returns "" when they do not
+func (d *SharingDetector) Reason(stats SharingStats) string {
+	var reasons []string
+	if d.maxNetworks > 0 && stats.Networks >= d.maxNetworks {
+		reasons = append(reasons, fmt.Sprintf("%d networks", stats.Networks))
+	}
+	if d.maxDevices > 0 && stats.Devices >= d.maxDevices {
+		reasons = append(reasons, fmt.Sprintf("%d devices", stats.Devices))
+	}
+	if d.maxEvictions > 0 && stats.Evictions >= d.maxEvictions {
+		reasons = append(reasons, fmt.Sprintf("%d sessions pushed out by the session limit", stats.Evictions))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return strings.Join(reasons, ", ") + " in " + durationText(d.window)
+}
+
+// Check flags the account for review when its recent sign-ins look shared
+func (d *SharingDetector) Check(a Account) {
+	if !a.SharingFlaggedAt.IsZero() {
+		return
+	}
+	reason := d.Reason(sharingStats(d.Recent(a)))
+	if reason == "" {
+		return
+	}
+	if _, err := accounts.Update(a.ID, func(a *Account) {
+		a.SharingFlaggedAt, a.SharingReason = time.Now(), reason
+	}); err != nil {
+		log.Printf("Error flagging %s for sharing: %v", a.Email, err)
+		return
+	}
+	log.Printf("Flagged %s for possible account sharing: %s", a.Email, reason)
+}
+
+// recordLogin logs a new session and checks the account for sharing
+func recordLogin(a Account, session LoginSession, evicted []LoginSession) {
+	if err := logins.Record(LoginRecord{
+		AccountID: a.ID,
+		At:        session.CreatedAt,
+		IP:        session.IP,
+		UserAgent: session.UserAgent,
+		DeviceID:  session.DeviceID,
+		Method:    session.Method,
+		Evicted:   len(evicted),
+	}); err != nil {
+		log.Printf("Error recording sign-in of %s: %v", a.Email, err)
+	}
+	sharing.Check(a)
+}
+
+// deviceKey tells the device of the sign-in apart, as for sessions
+func (rec LoginRecord) deviceKey() string {
+	return LoginSession{UserAgent: rec.UserAgent, DeviceID: rec.DeviceID}.deviceKey()
+}
+
+// durationText describes a duration in days or hours
+func durationText(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%d hours", int(d.Hours()))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials sign requests to AWS APIs such as SES
+type awsCredentials struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// awsRegionHost matches regional AWS endpoints such as email.eu-west-1.amazonaws.com
+var awsRegionHost = regexp.MustCompile(`^[a-z0-9-]+\.([a-z]{2}(-[a-z]+)+-\d+)\.amazonaws\.com$`)
+
+// parseAWSCredentials reads an "ACCESS_KEY_ID:SECRET_ACCESS_KEY" token. The
+// region comes from the endpoint unless it is given.
+func parseAWSCredentials(token, region, endpoint string) (awsCredentials, error) {
+	accessKey, secretKey, ok := strings.Cut(token, ":")
+	if !ok || accessKey == "" || secretKey == "" {
+		return awsCredentials{}, fmt.Errorf("EMAIL_API_TOKEN must be ACCESS_KEY_ID:SECRET_ACCESS_KEY for the ses format")
+	}
+	if region == "" {
+		if u, err := url.Parse(endpoint); err == nil {
+			if m := awsRegionHost.FindStringSubmatch(u.Hostname()); m != nil {
+				region = m[1]
+			}
+		}
+	}
+	if region == "" {
+		return awsCredentials{}, fmt.Errorf("EMAIL_API_REGION is required when EMAIL_API_URL is not a regional AWS endpoint")
+	}
+	return awsCredentials{accessKey: accessKey, secretKey: secretKey, region: region}, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request for the service.
+// The host, date and content type headers are signed.
+func (c awsCredentials) sign(req *http.Request, body []byte, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102T150405Z")
+	day := date[:8]
+	req.Header.Set("X-Amz-Date", date)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host, "x-amz-date": date}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := day + "/" + c.region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{day, c.region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// awsCanonicalQuery sorts and encodes query parameters the way SigV4 expects
+func awsCanonicalQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but RFC 3986 unreserved characters
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAWSSignature(t *testing.T) {
+	// Cases from the AWS Signature Version 4 test suite
+	credentials := awsCredentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", region: "us-east-1"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name, method, signature string
+	}{
+		{"get-vanilla", http.MethodGet, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", http.MethodPost, "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			credentials.sign(req, nil, "service", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %s\nwant %s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+func TestParseAWSCredentials(t *testing.T) {
+	tests := []struct {
+		token, region, endpoint string
+		want                    string // region, or part of the error
+	}{
+		{"AKID:secret", "", "https://email.eu-west-1.amazonaws.com/v2/email/outbound-emails", "eu-west-1"},
+		{"AKID:secret", "us-gov-west-1", "https://ses.example.com/send", "us-gov-west-1"},
+		{"AKID:secret/with:colon", "", "https://email.ap-southeast-2.amazonaws.com/v2/email/outbound-emails", "ap-southeast-2"},
+		{"AKID:secret", "", "https://ses.example.com/send", "EMAIL_API_REGION"},
+		{"token", "us-east-1", "", "ACCESS_KEY_ID:SECRET_ACCESS_KEY"},
+		{":secret", "us-east-1", "", "ACCESS_KEY_ID:SECRET_ACCESS_KEY"},
+	}
+	for _, tt := range tests {
+		c, err := parseAWSCredentials(tt.token, tt.region, tt.endpoint)
+		if err != nil {
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseAWSCredentials(%q, %q) = %v, want %s", tt.token, tt.endpoint, err, tt.want)
+			}
+			continue
+		}
+		if c.region != tt.want || c.accessKey != "AKID" {
+			t.Errorf("parseAWSCredentials(%q, %q) = %+v, want region %s", tt.token, tt.endpoint, c, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP connection security modes
+const (
+	smtpSecurityNone            = "none"             // plain text, never upgrade
+	smtpSecurityStartTLS        = "starttls"         // upgrade when the server offers STARTTLS
+	smtpSecurityRequireStartTLS = "require-starttls" // fail unless the server offers STARTTLS
+	smtpSecurityTLS             = "tls"              // implicit TLS, usually on port 465
+)
+
+// SMTP authentication mechanisms
+const (
+	smtpAuthNone    = "none"
+	smtpAuthPlain   = "plain"
+	smtpAuthLogin   = "login"
+	smtpAuthCRAMMD5 = "cram-md5"
+)
+
+// smtpTransport delivers messages to the configured SMTP server. It keeps the
+// last connection open for a short while so bulk sends reuse it.
+type smtpTransport struct {
+	config    EmailConfig
+	tlsConfig *tls.Config
+
+	mu        sync.Mutex
+	idle      *smtpConn
+	idleSince time.Time
+}
+
+// newSMTPTransport validates the SMTP settings and loads the CA bundle
+func newSMTPTransport(config EmailConfig) (*smtpTransport, error) {
+	switch config.Security {
+	case smtpSecurityNone, smtpSecurityStartTLS, smtpSecurityRequireStartTLS, smtpSecurityTLS:
+	default:
+		return nil, fmt.Errorf("unknown SMTP security mode %q", config.Security)
+	}
+	switch config.AuthMechanism {
+	case smtpAuthNone, smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5:
+	default:
+		return nil, fmt.Errorf("unknown SMTP auth mechanism %q", config.AuthMechanism)
+	}
+
+	tlsConfig := &tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SMTP CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &smtpTransport{config: config, tlsConfig: tlsConfig}, nil
+}
+
+// Send delivers a message, reusing an idle connection while the server still
+// answers on it
+func (t *smtpTransport) Send(from string, to []string, message []byte) error {
+	conn := t.take()
+	if conn != nil && conn.client.Noop() != nil {
+		// The server dropped the idle connection; checking before the
+		// transaction means a message is never sent twice
+		conn.close()
+		conn = nil
+	}
+	if conn == nil {
+		var err error
+		if conn, err = t.dial(); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.send(from, to, message); err != nil {
+		var protoErr *textproto.Error
+		// Keep the session usable after a rejected transaction
+		if errors.As(err, &protoErr) && conn.client.Reset() == nil {
+			t.put(conn)
+			return err
+		}
+		conn.close()
+		return err
+	}
+
+	t.put(conn)
+	return nil
+}
+
+// take returns the idle connection, closing it if it has been idle too long
+func (t *smtpTransport) take() *smtpConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn := t.idle
+	t.idle = nil
+	if conn != nil && time.Since(t.idleSince) > t.config.IdleTimeout {
+		conn.close()
+		return nil
+	}
+	return conn
+}
+
+// put keeps a connection for reuse, closing any surplus connection
+func (t *smtpTransport) put(conn *smtpConn) {
+	if t.config.IdleTimeout <= 0 {
+		conn.close()
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.idle != nil {
+		conn.close()
+		return
+	}
+	t.idle = conn
+	t.idleSince = time.Now()
+}
+
+// dial opens and authenticates a new SMTP session
+func (t *smtpTransport) dial() (*smtpConn, error) {
+	addr := net.JoinHostPort(t.config.Host, t.config.Port)
+	dialer := &net.Dialer{Timeout: t.config.DialTimeout}
+
+	raw, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+	var conn net.Conn = &timeoutConn{Conn: raw, readTimeout: t.config.ReadTimeout, writeTimeout: t.config.WriteTimeout}
+	if t.config.Security == smtpSecurityTLS {
+		conn = tls.Client(conn, t.tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, t.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting SMTP session: %v", err)
+	}
+	c := &smtpConn{client: client}
+
+	if err := t.handshake(client); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake negotiates STARTTLS and authenticates according to the config
+func (t *smtpTransport) handshake(client *smtp.Client) error {
+	if t.config.Security == smtpSecurityStartTLS || t.config.Security == smtpSecurityRequireStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(t.tlsConfig); err != nil {
+				return fmt.Errorf("error starting TLS: %v", err)
+			}
+		} else if t.config.Security == smtpSecurityRequireStartTLS {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", t.config.Host)
+		}
+	}
+
+	var auth smtp.Auth
+	switch t.config.AuthMechanism {
+	case smtpAuthPlain:
+		auth = smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)
+	case smtpAuthLogin:
+		auth = &loginAuth{username: t.config.Username, password: t.config.Password, host: t.config.Host}
+	case smtpAuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(t.config.Username, t.config.Password)
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+	return nil
+}
+
+// smtpConn is an open, authenticated SMTP session
+type smtpConn struct {
+	client *smtp.Client
+}
+
+// send runs a single mail transaction
+func (c *smtpConn) send(from string, to []string, message []byte) error {
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// close ends the session, dropping the connection if QUIT fails
+func (c *smtpConn) close() {
+	if err := c.client.Quit(); err != nil {
+		c.client.Close()
+	}
+}
+
+// timeoutConn applies a fresh deadline before every read and write so a hung
+// server cannot block a send indefinitely
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.Conn.Write(b)
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which net/smtp
+// does not provide but many Microsoft servers still expect
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like PlainAuth, only send credentials over TLS or to localhost
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// isLocalhost reports whether the SMTP host is the local machine
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	smtpTestUsername = "apex"
+	smtpTestPassword = "s3cret"
+	smtpTestMessage  = "From: hello@apex.test\r\nTo: student@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+)
+
+// fakeSMTPServer is a minimal SMTP server that records what clients do
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config // used for STARTTLS
+
+	startTLS bool   // advertise STARTTLS
+	auth     string // AUTH mechanisms to advertise, e.g. "PLAIN LOGIN"
+	hang     string // greeting or a command the server never answers
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	commands []string
+	messages []fakeSMTPMessage
+}
+
+// fakeSMTPMessage is a delivered message and how its session was set up
+type fakeSMTPMessage struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string // mechanism the session authenticated with
+}
+
+// smtpTestTLS creates a certificate for 127.0.0.1 and the CA file that trusts it
+func smtpTestTLS(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, caFile
+}
+
+// startFakeSMTP starts a server; implicitTLS wraps the listener in TLS
+func startFakeSMTP(t *testing.T, server *fakeSMTPServer, implicitTLS bool) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicitTLS {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+	server.listener = listener
+	t.Cleanup(func() {
+		listener.Close()
+		server.dropConnections()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go server.serve(conn, implicitTLS)
+		}
+	}()
+}
+
+// config returns transport settings pointing at the server
+func (s *fakeSMTPServer) config(security, auth, caFile string) EmailConfig {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return EmailConfig{
+		Host:          "127.0.0.1",
+		Port:          port,
+		Username:      smtpTestUsername,
+		Password:      smtpTestPassword,
+		Security:      security,
+		AuthMechanism: auth,
+		CAFile:        caFile,
+		DialTimeout:   time.Second,
+		ReadTimeout:   time.Second,
+		WriteTimeout:  time.Second,
+		IdleTimeout:   time.Minute,
+	}
+}
+
+// dropConnections closes every open connection, like a server timing out
+// idle clients
+func (s *fakeSMTPServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeSMTPServer) connectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *fakeSMTPServer) received() ([]fakeSMTPMessage, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeSMTPMessage(nil), s.messages...), append([]string(nil), s.commands...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn, secure bool) {
+	defer conn.Close()
+	if s.hang == "greeting" {
+		bufio.NewReader(conn).ReadString('\n')
+		return
+	}
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake.apex.test ESMTP")
+
+	var msg fakeSMTPMessage
+	authed := ""
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		s.mu.Lock()
+		s.commands = append(s.commands, verb)
+		s.mu.Unlock()
+		if verb == s.hang {
+			tp.ReadLine()
+			return
+		}
+
+		switch verb {
+		case "EHLO":
+			lines := []string{"fake.apex.test"}
+			if s.startTLS && !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.auth != "" {
+				lines = append(lines, "AUTH "+s.auth)
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, secure, authed = tlsConn, textproto.NewConn(tlsConn), true, ""
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if s.authenticate(tp, strings.ToUpper(mechanism), initial) {
+				authed = strings.ToUpper(mechanism)
+				tp.PrintfLine("235 Authenticated")
+			} else {
+				tp.PrintfLine("535 Authentication failed")
+			}
+		case "MAIL":
+			if s.auth != "" && authed == "" {
+				tp.PrintfLine("530 Authentication required")
+				continue
+			}
+			msg = fakeSMTPMessage{from: arg, tls: secure, auth: authed}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			if strings.Contains(arg, "reject") {
+				tp.PrintfLine("550 No such user")
+				continue
+			}
+			msg.to = append(msg.to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 Queued")
+		case "RSET":
+			msg = fakeSMTPMessage{}
+			tp.PrintfLine("250 OK")
+		case "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// authenticate runs one AUTH exchange and reports whether the credentials match
+func (s *fakeSMTPServer) authenticate(tp *textproto.Conn, mechanism, initial string) bool {
+	challenge := func(prompt string) string {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, _ := tp.ReadLine()
+		answer, _ := base64.StdEncoding.DecodeString(line)
+		return string(answer)
+	}
+	switch mechanism {
+	case "PLAIN":
+		answer, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return false
+		}
+		return string(answer) == "\x00"+smtpTestUsername+"\x00"+smtpTestPassword
+	case "LOGIN":
+		username := challenge("Username:")
+		password := challenge("Password:")
+		return username == smtpTestUsername && password == smtpTestPassword
+	case "CRAM-MD5":
+		nonce := "<1896.697170952@fake.apex.test>"
+		username, digest, _ := strings.Cut(challenge(nonce), " ")
+		mac := hmac.New(md5.New, []byte(smtpTestPassword))
+		mac.Write([]byte(nonce))
+		return username == smtpTestUsername && digest == hex.EncodeToString(mac.Sum(nil))
+	}
+	return false
+}
+
+func sendTestMessage(t *testing.T, transport *smtpTransport, to string) error {
+	t.Helper()
+	return transport.Send("hello@apex.test", []string{to}, []byte(smtpTestMessage))
+}
+
+func TestSMTPSecurity(t *testing.T) {
+	serverTLS, caFile := smtpTestTLS(t)
+	tests := []struct {
+		name        string
+		security    string
+		implicitTLS bool
+		startTLS    bool
+		wantTLS     bool
+		wantErr     string
+	}{
+		{"implicit TLS", smtpSecurityTLS, true, false, true, ""},
+		{"STARTTLS offered", smtpSecurityStartTLS, false, true, true, ""},
+		{"STARTTLS not offered", smtpSecurityStartTLS, false, false, false, ""},
+		{"required STARTTLS offered", smtpSecurityRequireStartTLS, false, true, true, ""},
+		{"required STARTTLS not offered", smtpSecurityRequireStartTLS, false, false, false, "does not support STARTTLS"},
+		{"plain text ignores STARTTLS", smtpSecurityNone, false, true, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeSMTPServer{tlsConfig: serverTLS, startTLS: tt.startTLS}
+			startFakeSMTP(t, server, tt.implicitTLS)
+			transport, err := newSMTPTransport(server.config(tt.security, smtpAuthNone, caFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sendTestMessage(t, transport, "student@example.com")
+			messages, _ := server.received()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if len(messages) != 0 {
+					t.Errorf("message was sent in plain text")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 {
+				t.Fatalf("server received %d messages", len(messages))
+			}
+			if messages[0].tls != tt.wantTLS {
+				t.Errorf("sent over TLS = %v, want %v", messages[0].tls, tt.wantTLS)
+			}
+			if messages[0].data != strings.ReplaceAll(smtpTestMessage, "\r\n", "\n") {
+				t.Errorf("data = %q", messages[0].data)
+			}
+		})
+	}
+}
+
+func TestSMTPImplicitTLSVerifiesCertificate(t *testing.T) {
+	serverTLS, _ := smtpTestTLS(t)
+	_, otherCA := smtpTestTLS(t)
+	server := &fakeSMTPServer{tlsConfig: serverTLS}
+	startFakeSMTP(t, server, true)
+	transport, err := newSMTPTransport(server.config(smtpSecurityTLS, smtpAuthNone, otherCA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendTestMessage(t, transport, "student@example.com"); err == nil {
+		t.Error("sent to a server with an untrusted certificate")
+	}
+}
+
+func TestSMTPAuth(t *testing.T) {
+	serverTLS, caFile := smtpTestTLS(t)
+	tests := []struct {
+		mechanism string
+		advertise string
+		password  string
+		wantAuth  string
+		wantErr   bool
+	}{
+		{smtpAuthPlain, "PLAIN LOGIN CRAM-MD5", smtpTestPassword, "PLAIN", false},
+		{smtpAuthLogin, "PLAIN LOGIN CRAM-MD5", smtpTestPassword, "LOGIN", false},
+		{smtpAuthCRAMMD5, "PLAIN LOGIN CRAM-MD5", smtpTestPassword, "CRAM-MD5", false},
+		{smtpAuthPlain, "PLAIN", "wrong", "", true},
+		{smtpAuthLogin, "LOGIN", "wrong", "", true},
+		{smtpAuthCRAMMD5, "CRAM-MD5", "wrong", "", true},
+	}
+	for _, tt := range tests {
+		name := tt.mechanism
+		if tt.wantErr {
+			name += " wrong password"
+		}
+		t.Run(name, func(t *testing.T) {
+			server := &fakeSMTPServer{tlsConfig: serverTLS, startTLS: true, auth: tt.advertise}
+			startFakeSMTP(t, server, false)
+			config := server.config(smtpSecurityRequireStartTLS, tt.mechanism, caFile)
+			config.Password = tt.password
+			transport, err := newSMTPTransport(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sendTestMessage(t, transport, "student@example.com")
+			messages, _ := server.received()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "error authenticating") {
+					t.Errorf("err = %v, want an authentication error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || messages[0].auth != tt.wantAuth || !messages[0].tls {
+				t.Errorf("messages = %+v, want one sent over TLS after %s", messages, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestSMTPLoginAuthNeedsTLS(t *testing.T) {
+	auth := &loginAuth{username: smtpTestUsername, password: smtpTestPassword, host: "smtp.apex.test"}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.apex.test", TLS: false}); err == nil {
+		t.Error("LOGIN started over an unencrypted connection")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "other.apex.test", TLS: true}); err == nil {
+		t.Error("LOGIN started with another host")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.apex.test", TLS: true}); err != nil {
+		t.Errorf("LOGIN over TLS: %v", err)
+	}
+}
+
+func TestSMTPTimeouts(t *testing.T) {
+	t.Run("dial", func(t *testing.T) {
+		server := &fakeSMTPServer{}
+		startFakeSMTP(t, server, false)
+		config := server.config(smtpSecurityNone, smtpAuthNone, "")
+		config.DialTimeout = time.Nanosecond
+		transport, err := newSMTPTransport(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sendTestMessage(t, transport, "student@example.com")
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("err = %v, want a dial timeout", err)
+		}
+	})
+
+	for _, hang := range []string{"greeting", "MAIL", "DATA"} {
+		t.Run("no reply to "+hang, func(t *testing.T) {
+			server := &fakeSMTPServer{hang: hang}
+			startFakeSMTP(t, server, false)
+			config := server.config(smtpSecurityNone, smtpAuthNone, "")
+			config.ReadTimeout = 200 * time.Millisecond
+			transport, err := newSMTPTransport(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			err = sendTestMessage(t, transport, "student@example.com")
+			if err == nil || !strings.Contains(err.Error(), "timeout") {
+				t.Errorf("err = %v, want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("send took %v with a 200ms read timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestSMTPConnectionReuse(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTP(t, server, false)
+	transport, err := newSMTPTransport(server.config(smtpSecurityNone, smtpAuthNone, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages, commands := server.received()
+	if len(messages) != 3 || server.connectionCount() != 1 {
+		t.Errorf("sent %d messages over %d connections, want 3 over 1", len(messages), server.connectionCount())
+	}
+	if n := countCommands(commands, "NOOP"); n != 2 {
+		t.Errorf("NOOP sent %d times, want 2", n)
+	}
+	if n := countCommands(commands, "EHLO"); n != 1 {
+		t.Errorf("EHLO sent %d times, want 1", n)
+	}
+}
+
+func TestSMTPReuseAfterRejectedRecipient(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTP(t, server, false)
+	transport, err := newSMTPTransport(server.config(smtpSecurityNone, smtpAuthNone, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sendTestMessage(t, transport, "reject@example.com")
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Fatalf("err = %v, want the 550 reply", err)
+	}
+	if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, commands := server.received()
+	if countCommands(commands, "RSET") != 1 {
+		t.Errorf("commands = %v, want RSET after the rejection", commands)
+	}
+	if len(messages) != 1 || server.connectionCount() != 1 {
+		t.Errorf("sent %d messages over %d connections, want 1 over 1", len(messages), server.connectionCount())
+	}
+}
+
+func TestSMTPIdleConnectionDropped(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTP(t, server, false)
+	transport, err := newSMTPTransport(server.config(smtpSecurityNone, smtpAuthNone, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	server.dropConnections()
+	if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+		t.Fatalf("send after the server dropped the idle connection: %v", err)
+	}
+
+	messages, _ := server.received()
+	if len(messages) != 2 || server.connectionCount() != 2 {
+		t.Errorf("sent %d messages over %d connections, want 2 over 2", len(messages), server.connectionCount())
+	}
+}
+
+func TestSMTPIdleTimeout(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTP(t, server, false)
+	config := server.config(smtpSecurityNone, smtpAuthNone, "")
+	config.IdleTimeout = 50 * time.Millisecond
+	transport, err := newSMTPTransport(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := sendTestMessage(t, transport, "student@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, commands := server.received()
+	if server.connectionCount() != 2 || countCommands(commands, "NOOP") != 0 {
+		t.Errorf("%d connections and commands %v, want a fresh connection after the idle timeout", server.connectionCount(), commands)
+	}
+	if countCommands(commands, "QUIT") != 1 {
+		t.Errorf("commands = %v, want QUIT on the expired connection", commands)
+	}
+}
+
+func TestNewSMTPTransportRejectsUnknownSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config EmailConfig
+	}{
+		{"security", EmailConfig{Security: "ssl", AuthMechanism: smtpAuthNone}},
+		{"auth", EmailConfig{Security: smtpSecurityNone, AuthMechanism: "xoauth2"}},
+		{"missing CA file", EmailConfig{Security: smtpSecurityTLS, AuthMechanism: smtpAuthNone, CAFile: "/nonexistent/ca.pem"}},
+	}
+	for _, tt := range tests {
+		if _, err := newSMTPTransport(tt.config); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func countCommands(commands []string, verb string) int {
+	n := 0
+	for _, c := range commands {
+		if c == verb {
+			n++
+		}
+	}
+	return n
+}
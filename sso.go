@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Single sign-on settings
+const (
+	ssoProtocolOIDC = "oidc"
+	ssoProtocolSAML = "saml"
+
+	ssoCookie  = "apex_sso"
+	ssoPurpose = "sso"
+	// ssoTimeout is how long a sign-in at the identity provider may take
+	ssoTimeout = 10 * time.Minute
+	// oidcDiscoveryTTL is how long a provider's discovery document is reused
+	oidcDiscoveryTTL = time.Hour
+)
+
+// ssoHTTPClient talks to identity providers, which must answer promptly
+// because a student is waiting on the other end
+var ssoHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ssoState is what the browser carries to the identity provider and back.
+// It lives in a signed cookie, so it cannot be changed on the way.
+type ssoState struct {
+	TeamID   string `json:"team_id"`
+	Request  string `json:"request"` // OIDC state or SAML request ID
+	Nonce    string `json:"nonce,omitempty"`
+	Verifier string `json:"verifier,omitempty"` // PKCE code verifier
+	Next     string `json:"next"`
+}
+
+// setSSOState remembers a sign-in sent to the identity provider. The cookie
+// must come back on the provider's cross-site POST, hence SameSite=None.
+func setSSOState(w http.ResponseWriter, state ssoState) {
+	payload, _ := json.Marshal(state)
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    expiringToken(ssoPurpose, string(payload), time.Now().Add(ssoTimeout)),
+		Path:     "/sso",
+		MaxAge:   int(ssoTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// takeSSOState returns the sign-in the browser started and forgets it, so the
+// answer to each request is accepted once
+func takeSSOState(w http.ResponseWriter, r *http.Request) (ssoState, bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    "",
+		Path:     "/sso",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	cookie, err := r.Cookie(ssoCookie)
+	if err != nil {
+		return ssoState{}, false
+	}
+	payload, ok := verifyExpiringToken(ssoPurpose, cookie.Value)
+	if !ok {
+		return ssoState{}, false
+	}
+	var state ssoState
+	if err := json.Unmarshal([]byte(payload), &state); err != nil {
+		return ssoState{}, false
+	}
+	return state, true
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ssoStartURL is where a team member starts signing in with single sign-on
+func ssoStartURL(t Team, next, email string) string {
+	query := url.Values{"team": {t.ID}}
+	if next = localPath(next); next != "/courses" {
+		query.Set("next", next)
+	}
+	if email != "" {
+		query.Set("login_hint", email)
+	}
+	return "/sso/start?" + query.Encode()
+}
+
+var ssoErrorTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Single sign-on failed</h1>
+		<p class="text-blue-200/90 mb-6">{{.Error}}</p>
+		<p class="text-sm text-blue-200/70"><a href="/login" class="text-blue-400 hover:text-blue-300">Back to sign in</a></p>
+	</div>
+{{end}}`)
+
+// renderSSOError explains why single sign-on did not sign the student in
+func renderSSOError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	if err := ssoErrorTmpl.Execute(w, struct {
+		Account Account
+		Error   string
+	}{Error: message}); err != nil {
+		log.Printf("Error rendering single sign-on error: %v", err)
+	}
+}
+
+// SSOStartHandler sends a team member to their company's identity provider
+func SSOStartHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := teams.Get(r.FormValue("team"))
+	if !ok || t.SSO == nil {
+		renderSSOError(w, http.StatusNotFound, "Single sign-on is not set up for this team.")
+		return
+	}
+	next := localPath(r.FormValue("next"))
+
+	var target string
+	var err error
+	switch t.SSO.Protocol {
+	case ssoProtocolOIDC:
+		target, err = startOIDC(w, r, t, next)
+	case ssoProtocolSAML:
+		target, err = startSAML(w, t, next)
+	default:
+		err = fmt.Errorf("unknown protocol %q", t.SSO.Protocol)
+	}
+	if err != nil {
+		log.Printf("Error starting single sign-on for team %s: %v", t.Name, err)
+		renderSSOError(w, http.StatusBadGateway, "We could not reach your company's identity provider. Please try again in a moment.")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// ssoSignIn signs in the person the identity provider vouched for. Their
+// address must be at one of the team's domains, and a newcomer takes one of
+// the team's free seats.
+func ssoSignIn(w http.ResponseWriter, r *http.Request, t Team, email, name, next string) {
+	email, _, err := validateEmail(email)
+	if err != nil || !slices.Contains(t.Domains, emailDomain(email)) {
+		log.Printf("Refused single sign-on of %q to team %s: not at one of its domains", email, t.Name)
+		renderSSOError(w, http.StatusForbidden, "Your company account's email address is not allowed to sign in to this team.")
+		return
+	}
+
+	a, ok := accounts.FindByEmail(email)
+	if m, member := t.Member(a.ID); !ok || !member || !m.Active() {
+		a, err = addTeamMember(t.ID, email, name, seatSourceSSO)
+		switch {
+		case errors.Is(err, errNoSeats):
+			renderSSOError(w, http.StatusForbidden, "All of your team's seats are taken. Please ask your team admin for one.")
+			return
+		case err != nil:
+			log.Printf("Error adding %s to team %s: %v", email, t.Name, err)
+			renderSSOError(w, http.StatusInternalServerError, "We could not set up your course access. Please try again in a moment.")
+			return
+		}
+	}
+	completeSignIn(w, r, a, signInMethodSSO, next)
+}
+
+// oidcProviders caches discovered OpenID Connect providers by issuer. Each
+// provider also caches the keys its ID tokens are signed with.
+var oidcProviders = struct {
+	mu       sync.Mutex
+	byIssuer map[string]*cachedProvider
+}{byIssuer: make(map[string]*cachedProvider)}
+
+type cachedProvider struct {
+	provider   *oidc.Provider
+	discovered time.Time
+}
+
+// ssoContext makes the OIDC and OAuth libraries use ssoHTTPClient
+func ssoContext(ctx context.Context) context.Context {
+	return context.WithValue(oidc.ClientContext(ctx, ssoHTTPClient), oauth2.HTTPClient, ssoHTTPClient)
+}
+
+// oidcProvider discovers a provider's endpoints from its issuer URL. The
+// lock is not held during discovery, so a slow provider does not hold up
+// sign-ins with other teams.
+func oidcProvider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	oidcProviders.mu.Lock()
+	cached, ok := oidcProviders.byIssuer[issuer]
+	oidcProviders.mu.Unlock()
+	if ok && time.Since(cached.discovered) < oidcDiscoveryTTL {
+		return cached.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ssoContext(ctx), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering %s: %v", issuer, err)
+	}
+	oidcProviders.mu.Lock()
+	oidcProviders.byIssuer[issuer] = &cachedProvider{provider, time.Now()}
+	oidcProviders.mu.Unlock()
+	return provider, nil
+}
+
+// oidcConfig is the OAuth client of a team at its provider
+func oidcConfig(provider *oidc.Provider, sso SSOConfig) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     sso.ClientID,
+		ClientSecret: sso.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  os.Getenv("DOMAIN_URL") + "/sso/oidc/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+// startOIDC returns the provider's authorization URL for an authorization
+// code flow protected by state, nonce and PKCE
+func startOIDC(w http.ResponseWriter, r *http.Request, t Team, next string) (string, error) {
+	provider, err := oidcProvider(r.Context(), t.SSO.Issuer)
+	if err != nil {
+		return "", err
+	}
+	state := ssoState{TeamID: t.ID, Next: next, Verifier: oauth2.GenerateVerifier()}
+	if state.Request, err = randomHex(16); err != nil {
+		return "", err
+	}
+	if state.Nonce, err = randomHex(16); err != nil {
+		return "", err
+	}
+	setSSOState(w, state)
+
+	options := []oauth2.AuthCodeOption{oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier)}
+	if hint := r.FormValue("login_hint"); hint != "" {
+		options = append(options, oauth2.SetAuthURLParam("login_hint", hint))
+	}
+	config := oidcConfig(provider, *t.SSO)
+	return config.AuthCodeURL(state.Request, options...), nil
+}
+
+// OIDCCallbackHandler finishes an OpenID Connect sign-in: it trades the code
+// for an ID token, checks the token's signature, issuer, audience, expiry and
+// nonce, and signs in the address it carries
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	state, ok := takeSSOState(w, r)
+	if !ok || subtle.ConstantTimeCompare([]byte(state.Request), []byte(r.FormValue("state"))) != 1 {
+		renderSSOError(w, http.StatusBadRequest, "This sign-in has expired. Please start again.")
+		return
+	}
+	if reason := r.FormValue("error"); reason != "" {
+		log.Printf("Identity provider refused single sign-on: %s %s", reason, r.FormValue("error_description"))
+		renderSSOError(w, http.StatusForbidden, "Your company's identity provider did not sign you in.")
+		return
+	}
+	t, ok := teams.Get(state.TeamID)
+	if !ok || t.SSO == nil || t.SSO.Protocol != ssoProtocolOIDC {
+		renderSSOError(w, http.StatusNotFound, "Single sign-on is not set up for this team.")
+		return
+	}
+
+	email, name, err := verifyOIDCSignIn(r.Context(), *t.SSO, r.FormValue("code"), state)
+	if err != nil {
+		log.Printf("Error verifying single sign-on for team %s: %v", t.Name, err)
+		renderSSOError(w, http.StatusForbidden, "We could not verify your sign-in with your company's identity provider.")
+		return
+	}
+	ssoSignIn(w, r, t, email, name, state.Next)
+}
+
+// verifyOIDCSignIn redeems an authorization code and returns the verified
+// address and name of the person signed in
+func verifyOIDCSignIn(ctx context.Context, sso SSOConfig, code string, state ssoState) (string, string, error) {
+	ctx = ssoContext(ctx)
+	provider, err := oidcProvider(ctx, sso.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+	config := oidcConfig(provider, sso)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return "", "", fmt.Errorf("error redeeming code: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", errors.New("no ID token in the token response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: sso.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid ID token: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		return "", "", errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", "", fmt.Errorf("error reading ID token claims: %v", err)
+	}
+	if claims.Email == "" {
+		return "", "", errors.New("ID token has no email claim")
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return "", "", fmt.Errorf("email %s is not verified", claims.Email)
+	}
+	return claims.Email, claims.Name, nil
+}
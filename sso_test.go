@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	oidcTestClientID     = "apex-courses"
+	oidcTestClientSecret = "client-secret"
+)
+
+// oidcTestIdP is an OpenID Connect provider that issues ID tokens for codes
+// handed out by authorize
+type oidcTestIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]oidcTestGrant
+}
+
+// oidcTestGrant is an authorization waiting to be redeemed
+type oidcTestGrant struct {
+	challenge string // S256 PKCE challenge
+	claims    map[string]any
+	key       *rsa.PrivateKey // signs the ID token
+}
+
+func newOIDCTestIdP(t *testing.T) *oidcTestIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &oidcTestIdP{key: key, codes: make(map[string]oidcTestGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test-key", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// config is the team's single sign-on settings for this provider
+func (idp *oidcTestIdP) config() SSOConfig {
+	return SSOConfig{Protocol: ssoProtocolOIDC, Issuer: idp.server.URL, ClientID: oidcTestClientID, ClientSecret: oidcTestClientSecret}
+}
+
+// authorize signs a person in at the provider and returns the code the
+// browser brings back. The ID token gets the standard claims for the nonce;
+// extra claims override them, and a nil extra claim removes one.
+func (idp *oidcTestIdP) authorize(t *testing.T, verifier, nonce string, extra map[string]any, key *rsa.PrivateKey) string {
+	t.Helper()
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            idp.server.URL,
+		"sub":            "user-1",
+		"aud":            oidcTestClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "ada@acme.test",
+		"email_verified": true,
+		"name":           "Ada Lovelace",
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	if key == nil {
+		key = idp.key
+	}
+	code, err := randomHex(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.codes[code] = oidcTestGrant{challenge: base64.RawURLEncoding.EncodeToString(sum[:]), claims: claims, key: key}
+	return code
+}
+
+// token redeems a code once, checking the client and the PKCE verifier
+func (idp *oidcTestIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != oidcTestClientID || secret != oidcTestClientSecret {
+		oidcTestError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		oidcTestError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := signOIDCTestToken(grant.key, grant.claims)
+	if err != nil {
+		oidcTestError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func oidcTestError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func signOIDCTestToken(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test-key"))
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.CompactSerialize()
+}
+
+func TestVerifyOIDCSignIn(t *testing.T) {
+	idp := newOIDCTestIdP(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		extra    map[string]any
+		key      *rsa.PrivateKey // signs the ID token instead of the provider's key
+		verifier string          // PKCE verifier sent with the code, when not the one in the state
+		nonce    string          // nonce in the state, when not the one in the token
+		wantErr  string
+	}{
+		{name: "good sign-in"},
+		{name: "email_verified absent", extra: map[string]any{"email_verified": nil}},
+		{name: "wrong nonce", nonce: "another-nonce", wantErr: "nonce does not match"},
+		{name: "bad PKCE verifier", verifier: "another-verifier-that-is-long-enough-to-be-valid-pkce", wantErr: "error redeeming code"},
+		{name: "email not verified", extra: map[string]any{"email_verified": false}, wantErr: "is not verified"},
+		{name: "no email", extra: map[string]any{"email": ""}, wantErr: "no email claim"},
+		{name: "other audience", extra: map[string]any{"aud": "another-client"}, wantErr: "invalid ID token"},
+		{name: "other issuer", extra: map[string]any{"iss": "https://evil.test"}, wantErr: "invalid ID token"},
+		{name: "expired", extra: map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}, wantErr: "invalid ID token"},
+		{name: "signed by another key", key: otherKey, wantErr: "invalid ID token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := ssoState{TeamID: "team-1", Request: "state", Nonce: "nonce-1", Verifier: "verifier-0123456789-0123456789-0123456789-0123"}
+			code := idp.authorize(t, state.Verifier, state.Nonce, tt.extra, tt.key)
+			if tt.verifier != "" {
+				state.Verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				state.Nonce = tt.nonce
+			}
+
+			email, name, err := verifyOIDCSignIn(context.Background(), idp.config(), code, state)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email != "ada@acme.test" || name != "Ada Lovelace" {
+				t.Errorf("got %q, %q", email, name)
+			}
+		})
+	}
+
+	t.Run("code used twice", func(t *testing.T) {
+		state := ssoState{Nonce: "nonce-1", Verifier: "verifier-0123456789-0123456789-0123456789-0123"}
+		code := idp.authorize(t, state.Verifier, state.Nonce, nil, nil)
+		if _, _, err := verifyOIDCSignIn(context.Background(), idp.config(), code, state); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := verifyOIDCSignIn(context.Background(), idp.config(), code, state); err == nil {
+			t.Error("code was redeemed twice")
+		}
+	})
+}
+
+func TestOIDCDiscoveryDoesNotBlockOtherIssuers(t *testing.T) {
+	idp := newOIDCTestIdP(t)
+	release := make(chan struct{})
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(stalled.Close)
+	t.Cleanup(func() { close(release) })
+
+	go oidcProvider(context.Background(), stalled.URL)
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := oidcProvider(context.Background(), idp.server.URL)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("discovery waited for another issuer")
+	}
+}
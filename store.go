@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dataPath returns the location of a file in the data directory
+func dataPath(name string) string {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return filepath.Join(dir, name)
+}
+
+// loadJSON reads a JSON file from the data directory into v.
+// A missing file is not an error and leaves v untouched.
+func loadJSON(name string, v any) error {
+	content, err := os.ReadFile(dataPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("error decoding %s: %v", name, err)
+	}
+	return nil
+}
+
+// saveJSON writes v to a JSON file in the data directory.
+// The file is replaced atomically so a crash never leaves it half written.
+func saveJSON(name string, v any) error {
+	path := dataPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating data directory: %v", err)
+	}
+
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", name, err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing %s: %v", name, err)
+	}
+	return nil
+}
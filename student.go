@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// studentLayout wraps every page of the student area. Pages fill in the "content" block.
+const studentLayout = `<!DOCTYPE html>
+<html lang="en" class="dark">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>APEX AI</title>
+	<link href="https://fonts.googleapis.com/css2?family=Lexend+Deca:wght@400;500;600;700&display=swap" rel="stylesheet">
+	<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-black text-white min-h-screen font-['Lexend_Deca']">
+	<nav class="max-w-3xl mx-auto px-8 py-6 flex items-center gap-6 text-blue-200">
+		<a href="/" class="font-bold" style="color: #0066FF">APEX AI</a>
+		{{if .Account.ID}}
+		<a href="/courses" class="hover:text-white">My courses</a>
+		<a href="/account/password" class="hover:text-white">Password</a>
+		<a href="/account/devices" class="hover:text-white">Devices</a>
+		<a href="/account/2fa" class="hover:text-white">Two-factor</a>
+		<span class="ml-auto text-blue-200/70 text-sm">{{.Account.Email}}</span>
+		<form method="post" action="/logout">
+			<button class="hover:text-white">Sign out</button>
+		</form>
+		{{end}}
+	</nav>
+	<main class="max-w-3xl mx-auto px-8 pb-16">
+		{{template "content" .}}
+	</main>
+</body>
+</html>`
+
+// studentTemplate parses a student page into the shared student layout
+func studentTemplate(content string) *template.Template {
+	return template.Must(template.Must(template.New("layout").Funcs(studentFuncs).Parse(studentLayout)).Parse(content))
+}
+
+// studentFuncs are the helpers available to student page templates
+var studentFuncs = template.FuncMap{
+	"date": func(t time.Time) string { return formatDate(defaultLocale, t) },
+}
+
+var loginTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Sign in</h1>
+		{{if .Sent}}
+		<p class="text-blue-200/90 mb-4">If {{.Email}} belongs to a student, a sign-in link is on its way. It works once, for the next 15 minutes.</p>
+		<p class="text-sm text-blue-200/70">Nothing after a few minutes? Check your spam folder or <a href="/login" class="text-blue-400 hover:text-blue-300">try again</a>.</p>
+		{{else}}
+		<p class="text-blue-200/90 mb-6">Enter the email address you enrolled with. Leave the password empty and we'll email you a link to sign in, or send you to your company's sign-in page if it uses single sign-on.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/login" class="flex flex-col gap-4">
+			<input type="hidden" name="next" value="{{.Next}}">
+			<label for="email" class="text-blue-200">Email address</label>
+			<input type="email" id="email" name="email" value="{{.Email}}" required autofocus autocomplete="email" class="bg-gray-900 rounded px-4 py-3">
+			<label for="password" class="text-blue-200">Password <span class="text-blue-200/70 text-sm">(if you have set one)</span></label>
+			<input type="password" id="password" name="password" autocomplete="current-password" class="bg-gray-900 rounded px-4 py-3">
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Sign in</button>
+		</form>
+		<p class="mt-4 text-sm text-blue-200/70"><a href="/password/forgot" class="text-blue-400 hover:text-blue-300">Forgot your password, or want to set one?</a></p>
+		{{end}}
+	</div>
+{{end}}`)
+
+// loginPage is the data of the login page
+type loginPage struct {
+	Account Account
+	Email   string
+	Next    string
+	Error   string
+	Sent    bool
+	Token   string // sign-in link waiting to be confirmed
+}
+
+// LoginHandler shows the login form, signs students in with their password
+// and emails sign-in links to those who leave it empty
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	page := loginPage{Next: localPath(r.FormValue("next"))}
+	if _, ok := currentAccount(r); ok && r.Method != http.MethodPost {
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		email, _, err := validateEmail(r.FormValue("email"))
+		if err != nil {
+			page.Email = r.FormValue("email")
+			page.Error = "Please enter a valid email address."
+		} else if t, ok := teams.ForSSODomain(emailDomain(email)); ok {
+			// Companies with single sign-on decide who may sign in
+			http.Redirect(w, r, ssoStartURL(t, page.Next, email), http.StatusSeeOther)
+			return
+		} else if password := r.FormValue("password"); password != "" {
+			if !signInLimits.AllowPassword(clientIP(r)) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				page.Email = email
+				page.Error = "Too many sign-in attempts. Please wait a while and try again."
+			} else if a, err := signInWithPassword(email, password); err != nil {
+				page.Email = email
+				page.Error = err.Error()
+			} else {
+				completeSignIn(w, r, a, signInMethodPassword, page.Next)
+				return
+			}
+		} else if !signInLimits.Allow(email, clientIP(r)) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			page.Email = email
+			page.Error = "Too many sign-in requests. Please wait a while and try again."
+		} else {
+			// The page reads the same whether or not the address has an
+			// account, so it cannot be used to find out who our students are
+			page.Email, page.Sent = email, true
+			if a, ok := accountForEmail(email); ok {
+				go sendSignInLink(a, page.Next)
+			}
+		}
+	}
+
+	if err := loginTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering login page: %v", err)
+	}
+}
+
+// sendSignInLink emails a sign-in link to the account
+func sendSignInLink(a Account, next string) {
+	data := accountEmailData(a)
+	var err error
+	if data.SignInURL, err = signInURL(a, next, signInLinkLifetime); err != nil {
+		log.Printf("Error creating sign-in link for %s: %v", a.Email, err)
+		return
+	}
+	if err := emailService.SendTemplateEmail("sign_in", data); err != nil && !errors.Is(err, errSuppressed) {
+		log.Printf("Error sending sign-in link to %s: %v", a.Email, err)
+	}
+}
+
+var loginVerifyTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Sign in</h1>
+		<p class="text-blue-200/90 mb-6">Continue as {{.Email}}?</p>
+		<form method="post" action="/login/verify" class="flex flex-col gap-4">
+			<input type="hidden" name="token" value="{{.Token}}">
+			<input type="hidden" name="next" value="{{.Next}}">
+			<button autofocus class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Sign in</button>
+		</form>
+		<p class="mt-4 text-sm text-blue-200/70">Not you? <a href="/login" class="text-blue-400 hover:text-blue-300">Sign in with another address</a>.</p>
+	</div>
+{{end}}`)
+
+// LoginVerifyHandler signs the student in from an emailed link. Opening the
+// link only asks to confirm; the link is used up when the student confirms,
+// so security gateways that open links in emails cannot spend it.
+func LoginVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	token, next := r.FormValue("token"), localPath(r.FormValue("next"))
+	id, ok := parseAccountLink(signInTokenPurpose, token, r.Method == http.MethodPost)
+	if !ok {
+		loginTmpl.Execute(w, loginPage{Next: next, Error: "This sign-in link has expired or was already used. Enter your email address to get a new one."})
+		return
+	}
+	a, ok := accounts.Get(id)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		if err := loginVerifyTmpl.Execute(w, loginPage{Email: a.Email, Next: next, Token: token}); err != nil {
+			log.Printf("Error rendering sign-in confirmation: %v", err)
+		}
+		return
+	}
+	completeSignIn(w, r, a, signInMethodLink, next)
+}
+
+// LogoutHandler signs the student out
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	endSession(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+var coursesTmpl = studentTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">My courses</h1>
+	<p class="text-blue-200/90 mb-8">Welcome back{{if .Account.Name}}, {{.Account.Name}}{{end}}.</p>
+	{{range .Enrollments}}
+	<div class="rounded-lg border border-blue-200/20 p-6 mb-4">
+		<div class="flex items-start gap-4">
+			<div class="flex-1">
+				<h2 class="text-xl font-semibold">{{.CourseName}}</h2>
+				<p class="text-sm text-blue-200/70">Enrolled {{date .EnrolledAt}}</p>
+			</div>
+			{{if .Refunded}}<span class="text-sm text-red-400">Refunded</span>{{else if not .RevokedAt.IsZero}}<span class="text-sm text-red-400">Seat removed by your team</span>{{end}}
+		</div>
+		{{if .Active}}
+		<p class="mt-4 text-blue-200/90">{{if .ModulesCompleted}}{{.ModulesCompleted}} module{{if ne .ModulesCompleted 1}}s{{end}} completed{{else}}You haven't started yet.{{end}}</p>
+		{{end}}
+	</div>
+	{{else}}
+	<p class="text-blue-200/70">No courses are linked to {{.Account.Email}}. If you enrolled with another address, sign in with that one, or contact support.</p>
+	{{end}}
+	{{if .Teams}}
+	<h2 class="text-xl font-semibold mt-10 mb-4">Teams you manage</h2>
+	{{range .Teams}}
+	<a href="/team?team={{.ID}}" class="block rounded-lg border border-blue-200/20 p-4 mb-2 hover:border-blue-400">{{.Name}} <span class="text-sm text-blue-200/70">{{.Taken}} of {{.Seats}} seats taken</span></a>
+	{{end}}
+	{{end}}
+{{end}}`)
+
+// CoursesHandler shows the signed-in student's enrollments
+func CoursesHandler(w http.ResponseWriter, r *http.Request) {
+	a, _ := currentAccount(r)
+	data := struct {
+		Account     Account
+		Enrollments []Enrollment
+		Teams       []Team
+	}{a, accountEnrollments(a), teams.ForAdmin(a.ID)}
+
+	if err := coursesTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering courses page: %v", err)
+	}
+}
+
+var devicesTmpl = studentTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">Signed-in devices</h1>
+	<p class="text-blue-200/90 mb-8">These browsers are signed in to {{.Account.Email}}. Sign out any you don't recognize, then change your password.</p>
+	{{range .Sessions}}
+	<div class="rounded-lg border border-blue-200/20 p-6 mb-4 flex items-start gap-4">
+		<div class="flex-1">
+			<h2 class="text-lg font-semibold">{{.Device}}{{if eq .ID $.Current}} <span class="text-sm text-green-400">This device</span>{{end}}</h2>
+			<p class="text-sm text-blue-200/70">Signed in {{date .CreatedAt}} with {{if eq .Method "password"}}a password{{else if eq .Method "sso"}}single sign-on{{else}}an email link{{end}} from {{.IP}}</p>
+			<p class="text-sm text-blue-200/70">Last active {{date .LastSeenAt}}</p>
+		</div>
+		{{if ne .ID $.Current}}
+		<form method="post" action="/account/devices/revoke">
+			<input type="hidden" name="id" value="{{.ID}}">
+			<button class="text-red-400 hover:text-red-300">Sign out</button>
+		</form>
+		{{end}}
+	</div>
+	{{end}}
+	{{if gt (len .Sessions) 1}}
+	<form method="post" action="/account/devices/revoke">
+		<input type="hidden" name="id" value="others">
+		<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Sign out all other devices</button>
+	</form>
+	{{end}}
+{{end}}`)
+
+// DevicesHandler lists the browsers signed in to the student's account
+func DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	session, a, _ := currentSession(r)
+	data := struct {
+		Account  Account
+		Current  string
+		Sessions []LoginSession
+	}{a, session.ID, loginSessions.ForAccount(a.ID)}
+
+	if err := devicesTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering devices page: %v", err)
+	}
+}
+
+// DevicesRevokeHandler signs one of the student's other devices out, or all of them
+func DevicesRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, a, _ := currentSession(r)
+
+	var err error
+	if id := r.FormValue("id"); id == "others" {
+		err = loginSessions.RevokeAccount(a.ID, session.ID)
+	} else {
+		err = loginSessions.Revoke(a.ID, id)
+	}
+	if err != nil {
+		log.Printf("Error revoking sessions of %s: %v", a.Email, err)
+		http.Error(w, "Error signing the device out", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/devices", http.StatusSeeOther)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const suppressionsFile = "suppressions.json"
+
+// errSuppressed is returned when the recipient must not receive the email
+var errSuppressed = errors.New("recipient is on the suppression list")
+
+// SuppressionStore is the list of addresses that opted out of email categories
+// or must not be emailed at all
+type SuppressionStore struct {
+	mu           sync.Mutex
+	suppressions map[string]*Suppression
+}
+
+// NewSuppressionStore creates a suppression store backed by the data directory
+func NewSuppressionStore() (*SuppressionStore, error) {
+	s := &SuppressionStore{suppressions: make(map[string]*Suppression)}
+	if err := loadJSON(suppressionsFile, &s.suppressions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// suppressionKey normalizes an address for lookups
+func suppressionKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Suppressed reports whether an email of the given category must not be sent
+// to the address. Transactional email is only stopped for blocked addresses.
+func (s *SuppressionStore) Suppressed(email, category string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup, ok := s.suppressions[suppressionKey(email)]
+	if !ok {
+		return false
+	}
+	if sup.Blocked {
+		return true
+	}
+	return category != categoryTransactional && slices.Contains(sup.Categories, category)
+}
+
+// Get returns the suppression entry for an address
+func (s *SuppressionStore) Get(email string) (Suppression, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup, ok := s.suppressions[suppressionKey(email)]
+	if !ok {
+		return Suppression{}, false
+	}
+	return *sup, true
+}
+
+// Unsubscribe opts the address out of a single category
+func (s *SuppressionStore) Unsubscribe(email, category string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup := s.entry(email)
+	if !slices.Contains(sup.Categories, category) {
+		sup.Categories = append(sup.Categories, category)
+		sort.Strings(sup.Categories)
+	}
+	sup.UpdatedAt = time.Now()
+	return s.save()
+}
+
+// SetUnsubscribed replaces the categories the address opted out of
+func (s *SuppressionStore) SetUnsubscribed(email string, categories []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup := s.entry(email)
+	sup.Categories = append([]string(nil), categories...)
+	sort.Strings(sup.Categories)
+	sup.UpdatedAt = time.Now()
+	return s.save()
+}
+
+// Block stops all email to the address, e.g. after a hard bounce
+func (s *SuppressionStore) Block(email, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup := s.entry(email)
+	sup.Blocked = true
+	sup.Reason = reason
+	sup.UpdatedAt = time.Now()
+	return s.save()
+}
+
+// RecordSoftBounce counts a temporary delivery failure and blocks the address
+// once softBounceLimit is reached. It reports whether the address is now blocked.
+func (s *SuppressionStore) RecordSoftBounce(email, reason string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sup := s.entry(email)
+	sup.SoftBounces++
+	if sup.SoftBounces >= softBounceLimit {
+		sup.Blocked = true
+		sup.Reason = reason
+	}
+	sup.UpdatedAt = time.Now()
+	return sup.Blocked, s.save()
+}
+
+// entry returns the entry for an address, creating it if needed; callers must hold s.mu
+func (s *SuppressionStore) entry(email string) *Suppression {
+	key := suppressionKey(email)
+	sup, ok := s.suppressions[key]
+	if !ok {
+		sup = &Suppression{Email: key}
+		s.suppressions[key] = sup
+	}
+	return sup
+}
+
+// save writes the suppression list to disk; callers must hold s.mu
+func (s *SuppressionStore) save() error {
+	return saveJSON(suppressionsFile, s.suppressions)
+}
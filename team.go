@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	teamsFile = "teams.json"
+	// maxSeatsPerOrder caps the seats one checkout can buy
+	maxSeatsPerOrder = 500
+)
+
+// Ways a member got their seat
+const (
+	seatSourceCheckout = "checkout"
+	seatSourceSSO      = "sso"
+	seatSourceSCIM     = "scim"
+	seatSourceInvite   = "invite"
+)
+
+var (
+	errNoSeats       = errors.New("all of the team's seats are taken")
+	errEmailDomain   = errors.New("the email address is not at one of the team's domains")
+	errAlreadyMember = errors.New("the account already holds one of the team's seats")
+	errNotMember     = errors.New("the account is not a member of the team")
+)
+
+// TeamStore keeps the teams that bought seats
+type TeamStore struct {
+	mu    sync.Mutex
+	teams map[string]*Team
+}
+
+// NewTeamStore creates a team store backed by the data directory
+func NewTeamStore() (*TeamStore, error) {
+	s := &TeamStore{teams: make(map[string]*Team)}
+	if err := loadJSON(teamsFile, &s.teams); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create stores a new team and returns it with its ID
+func (s *TeamStore) Create(t Team) (Team, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Team{}, fmt.Errorf("error generating team ID: %v", err)
+	}
+	t.ID = hex.EncodeToString(id)
+	t.CreatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.teams[t.ID] = &t
+	return t.copy(), s.save()
+}
+
+// Get returns the team with the given ID
+func (s *TeamStore) Get(id string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.teams[id]
+	if !ok {
+		return Team{}, false
+	}
+	return t.copy(), true
+}
+
+// List returns every team, oldest first
+func (s *TeamStore) List() []Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Team, 0, len(s.teams))
+	for _, t := range s.teams {
+		list = append(list, t.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Update applies fn to the team with the given ID and persists the result.
+// When fn returns an error the team is left unchanged.
+func (s *TeamStore) Update(id string, fn func(*Team) error) (Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.teams[id]
+	if !ok {
+		return Team{}, fmt.Errorf("team %s not found", id)
+	}
+	updated := t.copy()
+	if err := fn(&updated); err != nil {
+		return Team{}, err
+	}
+	*t = updated
+	return t.copy(), s.save()
+}
+
+// ForSSODomain returns the team that signs in the addresses at a domain with
+// its identity provider
+func (s *TeamStore) ForSSODomain(domain string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.teams {
+		if t.SSO != nil && slices.Contains(t.Domains, strings.ToLower(domain)) {
+			return t.copy(), true
+		}
+	}
+	return Team{}, false
+}
+
+// ForAdmin returns the teams an account manages, oldest first
+func (s *TeamStore) ForAdmin(accountID string) []Team {
+	var list []Team
+	for _, t := range s.List() {
+		if slices.Contains(t.AdminIDs, accountID) {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// ForSCIMToken returns the team a SCIM bearer token belongs to
+func (s *TeamStore) ForSCIMToken(token string) (Team, bool) {
+	hash := hashSessionToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.teams {
+		if t.SCIMToken != "" && subtle.ConstantTimeCompare([]byte(t.SCIMToken), []byte(hash)) == 1 {
+			return t.copy(), true
+		}
+	}
+	return Team{}, false
+}
+
+// save writes the teams to disk; callers must hold s.mu
+func (s *TeamStore) save() error {
+	return saveJSON(teamsFile, s.teams)
+}
+
+// copy returns the team with its own slices and SSO settings
+func (t *Team) copy() Team {
+	cp := *t
+	cp.Orders = append([]string(nil), t.Orders...)
+	cp.AdminIDs = append([]string(nil), t.AdminIDs...)
+	cp.Domains = append([]string(nil), t.Domains...)
+	cp.Members = append([]TeamMember(nil), t.Members...)
+	cp.Groups = make([]TeamGroup, len(t.Groups))
+	for i, g := range t.Groups {
+		g.MemberIDs = append([]string(nil), g.MemberIDs...)
+		cp.Groups[i] = g
+	}
+	if t.SSO != nil {
+		sso := *t.SSO
+		cp.SSO = &sso
+	}
+	return cp
+}
+
+// Member returns the account's membership of the team, which may be inactive
+func (t Team) Member(accountID string) (TeamMember, bool) {
+	for _, m := range t.Members {
+		if m.AccountID == accountID {
+			return m, true
+		}
+	}
+	return TeamMember{}, false
+}
+
+// Active reports whether the member holds a seat
+func (m TeamMember) Active() bool {
+	return m.RemovedAt.IsZero()
+}
+
+// Taken is how many of the team's seats are held
+func (t Team) Taken() int {
+	taken := 0
+	for _, m := range t.Members {
+		if m.Active() {
+			taken++
+		}
+	}
+	return taken
+}
+
+// FreeSeats is how many more members the team can add
+func (t Team) FreeSeats() int {
+	return max(t.Seats-t.Taken(), 0)
+}
+
+// AllowsEmail reports whether an address may hold one of the team's seats
+func (t Team) AllowsEmail(email string) bool {
+	if len(t.Domains) == 0 {
+		return true
+	}
+	return slices.Contains(t.Domains, emailDomain(email))
+}
+
+// emailDomain returns the lowercased domain of an address
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// checkoutSeats returns how many seats a checkout session bought
+func checkoutSeats(metadata map[string]string) int {
+	seats, err := strconv.Atoi(metadata["seats"])
+	if err != nil || seats < 1 {
+		return 1
+	}
+	return seats
+}
+
+// startTeam creates the team of an order that bought several seats. The buyer
+// manages it and takes the first seat with the order itself.
+func startTeam(order Enrollment, buyer Account, seats int) (Team, error) {
+	name := order.Company
+	if name == "" {
+		name = order.CustomerName
+	}
+	t, err := teams.Create(Team{
+		Name:     name,
+		Seats:    seats,
+		Orders:   []string{order.ID},
+		AdminIDs: []string{buyer.ID},
+		Members: []TeamMember{{
+			AccountID:    buyer.ID,
+			EnrollmentID: order.ID,
+			Source:       seatSourceCheckout,
+			AddedAt:      order.EnrolledAt,
+			UpdatedAt:    order.EnrolledAt,
+		}},
+	})
+	if err != nil {
+		return Team{}, err
+	}
+	if err := enrollments.Update(order.ID, func(e *Enrollment) { e.TeamID = t.ID }); err != nil {
+		return Team{}, err
+	}
+	return t, nil
+}
+
+// addTeamMember gives an address one of the team's free seats. The new
+// member gets an enrollment and account of their own and the same welcome as
+// a buyer. A member whose seat was freed gets it back with their progress.
+func addTeamMember(teamID, email, name, source string) (Account, error) {
+	return joinTeam(teamID, email, name, source, true)
+}
+
+// addInactiveTeamMember puts an address on a team without a seat or a
+// welcome; both come when restoreTeamMember activates the member
+func addInactiveTeamMember(teamID, email, name, source string) (Account, error) {
+	return joinTeam(teamID, email, name, source, false)
+}
+
+// joinTeam adds an address to a team, holding a seat when active
+func joinTeam(teamID, email, name, source string, active bool) (Account, error) {
+	t, ok := teams.Get(teamID)
+	if !ok {
+		return Account{}, fmt.Errorf("team %s not found", teamID)
+	}
+	if !t.AllowsEmail(email) {
+		return Account{}, errEmailDomain
+	}
+	if a, ok := accounts.FindByEmail(email); ok {
+		if m, ok := t.Member(a.ID); ok {
+			if m.Active() {
+				return a, errAlreadyMember
+			}
+			if !active {
+				return a, nil
+			}
+			return a, restoreTeamMember(t.ID, m)
+		}
+	}
+	if active && t.FreeSeats() == 0 {
+		return Account{}, errNoSeats
+	}
+	if len(t.Orders) == 0 {
+		return Account{}, fmt.Errorf("team %s has no order", teamID)
+	}
+	order, ok := enrollments.Get(t.Orders[0])
+	if !ok {
+		return Account{}, fmt.Errorf("order %s of team %s not found", t.Orders[0], teamID)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Account{}, fmt.Errorf("error generating enrollment ID: %v", err)
+	}
+	enrollment := Enrollment{
+		ID:            "seat_" + hex.EncodeToString(id),
+		CustomerName:  name,
+		CustomerEmail: email,
+		CourseName:    os.Getenv("COURSE_NAME"),
+		Currency:      order.Currency,
+		Locale:        order.Locale,
+		Country:       order.Country,
+		Company:       t.Name,
+		Source:        source,
+		TeamID:        t.ID,
+		EnrolledAt:    time.Now(),
+	}
+	if !active {
+		enrollment.RevokedAt = enrollment.EnrolledAt
+	}
+	if a, ok := accounts.FindByEmail(email); ok {
+		enrollment.Locale = a.Locale
+	}
+	a, err := accounts.Ensure(enrollment)
+	if err != nil {
+		return Account{}, err
+	}
+
+	// Take the seat first, so two sign-ins racing for the last seat cannot both get it
+	if _, err := teams.Update(t.ID, func(t *Team) error {
+		if _, ok := t.Member(a.ID); ok {
+			return errAlreadyMember
+		}
+		if active && t.FreeSeats() == 0 {
+			return errNoSeats
+		}
+		t.Members = append(t.Members, TeamMember{
+			AccountID:    a.ID,
+			EnrollmentID: enrollment.ID,
+			Source:       source,
+			AddedAt:      enrollment.EnrolledAt,
+			RemovedAt:    enrollment.RevokedAt,
+			UpdatedAt:    enrollment.EnrolledAt,
+			Pending:      !active,
+		})
+		return nil
+	}); err != nil {
+		return Account{}, err
+	}
+	if _, err := enrollments.Add(enrollment); err != nil {
+		return Account{}, fmt.Errorf("error saving enrollment: %v", err)
+	}
+	if !active {
+		log.Printf("Added %s to team %s without a seat (%s)", email, t.Name, source)
+		return a, nil
+	}
+	log.Printf("Added %s to team %s (%s)", email, t.Name, source)
+
+	if _, err := onboardStudent(enrollment); err != nil {
+		log.Printf("Error onboarding %s: %v", email, err)
+	}
+	return a, nil
+}
+
+// restoreTeamMember gives an inactive member their seat and enrollment back.
+// A member added inactive gets their welcome now.
+func restoreTeamMember(teamID string, m TeamMember) error {
+	welcome := false
+	if _, err := teams.Update(teamID, func(t *Team) error {
+		i := slices.IndexFunc(t.Members, func(x TeamMember) bool { return x.AccountID == m.AccountID })
+		if i < 0 {
+			return errNotMember
+		}
+		if t.Members[i].Active() {
+			return nil
+		}
+		if t.FreeSeats() == 0 {
+			return errNoSeats
+		}
+		welcome = t.Members[i].Pending
+		t.Members[i].RemovedAt, t.Members[i].UpdatedAt, t.Members[i].Pending = time.Time{}, time.Now(), false
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := enrollments.Update(m.EnrollmentID, func(e *Enrollment) { e.RevokedAt = time.Time{} }); err != nil {
+		return err
+	}
+	if welcome {
+		enrollment, ok := enrollments.Get(m.EnrollmentID)
+		if !ok {
+			return fmt.Errorf("enrollment %s not found", m.EnrollmentID)
+		}
+		if _, err := onboardStudent(enrollment); err != nil {
+			log.Printf("Error onboarding %s: %v", enrollment.CustomerEmail, err)
+		}
+	}
+	return nil
+}
+
+// removeTeamMember frees the seat an account holds and ends the course access
+// it gave. With forget set the member leaves the team and its groups;
+// otherwise they stay on it as inactive, so the seat can be given back.
+func removeTeamMember(teamID, accountID string, forget bool) error {
+	now := time.Now()
+	var removed TeamMember
+	if _, err := teams.Update(teamID, func(t *Team) error {
+		i := slices.IndexFunc(t.Members, func(m TeamMember) bool { return m.AccountID == accountID })
+		if i < 0 {
+			return errNotMember
+		}
+		removed = t.Members[i]
+		if forget {
+			t.Members = slices.Delete(t.Members, i, i+1)
+			for g := range t.Groups {
+				t.Groups[g].MemberIDs = slices.DeleteFunc(t.Groups[g].MemberIDs, func(id string) bool { return id == accountID })
+			}
+		} else if removed.Active() {
+			t.Members[i].RemovedAt, t.Members[i].UpdatedAt = now, now
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !removed.Active() {
+		return nil
+	}
+	if err := enrollments.Update(removed.EnrollmentID, func(e *Enrollment) { e.RevokedAt = now }); err != nil {
+		return err
+	}
+	log.Printf("Freed the seat of account %s in team %s", accountID, teamID)
+	return nil
+}
+
+// addTeamSeats adds the seats of a later order to its team. An order is only
+// counted once, however often its success page is loaded.
+func addTeamSeats(teamID, orderID string, seats int) error {
+	added := false
+	t, err := teams.Update(teamID, func(t *Team) error {
+		if slices.Contains(t.Orders, orderID) {
+			return nil
+		}
+		t.Seats += seats
+		t.Orders = append(t.Orders, orderID)
+		added = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error adding seats to team %s: %v", teamID, err)
+	}
+	if added {
+		log.Printf("Team %s bought %d more seats with order %s", t.Name, seats, orderID)
+	}
+	return nil
+}
+
+// reassignTeamSeat gives the seat of an active member to another address. The
+// member stays on the team as inactive, and gets the seat back if the new
+// address cannot take it.
+func reassignTeamSeat(teamID, fromAccountID, email, name string) (Account, error) {
+	t, ok := teams.Get(teamID)
+	if !ok {
+		return Account{}, fmt.Errorf("team %s not found", teamID)
+	}
+	from, ok := t.Member(fromAccountID)
+	if !ok || !from.Active() {
+		return Account{}, errNotMember
+	}
+	if !t.AllowsEmail(email) {
+		return Account{}, errEmailDomain
+	}
+	if a, ok := accounts.FindByEmail(email); ok {
+		if m, ok := t.Member(a.ID); ok && m.Active() {
+			return a, errAlreadyMember
+		}
+	}
+
+	if err := removeTeamMember(teamID, fromAccountID, false); err != nil {
+		return Account{}, err
+	}
+	a, err := addTeamMember(teamID, email, name, seatSourceInvite)
+	if err != nil {
+		if restoreErr := restoreTeamMember(teamID, from); restoreErr != nil {
+			log.Printf("Error giving account %s its seat in team %s back: %v", fromAccountID, t.Name, restoreErr)
+		}
+		return Account{}, err
+	}
+	return a, nil
+}
+
+// resendTeamInvitation sends an active member their welcome email again with
+// a new sign-in link
+func resendTeamInvitation(teamID, accountID string) (Account, error) {
+	t, ok := teams.Get(teamID)
+	if !ok {
+		return Account{}, fmt.Errorf("team %s not found", teamID)
+	}
+	m, ok := t.Member(accountID)
+	if !ok || !m.Active() {
+		return Account{}, errNotMember
+	}
+	a, ok := accounts.Get(accountID)
+	if !ok {
+		return Account{}, fmt.Errorf("account %s not found", accountID)
+	}
+	e, ok := enrollments.Get(m.EnrollmentID)
+	if !ok {
+		return Account{}, fmt.Errorf("enrollment %s not found", m.EnrollmentID)
+	}
+	return a, sendWelcomeEmail(e, a)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// teamNotices are shown after a team admin's change went through
+var teamNotices = map[string]string{
+	"invited":    "The invitation is on its way.",
+	"removed":    "The seat is free again. The member can no longer open the course.",
+	"reassigned": "The seat has a new holder, who is getting an invitation.",
+	"resent":     "The invitation was sent again with a new sign-in link.",
+	"bought":     "Thank you! Your new seats are ready to give out.",
+}
+
+var teamTmpl = studentTemplate(`{{define "content"}}
+	<h1 class="text-3xl font-bold mb-2">{{.Team.Name}}</h1>
+	<p class="text-blue-200/90 mb-6">{{.Team.Taken}} of {{.Team.Seats}} seats taken{{with .Team.FreeSeats}}, {{.}} free{{end}}.{{if .Team.Domains}} Members' addresses must be at {{range $i, $d := .Team.Domains}}{{if $i}}, {{end}}{{$d}}{{end}}.{{end}}</p>
+	{{if .Notice}}<p class="text-green-400 mb-4">{{.Notice}}</p>{{end}}
+	{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+
+	<div class="grid md:grid-cols-2 gap-4 mb-8">
+		<form method="post" action="/team/invite" class="rounded-lg border border-blue-200/20 p-4 flex flex-col gap-2">
+			<h2 class="font-semibold">Invite someone</h2>
+			<input type="hidden" name="team" value="{{.Team.ID}}">
+			<input type="email" name="email" value="{{.Email}}" required placeholder="Email address" class="bg-gray-900 rounded px-3 py-2">
+			<input type="text" name="name" value="{{.Name}}" placeholder="Name (optional)" class="bg-gray-900 rounded px-3 py-2">
+			<button class="px-4 py-2 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold" {{if not .Team.FreeSeats}}disabled title="All seats are taken"{{end}}>Send invitation</button>
+		</form>
+		<form method="post" action="/team/buy" class="rounded-lg border border-blue-200/20 p-4 flex flex-col gap-2">
+			<h2 class="font-semibold">Buy more seats</h2>
+			<input type="hidden" name="team" value="{{.Team.ID}}">
+			<input type="number" name="seats" value="1" min="1" max="{{.MaxSeats}}" required class="bg-gray-900 rounded px-3 py-2">
+			<button class="px-4 py-2 rounded border border-blue-200/40 hover:border-blue-400 font-semibold">Continue to payment</button>
+		</form>
+	</div>
+
+	<div class="flex items-center mb-2">
+		<h2 class="text-xl font-semibold flex-1">Members</h2>
+		<a href="/team/export?team={{.Team.ID}}" class="text-sm text-blue-400 hover:text-blue-300">Export CSV</a>
+	</div>
+	<table class="w-full text-left text-sm">
+		<thead class="text-blue-200 border-b border-blue-200/20">
+			<tr><th class="py-2">Member</th><th>Added</th><th>Last sign-in</th><th>Progress</th><th></th></tr>
+		</thead>
+		<tbody>
+		{{range .Members}}
+			<tr class="border-b border-blue-200/10 align-top">
+				<td class="py-2">{{.Email}}{{if .Name}}<br><span class="text-blue-200/70">{{.Name}}</span>{{end}}{{if not .Active}}<br><span class="text-red-400">seat freed {{date .RemovedAt}}</span>{{end}}</td>
+				<td>{{date .AddedAt}}</td>
+				<td>{{if .LastLoginAt.IsZero}}Never{{else}}{{date .LastLoginAt}}{{end}}</td>
+				<td>{{.ModulesCompleted}} module{{if ne .ModulesCompleted 1}}s{{end}}</td>
+				<td class="flex flex-col items-start gap-1">
+					{{if .Active}}
+					<form method="post" action="/team/resend">
+						<input type="hidden" name="team" value="{{$.Team.ID}}">
+						<input type="hidden" name="id" value="{{.AccountID}}">
+						<button class="text-blue-400 hover:text-blue-300">Resend invitation</button>
+					</form>
+					<details>
+						<summary class="cursor-pointer text-blue-400 hover:text-blue-300">Reassign</summary>
+						<form method="post" action="/team/reassign" class="flex flex-col gap-1 mt-1">
+							<input type="hidden" name="team" value="{{$.Team.ID}}">
+							<input type="hidden" name="id" value="{{.AccountID}}">
+							<input type="email" name="email" required placeholder="New holder's email" class="bg-gray-900 rounded px-2 py-1">
+							<input type="text" name="name" placeholder="Name (optional)" class="bg-gray-900 rounded px-2 py-1">
+							<button class="text-left text-blue-400 hover:text-blue-300">Give them this seat</button>
+						</form>
+					</details>
+					<form method="post" action="/team/remove" onsubmit="return confirm('Take the seat back from {{.Email}}? They will lose access to the course.')">
+						<input type="hidden" name="team" value="{{$.Team.ID}}">
+						<input type="hidden" name="id" value="{{.AccountID}}">
+						<button class="text-red-400 hover:text-red-300">Remove</button>
+					</form>
+					{{end}}
+				</td>
+			</tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}`)
+
+// teamPage is the data of the team admin page
+type teamPage struct {
+	Account  Account
+	Team     Team
+	Members  []teamMemberRow
+	MaxSeats int
+	Notice   string
+	Error    string
+	Email    string // kept in the invite form after an error
+	Name     string
+}
+
+// renderTeamPage shows a team's seats. The team was checked by requirePermission.
+func renderTeamPage(w http.ResponseWriter, r *http.Request, page teamPage) {
+	page.Account, _ = currentAccount(r)
+	t, ok := teams.Get(r.FormValue("team"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	page.Team, page.Members, page.MaxSeats = t, teamMemberRows(t), maxSeatsPerOrder
+	if err := teamTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering team page: %v", err)
+	}
+}
+
+// teamDone sends the team admin back to the team page with a notice
+func teamDone(w http.ResponseWriter, r *http.Request, notice string) {
+	http.Redirect(w, r, "/team?"+url.Values{"team": {r.FormValue("team")}, "done": {notice}}.Encode(), http.StatusSeeOther)
+}
+
+// teamError explains why a seat change did not go through
+func teamError(err error) string {
+	switch {
+	case errors.Is(err, errNoSeats):
+		return "All of your team's seats are taken. Buy more seats or remove a member first."
+	case errors.Is(err, errEmailDomain):
+		return "That address is not at one of your team's email domains."
+	case errors.Is(err, errAlreadyMember):
+		return "That address already holds one of your team's seats."
+	case errors.Is(err, errNotMember):
+		return "That person no longer holds one of your team's seats."
+	}
+	return "Something went wrong. Please try again in a moment."
+}
+
+// TeamHandler shows a team admin their team's seats and members' progress
+func TeamHandler(w http.ResponseWriter, r *http.Request) {
+	renderTeamPage(w, r, teamPage{Notice: teamNotices[r.FormValue("done")]})
+}
+
+// TeamInviteHandler gives a free seat to an address and sends it the welcome email
+func TeamInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := currentAccount(r)
+	name := strings.TrimSpace(r.FormValue("name"))
+	email, _, err := validateEmail(r.FormValue("email"))
+	if err != nil {
+		renderTeamPage(w, r, teamPage{Error: "Please enter a valid email address.", Email: r.FormValue("email"), Name: name})
+		return
+	}
+	if _, err := addTeamMember(r.FormValue("team"), email, name, seatSourceInvite); err != nil {
+		log.Printf("Error inviting %s to team %s: %v", email, r.FormValue("team"), err)
+		renderTeamPage(w, r, teamPage{Error: teamError(err), Email: email, Name: name})
+		return
+	}
+	log.Printf("Team admin %s invited %s", admin.Email, email)
+	teamDone(w, r, "invited")
+}
+
+// TeamRemoveHandler takes a member's seat back
+func TeamRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := currentAccount(r)
+	if err := removeTeamMember(r.FormValue("team"), r.FormValue("id"), false); err != nil {
+		log.Printf("Error removing account %s from team %s: %v", r.FormValue("id"), r.FormValue("team"), err)
+		renderTeamPage(w, r, teamPage{Error: teamError(err)})
+		return
+	}
+	log.Printf("Team admin %s removed account %s", admin.Email, r.FormValue("id"))
+	teamDone(w, r, "removed")
+}
+
+// TeamReassignHandler moves a member's seat to another address
+func TeamReassignHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := currentAccount(r)
+	email, _, err := validateEmail(r.FormValue("email"))
+	if err != nil {
+		renderTeamPage(w, r, teamPage{Error: "Please enter a valid email address for the new seat holder."})
+		return
+	}
+	if _, err := reassignTeamSeat(r.FormValue("team"), r.FormValue("id"), email, strings.TrimSpace(r.FormValue("name"))); err != nil {
+		log.Printf("Error reassigning the seat of account %s in team %s: %v", r.FormValue("id"), r.FormValue("team"), err)
+		renderTeamPage(w, r, teamPage{Error: teamError(err)})
+		return
+	}
+	log.Printf("Team admin %s reassigned the seat of account %s to %s", admin.Email, r.FormValue("id"), email)
+	teamDone(w, r, "reassigned")
+}
+
+// TeamResendHandler sends a member their invitation again
+func TeamResendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := resendTeamInvitation(r.FormValue("team"), r.FormValue("id")); err != nil {
+		log.Printf("Error resending the invitation of account %s: %v", r.FormValue("id"), err)
+		renderTeamPage(w, r, teamPage{Error: teamError(err)})
+		return
+	}
+	teamDone(w, r, "resent")
+}
+
+// TeamBuySeatsHandler sends a team admin to Checkout for more seats
+func TeamBuySeatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	seats, err := strconv.Atoi(r.FormValue("seats"))
+	if err != nil || seats < 1 || seats > maxSeatsPerOrder {
+		renderTeamPage(w, r, teamPage{Error: fmt.Sprintf("Please choose between 1 and %d seats.", maxSeatsPerOrder)})
+		return
+	}
+	t, ok := teams.Get(r.FormValue("team"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	admin, _ := currentAccount(r)
+	target, err := teamSeatsCheckout(t, admin, seats)
+	if err != nil {
+		log.Printf("Error starting seat checkout for team %s: %v", t.Name, err)
+		http.Error(w, "Error setting up payment", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// unsafeFileName matches what is left out of download file names
+var unsafeFileName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// TeamExportHandler downloads the team's members and their progress as CSV
+func TeamExportHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := teams.Get(r.FormValue("team"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.Trim(unsafeFileName.ReplaceAllString(strings.ToLower(t.Name), "-"), "-")
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-members-%s.csv"`, cmp.Or(name, "team"), time.Now().Format("2006-01-02")))
+
+	timestamp := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	out := csv.NewWriter(w)
+	out.Write([]string{"email", "name", "status", "seat_from", "added_at", "removed_at", "last_sign_in_at", "modules_completed"})
+	for _, m := range teamMemberRows(t) {
+		status := "active"
+		if !m.Active() {
+			status = "removed"
+		}
+		out.Write([]string{
+			csvSafe(m.Email), csvSafe(m.Name), status, m.Source,
+			timestamp(m.AddedAt), timestamp(m.RemovedAt), timestamp(m.LastLoginAt),
+			strconv.Itoa(m.ModulesCompleted),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error exporting team %s: %v", t.Name, err)
+	}
+}
+
+// csvSafe keeps spreadsheets from running a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
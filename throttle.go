@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleDefaults are the limits used when a transport's THROTTLE settings are not set
+var throttleDefaults = map[string]ThrottleConfig{
+	"smtp": {Rate: 5, DomainRate: 1, DomainConcurrency: 2, Backoff: 30 * time.Second, MaxBackoff: 15 * time.Minute},
+	"api":  {Rate: 20, DomainRate: 5, DomainConcurrency: 5, Backoff: 10 * time.Second, MaxBackoff: 5 * time.Minute},
+}
+
+// throttlePrefixes are the environment prefixes of each transport's throttle settings
+var throttlePrefixes = map[string]string{
+	"smtp": "SMTP_THROTTLE_",
+	"api":  "EMAIL_API_THROTTLE_",
+}
+
+// loadThrottleConfig reads the throttle settings for a transport. Local
+// transports are not throttled.
+func loadThrottleConfig(transport string) (ThrottleConfig, error) {
+	if transport == "" {
+		transport = "smtp"
+	}
+	config := throttleDefaults[transport]
+	prefix, ok := throttlePrefixes[transport]
+	if !ok {
+		return config, nil
+	}
+
+	var err error
+	if config.Rate, err = envFloat(prefix+"RATE", config.Rate); err != nil {
+		return config, err
+	}
+	if config.DomainRate, err = envFloat(prefix+"DOMAIN_RATE", config.DomainRate); err != nil {
+		return config, err
+	}
+	if config.DomainConcurrency, err = envInt(prefix+"DOMAIN_CONCURRENCY", config.DomainConcurrency); err != nil {
+		return config, err
+	}
+	if config.Backoff, err = envDuration(prefix+"BACKOFF", config.Backoff); err != nil {
+		return config, err
+	}
+	if config.MaxBackoff, err = envDuration(prefix+"MAX_BACKOFF", config.MaxBackoff); err != nil {
+		return config, err
+	}
+
+	// Per-domain overrides such as "gmail.com=2/3": 2 messages a second, 3 at a time
+	for _, entry := range splitList(os.Getenv(prefix + "DOMAINS")) {
+		domain, limit, ok := strings.Cut(entry, "=")
+		rate, concurrency, ok2 := strings.Cut(limit, "/")
+		r, err1 := strconv.ParseFloat(rate, 64)
+		c, err2 := strconv.Atoi(concurrency)
+		if !ok || !ok2 || err1 != nil || err2 != nil {
+			return config, fmt.Errorf("invalid %sDOMAINS entry %q", prefix, entry)
+		}
+		if config.Domains == nil {
+			config.Domains = make(map[string]DomainLimit)
+		}
+		config.Domains[strings.ToLower(strings.TrimSpace(domain))] = DomainLimit{Rate: r, Concurrency: c}
+	}
+	return config, nil
+}
+
+// envFloat reads a number such as "0.5" from the environment
+func envFloat(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return f, nil
+}
+
+// envInt reads a whole number from the environment
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// Throttle paces outgoing email with a global rate and per-recipient-domain
+// rate and concurrency limits. A mail server that answers as busy pauses bulk
+// sends to its domain, and a provider API that does pauses every bulk send,
+// with exponential backoff until mail is accepted again. Transactional email
+// never waits out a pause.
+type Throttle struct {
+	config ThrottleConfig
+
+	mu          sync.Mutex
+	freed       *sync.Cond // signalled when a send finishes
+	next        time.Time  // earliest start of the next send across all domains
+	pausedUntil time.Time  // bulk sends to every domain wait until then
+	backoff     time.Duration
+	domains     map[string]*domainThrottle
+}
+
+// What a temporary failure pauses
+const (
+	pauseNone   = iota
+	pauseDomain // the receiving mail server is busy
+	pauseAll    // the provider API is limiting our whole account
+)
+
+// domainThrottle is the state and counters of one recipient domain
+type domainThrottle struct {
+	next        time.Time
+	active      int
+	pausedUntil time.Time
+	backoff     time.Duration
+
+	sent         int64
+	failed       int64
+	tempFailures int64
+	waited       time.Duration
+}
+
+// ThrottleStats is a snapshot of one domain's throttle state for metrics
+type ThrottleStats struct {
+	Domain       string
+	InFlight     int
+	Paused       bool
+	Backoff      time.Duration
+	Sent         int64
+	Failed       int64
+	TempFailures int64
+	Waited       time.Duration
+}
+
+// NewThrottle creates a throttle with the given limits
+func NewThrottle(config ThrottleConfig) *Throttle {
+	t := &Throttle{config: config, domains: make(map[string]*domainThrottle)}
+	t.freed = sync.NewCond(&t.mu)
+	return t
+}
+
+// limits returns the rate and concurrency that apply to a domain
+func (t *Throttle) limits(domain string) (float64, int) {
+	if limit, ok := t.config.Domains[domain]; ok {
+		return limit.Rate, limit.Concurrency
+	}
+	return t.config.DomainRate, t.config.DomainConcurrency
+}
+
+// Acquire blocks until a message to the domain may be sent. Only bulk sends
+// wait for paused domains. The returned function must be called with the
+// outcome once the send has finished.
+func (t *Throttle) Acquire(domain string, bulk bool) func(err error) {
+	domain = strings.ToLower(domain)
+	rate, concurrency := t.limits(domain)
+	started := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.domains[domain]
+	if !ok {
+		d = &domainThrottle{}
+		t.domains[domain] = d
+	}
+
+	for {
+		if concurrency > 0 && d.active >= concurrency {
+			t.freed.Wait()
+			continue
+		}
+		now := time.Now()
+		next := latest(t.next, d.next)
+		if bulk {
+			next = latest(next, d.pausedUntil, t.pausedUntil)
+		}
+		wait := next.Sub(now)
+		if wait > 0 {
+			t.mu.Unlock()
+			time.Sleep(wait)
+			t.mu.Lock()
+			continue
+		}
+
+		// Reserve this slot so the next sender waits its turn
+		t.next = now.Add(interval(t.config.Rate))
+		d.next = now.Add(interval(rate))
+		d.active++
+		d.waited += now.Sub(started)
+		break
+	}
+
+	return func(err error) {
+		t.release(d, err)
+	}
+}
+
+// release records the outcome of a send and wakes up waiting senders
+func (t *Throttle) release(d *domainThrottle, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d.active--
+	if err == nil {
+		// Mail is accepted again, so the pauses are over
+		d.sent++
+		d.backoff, d.pausedUntil = 0, time.Time{}
+		t.backoff, t.pausedUntil = 0, time.Time{}
+		t.freed.Broadcast()
+		return
+	}
+	switch failurePause(err) {
+	case pauseDomain:
+		d.tempFailures++
+		d.backoff = min(max(d.backoff*2, t.config.Backoff), t.config.MaxBackoff)
+		d.pausedUntil = time.Now().Add(d.backoff)
+	case pauseAll:
+		d.tempFailures++
+		t.backoff = min(max(t.backoff*2, t.config.Backoff), t.config.MaxBackoff)
+		t.pausedUntil = time.Now().Add(t.backoff)
+	default:
+		d.failed++
+	}
+	t.freed.Broadcast()
+}
+
+// Stats returns the state of every domain the throttle has seen
+func (t *Throttle) Stats() []ThrottleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]ThrottleStats, 0, len(t.domains))
+	for domain, d := range t.domains {
+		stats = append(stats, ThrottleStats{
+			Domain:       domain,
+			InFlight:     d.active,
+			Paused:       d.pausedUntil.After(now) || t.pausedUntil.After(now),
+			Backoff:      max(d.backoff, t.backoff),
+			Sent:         d.sent,
+			Failed:       d.failed,
+			TempFailures: d.tempFailures,
+			Waited:       d.waited,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Domain < stats[j].Domain })
+	return stats
+}
+
+// failurePause tells what a failed send pauses. Only replies about the whole
+// server count: a single recipient greylisted with 450, or a full mailbox,
+// says nothing about the rest of its domain.
+func failurePause(err error) int {
+	var protoErr *textproto.Error
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &protoErr) && (protoErr.Code == 421 || protoErr.Code == 451):
+		return pauseDomain
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == 429 || apiErr.StatusCode == 503):
+		return pauseAll
+	}
+	return pauseNone
+}
+
+// interval returns the time between sends at the given rate; zero is unlimited
+func interval(rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// latest returns the latest of the given times
+func latest(times ...time.Time) time.Time {
+	var t time.Time
+	for _, candidate := range times {
+		if candidate.After(t) {
+			t = candidate
+		}
+	}
+	return t
+}
+
+// recipientDomain returns the domain part of an address
+func recipientDomain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.ToLower(address[at+1:])
+	}
+	return ""
+}
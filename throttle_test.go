@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+// acquired reports whether Acquire returns within the wait, releasing the
+// slot with err if it does
+func acquired(t *Throttle, domain string, bulk bool, wait time.Duration, err error) bool {
+	done := make(chan func(error), 1)
+	go func() { done <- t.Acquire(domain, bulk) }()
+	select {
+	case release := <-done:
+		release(err)
+		return true
+	case <-time.After(wait):
+		// Let the blocked sender finish in the background
+		go func() { (<-done)(nil) }()
+		return false
+	}
+}
+
+func TestFailurePause(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&textproto.Error{Code: 421, Msg: "4.7.0 Try again later, closing connection"}, pauseDomain},
+		{&textproto.Error{Code: 451, Msg: "4.3.0 Temporary server error"}, pauseDomain},
+		{&textproto.Error{Code: 450, Msg: "4.2.1 Greylisted, try again in 5 minutes"}, pauseNone},
+		{&textproto.Error{Code: 452, Msg: "4.2.2 Mailbox full"}, pauseNone},
+		{&textproto.Error{Code: 550, Msg: "5.1.1 No such user"}, pauseNone},
+		{&apiError{StatusCode: 429}, pauseAll},
+		{&apiError{StatusCode: 503}, pauseAll},
+		{&apiError{StatusCode: 400}, pauseNone},
+		{&apiError{StatusCode: 500}, pauseNone},
+		{errors.New("connection refused"), pauseNone},
+	}
+	for _, tt := range tests {
+		if got := failurePause(tt.err); got != tt.want {
+			t.Errorf("failurePause(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestThrottlePausesBulkSendsOnly(t *testing.T) {
+	throttle := NewThrottle(ThrottleConfig{Backoff: time.Hour, MaxBackoff: time.Hour})
+	const wait = 100 * time.Millisecond
+
+	// A greylisted recipient does not pause the domain
+	if !acquired(throttle, "gmail.com", true, wait, &textproto.Error{Code: 450, Msg: "Greylisted"}) {
+		t.Fatal("first send blocked")
+	}
+	if !acquired(throttle, "gmail.com", true, wait, &textproto.Error{Code: 421, Msg: "Busy"}) {
+		t.Fatal("send after a greylisted recipient blocked")
+	}
+
+	// A busy server pauses bulk email to its domain, but not other domains
+	if acquired(throttle, "GMAIL.com", true, wait, nil) {
+		t.Error("bulk send to a paused domain went ahead")
+	}
+	if !acquired(throttle, "outlook.com", true, wait, nil) {
+		t.Error("bulk send to another domain waited")
+	}
+	stats := throttle.Stats()
+	if len(stats) != 2 || stats[0].Domain != "gmail.com" || !stats[0].Paused || stats[0].Backoff != time.Hour ||
+		stats[0].TempFailures != 1 || stats[0].Failed != 1 {
+		t.Errorf("stats = %+v, want gmail.com paused after one temporary failure", stats)
+	}
+
+	// Sign-in links still go out, and once one is accepted bulk sends resume
+	if !acquired(throttle, "gmail.com", false, wait, nil) {
+		t.Error("transactional send waited for the paused domain")
+	}
+	if !acquired(throttle, "gmail.com", true, wait, nil) {
+		t.Error("bulk send still paused after the domain accepted mail")
+	}
+}
+
+func TestThrottleProviderLimitPausesAllBulkSends(t *testing.T) {
+	throttle := NewThrottle(ThrottleConfig{Backoff: time.Hour, MaxBackoff: time.Hour})
+	const wait = 100 * time.Millisecond
+
+	if !acquired(throttle, "gmail.com", true, wait, &apiError{StatusCode: 429}) {
+		t.Fatal("first send blocked")
+	}
+	if acquired(throttle, "outlook.com", true, wait, nil) {
+		t.Error("bulk send went ahead while the provider limits us")
+	}
+	if !acquired(throttle, "outlook.com", false, wait, nil) {
+		t.Error("transactional send waited for the provider limit")
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle := NewThrottle(ThrottleConfig{Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond})
+	busy := &textproto.Error{Code: 421, Msg: "Busy"}
+	for _, want := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond} {
+		if !acquired(throttle, "gmail.com", true, time.Second, busy) {
+			t.Fatal("send blocked past the backoff")
+		}
+		if got := throttle.Stats()[0].Backoff; got != want {
+			t.Errorf("backoff = %v, want %v", got, want)
+		}
+	}
+	if !acquired(throttle, "gmail.com", true, time.Second, nil) {
+		t.Fatal("send blocked past the backoff")
+	}
+	if got := throttle.Stats()[0].Backoff; got != 0 {
+		t.Errorf("backoff = %v after a successful send, want 0", got)
+	}
+}
+
+func TestThrottleLimits(t *testing.T) {
+	throttle := NewThrottle(ThrottleConfig{DomainConcurrency: 1, Domains: map[string]DomainLimit{"gmail.com": {Concurrency: 2}}})
+	const wait = 100 * time.Millisecond
+
+	release := throttle.Acquire("outlook.com", true)
+	if acquired(throttle, "outlook.com", false, wait, nil) {
+		t.Error("second send exceeded the domain concurrency")
+	}
+	release(nil)
+
+	first := throttle.Acquire("gmail.com", true)
+	if !acquired(throttle, "gmail.com", true, wait, nil) {
+		t.Error("per-domain override not applied")
+	}
+	first(nil)
+
+	paced := NewThrottle(ThrottleConfig{Rate: 20})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		paced.Acquire("gmail.com", false)(nil)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 sends at 20 a second took %v", elapsed)
+	}
+}
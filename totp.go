@@ -0,0 +1,455 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Two-factor authentication settings. Codes follow RFC 6238 with the
+// parameters every authenticator app supports: SHA-1, 6 digits, 30 seconds.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted either side of now, for clock drift
+
+	recoveryCodeCount = 10
+
+	totpSetupPurpose        = "totp-setup"
+	twoFactorPendingCookie  = "apex_2fa_pending"
+	twoFactorPendingPurpose = "2fa-pending"
+	twoFactorPendingTimeout = 10 * time.Minute
+	rememberDeviceCookie    = "apex_2fa_device"
+	rememberDevicePurpose   = "2fa-device"
+	rememberDeviceLifetime  = 30 * 24 * time.Hour
+)
+
+// totpEncoding is the unpadded base32 authenticator apps expect secrets in
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, base32 encoded
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating two-factor secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCode returns the code of a secret for a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid two-factor secret: %v", err)
+	}
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP checks a code against the steps around now and returns the
+// step it matched. Steps up to and including after are refused, so a code
+// cannot be used twice.
+func verifyTOTP(secret, code string, after int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// provisioning URI authenticator apps scan
+func totpURI(secret, account string) string {
+	issuer := os.Getenv("COMPANY_NAME")
+	if issuer == "" {
+		issuer = "APEX AI"
+	}
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + query.Encode()
+}
+
+// qrDataURL renders text as a QR code PNG data URL. It is drawn here rather
+// than by an online QR service so the secret never leaves the server.
+func qrDataURL(text string) (template.URL, error) {
+	png, err := qrcode.Encode(text, qrcode.Medium, 240)
+	if err != nil {
+		return "", fmt.Errorf("error drawing QR code: %v", err)
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+// newRecoveryCodes returns one-time recovery codes and the hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	alphabet := "abcdefghjkmnpqrstuvwxyz23456789"
+	for range recoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery codes: %v", err)
+		}
+		code := make([]byte, 0, 11)
+		for i, c := range b {
+			if i == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, alphabet[int(c)%len(alphabet)])
+		}
+		codes = append(codes, string(code))
+		hashes = append(hashes, hashRecoveryCode(string(code)))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the stored form of a recovery code, ignoring case,
+// spaces and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useSecondFactor checks an authenticator or recovery code for the account.
+// A recovery code is used up; failures count towards the sign-in lockout.
+func useSecondFactor(id, code string) (ok, recovery bool, err error) {
+	_, err = accounts.Update(id, func(a *Account) {
+		if time.Now().Before(a.LockedUntil) || a.TOTPSecret == "" {
+			return
+		}
+		if step, matched := verifyTOTP(a.TOTPSecret, code, a.TOTPLastStep); matched {
+			a.TOTPLastStep, ok = step, true
+		} else if i := slices.Index(a.RecoveryCodes, hashRecoveryCode(code)); i >= 0 && strings.TrimSpace(code) != "" {
+			a.RecoveryCodes = slices.Delete(a.RecoveryCodes, i, i+1)
+			ok, recovery = true, true
+		}
+		if ok {
+			a.FailedLogins, a.LockedUntil = 0, time.Time{}
+			return
+		}
+		a.FailedLogins++
+		if backoff := loginBackoff(a.FailedLogins); backoff > 0 {
+			a.LockedUntil = time.Now().Add(backoff)
+		}
+	})
+	return ok, recovery, err
+}
+
+// rememberedDevice reports whether the browser skipped the second factor
+// for the account within the last rememberDeviceLifetime. Turning two-factor
+// authentication off or resetting it forgets every device.
+func rememberedDevice(r *http.Request, a Account) bool {
+	cookie, err := r.Cookie(rememberDeviceCookie)
+	if err != nil {
+		return false
+	}
+	payload, ok := verifyExpiringToken(rememberDevicePurpose, cookie.Value)
+	return ok && payload == a.ID+"\n"+strconv.FormatInt(a.TOTPEnabledAt.UnixNano(), 10)
+}
+
+// rememberDevice lets the browser skip the second factor for a while
+func rememberDevice(w http.ResponseWriter, a Account) {
+	payload := a.ID + "\n" + strconv.FormatInt(a.TOTPEnabledAt.UnixNano(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberDeviceCookie,
+		Value:    expiringToken(rememberDevicePurpose, payload, time.Now().Add(rememberDeviceLifetime)),
+		Path:     "/",
+		MaxAge:   int(rememberDeviceLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// completeSignIn signs the account in once the first factor is checked,
+// asking for the second one first when the account has it turned on
+func completeSignIn(w http.ResponseWriter, r *http.Request, a Account, method, next string) {
+	if a.TOTPSecret != "" && !rememberedDevice(r, a) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     twoFactorPendingCookie,
+			Value:    expiringToken(twoFactorPendingPurpose, a.ID+"\n"+method+"\n"+next, time.Now().Add(twoFactorPendingTimeout)),
+			Path:     "/login",
+			MaxAge:   int(twoFactorPendingTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// A remembered device checked the second factor when it was remembered
+	if err := startSession(w, r, a, method, a.TOTPSecret != ""); err != nil {
+		log.Printf("Error signing in account %s: %v", a.ID, err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, localPath(next), http.StatusSeeOther)
+}
+
+var twoFactorLoginTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-md mx-auto mt-16">
+		<h1 class="text-3xl font-bold mb-2">Two-factor authentication</h1>
+		<p class="text-blue-200/90 mb-6">Enter the 6-digit code from your authenticator app, or one of your recovery codes.</p>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		<form method="post" action="/login/2fa" class="flex flex-col gap-4">
+			<label for="code" class="text-blue-200">Code</label>
+			<input type="text" id="code" name="code" required autofocus autocomplete="one-time-code" inputmode="numeric" class="bg-gray-900 rounded px-4 py-3">
+			<label class="flex items-center gap-2 text-blue-200"><input type="checkbox" name="remember" value="1"> Don't ask again on this device for 30 days</label>
+			<button class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Verify</button>
+		</form>
+	</div>
+{{end}}`)
+
+// TwoFactorLoginHandler asks for the second factor of a sign-in in progress
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var id, method, next string
+	if cookie, err := r.Cookie(twoFactorPendingCookie); err == nil {
+		if payload, ok := verifyExpiringToken(twoFactorPendingPurpose, cookie.Value); ok {
+			parts := strings.SplitN(payload, "\n", 3)
+			if len(parts) == 3 {
+				id, method, next = parts[0], parts[1], parts[2]
+			}
+		}
+	}
+	a, ok := accounts.Get(id)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	page := loginPage{}
+	if r.Method == http.MethodPost {
+		if !signInLimits.AllowPassword(clientIP(r)) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			page.Error = "Too many attempts. Please wait a while and try again."
+		} else if wait := time.Until(a.LockedUntil); wait > 0 {
+			page.Error = fmt.Sprintf("Too many failed attempts. Try again in %s.", waitText(wait))
+		} else if ok, recovery, err := useSecondFactor(a.ID, r.FormValue("code")); err != nil {
+			log.Printf("Error checking second factor of %s: %v", a.Email, err)
+			page.Error = "Your code could not be checked. Please try again."
+		} else if !ok {
+			page.Error = "That code is not valid."
+		} else {
+			if recovery {
+				log.Printf("%s signed in with a recovery code", a.Email)
+			}
+			http.SetCookie(w, &http.Cookie{Name: twoFactorPendingCookie, Path: "/login", MaxAge: -1, HttpOnly: true, Secure: secureCookies()})
+			if r.FormValue("remember") == "1" {
+				rememberDevice(w, a)
+			}
+			if err := startSession(w, r, a, method, true); err != nil {
+				log.Printf("Error signing in account %s: %v", a.ID, err)
+				http.Error(w, "Error signing in", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, localPath(next), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := twoFactorLoginTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering two-factor page: %v", err)
+	}
+}
+
+var twoFactorTmpl = studentTemplate(`{{define "content"}}
+	<div class="max-w-xl mx-auto mt-8">
+		<h1 class="text-3xl font-bold mb-2">Two-factor authentication</h1>
+		{{if .Error}}<p class="text-red-400 mb-4">{{.Error}}</p>{{end}}
+		{{if .RecoveryCodes}}
+		<p class="text-green-400 mb-4">Save these recovery codes somewhere safe. Each one signs you in once if you lose your phone. They won't be shown again.</p>
+		<ul class="grid grid-cols-2 gap-2 font-mono text-lg mb-6">{{range .RecoveryCodes}}<li>{{.}}</li>{{end}}</ul>
+		<p><a href="/account/2fa" class="text-blue-400 hover:text-blue-300">I've saved them</a></p>
+		{{else if .Account.TOTPSecret}}
+		<p class="text-blue-200/90 mb-2">Two-factor authentication is on since {{date .Account.TOTPEnabledAt}}. Signing in asks for a code from your authenticator app.</p>
+		<p class="text-blue-200/70 mb-8">{{len .Account.RecoveryCodes}} unused recovery code{{if ne (len .Account.RecoveryCodes) 1}}s{{end}} left.</p>
+		<form method="post" action="/account/2fa" class="flex flex-col gap-4 mb-8">
+			<label for="code" class="text-blue-200">Current code from your app, or a recovery code</label>
+			<input type="text" id="code" name="code" required autocomplete="one-time-code" class="bg-gray-900 rounded px-4 py-3">
+			<div class="flex gap-4">
+				<button name="action" value="codes" class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">New recovery codes</button>
+				<button name="action" value="disable" class="px-4 py-3 rounded border border-red-400 text-red-400 hover:text-red-300">Turn off</button>
+			</div>
+		</form>
+		{{else}}
+		<p class="text-blue-200/90 mb-6">Protect your account with a code from an authenticator app such as 1Password, Google Authenticator or Authy, on top of your password or email link.</p>
+		<ol class="list-decimal pl-6 space-y-4 text-blue-200/90 mb-6">
+			<li>Scan this QR code with your authenticator app:
+				<img src="{{.QRCode}}" alt="QR code" width="240" height="240" class="mt-2 bg-white p-2 rounded">
+				<span class="block text-sm text-blue-200/70 mt-2">Can't scan it? Enter this key instead: <span class="font-mono">{{.Secret}}</span></span>
+			</li>
+			<li>Enter the 6-digit code the app shows:</li>
+		</ol>
+		<form method="post" action="/account/2fa" class="flex flex-col gap-4">
+			<input type="hidden" name="setup" value="{{.Setup}}">
+			<input type="text" id="code" name="code" required inputmode="numeric" autocomplete="one-time-code" class="bg-gray-900 rounded px-4 py-3">
+			<button name="action" value="enable" class="px-4 py-3 rounded bg-[#0066FF] hover:bg-blue-500 font-semibold">Turn on</button>
+		</form>
+		{{end}}
+	</div>
+{{end}}`)
+
+// twoFactorPage is the data of the two-factor settings page
+type twoFactorPage struct {
+	Account       Account
+	Secret        string
+	Setup         string // signed secret being set up
+	QRCode        template.URL
+	RecoveryCodes []string // shown once, right after they are generated
+	Error         string
+}
+
+// TwoFactorHandler lets a student turn two-factor authentication on and off
+// and replace their recovery codes
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	a, _ := currentAccount(r)
+	page := twoFactorPage{Account: a}
+
+	if r.Method == http.MethodPost {
+		var err error
+		switch r.FormValue("action") {
+		case "enable":
+			page, err = enableTwoFactor(a, r.FormValue("setup"), r.FormValue("code"))
+		case "codes", "disable":
+			page, err = changeTwoFactor(a, r.FormValue("action"), r.FormValue("code"))
+		}
+		if err != nil {
+			page.Error = err.Error()
+		}
+	}
+
+	if page.Account.TOTPSecret == "" && page.Setup == "" {
+		if err := newTwoFactorSetup(&page); err != nil {
+			log.Printf("Error setting up two-factor authentication for %s: %v", a.Email, err)
+			http.Error(w, "Error setting up two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := twoFactorTmpl.Execute(w, page); err != nil {
+		log.Printf("Error rendering two-factor settings: %v", err)
+	}
+}
+
+// newTwoFactorSetup fills in a fresh secret and its QR code. The secret
+// travels signed in the form until the first code confirms it.
+func newTwoFactorSetup(page *twoFactorPage) error {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return err
+	}
+	return setTwoFactorSecret(page, secret)
+}
+
+// setTwoFactorSecret shows a secret being set up on the page
+func setTwoFactorSecret(page *twoFactorPage, secret string) error {
+	qr, err := qrDataURL(totpURI(secret, page.Account.Email))
+	if err != nil {
+		return err
+	}
+	page.Secret, page.QRCode = secret, qr
+	page.Setup = expiringToken(totpSetupPurpose, page.Account.ID+"\n"+secret, time.Now().Add(15*time.Minute))
+	return nil
+}
+
+// enableTwoFactor turns two-factor authentication on once the first code
+// from the app matches the secret being set up
+func enableTwoFactor(a Account, setup, code string) (twoFactorPage, error) {
+	page := twoFactorPage{Account: a}
+	payload, ok := verifyExpiringToken(totpSetupPurpose, setup)
+	id, secret, _ := strings.Cut(payload, "\n")
+	if !ok || id != a.ID || a.TOTPSecret != "" {
+		return page, errors.New("The setup expired. Scan the new QR code.")
+	}
+	step, ok := verifyTOTP(secret, code, 0)
+	if !ok {
+		if err := setTwoFactorSecret(&page, secret); err != nil {
+			return page, err
+		}
+		return page, errors.New("That code is not valid. Check the time on your phone and try again.")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return page, err
+	}
+	if page.Account, err = accounts.Update(a.ID, func(a *Account) {
+		a.TOTPSecret, a.TOTPEnabledAt, a.TOTPLastStep, a.RecoveryCodes = secret, time.Now(), step, hashes
+	}); err != nil {
+		return page, err
+	}
+	page.RecoveryCodes = codes
+	return page, nil
+}
+
+// changeTwoFactor replaces the recovery codes or turns two-factor
+// authentication off, after checking a current code
+func changeTwoFactor(a Account, action, code string) (twoFactorPage, error) {
+	page := twoFactorPage{Account: a}
+	if wait := time.Until(a.LockedUntil); wait > 0 {
+		return page, fmt.Errorf("Too many failed attempts. Try again in %s.", waitText(wait))
+	}
+	ok, _, err := useSecondFactor(a.ID, code)
+	if err != nil {
+		return page, err
+	}
+	if !ok {
+		return page, errors.New("That code is not valid.")
+	}
+
+	if action == "disable" {
+		page.Account, err = accounts.Update(a.ID, clearTwoFactor)
+		return page, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return page, err
+	}
+	if page.Account, err = accounts.Update(a.ID, func(a *Account) { a.RecoveryCodes = hashes }); err != nil {
+		return page, err
+	}
+	page.RecoveryCodes = codes
+	return page, nil
+}
+
+// clearTwoFactor turns two-factor authentication off for an account
+func clearTwoFactor(a *Account) {
+	a.TOTPSecret, a.TOTPEnabledAt, a.TOTPLastStep, a.RecoveryCodes = "", time.Time{}, 0, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 test key "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// newTwoFactorAccount creates a student account with two-factor
+// authentication on
+func newTwoFactorAccount(t *testing.T, email string) Account {
+	t.Helper()
+	a, err := accounts.Ensure(Enrollment{CustomerEmail: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err = accounts.Update(a.ID, func(a *Account) {
+		a.TOTPSecret, a.TOTPEnabledAt, a.RecoveryCodes = rfcSecret, time.Now(), hashes
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// codeAt returns the code of the RFC secret for the step offset from now
+func codeAt(t *testing.T, offset int64) string {
+	t.Helper()
+	code, err := totpCode(rfcSecret, time.Now().Unix()/totpPeriod+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// responseCookie returns the named cookie a response sets
+func responseCookie(w *httptest.ResponseRecorder, name string) (*http.Cookie, bool) {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Now().Unix() / totpPeriod
+	tests := []struct {
+		name  string
+		code  string
+		after int64
+		ok    bool
+	}{
+		{"current code", codeAt(t, 0), 0, true},
+		{"code with spaces", codeAt(t, 0)[:3] + " " + codeAt(t, 0)[3:], 0, true},
+		{"previous code", codeAt(t, -1), 0, true},
+		{"next code", codeAt(t, 1), 0, true},
+		{"expired code", codeAt(t, -3), 0, false},
+		{"future code", codeAt(t, 3), 0, false},
+		{"code already used", codeAt(t, 0), now + 1, false},
+		{"wrong length", codeAt(t, 0)[:5], 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := verifyTOTP(rfcSecret, tt.code, tt.after); ok != tt.ok {
+				t.Errorf("verifyTOTP(%q) = %v, want %v", tt.code, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUseSecondFactor(t *testing.T) {
+	setupTestStores(t)
+	a := newTwoFactorAccount(t, "ada@example.com")
+
+	code := codeAt(t, 0)
+	if ok, recovery, err := useSecondFactor(a.ID, code); err != nil || !ok || recovery {
+		t.Fatalf("first use = %v, %v, %v; want accepted", ok, recovery, err)
+	}
+	if ok, _, _ := useSecondFactor(a.ID, code); ok {
+		t.Error("code accepted twice")
+	}
+	if ok, _, _ := useSecondFactor(a.ID, codeAt(t, -1)); ok {
+		t.Error("older code accepted after a newer one")
+	}
+
+	// A recovery code works once
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accounts.Update(a.ID, func(a *Account) { a.RecoveryCodes = hashes }); err != nil {
+		t.Fatal(err)
+	}
+	if ok, recovery, _ := useSecondFactor(a.ID, " "+strings.ToUpper(codes[3])+" "); !ok || !recovery {
+		t.Fatal("recovery code refused")
+	}
+	if ok, _, _ := useSecondFactor(a.ID, codes[3]); ok {
+		t.Error("recovery code accepted twice")
+	}
+	if a, _ := accounts.Get(a.ID); len(a.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", len(a.RecoveryCodes), recoveryCodeCount-1)
+	}
+
+	// Wrong codes lock the account like wrong passwords
+	for range freeLoginAttempts {
+		useSecondFactor(a.ID, "000000")
+	}
+	if a, _ := accounts.Get(a.ID); !time.Now().Before(a.LockedUntil) {
+		t.Fatal("account not locked after repeated wrong codes")
+	}
+	if ok, _, _ := useSecondFactor(a.ID, codeAt(t, 1)); ok {
+		t.Error("code accepted while the account is locked")
+	}
+}
+
+func TestTwoFactorSignIn(t *testing.T) {
+	setupTestStores(t)
+	a := newTwoFactorAccount(t, "ada@example.com")
+
+	// The first factor leads to the code page, not a session
+	w := httptest.NewRecorder()
+	completeSignIn(w, httptest.NewRequest(http.MethodPost, "/login", nil), a, signInMethodPassword, "/courses/ai")
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/login/2fa" {
+		t.Fatalf("response = %d to %q, want the two-factor page", w.Code, loc)
+	}
+	if _, ok := responseCookie(w, sessionCookie); ok {
+		t.Fatal("signed in before the second factor")
+	}
+	pending, ok := responseCookie(w, twoFactorPendingCookie)
+	if !ok {
+		t.Fatal("no pending sign-in cookie")
+	}
+
+	submit := func(pending *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(pending)
+		w := httptest.NewRecorder()
+		TwoFactorLoginHandler(w, r)
+		return w
+	}
+
+	// Without a valid pending cookie the code page sends the student back
+	forged := &http.Cookie{Name: twoFactorPendingCookie, Value: pending.Value + "x"}
+	if w := submit(forged, url.Values{"code": {codeAt(t, 0)}}); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("forged cookie: %d to %q, want back to sign-in", w.Code, w.Header().Get("Location"))
+	}
+
+	w = submit(pending, url.Values{"code": {"000000"}})
+	if _, ok := responseCookie(w, sessionCookie); ok || !strings.Contains(w.Body.String(), "not valid") {
+		t.Fatal("wrong code signed in")
+	}
+
+	w = submit(pending, url.Values{"code": {codeAt(t, 0)}, "remember": {"1"}})
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/courses/ai" {
+		t.Fatalf("response = %d to %q, want the page the sign-in started from", w.Code, loc)
+	}
+	session, ok := responseCookie(w, sessionCookie)
+	if !ok {
+		t.Fatal("not signed in after the second factor")
+	}
+	if s, ok := loginSessions.Lookup(session.Value); !ok || s.Method != signInMethodPassword || !s.TwoFactor {
+		t.Errorf("session = %+v, want a password sign-in with two-factor", s)
+	}
+	if c, ok := responseCookie(w, twoFactorPendingCookie); !ok || c.MaxAge >= 0 {
+		t.Error("pending sign-in cookie not cleared")
+	}
+	device, ok := responseCookie(w, rememberDeviceCookie)
+	if !ok {
+		t.Fatal("device not remembered")
+	}
+
+	// A remembered device skips the second factor
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.AddCookie(device)
+	w = httptest.NewRecorder()
+	completeSignIn(w, r, a, signInMethodLink, "/courses")
+	if c, ok := responseCookie(w, sessionCookie); !ok {
+		t.Error("remembered device asked for a code")
+	} else if s, _ := loginSessions.Lookup(c.Value); !s.TwoFactor {
+		t.Error("remembered device session not marked as two-factor")
+	}
+
+	// Setting two-factor up again forgets every device
+	a, err := accounts.Update(a.ID, func(a *Account) {
+		clearTwoFactor(a)
+		a.TOTPSecret, a.TOTPEnabledAt = rfcSecret, time.Now().Add(time.Second)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rememberedDevice(r, a) {
+		t.Error("device still remembered after two-factor was set up again")
+	}
+	if rememberedDevice(r, Account{ID: "someone-else", TOTPEnabledAt: a.TOTPEnabledAt}) {
+		t.Error("device remembered for another account")
+	}
+}
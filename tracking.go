@@ -0,0 +1,186 @@
+package main
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const trackingFile = "tracking.json"
+
+// Tracking token purposes
+const (
+	openTokenPurpose  = "track-open"
+	clickTokenPurpose = "track-click"
+)
+
+// Tracking event types
+const (
+	trackingOpen  = "open"
+	trackingClick = "click"
+)
+
+var (
+	trackedHrefPattern = regexp.MustCompile(`(?i)(<a\s[^>]*href=")(https?://[^"]*)(")`)
+	openPixelPattern   = regexp.MustCompile(`(?i)<img[^>]*/t/open\?[^>]*>`)
+)
+
+// transparentGIF is the 1×1 image served for opens
+var transparentGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// trackingEnabled reports whether open and click tracking is switched on
+func trackingEnabled() bool {
+	return os.Getenv("EMAIL_TRACKING") == "true"
+}
+
+// TrackingStore records opens and clicks per message and recipient
+type TrackingStore struct {
+	mu     sync.Mutex
+	events map[string][]TrackingEvent // keyed by Message-ID
+}
+
+// NewTrackingStore creates a tracking store backed by the data directory
+func NewTrackingStore() (*TrackingStore, error) {
+	s := &TrackingStore{events: make(map[string][]TrackingEvent)}
+	if err := loadJSON(trackingFile, &s.events); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Record stores an event
+func (s *TrackingStore) Record(event TrackingEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[event.MessageID] = append(s.events[event.MessageID], event)
+	return saveJSON(trackingFile, s.events)
+}
+
+// ForMessage returns the events of one message, oldest first
+func (s *TrackingStore) ForMessage(messageID string) []TrackingEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]TrackingEvent(nil), s.events[messageID]...)
+}
+
+// ForRecipient returns every event of a recipient across messages, oldest first
+func (s *TrackingStore) ForRecipient(email string) []TrackingEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []TrackingEvent
+	for _, events := range s.events {
+		for _, e := range events {
+			if strings.EqualFold(e.Recipient, email) {
+				list = append(list, e)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+	return list
+}
+
+// Counts returns how many opens and clicks a message has
+func (s *TrackingStore) Counts(messageID string) (opens, clicks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events[messageID] {
+		switch e.Type {
+		case trackingOpen:
+			opens++
+		case trackingClick:
+			clicks++
+		}
+	}
+	return opens, clicks
+}
+
+// addTracking rewrites the links of a rendered email to signed redirect URLs
+// and appends the open pixel. The unsubscribe link and the private links, such
+// as sign-in and password reset links, are left untouched.
+func addTracking(body string, email *Email, private ...string) string {
+	base := os.Getenv("DOMAIN_URL")
+	subject := email.MessageID + "\n" + email.To + "\n" + email.Template
+
+	body = trackedHrefPattern.ReplaceAllStringFunc(body, func(match string) string {
+		parts := trackedHrefPattern.FindStringSubmatch(match)
+		target := html.UnescapeString(parts[2])
+		// Sign-in and reset links stay direct so their tokens never reach the tracking log
+		if target == email.UnsubscribeURL || slices.Contains(private, target) ||
+			strings.Contains(target, "/unsubscribe?") || strings.Contains(target, "/login/verify?") || strings.Contains(target, "/password/reset?") {
+			return match
+		}
+		token := signToken(clickTokenPurpose, subject+"\n"+target)
+		return parts[1] + html.EscapeString(base+"/t/click?token="+url.QueryEscape(token)) + parts[3]
+	})
+
+	pixel := `<img src="` + html.EscapeString(base+"/t/open?token="+url.QueryEscape(signToken(openTokenPurpose, subject))) +
+		`" width="1" height="1" alt="" style="display:block;border:0;width:1px;height:1px">`
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + pixel + body[i:]
+	}
+	return body + pixel
+}
+
+// stripOpenPixel removes the open pixel so previews do not count as opens
+func stripOpenPixel(body string) string {
+	return openPixelPattern.ReplaceAllString(body, "")
+}
+
+// parseTrackingToken returns the event described by a tracking token
+func parseTrackingToken(purpose, token string) (TrackingEvent, bool) {
+	payload, ok := verifyToken(purpose, token)
+	if !ok {
+		return TrackingEvent{}, false
+	}
+	fields := strings.SplitN(payload, "\n", 4)
+	if len(fields) < 3 {
+		return TrackingEvent{}, false
+	}
+	event := TrackingEvent{MessageID: fields[0], Recipient: fields[1], Template: fields[2], At: time.Now()}
+	if len(fields) == 4 {
+		event.URL = fields[3]
+	}
+	return event, true
+}
+
+// TrackOpenHandler serves the open pixel and records the open
+func TrackOpenHandler(w http.ResponseWriter, r *http.Request) {
+	if event, ok := parseTrackingToken(openTokenPurpose, r.URL.Query().Get("token")); ok {
+		event.Type = trackingOpen
+		if err := tracking.Record(event); err != nil {
+			log.Printf("Error recording email open: %v", err)
+		}
+	}
+
+	// Always answer with the image so mail clients never show a broken one
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, private")
+	w.Write(transparentGIF)
+}
+
+// TrackClickHandler records a click and redirects to the original link
+func TrackClickHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := parseTrackingToken(clickTokenPurpose, r.URL.Query().Get("token"))
+	if !ok || event.URL == "" {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+
+	event.Type = trackingClick
+	if err := tracking.Record(event); err != nil {
+		log.Printf("Error recording email click: %v", err)
+	}
+	http.Redirect(w, r, event.URL, http.StatusFound)
+}
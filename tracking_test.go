@@ -0,0 +1,66 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAddTrackingLeavesPrivateLinks(t *testing.T) {
+	setupTestStores(t)
+	email := &Email{MessageID: "<m1@apex.test>", To: "ada@example.com", Template: "welcome", UnsubscribeURL: "https://apex.test/unsubscribe?token=u1"}
+	private := "https://apex.test/welcome?code=p1&next=%2Fcourses"
+	links := []struct {
+		href    string
+		tracked bool
+	}{
+		{"https://apex.test/courses", true},
+		{"https://example.com/guide?page=2", true},
+		{email.UnsubscribeURL, false},
+		{"https://apex.test/unsubscribe?token=other", false},
+		{"https://apex.test/login/verify?token=s1", false},
+		{"https://apex.test/password/reset?token=r1", false},
+		{private, false},
+	}
+	var body strings.Builder
+	body.WriteString("<html><body>")
+	for _, link := range links {
+		body.WriteString(`<a href="` + html.EscapeString(link.href) + `">link</a>`)
+	}
+	body.WriteString("</body></html>")
+
+	tracked := addTracking(body.String(), email, private, "")
+	hrefs := regexp.MustCompile(`href="([^"]*)"`).FindAllStringSubmatch(tracked, -1)
+	if len(hrefs) != len(links) {
+		t.Fatalf("%d links after tracking, want %d", len(hrefs), len(links))
+	}
+	for i, link := range links {
+		href := html.UnescapeString(hrefs[i][1])
+		if wrapped := strings.HasPrefix(href, "https://apex.test/t/click?"); wrapped != link.tracked {
+			t.Errorf("%s: tracked = %v, want %v", link.href, wrapped, link.tracked)
+		} else if !link.tracked && href != link.href {
+			t.Errorf("%s: changed to %s", link.href, href)
+		}
+	}
+	if !strings.Contains(tracked, "/t/open?token=") {
+		t.Error("open pixel missing")
+	}
+}
+
+func TestRenderEmailLeavesSignInLinkUntracked(t *testing.T) {
+	setupTestStores(t)
+	t.Setenv("EMAIL_TRACKING", "true")
+	// Any link the data marks as a sign-in link stays direct, whatever its path
+	link := "https://apex.test/welcome?code=s1"
+	email, err := emailService.renderEmail("welcome", EmailData{CustomerName: "Ada", CustomerEmail: "ada@example.com", SignInURL: link})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(email.HTMLContent, `href="`+link+`"`) {
+		t.Error("sign-in link was rewritten for click tracking")
+	}
+	if !strings.Contains(email.HTMLContent, "/t/open?token=") {
+		t.Error("email not tracked at all")
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // EmailData represents the data needed for sending emails
 type EmailData struct {
 	CustomerName  string
@@ -9,6 +11,20 @@ type EmailData struct {
 	SupportEmail  string
 	DomainURL     string
 	SenderEmail   string
+
+	UnsubscribeURL string // set for non-transactional emails
+
+	Locale     string // language the email is written in; English when empty
+	AmountPaid int64  // in the currency's smallest unit
+	Currency   string
+	EnrolledAt time.Time
+
+	Session   *LiveSession // set for live session invitations
+	Broadcast *Broadcast   // set for broadcast announcements
+	Order     *Enrollment  // set for internal sale notifications
+	SignInURL string       // set for sign-in links
+
+	PasswordResetURL string // set for password reset links
 }
 
 // EmailConfig holds SMTP configuration
@@ -18,13 +34,334 @@ type EmailConfig struct {
 	Username string
 	Password string
 	From     string
+	// ReturnPath is the envelope sender that receives bounces; defaults to From
+	ReturnPath string
+
+	Transport string // smtp, api, file or stdout
+	FileDir   string // where the file transport writes messages
+
+	// HTTP provider API used by the api transport
+	APIURL       string
+	APIToken     string
+	APIFormat    string            // postmark, sendgrid, ses or custom
+	APIFields    map[string]string // JSON path for each email field, for the custom format
+	APIMessageID string            // response JSON path, or "header:Name", holding the provider's message ID
+	APIRegion    string            // AWS region for the ses format, when the URL does not name it
+	APITimeout   time.Duration
+
+	Throttle ThrottleConfig // pacing for the selected transport
+
+	Security      string // none, starttls, require-starttls or tls
+	AuthMechanism string // none, plain, login or cram-md5
+	CAFile        string // PEM bundle used instead of the system roots
+	DialTimeout   time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	IdleTimeout   time.Duration // how long an idle connection is kept for reuse
+
+	// DKIM signing; disabled when DKIMPrivateKey is empty
+	DKIMDomain     string
+	DKIMSelector   string
+	DKIMPrivateKey string   // path to a PEM encoded RSA or Ed25519 key
+	DKIMHeaders    []string // header fields to sign
+}
+
+// ThrottleConfig limits how fast email is handed to a transport
+type ThrottleConfig struct {
+	Rate              float64                // messages per second across all domains; 0 is unlimited
+	DomainRate        float64                // messages per second to one recipient domain
+	DomainConcurrency int                    // messages in flight to one recipient domain; 0 is unlimited
+	Domains           map[string]DomainLimit // overrides for specific domains such as gmail.com
+	Backoff           time.Duration          // first pause after a temporary failure
+	MaxBackoff        time.Duration
+}
+
+// DomainLimit is the rate and concurrency allowed for one recipient domain
+type DomainLimit struct {
+	Rate        float64
+	Concurrency int
 }
 
 // Email represents an email to be sent
 type Email struct {
-	To          string
-	From        string
-	Subject     string
-	HTMLContent string
-	TextContent string
+	To             string
+	From           string
+	Subject        string
+	HTMLContent    string
+	TextContent    string
+	Template       string
+	Category       string // transactional emails ignore unsubscribe preferences
+	UnsubscribeURL string // one-click unsubscribe endpoint for non-transactional emails
+	MessageID      string // assigned on the first send and kept across retries
+
+	Calendar       string // iCalendar invite sent alongside the email, if any
+	CalendarMethod string // iTIP method of the invite: REQUEST or CANCEL
+}
+
+// EmailTemplate is a named email rendered inside the shared email layout
+type EmailTemplate struct {
+	Name     string
+	Category string
+	Subject  string // text/template source for the subject line
+	Body     string // html/template source defining the "heading" and "content" blocks
+
+	Variants map[string]string // translated Body sources keyed by locale
+
+	CalendarMethod string // attach the live session as an iCalendar REQUEST or CANCEL
+
+	NoTracking bool // never add open and click tracking, for privacy-sensitive emails
+}
+
+// Enrollment records a fulfilled course purchase
+type Enrollment struct {
+	ID               string    `json:"id"` // Stripe checkout session ID
+	CustomerID       string    `json:"customer_id"`
+	PaymentIntentID  string    `json:"payment_intent_id"`
+	CustomerName     string    `json:"customer_name"`
+	CustomerEmail    string    `json:"customer_email"`
+	CustomerPhone    string    `json:"customer_phone"`
+	CourseName       string    `json:"course_name"`
+	AmountTotal      int64     `json:"amount_total"`
+	Currency         string    `json:"currency"`
+	Locale           string    `json:"locale"` // language all emails to the customer use
+	Country          string    `json:"country"`
+	Company          string    `json:"company,omitempty"`
+	JobTitle         string    `json:"job_title,omitempty"`
+	Coupon           string    `json:"coupon,omitempty"`
+	Source           string    `json:"source,omitempty"`  // campaign the buyer arrived from
+	TeamID           string    `json:"team_id,omitempty"` // team whose seat the enrollment is
+	ModulesCompleted int       `json:"modules_completed"`
+	Refunded         bool      `json:"refunded"`
+	RevokedAt        time.Time `json:"revoked_at,omitempty"`  // when the team took its seat back
+	EmailIssue       string    `json:"email_issue,omitempty"` // why emails to the customer are failing
+	EmailIssueAt     time.Time `json:"email_issue_at,omitempty"`
+	EnrolledAt       time.Time `json:"enrolled_at"`
+}
+
+// SequenceProgress tracks where an enrollment is in a drip sequence
+type SequenceProgress struct {
+	EnrollmentID string       `json:"enrollment_id"`
+	Sequence     string       `json:"sequence"`
+	NextStep     int          `json:"next_step"`
+	Attempts     int          `json:"attempts,omitempty"` // failed attempts at the next step
+	RetryAt      time.Time    `json:"retry_at,omitempty"` // when the next step is tried again
+	Completed    bool         `json:"completed"`
+	History      []StepResult `json:"history"`
+}
+
+// StepResult records the outcome of a single drip sequence step
+type StepResult struct {
+	Step   string    `json:"step"`
+	Status string    `json:"status"` // sent, skipped, retrying or failed
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Suppression records the email categories an address opted out of
+// and whether it must not be emailed at all
+type Suppression struct {
+	Email       string    `json:"email"`
+	Categories  []string  `json:"categories,omitempty"`
+	Blocked     bool      `json:"blocked,omitempty"` // set after hard bounces
+	Reason      string    `json:"reason,omitempty"`
+	SoftBounces int       `json:"soft_bounces,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// EmailDelivery is the delivery log entry for one email sent to a customer
+type EmailDelivery struct {
+	ID           string    `json:"id"`
+	MessageID    string    `json:"message_id"`
+	ProviderID   string    `json:"provider_id,omitempty"` // message ID assigned by an HTTP provider API
+	Template     string    `json:"template"`
+	Category     string    `json:"category"`
+	To           string    `json:"to"`
+	Subject      string    `json:"subject"`
+	Status       string    `json:"status"` // queued, retrying, sent, failed or suppressed
+	ResponseCode int       `json:"response_code,omitempty"`
+	Response     string    `json:"response,omitempty"`
+	Attempts     int       `json:"attempts"`
+	ResendOf     string    `json:"resend_of,omitempty"`
+	HTMLContent  string    `json:"html_content"`
+	TextContent  string    `json:"text_content"`
+	Data         EmailData `json:"data"` // kept so the email can be rendered again for a resend
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// LiveSession is a live Q&A call enrolled students are invited to
+type LiveSession struct {
+	ID          string        `json:"id"` // also the iCalendar UID, so updates replace the original event
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	TimeZone    string        `json:"time_zone"` // IANA name such as Europe/Paris
+	JoinURL     string        `json:"join_url"`
+	Sequence    int           `json:"sequence"` // incremented on every reschedule and cancellation
+	Cancelled   bool          `json:"cancelled,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// TrackingEvent is an open or click recorded for one recipient of one message
+type TrackingEvent struct {
+	MessageID string    `json:"message_id"`
+	Recipient string    `json:"recipient"`
+	Template  string    `json:"template"`
+	Type      string    `json:"type"` // open or click
+	URL       string    `json:"url,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Segment selects which students receive a broadcast
+type Segment struct {
+	Kind  string `json:"kind"`            // all, product, cohort, company or progress
+	Value string `json:"value,omitempty"` // product name, cohort month, company or module range
+}
+
+// Broadcast is a one-off announcement emailed to a segment of students
+type Broadcast struct {
+	ID          string    `json:"id"`
+	Template    string    `json:"template"`
+	Subject     string    `json:"subject"`
+	Heading     string    `json:"heading"`
+	Message     string    `json:"message"` // plain text; blank lines separate paragraphs
+	ButtonLabel string    `json:"button_label,omitempty"`
+	ButtonURL   string    `json:"button_url,omitempty"`
+	Segment     Segment   `json:"segment"`
+	Status      string    `json:"status"`
+	ScheduledAt time.Time `json:"scheduled_at,omitempty"`
+	SentAt      time.Time `json:"sent_at,omitempty"`
+	Recipients  int       `json:"recipients"` // students in the segment when it was sent
+	Sent        int       `json:"sent"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SignInLink is an issued magic link that has not been used yet
+type SignInLink struct {
+	AccountID string    `json:"account_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Account is a student's login, shared by every order placed with its email address
+type Account struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	Locale      string    `json:"locale"`
+	CustomerIDs []string  `json:"customer_ids"` // Stripe customers whose orders belong to the account
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at,omitempty"`
+
+	// Optional password sign-in
+	PasswordHash      string    `json:"password_hash,omitempty"` // Argon2id (PHC format) or bcrypt
+	PasswordChangedAt time.Time `json:"password_changed_at,omitempty"`
+	FailedLogins      int       `json:"failed_logins,omitempty"` // wrong passwords in a row
+	LockedUntil       time.Time `json:"locked_until,omitempty"`
+
+	// Optional two-factor authentication
+	TOTPSecret    string    `json:"totp_secret,omitempty"` // base32; empty when two-factor authentication is off
+	TOTPEnabledAt time.Time `json:"totp_enabled_at,omitempty"`
+	TOTPLastStep  int64     `json:"totp_last_step,omitempty"` // time step of the last code used, so codes work once
+	RecoveryCodes []string  `json:"recovery_codes,omitempty"` // SHA-256 hashes of the unused recovery codes
+
+	// Roles granted on top of being a student; team admins are set on the team
+	Roles []string `json:"roles,omitempty"`
+
+	// Account sharing review
+	SharingFlaggedAt  time.Time `json:"sharing_flagged_at,omitempty"`
+	SharingReason     string    `json:"sharing_reason,omitempty"`
+	SharingReviewedAt time.Time `json:"sharing_reviewed_at,omitempty"` // sign-ins before it no longer count
+}
+
+// LoginSession is a browser signed in to a student account
+type LoginSession struct {
+	ID         string    `json:"id"` // shown on the devices page; not the cookie token
+	AccountID  string    `json:"account_id"`
+	Method     string    `json:"method"` // link, password or sso
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	DeviceID   string    `json:"device_id,omitempty"` // from the browser's device cookie
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	TwoFactor  bool      `json:"two_factor,omitempty"` // the second factor was checked at sign-in
+}
+
+// LoginRecord is one sign-in to a student account
+type LoginRecord struct {
+	AccountID string    `json:"account_id"`
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	Method    string    `json:"method"`
+	Evicted   int       `json:"evicted,omitempty"` // older sessions ended by the session limits
+}
+
+// AccessDenial records a request refused for lack of permission
+type AccessDenial struct {
+	At         time.Time  `json:"at"`
+	AccountID  string     `json:"account_id,omitempty"` // empty for wrong admin credentials
+	Email      string     `json:"email,omitempty"`
+	Permission Permission `json:"permission"`
+	TeamID     string     `json:"team_id,omitempty"`
+	Method     string     `json:"method"`
+	Path       string     `json:"path"`
+	IP         string     `json:"ip"`
+	Reason     string     `json:"reason"`
+}
+
+// Team is a company that bought several seats of the course. Each member
+// holds one seat and has an enrollment of their own.
+type Team struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Seats     int          `json:"seats"`             // bought at checkout, in one or more orders
+	Orders    []string     `json:"orders"`            // checkout sessions that bought the seats, the first with the buyer's own seat
+	AdminIDs  []string     `json:"admin_ids"`         // accounts that manage the team
+	Domains   []string     `json:"domains,omitempty"` // when set, members' addresses must be at one of them
+	Members   []TeamMember `json:"members"`
+	Groups    []TeamGroup  `json:"groups,omitempty"`
+	SSO       *SSOConfig   `json:"sso,omitempty"`
+	SCIMToken string       `json:"scim_token,omitempty"` // SHA-256 hash of the team's SCIM bearer token
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// TeamMember is an account holding one of a team's seats
+type TeamMember struct {
+	AccountID    string    `json:"account_id"` // also the member's SCIM user ID
+	EnrollmentID string    `json:"enrollment_id"`
+	Source       string    `json:"source"`                // checkout, invite, sso or scim
+	ExternalID   string    `json:"external_id,omitempty"` // the identity provider's ID for the member
+	AddedAt      time.Time `json:"added_at"`
+	RemovedAt    time.Time `json:"removed_at,omitempty"` // set while the seat is freed but the member kept for reactivation
+	UpdatedAt    time.Time `json:"updated_at"`
+	Pending      bool      `json:"pending,omitempty"` // added inactive and not welcomed yet
+}
+
+// TeamGroup is a group of team members pushed by the team's identity provider
+type TeamGroup struct {
+	ID          string    `json:"id"`
+	DisplayName string    `json:"display_name"`
+	ExternalID  string    `json:"external_id,omitempty"`
+	MemberIDs   []string  `json:"member_ids"` // account IDs
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SSOConfig is how a team's members sign in with their company's identity provider
+type SSOConfig struct {
+	Protocol string `json:"protocol"` // oidc or saml
+
+	// OpenID Connect
+	Issuer       string `json:"issuer,omitempty"` // discovery is at Issuer + /.well-known/openid-configuration
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+
+	// SAML 2.0
+	IdPEntityID    string `json:"idp_entity_id,omitempty"`
+	IdPSSOURL      string `json:"idp_sso_url,omitempty"`     // HTTP-Redirect binding endpoint
+	IdPCertificate string `json:"idp_certificate,omitempty"` // PEM certificate the IdP signs with
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Email categories. Everything except transactional email can be unsubscribed from.
+const (
+	categoryTransactional = "transactional"
+	categoryOnboarding    = "onboarding"
+	categoryAnnouncements = "announcements"
+)
+
+// emailCategories are the categories shown in the preference center
+var emailCategories = []struct {
+	Name        string
+	Title       string
+	Description string
+}{
+	{categoryOnboarding, "Onboarding tips", "Emails that help you get started during your first weeks in the course."},
+	{categoryAnnouncements, "Course announcements", "New modules, live sessions and other course news."},
+}
+
+// unsubscribeTokenPurpose separates unsubscribe tokens from other signed tokens
+const unsubscribeTokenPurpose = "unsubscribe"
+
+// unsubscribeURL returns the signed unsubscribe link for an address and category
+func unsubscribeURL(email, category string) string {
+	token := signToken(unsubscribeTokenPurpose, suppressionKey(email)+"\n"+category)
+	return os.Getenv("DOMAIN_URL") + "/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// parseUnsubscribeToken returns the address and category an unsubscribe token was issued for
+func parseUnsubscribeToken(token string) (email, category string, ok bool) {
+	payload, ok := verifyToken(unsubscribeTokenPurpose, token)
+	if !ok {
+		return "", "", false
+	}
+	email, category, ok = strings.Cut(payload, "\n")
+	return email, category, ok
+}
+
+var unsubscribeTmpl = template.Must(template.New("unsubscribe").Parse(`<html>
+	<head>
+		<title>Email Preferences</title>
+		<link href="https://fonts.googleapis.com/css2?family=Lexend+Deca:wght@400;500;600;700&display=swap" rel="stylesheet">
+		<script src="https://cdn.tailwindcss.com"></script>
+	</head>
+	<body class="bg-black text-white min-h-screen flex items-center justify-center font-['Lexend_Deca']">
+		<div class="max-w-lg p-8">
+			<h1 class="text-4xl font-bold mb-4">Email Preferences</h1>
+			<p class="text-lg text-blue-200/90 mb-8">Choose which emails {{.Email}} receives from us.</p>
+			{{if .Saved}}<p class="mb-6 text-green-400">Your preferences have been saved.</p>{{end}}
+			<form method="post" action="/unsubscribe?token={{.Token}}" class="space-y-4">
+				{{range .Categories}}
+				<label class="flex gap-3 items-start">
+					<input type="checkbox" name="category" value="{{.Name}}" class="mt-1.5" {{if .Subscribed}}checked{{end}}>
+					<span>
+						<span class="font-medium">{{.Title}}</span><br>
+						<span class="text-sm text-blue-200/70">{{.Description}}</span>
+					</span>
+				</label>
+				{{end}}
+				<div class="flex gap-4 pt-4">
+					<button name="action" value="save" class="px-6 py-2 rounded-lg bg-blue-600 hover:bg-blue-500">Save preferences</button>
+					<button name="action" value="all" class="px-6 py-2 rounded-lg border border-blue-200/30 hover:border-blue-200/60">Unsubscribe from all</button>
+				</div>
+			</form>
+			<p class="text-sm text-blue-200/70 mt-8">We will still send emails about your purchase and account, such as receipts and access details.</p>
+		</div>
+	</body>
+</html>`))
+
+// preferenceCategory is one checkbox in the preference center
+type preferenceCategory struct {
+	Name        string
+	Title       string
+	Description string
+	Subscribed  bool
+}
+
+// UnsubscribeHandler serves the preference center and handles RFC 8058 one-click unsubscribes
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	email, category, ok := parseUnsubscribeToken(token)
+	if !ok {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	saved := false
+	if r.Method == http.MethodPost {
+		var err error
+		switch r.FormValue("action") {
+		case "save":
+			var unsubscribed []string
+			for _, c := range emailCategories {
+				if !slices.Contains(r.Form["category"], c.Name) {
+					unsubscribed = append(unsubscribed, c.Name)
+				}
+			}
+			err = suppressions.SetUnsubscribed(email, unsubscribed)
+		case "all":
+			var all []string
+			for _, c := range emailCategories {
+				all = append(all, c.Name)
+			}
+			err = suppressions.SetUnsubscribed(email, all)
+		default:
+			// Mail clients send List-Unsubscribe=One-Click without further interaction
+			err = suppressions.Unsubscribe(email, category)
+		}
+		if err != nil {
+			log.Printf("Error updating email preferences for %s: %v", email, err)
+			http.Error(w, "Error updating preferences", http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("List-Unsubscribe") == "One-Click" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		saved = true
+	}
+
+	sup, _ := suppressions.Get(email)
+	var categories []preferenceCategory
+	for _, c := range emailCategories {
+		categories = append(categories, preferenceCategory{
+			Name:        c.Name,
+			Title:       c.Title,
+			Description: c.Description,
+			Subscribed:  !slices.Contains(sup.Categories, c.Name),
+		})
+	}
+
+	data := struct {
+		Email      string
+		Token      string
+		Saved      bool
+		Categories []preferenceCategory
+	}{email, token, saved, categories}
+
+	if err := unsubscribeTmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering preference center: %v", err)
+	}
+}